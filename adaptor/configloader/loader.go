@@ -30,26 +30,46 @@ func isRubyStyleYAML(data []byte) bool {
 
 // rawConfig mirrors the YAML structure of codedeployagent.yml.
 type rawConfig struct {
-	ProgramName               string `yaml:"program_name"`
-	RootDir                   string `yaml:"root_dir"`
-	LogDir                    string `yaml:"log_dir"`
-	PIDDir                    string `yaml:"pid_dir"`
-	OngoingDeploymentTracking string `yaml:"ongoing_deployment_tracking"`
-	OnPremisesConfigFile      string `yaml:"on_premises_config_file"`
-	ProxyURI                  string `yaml:"proxy_uri"`
-	DeployControlEndpoint     string `yaml:"deploy_control_endpoint"`
-	S3EndpointOverride        string `yaml:"s3_endpoint_override"`
-	WaitBetweenRuns           *int   `yaml:"wait_between_runs"`
-	WaitBetweenRunsActive     *int   `yaml:"wait_between_runs_active"`
-	WaitAfterError            *int   `yaml:"wait_after_error"`
-	HTTPReadTimeout           *int   `yaml:"http_read_timeout"`
-	KillAgentMaxWaitTime      *int   `yaml:"kill_agent_max_wait_time_seconds"`
-	MaxRevisions              *int   `yaml:"max_revisions"`
-	UseFIPSMode               *bool  `yaml:"use_fips_mode"`
-	UseDualStack              *bool  `yaml:"use_dual_stack"`
-	EnableAuthPolicy          *bool  `yaml:"enable_auth_policy"`
-	EnableDeploymentsLog      *bool  `yaml:"enable_deployments_log"`
-	DisableIMDSv1             *bool  `yaml:"disable_imds_v1"`
+	ProgramName               string   `yaml:"program_name"`
+	RootDir                   string   `yaml:"root_dir"`
+	LogDir                    string   `yaml:"log_dir"`
+	PIDDir                    string   `yaml:"pid_dir"`
+	OngoingDeploymentTracking string   `yaml:"ongoing_deployment_tracking"`
+	OnPremisesConfigFile      string   `yaml:"on_premises_config_file"`
+	TemplateVariablesFile     string   `yaml:"template_variables_file"`
+	ProxyURI                  string   `yaml:"proxy_uri"`
+	DeployControlEndpoint     string   `yaml:"deploy_control_endpoint"`
+	S3EndpointOverride        string   `yaml:"s3_endpoint_override"`
+	IncrementalInstall        string   `yaml:"incremental_install"`
+	HookShell                 string   `yaml:"hook_shell"`
+	HookEnvPolicy             string   `yaml:"hook_env_policy"`
+	HookEnvAllowlist          []string `yaml:"hook_env_allowlist"`
+	WaitBetweenRuns           *int     `yaml:"wait_between_runs"`
+	WaitBetweenRunsActive     *int     `yaml:"wait_between_runs_active"`
+	WaitAfterError            *int     `yaml:"wait_after_error"`
+	HTTPReadTimeout           *int     `yaml:"http_read_timeout"`
+	KillAgentMaxWaitTime      *int     `yaml:"kill_agent_max_wait_time_seconds"`
+	HookGracePeriod           *int     `yaml:"hook_grace_period_seconds"`
+	HookOutputWait            *int     `yaml:"hook_output_wait_seconds"`
+	HookLimitCPUTime          *int     `yaml:"hook_limit_cpu_time_seconds"`
+	HookLimitAddressSpace     *int64   `yaml:"hook_limit_address_space_mb"`
+	HookLimitOpenFiles        *int     `yaml:"hook_limit_open_files"`
+	HookLimitProcesses        *int     `yaml:"hook_limit_processes"`
+	HookLimitMemory           *int64   `yaml:"hook_limit_memory_mb"`
+	HookLimitCPUs             *float64 `yaml:"hook_limit_cpus"`
+	GCInterval                *int     `yaml:"gc_interval"`
+	VerifyInterval            *int     `yaml:"verify_interval"`
+	DeploymentLogsRetention   *int     `yaml:"deployment_logs_retention_days"`
+	MaxRevisions              *int     `yaml:"max_revisions"`
+	UseFIPSMode               *bool    `yaml:"use_fips_mode"`
+	UseDualStack              *bool    `yaml:"use_dual_stack"`
+	EnableAuthPolicy          *bool    `yaml:"enable_auth_policy"`
+	EnableDeploymentsLog      *bool    `yaml:"enable_deployments_log"`
+	DisableIMDSv1             *bool    `yaml:"disable_imds_v1"`
+	HookLeaveDaemonsRunning   *bool    `yaml:"hook_leave_daemons_running"`
+	HookChmodScripts          *bool    `yaml:"hook_chmod_scripts"`
+	BackupOverwrittenFiles    *bool    `yaml:"backup_overwritten_files"`
+	AllowSharedDestinations   *bool    `yaml:"allow_shared_destinations"`
 }
 
 // rawOnPremises mirrors the YAML structure of codedeploy.onpremises.yml.
@@ -105,6 +125,18 @@ func LoadAgent(path string) (config.Agent, error) {
 	if raw.OnPremisesConfigFile != "" {
 		cfg.OnPremisesConfigFile = raw.OnPremisesConfigFile
 	}
+	if raw.TemplateVariablesFile != "" {
+		cfg.TemplateVariablesFile = raw.TemplateVariablesFile
+	}
+	if raw.HookShell != "" {
+		cfg.HookShell = raw.HookShell
+	}
+	if raw.HookEnvPolicy != "" {
+		cfg.HookEnvPolicy = raw.HookEnvPolicy
+	}
+	if raw.HookEnvAllowlist != nil {
+		cfg.HookEnvAllowlist = raw.HookEnvAllowlist
+	}
 	if raw.ProxyURI != "" {
 		cfg.ProxyURI = raw.ProxyURI
 	}
@@ -114,6 +146,9 @@ func LoadAgent(path string) (config.Agent, error) {
 	if raw.S3EndpointOverride != "" {
 		cfg.S3EndpointOverride = raw.S3EndpointOverride
 	}
+	if raw.IncrementalInstall != "" {
+		cfg.IncrementalInstall = raw.IncrementalInstall
+	}
 	if raw.WaitBetweenRuns != nil {
 		cfg.PollInterval = time.Duration(*raw.WaitBetweenRuns) * time.Second
 	}
@@ -129,6 +164,39 @@ func LoadAgent(path string) (config.Agent, error) {
 	if raw.KillAgentMaxWaitTime != nil {
 		cfg.KillAgentMaxWait = time.Duration(*raw.KillAgentMaxWaitTime) * time.Second
 	}
+	if raw.HookGracePeriod != nil {
+		cfg.HookGracePeriod = time.Duration(*raw.HookGracePeriod) * time.Second
+	}
+	if raw.HookOutputWait != nil {
+		cfg.HookOutputWait = time.Duration(*raw.HookOutputWait) * time.Second
+	}
+	if raw.HookLimitCPUTime != nil {
+		cfg.HookLimitCPUTime = time.Duration(*raw.HookLimitCPUTime) * time.Second
+	}
+	if raw.HookLimitAddressSpace != nil {
+		cfg.HookLimitAddressSpace = *raw.HookLimitAddressSpace << 20
+	}
+	if raw.HookLimitOpenFiles != nil {
+		cfg.HookLimitOpenFiles = *raw.HookLimitOpenFiles
+	}
+	if raw.HookLimitProcesses != nil {
+		cfg.HookLimitProcesses = *raw.HookLimitProcesses
+	}
+	if raw.HookLimitMemory != nil {
+		cfg.HookLimitMemory = *raw.HookLimitMemory << 20
+	}
+	if raw.HookLimitCPUs != nil {
+		cfg.HookLimitCPUs = *raw.HookLimitCPUs
+	}
+	if raw.GCInterval != nil {
+		cfg.GCInterval = time.Duration(*raw.GCInterval) * time.Second
+	}
+	if raw.VerifyInterval != nil {
+		cfg.VerifyInterval = time.Duration(*raw.VerifyInterval) * time.Second
+	}
+	if raw.DeploymentLogsRetention != nil {
+		cfg.DeploymentLogsMaxAge = time.Duration(*raw.DeploymentLogsRetention) * 24 * time.Hour
+	}
 	if raw.MaxRevisions != nil {
 		cfg.MaxRevisions = *raw.MaxRevisions
 	}
@@ -147,6 +215,18 @@ func LoadAgent(path string) (config.Agent, error) {
 	if raw.DisableIMDSv1 != nil {
 		cfg.DisableIMDSv1 = *raw.DisableIMDSv1
 	}
+	if raw.HookLeaveDaemonsRunning != nil {
+		cfg.HookLeaveDaemonsRunning = *raw.HookLeaveDaemonsRunning
+	}
+	if raw.HookChmodScripts != nil {
+		cfg.HookChmodScripts = *raw.HookChmodScripts
+	}
+	if raw.BackupOverwrittenFiles != nil {
+		cfg.BackupOverwrittenFiles = *raw.BackupOverwrittenFiles
+	}
+	if raw.AllowSharedDestinations != nil {
+		cfg.AllowSharedDestinations = *raw.AllowSharedDestinations
+	}
 
 	return cfg, nil
 }
@@ -174,3 +254,22 @@ func LoadOnPremises(path string) (config.OnPremises, error) {
 		CredentialsFile:    raw.CredentialsFile,
 	}, nil
 }
+
+// LoadTemplateVariables loads the host-local template variables from a YAML
+// mapping. A missing file yields no variables.
+//
+//	vars, err := configloader.LoadTemplateVariables("/etc/codedeploy-agent/conf/template-variables.yml")
+func LoadTemplateVariables(path string) (map[string]any, error) {
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return map[string]any{}, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("configloader: %w", err)
+	}
+	vars := map[string]any{}
+	if err := yaml.Unmarshal(data, &vars); err != nil {
+		return nil, fmt.Errorf("configloader: parse %s: %w", path, err)
+	}
+	return vars, nil
+}
@@ -237,12 +237,32 @@ wait_between_runs_active: 5
 wait_after_error: 60
 http_read_timeout: 120
 kill_agent_max_wait_time_seconds: 300
+gc_interval: 3600
+verify_interval: 900
+deployment_logs_retention_days: 14
 max_revisions: 7
 use_fips_mode: true
 use_dual_stack: true
 enable_auth_policy: true
 enable_deployments_log: true
 disable_imds_v1: true
+backup_overwritten_files: true
+allow_shared_destinations: true
+template_variables_file: /custom/vars.yml
+incremental_install: mtime
+hook_shell: /bin/bash
+hook_env_policy: allowlist
+hook_env_allowlist: [PATH, LC_*]
+hook_grace_period_seconds: 15
+hook_output_wait_seconds: 3
+hook_leave_daemons_running: true
+hook_chmod_scripts: false
+hook_limit_cpu_time_seconds: 600
+hook_limit_address_space_mb: 4096
+hook_limit_open_files: 1024
+hook_limit_processes: 200
+hook_limit_memory_mb: 512
+hook_limit_cpus: 1.5
 `
 	if err := os.WriteFile(path, []byte(data), 0o644); err != nil {
 		t.Fatal(err)
@@ -289,6 +309,15 @@ disable_imds_v1: true
 	if cfg.KillAgentMaxWait != 300*time.Second {
 		t.Errorf("KillAgentMaxWait = %v", cfg.KillAgentMaxWait)
 	}
+	if cfg.GCInterval != time.Hour {
+		t.Errorf("GCInterval = %v", cfg.GCInterval)
+	}
+	if cfg.VerifyInterval != 15*time.Minute {
+		t.Errorf("VerifyInterval = %v", cfg.VerifyInterval)
+	}
+	if cfg.DeploymentLogsMaxAge != 14*24*time.Hour {
+		t.Errorf("DeploymentLogsMaxAge = %v", cfg.DeploymentLogsMaxAge)
+	}
 	if cfg.MaxRevisions != 7 {
 		t.Errorf("MaxRevisions = %d", cfg.MaxRevisions)
 	}
@@ -301,6 +330,39 @@ disable_imds_v1: true
 	if !cfg.EnableDeploymentsLog {
 		t.Error("EnableDeploymentsLog should be true")
 	}
+	if !cfg.BackupOverwrittenFiles {
+		t.Error("BackupOverwrittenFiles should be true")
+	}
+	if !cfg.AllowSharedDestinations {
+		t.Error("AllowSharedDestinations should be true")
+	}
+	if cfg.TemplateVariablesFile != "/custom/vars.yml" {
+		t.Errorf("TemplateVariablesFile = %q", cfg.TemplateVariablesFile)
+	}
+	if cfg.IncrementalInstall != "mtime" {
+		t.Errorf("IncrementalInstall = %q, want mtime", cfg.IncrementalInstall)
+	}
+	if cfg.HookShell != "/bin/bash" {
+		t.Errorf("HookShell = %q, want /bin/bash", cfg.HookShell)
+	}
+	if cfg.HookEnvPolicy != "allowlist" || len(cfg.HookEnvAllowlist) != 2 || cfg.HookEnvAllowlist[1] != "LC_*" {
+		t.Errorf("HookEnvPolicy = %q, HookEnvAllowlist = %v", cfg.HookEnvPolicy, cfg.HookEnvAllowlist)
+	}
+	if cfg.HookGracePeriod != 15*time.Second {
+		t.Errorf("HookGracePeriod = %v, want 15s", cfg.HookGracePeriod)
+	}
+	if cfg.HookOutputWait != 3*time.Second || !cfg.HookLeaveDaemonsRunning {
+		t.Errorf("HookOutputWait = %v, HookLeaveDaemonsRunning = %v", cfg.HookOutputWait, cfg.HookLeaveDaemonsRunning)
+	}
+	if cfg.HookChmodScripts {
+		t.Error("HookChmodScripts should be false")
+	}
+	if cfg.HookLimitCPUTime != 600*time.Second || cfg.HookLimitOpenFiles != 1024 || cfg.HookLimitProcesses != 200 {
+		t.Errorf("HookLimitCPUTime = %v, HookLimitOpenFiles = %d, HookLimitProcesses = %d", cfg.HookLimitCPUTime, cfg.HookLimitOpenFiles, cfg.HookLimitProcesses)
+	}
+	if cfg.HookLimitAddressSpace != 4<<30 || cfg.HookLimitMemory != 512<<20 || cfg.HookLimitCPUs != 1.5 {
+		t.Errorf("HookLimitAddressSpace = %d, HookLimitMemory = %d, HookLimitCPUs = %v", cfg.HookLimitAddressSpace, cfg.HookLimitMemory, cfg.HookLimitCPUs)
+	}
 }
 
 // TestLoadAgentUseDualStack verifies that use_dual_stack: true in YAML sets the
@@ -362,3 +424,34 @@ func TestIsRubyStyleYAML(t *testing.T) {
 		t.Error("should not detect plain YAML as Ruby style")
 	}
 }
+
+// TestLoadTemplateVariables verifies that nested YAML values reach templates
+// as maps, that a missing file means no variables, and that malformed YAML is
+// reported instead of rendering templates without their variables.
+func TestLoadTemplateVariables(t *testing.T) {
+	dir := t.TempDir()
+	vars, err := LoadTemplateVariables(filepath.Join(dir, "missing.yml"))
+	if err != nil || len(vars) != 0 {
+		t.Errorf("missing file = %v, %v; want no variables", vars, err)
+	}
+
+	path := filepath.Join(dir, "vars.yml")
+	if err := os.WriteFile(path, []byte("db_host: db.internal\ndb:\n  port: 5432\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	vars, err = LoadTemplateVariables(path)
+	if err != nil {
+		t.Fatalf("LoadTemplateVariables: %v", err)
+	}
+	db, _ := vars["db"].(map[string]any)
+	if vars["db_host"] != "db.internal" || db["port"] != 5432 {
+		t.Errorf("vars = %v", vars)
+	}
+
+	if err := os.WriteFile(path, []byte("db_host: [\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := LoadTemplateVariables(path); err == nil {
+		t.Error("expected parse error")
+	}
+}
@@ -0,0 +1,258 @@
+package filesystem
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// POSIX ACL xattr names and encoding, as defined by the Linux kernel in
+// include/uapi/linux/posix_acl_xattr.h.
+const (
+	aclAccessXattr  = "system.posix_acl_access"
+	aclDefaultXattr = "system.posix_acl_default"
+	aclXattrVersion = 2
+	aclUndefinedID  = 0xFFFFFFFF
+)
+
+// ACL entry tags.
+const (
+	aclUserObj  uint16 = 0x01
+	aclUser     uint16 = 0x02
+	aclGroupObj uint16 = 0x04
+	aclGroup    uint16 = 0x08
+	aclMask     uint16 = 0x10
+	aclOther    uint16 = 0x20
+)
+
+// errUnresolvedName reports an ACL qualifier that cannot be resolved to a
+// numeric ID locally, such as a directory-service user when the user database
+// is not readable without cgo.
+var errUnresolvedName = errors.New("unresolved name")
+
+type aclEntry struct {
+	tag  uint16
+	perm uint16
+	id   uint32
+}
+
+// posixACL is one ACL (access or default) in kernel order.
+type posixACL []aclEntry
+
+// aclTarget describes the file an ACL spec is applied to. X permissions
+// resolve to execute for directories and for files that already have an
+// execute bit, as in setfacl.
+type aclTarget struct {
+	isDir   bool
+	hasExec bool
+}
+
+// parseACLSpec converts setfacl --set entries ([default:]tag:qualifier:perms)
+// into access and default ACLs, with the semantics of setfacl --set:
+//
+//   - the access ACL replaces the existing one and must contain the user::,
+//     group:: and other:: entries;
+//   - a mask is computed as the union of the group class when named entries
+//     are present without one;
+//   - default entries are only valid on directories, and missing base
+//     entries in the default ACL are copied from the access ACL.
+//
+// A nil default ACL means the existing default ACL is left unchanged.
+// resolve maps a user ("u") or group ("g") name to its numeric ID.
+func parseACLSpec(entries []string, target aclTarget, resolve func(kind, name string) (uint32, error)) (access, def posixACL, err error) {
+	for _, raw := range entries {
+		spec, isDefault := strings.CutPrefix(raw, "default:")
+		if !isDefault {
+			spec, isDefault = strings.CutPrefix(raw, "d:")
+		}
+		e, err := parseACE(spec, target, resolve)
+		if err != nil {
+			return nil, nil, fmt.Errorf("acl entry %q: %w", raw, err)
+		}
+		if isDefault {
+			def = append(def, e)
+		} else {
+			access = append(access, e)
+		}
+	}
+
+	if err := access.complete(nil); err != nil {
+		return nil, nil, fmt.Errorf("access acl: %w", err)
+	}
+	if def != nil {
+		if !target.isDir {
+			return nil, nil, errors.New("only directories can have default ACLs")
+		}
+		if err := def.complete(access); err != nil {
+			return nil, nil, fmt.Errorf("default acl: %w", err)
+		}
+	}
+	access.sort()
+	def.sort()
+	return access, def, nil
+}
+
+func parseACE(spec string, target aclTarget, resolve func(kind, name string) (uint32, error)) (aclEntry, error) {
+	parts := strings.Split(spec, ":")
+	var tag, qualifier, perms string
+	switch len(parts) {
+	case 2:
+		// other and mask may omit the empty qualifier: o:r, m:rwx.
+		tag, perms = parts[0], parts[1]
+	case 3:
+		tag, qualifier, perms = parts[0], parts[1], parts[2]
+	default:
+		return aclEntry{}, errors.New("malformed entry")
+	}
+
+	perm, err := parseACLPerms(perms, target)
+	if err != nil {
+		return aclEntry{}, err
+	}
+	e := aclEntry{perm: perm, id: aclUndefinedID}
+
+	switch tag {
+	case "u", "user":
+		e.tag = aclUserObj
+		if qualifier != "" {
+			e.tag = aclUser
+			e.id, err = resolveACLID(resolve, "u", qualifier)
+		}
+	case "g", "group":
+		e.tag = aclGroupObj
+		if qualifier != "" {
+			e.tag = aclGroup
+			e.id, err = resolveACLID(resolve, "g", qualifier)
+		}
+	case "o", "other":
+		e.tag = aclOther
+	case "m", "mask":
+		e.tag = aclMask
+	default:
+		return aclEntry{}, fmt.Errorf("unknown tag %q", tag)
+	}
+	if err != nil {
+		return aclEntry{}, err
+	}
+	if (e.tag == aclOther || e.tag == aclMask) && qualifier != "" {
+		return aclEntry{}, fmt.Errorf("tag %q takes no qualifier", tag)
+	}
+	if len(parts) == 2 && e.tag != aclOther && e.tag != aclMask {
+		return aclEntry{}, errors.New("malformed entry")
+	}
+	return e, nil
+}
+
+func resolveACLID(resolve func(kind, name string) (uint32, error), kind, qualifier string) (uint32, error) {
+	if id, err := strconv.ParseUint(qualifier, 10, 32); err == nil {
+		return uint32(id), nil
+	}
+	return resolve(kind, qualifier)
+}
+
+// parseACLPerms accepts a combination of r, w, x, X and - or a single octal
+// digit.
+func parseACLPerms(s string, target aclTarget) (uint16, error) {
+	if len(s) == 1 && s[0] >= '0' && s[0] <= '7' {
+		return uint16(s[0] - '0'), nil
+	}
+	if s == "" {
+		return 0, errors.New("missing permissions")
+	}
+	var perm uint16
+	for _, c := range s {
+		switch c {
+		case 'r':
+			perm |= 4
+		case 'w':
+			perm |= 2
+		case 'x':
+			perm |= 1
+		case 'X':
+			if target.isDir || target.hasExec {
+				perm |= 1
+			}
+		case '-':
+		default:
+			return 0, fmt.Errorf("invalid permission %q", c)
+		}
+	}
+	return perm, nil
+}
+
+// complete validates a as a full ACL, filling missing base entries from base
+// when base is non-nil, and adds a mask when named entries need one.
+func (a *posixACL) complete(base posixACL) error {
+	seen := make(map[[2]uint32]bool, len(*a))
+	for _, e := range *a {
+		key := [2]uint32{uint32(e.tag), e.id}
+		if seen[key] {
+			return errors.New("duplicate entry")
+		}
+		seen[key] = true
+	}
+
+	for _, tag := range []uint16{aclUserObj, aclGroupObj, aclOther} {
+		if a.find(tag) >= 0 {
+			continue
+		}
+		i := base.find(tag)
+		if i < 0 {
+			return errors.New("missing user::, group:: or other:: entry")
+		}
+		*a = append(*a, base[i])
+	}
+
+	if a.find(aclMask) >= 0 {
+		return nil
+	}
+	var union uint16
+	named := false
+	for _, e := range *a {
+		switch e.tag {
+		case aclUser, aclGroup:
+			named = true
+			union |= e.perm
+		case aclGroupObj:
+			union |= e.perm
+		}
+	}
+	if named {
+		*a = append(*a, aclEntry{tag: aclMask, perm: union, id: aclUndefinedID})
+	}
+	return nil
+}
+
+func (a posixACL) find(tag uint16) int {
+	for i, e := range a {
+		if e.tag == tag {
+			return i
+		}
+	}
+	return -1
+}
+
+// sort orders entries by tag, then qualifier, as the kernel requires.
+func (a posixACL) sort() {
+	sort.Slice(a, func(i, j int) bool {
+		if a[i].tag != a[j].tag {
+			return a[i].tag < a[j].tag
+		}
+		return a[i].id < a[j].id
+	})
+}
+
+// encode returns the xattr value of the ACL.
+func (a posixACL) encode() []byte {
+	buf := make([]byte, 4, 4+8*len(a))
+	binary.LittleEndian.PutUint32(buf, aclXattrVersion)
+	for _, e := range a {
+		buf = binary.LittleEndian.AppendUint16(buf, e.tag)
+		buf = binary.LittleEndian.AppendUint16(buf, e.perm)
+		buf = binary.LittleEndian.AppendUint32(buf, e.id)
+	}
+	return buf
+}
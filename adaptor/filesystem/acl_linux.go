@@ -0,0 +1,97 @@
+//go:build linux
+
+package filesystem
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"syscall"
+)
+
+// SetACL replaces the POSIX ACL of path with the given setfacl --set entries
+// by writing the system.posix_acl_access and system.posix_acl_default xattrs
+// directly. Like setfacl it follows symlinks. Entries naming users or groups
+// that cannot be resolved locally fall back to the setfacl binary.
+func (o *Operator) SetACL(path string, acl []string) error {
+	if len(acl) == 0 {
+		return nil
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		return fmt.Errorf("filesystem: setfacl %s: %w", path, err)
+	}
+	target := aclTarget{isDir: info.IsDir(), hasExec: info.Mode().Perm()&0o111 != 0}
+
+	access, def, err := parseACLSpec(acl, target, o.resolveACLName)
+	if errors.Is(err, errUnresolvedName) {
+		return o.setACLExec(path, acl)
+	}
+	if err != nil {
+		return fmt.Errorf("filesystem: setfacl %s: %w", path, err)
+	}
+
+	if err := syscall.Setxattr(path, aclAccessXattr, access.encode(), 0); err != nil {
+		return fmt.Errorf("filesystem: setfacl %s: %w", path, err)
+	}
+	if def != nil {
+		if err := syscall.Setxattr(path, aclDefaultXattr, def.encode(), 0); err != nil {
+			return fmt.Errorf("filesystem: setfacl %s: default acl: %w", path, err)
+		}
+	}
+	return nil
+}
+
+func (o *Operator) resolveACLName(kind, name string) (uint32, error) {
+	id, err := o.lookupID(kind, name)
+	if err != nil {
+		return 0, fmt.Errorf("%w: %s", errUnresolvedName, name)
+	}
+	return uint32(id), nil
+}
+
+// ACLMatches reports whether the POSIX ACL of path is the one SetACL would
+// have written for the given entries. A file without an access ACL xattr is
+// compared through its mode bits, which is how the kernel stores an ACL that
+// has only the base entries.
+func (o *Operator) ACLMatches(path string, acl []string) (bool, error) {
+	if len(acl) == 0 {
+		return true, nil
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		return false, err
+	}
+	target := aclTarget{isDir: info.IsDir(), hasExec: info.Mode().Perm()&0o111 != 0}
+	access, def, err := parseACLSpec(acl, target, o.resolveACLName)
+	if errors.Is(err, errUnresolvedName) {
+		return false, errors.ErrUnsupported
+	}
+	if err != nil {
+		return false, fmt.Errorf("filesystem: acl %s: %w", path, err)
+	}
+
+	current, err := getxattr(path, aclAccessXattr)
+	if errors.Is(err, syscall.ENODATA) {
+		perm := uint16(info.Mode().Perm())
+		current = posixACL{
+			{tag: aclUserObj, perm: perm >> 6 & 7, id: aclUndefinedID},
+			{tag: aclGroupObj, perm: perm >> 3 & 7, id: aclUndefinedID},
+			{tag: aclOther, perm: perm & 7, id: aclUndefinedID},
+		}.encode()
+	} else if err != nil {
+		return false, fmt.Errorf("filesystem: acl %s: %w", path, err)
+	}
+	if string(current) != string(access.encode()) {
+		return false, nil
+	}
+
+	if def == nil {
+		return true, nil
+	}
+	current, err = getxattr(path, aclDefaultXattr)
+	if err != nil {
+		return false, nil
+	}
+	return string(current) == string(def.encode()), nil
+}
@@ -0,0 +1,146 @@
+//go:build linux
+
+package filesystem
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+)
+
+// TestSetACL_WritesXattr verifies that SetACL writes the access ACL xattr
+// directly and that the kernel folds the mask into the group mode bits,
+// without needing the setfacl binary.
+func TestSetACL_WritesXattr(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "file.txt")
+	if err := os.WriteFile(path, []byte("data"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	op := NewOperator()
+	err := op.SetACL(path, []string{"u::rw-", "g::r--", "o::---", "u:4242:rwx"})
+	if errors.Is(err, syscall.ENOTSUP) {
+		t.Skip("filesystem does not support POSIX ACLs")
+	}
+	if err != nil {
+		t.Fatalf("SetACL: %v", err)
+	}
+
+	data, err := getxattr(path, aclAccessXattr)
+	if err != nil {
+		t.Fatalf("getxattr: %v", err)
+	}
+	if len(data) != 4+8*5 {
+		t.Errorf("acl xattr has %d bytes, want 5 entries", len(data))
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := info.Mode().Perm(); got != 0o670 {
+		t.Errorf("mode = %o, want 670 (mask in group bits)", got)
+	}
+}
+
+// TestACLMatches_DetectsChange verifies that ACLMatches accepts the ACL just
+// applied, including a minimal one the kernel stores only as mode bits, and
+// rejects it once an entry is changed behind the agent's back.
+func TestACLMatches_DetectsChange(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "file.txt")
+	if err := os.WriteFile(path, []byte("data"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	acl := []string{"u::rw-", "g::r--", "o::---", "u:4242:rwx"}
+
+	op := NewOperator()
+	err := op.SetACL(path, acl)
+	if errors.Is(err, syscall.ENOTSUP) {
+		t.Skip("filesystem does not support POSIX ACLs")
+	}
+	if err != nil {
+		t.Fatalf("SetACL: %v", err)
+	}
+	if ok, err := op.ACLMatches(path, acl); err != nil || !ok {
+		t.Errorf("ACLMatches after SetACL = %v, %v; want true", ok, err)
+	}
+	if err := op.SetACL(path, []string{"u::rw-", "g::r--", "o::---", "u:4242:r--"}); err != nil {
+		t.Fatal(err)
+	}
+	if ok, _ := op.ACLMatches(path, acl); ok {
+		t.Error("ACLMatches after change = true, want false")
+	}
+
+	minimal := []string{"u::rw-", "g::r--", "o::---"}
+	if err := op.SetACL(path, minimal); err != nil {
+		t.Fatal(err)
+	}
+	if ok, err := op.ACLMatches(path, minimal); err != nil || !ok {
+		t.Errorf("ACLMatches minimal = %v, %v; want true", ok, err)
+	}
+}
+
+// TestChown_NumericAndName verifies that Chown resolves names locally and
+// accepts numeric IDs, changing ownership without the chown binary.
+func TestChown_NumericAndName(t *testing.T) {
+	if os.Geteuid() != 0 {
+		t.Skip("chown to another user requires root")
+	}
+	path := filepath.Join(t.TempDir(), "file.txt")
+	if err := os.WriteFile(path, []byte("data"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	op := NewOperator()
+	if err := op.Chown(path, "4242", "4343"); err != nil {
+		t.Fatalf("Chown numeric: %v", err)
+	}
+	assertOwner(t, path, 4242, 4343)
+
+	if err := op.Chown(path, "root", ""); err != nil {
+		t.Fatalf("Chown name: %v", err)
+	}
+	assertOwner(t, path, 0, 4343)
+}
+
+// TestChown_Symlink verifies that Chown changes the link itself, not the
+// file it points to.
+func TestChown_Symlink(t *testing.T) {
+	if os.Geteuid() != 0 {
+		t.Skip("chown to another user requires root")
+	}
+	dir := t.TempDir()
+	target := filepath.Join(dir, "target.txt")
+	link := filepath.Join(dir, "link.txt")
+	if err := os.WriteFile(target, []byte("data"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink(target, link); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := NewOperator().Chown(link, "4242", ""); err != nil {
+		t.Fatalf("Chown: %v", err)
+	}
+	assertOwner(t, target, 0, 0)
+	info, err := os.Lstat(link)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if uid, _, _ := fileOwner(info); uid != 4242 {
+		t.Errorf("link uid = %d, want 4242", uid)
+	}
+}
+
+func assertOwner(t *testing.T, path string, uid, gid int) {
+	t.Helper()
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	gotUID, gotGID, _ := fileOwner(info)
+	if gotUID != uid || gotGID != gid {
+		t.Errorf("%s owner = %d:%d, want %d:%d", path, gotUID, gotGID, uid, gid)
+	}
+}
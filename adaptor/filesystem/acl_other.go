@@ -0,0 +1,19 @@
+//go:build !linux
+
+package filesystem
+
+import "errors"
+
+// SetACL applies POSIX ACL entries with the setfacl binary. ACL xattrs are
+// Linux-specific, so there is no native path elsewhere.
+func (o *Operator) SetACL(path string, acl []string) error {
+	if len(acl) == 0 {
+		return nil
+	}
+	return o.setACLExec(path, acl)
+}
+
+// ACLMatches cannot read POSIX ACLs outside Linux.
+func (o *Operator) ACLMatches(_ string, _ []string) (bool, error) {
+	return false, errors.ErrUnsupported
+}
@@ -0,0 +1,134 @@
+package filesystem
+
+import (
+	"encoding/binary"
+	"errors"
+	"strings"
+	"testing"
+)
+
+// TestParseACLSpec_NamedEntriesGetMask verifies that a named entry without
+// an explicit mask gets one computed as the union of the group class, as
+// setfacl --set does, and that entries come out in kernel order.
+func TestParseACLSpec_NamedEntriesGetMask(t *testing.T) {
+	access, def, err := parseACLSpec(
+		[]string{"o::r--", "user:1001:rwx", "u::rw-", "g::r--"},
+		aclTarget{}, noResolve)
+	if err != nil {
+		t.Fatalf("parseACLSpec: %v", err)
+	}
+	if def != nil {
+		t.Errorf("default ACL = %v, want nil", def)
+	}
+	want := posixACL{
+		{tag: aclUserObj, perm: 6, id: aclUndefinedID},
+		{tag: aclUser, perm: 7, id: 1001},
+		{tag: aclGroupObj, perm: 4, id: aclUndefinedID},
+		{tag: aclMask, perm: 7, id: aclUndefinedID},
+		{tag: aclOther, perm: 4, id: aclUndefinedID},
+	}
+	if !equalACL(access, want) {
+		t.Errorf("access = %v, want %v", access, want)
+	}
+}
+
+// TestParseACLSpec_MissingBaseEntry verifies that an access ACL without the
+// base entries is rejected, matching setfacl --set.
+func TestParseACLSpec_MissingBaseEntry(t *testing.T) {
+	_, _, err := parseACLSpec([]string{"user:1001:rwx"}, aclTarget{}, noResolve)
+	if err == nil || !strings.Contains(err.Error(), "missing") {
+		t.Errorf("expected missing base entry error, got %v", err)
+	}
+}
+
+// TestParseACLSpec_DefaultCopiesBase verifies that default entries on a
+// directory inherit missing base entries from the access ACL.
+func TestParseACLSpec_DefaultCopiesBase(t *testing.T) {
+	_, def, err := parseACLSpec(
+		[]string{"u::rwx", "g::r-x", "o::---", "d:g:2000:rwx"},
+		aclTarget{isDir: true}, noResolve)
+	if err != nil {
+		t.Fatalf("parseACLSpec: %v", err)
+	}
+	want := posixACL{
+		{tag: aclUserObj, perm: 7, id: aclUndefinedID},
+		{tag: aclGroupObj, perm: 5, id: aclUndefinedID},
+		{tag: aclGroup, perm: 7, id: 2000},
+		{tag: aclMask, perm: 7, id: aclUndefinedID},
+		{tag: aclOther, perm: 0, id: aclUndefinedID},
+	}
+	if !equalACL(def, want) {
+		t.Errorf("default = %v, want %v", def, want)
+	}
+}
+
+// TestParseACLSpec_DefaultOnFile verifies that default entries are rejected
+// on files, which cannot carry a default ACL.
+func TestParseACLSpec_DefaultOnFile(t *testing.T) {
+	_, _, err := parseACLSpec([]string{"u::rw", "g::r", "o::r", "default:u:1:rwx"}, aclTarget{}, noResolve)
+	if err == nil {
+		t.Error("expected error for default ACL on a file")
+	}
+}
+
+// TestParseACLSpec_ConditionalExecute verifies that X grants execute only
+// to directories and already-executable files.
+func TestParseACLSpec_ConditionalExecute(t *testing.T) {
+	spec := []string{"u::rwX", "g::r-X", "o::r"}
+	for _, tc := range []struct {
+		target aclTarget
+		want   uint16
+	}{
+		{aclTarget{}, 6},
+		{aclTarget{hasExec: true}, 7},
+		{aclTarget{isDir: true}, 7},
+	} {
+		access, _, err := parseACLSpec(spec, tc.target, noResolve)
+		if err != nil {
+			t.Fatalf("parseACLSpec: %v", err)
+		}
+		if access[0].perm != tc.want {
+			t.Errorf("%+v: user perm = %o, want %o", tc.target, access[0].perm, tc.want)
+		}
+	}
+}
+
+// TestParseACLSpec_UnresolvedName verifies that an unknown name surfaces
+// errUnresolvedName so the caller can fall back to setfacl.
+func TestParseACLSpec_UnresolvedName(t *testing.T) {
+	_, _, err := parseACLSpec([]string{"u::rw", "g::r", "o::r", "u:ldapuser:r"}, aclTarget{}, noResolve)
+	if !errors.Is(err, errUnresolvedName) {
+		t.Errorf("expected errUnresolvedName, got %v", err)
+	}
+}
+
+// TestPosixACL_Encode verifies the kernel xattr layout: a version header
+// followed by little-endian tag, perm, id triples.
+func TestPosixACL_Encode(t *testing.T) {
+	data := posixACL{{tag: aclUser, perm: 5, id: 42}}.encode()
+	if len(data) != 12 {
+		t.Fatalf("len = %d, want 12", len(data))
+	}
+	if v := binary.LittleEndian.Uint32(data); v != aclXattrVersion {
+		t.Errorf("version = %d", v)
+	}
+	if tag, perm, id := binary.LittleEndian.Uint16(data[4:]), binary.LittleEndian.Uint16(data[6:]), binary.LittleEndian.Uint32(data[8:]); tag != aclUser || perm != 5 || id != 42 {
+		t.Errorf("entry = %d %d %d", tag, perm, id)
+	}
+}
+
+func noResolve(_, name string) (uint32, error) {
+	return 0, errUnresolvedName
+}
+
+func equalACL(a, b posixACL) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
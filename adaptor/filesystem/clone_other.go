@@ -0,0 +1,13 @@
+//go:build !linux
+
+package filesystem
+
+import (
+	"errors"
+	"os"
+)
+
+// cloneFile is unsupported outside Linux; callers fall back to a copy.
+func cloneFile(_, _ *os.File) error {
+	return errors.ErrUnsupported
+}
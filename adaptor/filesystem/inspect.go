@@ -0,0 +1,38 @@
+package filesystem
+
+import (
+	"errors"
+	"fmt"
+	"os"
+)
+
+// Owner returns the numeric owner and group of path without following a
+// final symlink, matching how Chown applies ownership.
+func (o *Operator) Owner(path string) (uid, gid int, err error) {
+	info, err := os.Lstat(path)
+	if err != nil {
+		return 0, 0, err
+	}
+	uid, gid, ok := fileOwner(info)
+	if !ok {
+		return 0, 0, errors.ErrUnsupported
+	}
+	return uid, gid, nil
+}
+
+// LookupOwner resolves an owner and group, given by name or numeric ID, to
+// numeric IDs. An empty name resolves to -1.
+func (o *Operator) LookupOwner(owner, group string) (uid, gid int, err error) {
+	uid, gid = -1, -1
+	if owner != "" {
+		if uid, err = o.lookupID("u", owner); err != nil {
+			return 0, 0, fmt.Errorf("filesystem: lookup user %s: %w", owner, err)
+		}
+	}
+	if group != "" {
+		if gid, err = o.lookupID("g", group); err != nil {
+			return 0, 0, fmt.Errorf("filesystem: lookup group %s: %w", group, err)
+		}
+	}
+	return uid, gid, nil
+}
@@ -1,5 +1,5 @@
 // Package filesystem provides file system operations for deployment installation:
-// copy, mkdir, chmod, chown, setfacl, semanage, and removal.
+// copy, mkdir, chmod, chown, POSIX ACLs, SELinux labels, and removal.
 package filesystem
 
 import (
@@ -7,11 +7,16 @@ import (
 	"io"
 	"os"
 	"os/exec"
-	"path/filepath"
+	"os/user"
+	"strconv"
+	"strings"
+	"sync"
 )
 
 // Operator performs file system operations during deployment installation.
-type Operator struct{}
+type Operator struct {
+	ids sync.Map // "u:name" or "g:name" -> resolved numeric ID
+}
 
 // NewOperator creates a new file system operator.
 //
@@ -73,55 +78,92 @@ func (o *Operator) Chmod(path string, mode os.FileMode) error {
 	return os.Chmod(path, mode)
 }
 
-// Chown changes the file owner and group by name.
-// Uses OS lookup to resolve names to uid/gid.
+// Chown changes the owner and group of path by name or numeric ID. Names are
+// resolved from the local user database and the change is made with lchown,
+// so a symlink is re-owned rather than its target. Names the local database
+// cannot resolve, such as directory-service accounts in a build without cgo,
+// fall back to the chown binary.
 func (o *Operator) Chown(path, owner, group string) error {
-	// Build chown argument
-	var ownerGroup string
-	switch {
-	case owner != "" && group != "":
-		ownerGroup = owner + ":" + group
-	case owner != "":
-		ownerGroup = owner
-	case group != "":
-		ownerGroup = ":" + group
-	default:
+	if owner == "" && group == "" {
 		return nil
 	}
-
-	cmd := exec.Command("chown", ownerGroup, path)
-	if output, err := cmd.CombinedOutput(); err != nil {
-		return fmt.Errorf("filesystem: chown %s %s: %s: %w", ownerGroup, path, string(output), err)
+	uid, gid := -1, -1
+	var err error
+	if owner != "" {
+		if uid, err = o.lookupID("u", owner); err != nil {
+			return o.chownExec(path, owner, group)
+		}
+	}
+	if group != "" {
+		if gid, err = o.lookupID("g", group); err != nil {
+			return o.chownExec(path, owner, group)
+		}
+	}
+	if err := os.Lchown(path, uid, gid); err != nil {
+		return fmt.Errorf("filesystem: chown %s: %w", path, err)
 	}
 	return nil
 }
 
-// SetACL applies POSIX ACL entries to a file or directory via setfacl.
-func (o *Operator) SetACL(path string, acl []string) error {
-	if len(acl) == 0 {
-		return nil
+// lookupID resolves a user ("u") or group ("g") name or numeric ID. Results
+// are cached because an install applies the same owner to many files.
+func (o *Operator) lookupID(kind, name string) (int, error) {
+	if id, err := strconv.Atoi(name); err == nil && id >= 0 {
+		return id, nil
 	}
-	aclStr := ""
-	for i, a := range acl {
-		if i > 0 {
-			aclStr += ","
+	key := kind + ":" + name
+	if id, ok := o.ids.Load(key); ok {
+		return id.(int), nil
+	}
+
+	var raw string
+	if kind == "u" {
+		u, err := user.Lookup(name)
+		if err != nil {
+			return 0, err
+		}
+		raw = u.Uid
+	} else {
+		g, err := user.LookupGroup(name)
+		if err != nil {
+			return 0, err
 		}
-		aclStr += a
+		raw = g.Gid
+	}
+	// Windows returns SIDs, which have no numeric form.
+	id, err := strconv.Atoi(raw)
+	if err != nil {
+		return 0, fmt.Errorf("%w: %s", errUnresolvedName, name)
+	}
+	o.ids.Store(key, id)
+	return id, nil
+}
+
+// chownExec changes ownership with the chown binary.
+func (o *Operator) chownExec(path, owner, group string) error {
+	ownerGroup := owner
+	if group != "" {
+		ownerGroup += ":" + group
 	}
-	cmd := exec.Command("setfacl", "--set", aclStr, path)
+	cmd := exec.Command("chown", ownerGroup, path)
 	if output, err := cmd.CombinedOutput(); err != nil {
-		return fmt.Errorf("filesystem: setfacl %s: %s: %w", path, string(output), err)
+		return fmt.Errorf("filesystem: chown %s %s: %s: %w", ownerGroup, path, string(output), err)
 	}
 	return nil
 }
 
-// SetContext applies SELinux context to a file via semanage and restorecon.
-func (o *Operator) SetContext(path string, seUser, seType, seRange string) error {
-	realPath, err := filepath.EvalSymlinks(path)
-	if err != nil {
-		return fmt.Errorf("filesystem: resolve %s: %w", path, err)
+// setACLExec applies POSIX ACL entries with the setfacl binary.
+func (o *Operator) setACLExec(path string, acl []string) error {
+	cmd := exec.Command("setfacl", "--set", strings.Join(acl, ","), path)
+	if output, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("filesystem: setfacl %s: %s: %w", path, string(output), err)
 	}
+	return nil
+}
 
+// setContextExec records an SELinux file context rule with semanage and
+// applies it with restorecon.
+func (o *Operator) setContextExec(realPath, seUser, seType, seRange string) error {
 	args := []string{"fcontext", "-a"}
 	if seUser != "" {
 		args = append(args, "-s", seUser)
@@ -144,8 +186,14 @@ func (o *Operator) SetContext(path string, seUser, seType, seRange string) error
 	return nil
 }
 
-// RemoveContext removes an SELinux context from a file via semanage.
+// RemoveContext removes the SELinux file context rule that an earlier
+// semanage fallback may have recorded for path. Labels set directly on the
+// file need no cleanup. Best effort: errors are ignored, and nothing runs when
+// semanage is not installed.
 func (o *Operator) RemoveContext(path string) error {
+	if _, err := exec.LookPath("semanage"); err != nil {
+		return nil
+	}
 	cmd := exec.Command("semanage", "fcontext", "-d", path)
 	_ = cmd.Run() // Best effort, ignore errors (matches Ruby behavior)
 	return nil
@@ -181,3 +229,20 @@ func (o *Operator) Remove(path string) error {
 func (o *Operator) RemoveAll(path string) error {
 	return os.RemoveAll(path)
 }
+
+// Symlink creates link pointing at target.
+func (o *Operator) Symlink(target, link string) error {
+	if err := os.Symlink(target, link); err != nil {
+		return fmt.Errorf("filesystem: symlink %s: %w", link, err)
+	}
+	return nil
+}
+
+// Rename atomically replaces newPath with oldPath when both are on the same
+// filesystem.
+func (o *Operator) Rename(oldPath, newPath string) error {
+	if err := os.Rename(oldPath, newPath); err != nil {
+		return fmt.Errorf("filesystem: rename %s -> %s: %w", oldPath, newPath, err)
+	}
+	return nil
+}
@@ -0,0 +1,17 @@
+//go:build !windows
+
+package filesystem
+
+import (
+	"io/fs"
+	"syscall"
+)
+
+// fileOwner returns the numeric owner of a file from its stat data.
+func fileOwner(info fs.FileInfo) (uid, gid int, ok bool) {
+	st, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return 0, 0, false
+	}
+	return int(st.Uid), int(st.Gid), true
+}
@@ -0,0 +1,10 @@
+//go:build windows
+
+package filesystem
+
+import "io/fs"
+
+// fileOwner reports no owner on Windows, where ownership is not a uid/gid pair.
+func fileOwner(fs.FileInfo) (uid, gid int, ok bool) {
+	return 0, 0, false
+}
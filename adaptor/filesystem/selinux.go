@@ -0,0 +1,34 @@
+package filesystem
+
+import (
+	"errors"
+	"strings"
+)
+
+// selinuxXattr holds a file's SELinux label.
+const selinuxXattr = "security.selinux"
+
+// mergeLabel applies the appspec context fields to the file's current label
+// (user:role:type[:range]). The role is always kept; user and range are kept
+// when not given, as semanage does when -s or -r is omitted.
+func mergeLabel(current, seUser, seType, seRange string) (string, error) {
+	if seType == "" {
+		return "", errors.New("selinux type required")
+	}
+	parts := strings.SplitN(strings.TrimRight(current, "\x00"), ":", 4)
+	if len(parts) < 3 {
+		return "", errors.New("malformed selinux label " + current)
+	}
+	if seUser != "" {
+		parts[0] = seUser
+	}
+	parts[2] = seType
+	if seRange != "" {
+		if len(parts) == 4 {
+			parts[3] = seRange
+		} else {
+			parts = append(parts, seRange)
+		}
+	}
+	return strings.Join(parts, ":"), nil
+}
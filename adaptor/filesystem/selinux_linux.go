@@ -0,0 +1,59 @@
+//go:build linux
+
+package filesystem
+
+import (
+	"fmt"
+	"path/filepath"
+	"syscall"
+)
+
+// SetContext labels the file behind path with the given SELinux context by
+// rewriting its security.selinux xattr, keeping the current role and, when
+// not given, the current user and range. Symlinks are resolved first so that
+// the target is labelled. When the label cannot be read or written directly,
+// for example because SELinux is not enabled or the policy rejects the
+// label, semanage and restorecon are used instead.
+func (o *Operator) SetContext(path string, seUser, seType, seRange string) error {
+	realPath, err := filepath.EvalSymlinks(path)
+	if err != nil {
+		return fmt.Errorf("filesystem: resolve %s: %w", path, err)
+	}
+	if err := setLabel(realPath, seUser, seType, seRange); err == nil {
+		return nil
+	}
+	return o.setContextExec(realPath, seUser, seType, seRange)
+}
+
+func setLabel(path, seUser, seType, seRange string) error {
+	current, err := getxattr(path, selinuxXattr)
+	if err != nil {
+		return err
+	}
+	label, err := mergeLabel(string(current), seUser, seType, seRange)
+	if err != nil {
+		return err
+	}
+	// libselinux stores the label with its terminating NUL.
+	return syscall.Setxattr(path, selinuxXattr, append([]byte(label), 0), 0)
+}
+
+// getxattr reads an extended attribute, growing the buffer if needed.
+func getxattr(path, name string) ([]byte, error) {
+	buf := make([]byte, 256)
+	for {
+		n, err := syscall.Getxattr(path, name, buf)
+		if err == syscall.ERANGE {
+			size, err := syscall.Getxattr(path, name, nil)
+			if err != nil {
+				return nil, err
+			}
+			buf = make([]byte, size)
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		return buf[:n], nil
+	}
+}
@@ -0,0 +1,18 @@
+//go:build !linux
+
+package filesystem
+
+import (
+	"fmt"
+	"path/filepath"
+)
+
+// SetContext applies an SELinux context with semanage and restorecon.
+// Symlinks are resolved first so that the target is labelled.
+func (o *Operator) SetContext(path string, seUser, seType, seRange string) error {
+	realPath, err := filepath.EvalSymlinks(path)
+	if err != nil {
+		return fmt.Errorf("filesystem: resolve %s: %w", path, err)
+	}
+	return o.setContextExec(realPath, seUser, seType, seRange)
+}
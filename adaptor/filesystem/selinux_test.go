@@ -0,0 +1,38 @@
+package filesystem
+
+import "testing"
+
+// TestMergeLabel verifies that the appspec context replaces the type and,
+// when given, the user and range of the current label while keeping the
+// role, including ranges that themselves contain colons.
+func TestMergeLabel(t *testing.T) {
+	tests := []struct {
+		current, user, typ, rng string
+		want                    string
+	}{
+		{"unconfined_u:object_r:user_tmp_t:s0\x00", "system_u", "httpd_sys_content_t", "s0", "system_u:object_r:httpd_sys_content_t:s0"},
+		{"unconfined_u:object_r:user_tmp_t:s0", "", "httpd_sys_content_t", "", "unconfined_u:object_r:httpd_sys_content_t:s0"},
+		{"system_u:object_r:var_t:s0-s0:c0.c1023", "", "var_log_t", "", "system_u:object_r:var_log_t:s0-s0:c0.c1023"},
+		{"system_u:object_r:var_t", "", "var_log_t", "s0", "system_u:object_r:var_log_t:s0"},
+	}
+	for _, tc := range tests {
+		got, err := mergeLabel(tc.current, tc.user, tc.typ, tc.rng)
+		if err != nil {
+			t.Fatalf("mergeLabel(%q): %v", tc.current, err)
+		}
+		if got != tc.want {
+			t.Errorf("mergeLabel(%q) = %q, want %q", tc.current, got, tc.want)
+		}
+	}
+}
+
+// TestMergeLabel_Invalid verifies that a missing type or malformed current
+// label is rejected so SetContext falls back to semanage.
+func TestMergeLabel_Invalid(t *testing.T) {
+	if _, err := mergeLabel("system_u:object_r:var_t:s0", "", "", ""); err == nil {
+		t.Error("expected error for empty type")
+	}
+	if _, err := mergeLabel("unlabeled", "", "var_t", ""); err == nil {
+		t.Error("expected error for malformed label")
+	}
+}
@@ -0,0 +1,208 @@
+//go:build !windows
+
+package filesystem
+
+import (
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// TestCopyTree_PreservesSymlinks verifies that symlinks inside a local
+// directory revision are recreated as symlinks with the same target instead
+// of being dereferenced into regular files.
+func TestCopyTree_PreservesSymlinks(t *testing.T) {
+	src := t.TempDir()
+	writeTreeFile(t, filepath.Join(src, "config.yml"), "key: value", 0o644)
+	if err := os.Symlink("config.yml", filepath.Join(src, "current.yml")); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink("/nonexistent/target", filepath.Join(src, "dangling")); err != nil {
+		t.Fatal(err)
+	}
+
+	dst := filepath.Join(t.TempDir(), "archive")
+	if err := NewOperator().CopyTree(src, dst, LinkNone); err != nil {
+		t.Fatalf("CopyTree: %v", err)
+	}
+
+	for name, want := range map[string]string{"current.yml": "config.yml", "dangling": "/nonexistent/target"} {
+		got, err := os.Readlink(filepath.Join(dst, name))
+		if err != nil {
+			t.Fatalf("readlink %s: %v", name, err)
+		}
+		if got != want {
+			t.Errorf("%s -> %q, want %q", name, got, want)
+		}
+	}
+}
+
+// TestCopyTree_PreservesModesAndMtimes verifies that file and directory
+// modes and modification times survive the copy, so incremental tooling and
+// make-style builds in hooks see the same timestamps as the source.
+func TestCopyTree_PreservesModesAndMtimes(t *testing.T) {
+	src := t.TempDir()
+	mtime := time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC)
+	script := filepath.Join(src, "scripts", "start.sh")
+	writeTreeFile(t, script, "#!/bin/sh\n", 0o750)
+	if err := os.Chtimes(script, mtime, mtime); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chmod(filepath.Join(src, "scripts"), 0o710); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chtimes(filepath.Join(src, "scripts"), mtime, mtime); err != nil {
+		t.Fatal(err)
+	}
+
+	dst := filepath.Join(t.TempDir(), "archive")
+	if err := NewOperator().CopyTree(src, dst, LinkNone); err != nil {
+		t.Fatalf("CopyTree: %v", err)
+	}
+
+	for path, wantMode := range map[string]os.FileMode{
+		filepath.Join(dst, "scripts", "start.sh"): 0o750,
+		filepath.Join(dst, "scripts"):             0o710,
+	} {
+		info, err := os.Stat(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if info.Mode().Perm() != wantMode {
+			t.Errorf("%s mode = %v, want %v", path, info.Mode().Perm(), wantMode)
+		}
+		if !info.ModTime().Equal(mtime) {
+			t.Errorf("%s mtime = %v, want %v", path, info.ModTime(), mtime)
+		}
+	}
+}
+
+// TestCopyTree_ReadOnlyDirectory verifies that a read-only source directory
+// is copied with its contents. Applying the source mode before the children
+// were written used to fail with EACCES for non-root users.
+func TestCopyTree_ReadOnlyDirectory(t *testing.T) {
+	src := t.TempDir()
+	ro := filepath.Join(src, "ro")
+	writeTreeFile(t, filepath.Join(ro, "data.txt"), "data", 0o444)
+	if err := os.Chmod(ro, 0o555); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = os.Chmod(ro, 0o755) })
+
+	dst := filepath.Join(t.TempDir(), "archive")
+	t.Cleanup(func() { _ = os.Chmod(filepath.Join(dst, "ro"), 0o755) })
+	if err := NewOperator().CopyTree(src, dst, LinkNone); err != nil {
+		t.Fatalf("CopyTree: %v", err)
+	}
+
+	got, err := os.ReadFile(filepath.Join(dst, "ro", "data.txt"))
+	if err != nil || string(got) != "data" {
+		t.Fatalf("data.txt = %q, %v", got, err)
+	}
+	info, err := os.Stat(filepath.Join(dst, "ro"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode().Perm() != 0o555 {
+		t.Errorf("ro mode = %v, want 0555", info.Mode().Perm())
+	}
+}
+
+// TestCopyTree_HardLink verifies that hardlink mode shares inodes with the
+// source when both trees live on the same filesystem.
+func TestCopyTree_HardLink(t *testing.T) {
+	base := t.TempDir()
+	src := filepath.Join(base, "src")
+	writeTreeFile(t, filepath.Join(src, "big.bin"), "payload", 0o644)
+
+	dst := filepath.Join(base, "archive")
+	if err := NewOperator().CopyTree(src, dst, LinkHard); err != nil {
+		t.Fatalf("CopyTree: %v", err)
+	}
+
+	srcInfo, err := os.Stat(filepath.Join(src, "big.bin"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	dstInfo, err := os.Stat(filepath.Join(dst, "big.bin"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !os.SameFile(srcInfo, dstInfo) {
+		t.Error("hardlink mode should share the source inode")
+	}
+}
+
+// TestCopyTree_ReflinkFallsBack verifies that reflink mode produces an
+// independent copy with identical contents, falling back to a byte copy on
+// filesystems (like tmpfs) that cannot clone extents.
+func TestCopyTree_ReflinkFallsBack(t *testing.T) {
+	src := t.TempDir()
+	writeTreeFile(t, filepath.Join(src, "app.bin"), "binary", 0o644)
+
+	dst := filepath.Join(t.TempDir(), "archive")
+	if err := NewOperator().CopyTree(src, dst, LinkReflink); err != nil {
+		t.Fatalf("CopyTree: %v", err)
+	}
+
+	got, err := os.ReadFile(filepath.Join(dst, "app.bin"))
+	if err != nil || string(got) != "binary" {
+		t.Fatalf("app.bin = %q, %v", got, err)
+	}
+	srcInfo, _ := os.Stat(filepath.Join(src, "app.bin"))
+	dstInfo, _ := os.Stat(filepath.Join(dst, "app.bin"))
+	if os.SameFile(srcInfo, dstInfo) {
+		t.Error("reflink mode must not share the source inode")
+	}
+}
+
+// TestCopyTree_SkipsSpecialFiles verifies that named pipes in the source are
+// skipped rather than blocking the copy on an open of the FIFO.
+func TestCopyTree_SkipsSpecialFiles(t *testing.T) {
+	src := t.TempDir()
+	if err := syscall.Mkfifo(filepath.Join(src, "pipe"), 0o644); err != nil {
+		t.Skipf("mkfifo unsupported: %v", err)
+	}
+	writeTreeFile(t, filepath.Join(src, "app.txt"), "app", 0o644)
+
+	dst := filepath.Join(t.TempDir(), "archive")
+	if err := NewOperator().CopyTree(src, dst, LinkNone); err != nil {
+		t.Fatalf("CopyTree: %v", err)
+	}
+	if _, err := os.Lstat(filepath.Join(dst, "pipe")); !os.IsNotExist(err) {
+		t.Errorf("named pipe should be skipped, Lstat err = %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(dst, "app.txt")); err != nil {
+		t.Errorf("regular file missing: %v", err)
+	}
+}
+
+// TestParseLinkMode verifies flag parsing, including the empty default and
+// rejection of unknown values.
+func TestParseLinkMode(t *testing.T) {
+	cases := map[string]LinkMode{"": LinkNone, "copy": LinkNone, "hardlink": LinkHard, "reflink": LinkReflink}
+	for in, want := range cases {
+		got, err := ParseLinkMode(in)
+		if err != nil || got != want {
+			t.Errorf("ParseLinkMode(%q) = %v, %v; want %v", in, got, err, want)
+		}
+	}
+	if _, err := ParseLinkMode("symlink"); err == nil {
+		t.Error("ParseLinkMode(symlink) should fail")
+	}
+}
+
+func writeTreeFile(t *testing.T, path, content string, mode os.FileMode) {
+	t.Helper()
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, []byte(content), mode); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chmod(path, mode); err != nil {
+		t.Fatal(err)
+	}
+}
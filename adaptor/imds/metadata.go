@@ -31,9 +31,13 @@ const (
 
 // IdentityDocument holds the EC2 instance identity document fields.
 type IdentityDocument struct {
-	Region     string `json:"region"`
-	AccountID  string `json:"accountId"`
-	InstanceID string `json:"instanceId"`
+	Region           string `json:"region"`
+	AccountID        string `json:"accountId"`
+	InstanceID       string `json:"instanceId"`
+	InstanceType     string `json:"instanceType"`
+	AvailabilityZone string `json:"availabilityZone"`
+	PrivateIP        string `json:"privateIp"`
+	ImageID          string `json:"imageId"`
 }
 
 // Client accesses EC2 instance metadata via IMDSv2 (with optional v1 fallback).
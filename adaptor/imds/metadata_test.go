@@ -79,7 +79,7 @@ func (f *fakeIMDS) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
-const testIdentityJSON = `{"region":"us-east-1","accountId":"123456789012","instanceId":"i-abc123"}`
+const testIdentityJSON = `{"region":"us-east-1","accountId":"123456789012","instanceId":"i-abc123","instanceType":"t3.micro","availabilityZone":"us-east-1a"}`
 
 // TestIdentityDocument verifies that a well-formed identity document JSON
 // response is parsed into the correct struct fields. This test exists because
@@ -108,6 +108,9 @@ func TestIdentityDocument(t *testing.T) {
 	if doc.InstanceID != "i-abc123" {
 		t.Errorf("InstanceID = %q, want %q", doc.InstanceID, "i-abc123")
 	}
+	if doc.InstanceType != "t3.micro" || doc.AvailabilityZone != "us-east-1a" {
+		t.Errorf("InstanceType/AvailabilityZone = %q/%q, want t3.micro/us-east-1a", doc.InstanceType, doc.AvailabilityZone)
+	}
 }
 
 // TestRegion verifies that Region delegates to IdentityDocument and returns
@@ -0,0 +1,134 @@
+package scriptrunner
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+)
+
+// EnvPolicy selects which variables of the agent's own environment hook
+// scripts inherit. The hook's variables are added on top whatever the policy.
+type EnvPolicy int
+
+const (
+	// EnvInherit passes the agent's whole environment.
+	EnvInherit EnvPolicy = iota
+	// EnvMinimal passes only the variables a process needs to run: PATH,
+	// locale, HOME and the like (see minimalEnv).
+	EnvMinimal
+	// EnvAllowlist passes only the variables named in the allowlist.
+	EnvAllowlist
+)
+
+// String returns the hook_env_policy name of p.
+func (p EnvPolicy) String() string {
+	switch p {
+	case EnvMinimal:
+		return "minimal"
+	case EnvAllowlist:
+		return "allowlist"
+	}
+	return "inherit"
+}
+
+// ParseEnvPolicy parses the hook_env_policy setting: "inherit" (the
+// default), "minimal" or "allowlist".
+//
+//	policy, err := scriptrunner.ParseEnvPolicy(cfg.HookEnvPolicy)
+func ParseEnvPolicy(s string) (EnvPolicy, error) {
+	switch s {
+	case "inherit", "":
+		return EnvInherit, nil
+	case "minimal":
+		return EnvMinimal, nil
+	case "allowlist":
+		return EnvAllowlist, nil
+	}
+	return EnvInherit, fmt.Errorf("scriptrunner: invalid hook environment policy %q (want inherit, minimal or allowlist)", s)
+}
+
+// userEnv are the variables that describe the user a process runs as. RunAs
+// leaves them to sudo, which sets them for the target user.
+var userEnv = []string{"HOME", "USER", "LOGNAME", "SHELL", "MAIL"}
+
+// SetEnvPolicy sets which variables of the agent's environment scripts
+// inherit. allowlist is used by EnvAllowlist; its entries are variable
+// names, or prefixes ending in * such as LC_*. Call it before the first Run.
+//
+//	r.SetEnvPolicy(scriptrunner.EnvAllowlist, []string{"PATH", "LANG", "LC_*"})
+func (r *Runner) SetEnvPolicy(policy EnvPolicy, allowlist []string) {
+	r.envPolicy = policy
+	r.envAllowlist = allowlist
+}
+
+// Environ returns the environment a hook with the extra variables runs
+// with: the agent's environment filtered by the policy, with extra added
+// on top, as sorted NAME=value entries.
+//
+//	env := r.Environ(map[string]string{"LIFECYCLE_EVENT": "AfterInstall"})
+func (r *Runner) Environ(extra map[string]string) []string {
+	return r.environ(extra, nil)
+}
+
+// environ is Environ with the inherited variables named in drop removed.
+func (r *Runner) environ(extra map[string]string, drop []string) []string {
+	var patterns []string
+	switch r.envPolicy {
+	case EnvMinimal:
+		patterns = minimalEnv
+	case EnvAllowlist:
+		patterns = r.envAllowlist
+	}
+
+	vars := make(map[string]string)
+	keys := make(map[string]string) // canonical name -> name as written
+	set := func(k, v string) {
+		c := canonicalEnvName(k)
+		if old, ok := keys[c]; ok {
+			delete(vars, old)
+		}
+		keys[c] = k
+		vars[k] = v
+	}
+	for _, kv := range os.Environ() {
+		k, v, ok := strings.Cut(kv, "=")
+		if !ok || k == "" {
+			continue
+		}
+		if r.envPolicy != EnvInherit && !matchEnv(patterns, k) {
+			continue
+		}
+		if matchEnv(drop, k) {
+			continue
+		}
+		set(k, v)
+	}
+	for k, v := range extra {
+		set(k, v)
+	}
+
+	env := make([]string, 0, len(vars))
+	for k, v := range vars {
+		env = append(env, k+"="+v)
+	}
+	sort.Strings(env)
+	return env
+}
+
+// matchEnv reports whether name matches one of patterns: an exact name, or
+// a prefix followed by *.
+func matchEnv(patterns []string, name string) bool {
+	name = canonicalEnvName(name)
+	for _, p := range patterns {
+		p = canonicalEnvName(p)
+		if prefix, ok := strings.CutSuffix(p, "*"); ok {
+			if strings.HasPrefix(name, prefix) {
+				return true
+			}
+		} else if p == name {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,220 @@
+package scriptrunner
+
+import (
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// Limits caps a script's resources; zero fields are unset. Its fields
+// match appspec.Limits, so callers convert one into the other.
+type Limits struct {
+	// CPUTime is the CPU time in seconds each process may use (RLIMIT_CPU).
+	CPUTime int
+	// AddressSpace caps each process's virtual memory in bytes (RLIMIT_AS).
+	AddressSpace int64
+	// OpenFiles caps each process's open file descriptors (RLIMIT_NOFILE).
+	OpenFiles int
+	// Processes caps the script's cgroup in processes (pids.max).
+	Processes int
+	// Memory caps the script's cgroup in bytes (memory.max).
+	Memory int64
+	// CPUs caps the script's cgroup in CPUs (cpu.max).
+	CPUs float64
+}
+
+// Usage is what a script run with limits used.
+type Usage struct {
+	// CPUTime is the user and system CPU time of the script and the
+	// descendants it waited for.
+	CPUTime time.Duration
+	// MaxRSS is the largest resident set among those processes, in bytes.
+	// Linux only.
+	MaxRSS int64
+	// MemoryPeak is the peak memory of the script's cgroup in bytes; zero
+	// without a cgroup or before Linux 5.19.
+	MemoryPeak int64
+	// OOMKills counts the processes the cgroup's memory limit killed.
+	OOMKills int
+	// Unenforced names the limits that could not be applied.
+	Unenforced []string
+}
+
+// String formats u for the hook log.
+//
+//	usage.String() // "cpu 1.25s, max rss 48.0 MiB, memory peak 61.2 MiB"
+func (u Usage) String() string {
+	parts := []string{"cpu " + u.CPUTime.Round(time.Millisecond).String()}
+	if u.MaxRSS > 0 {
+		parts = append(parts, "max rss "+formatBytes(u.MaxRSS))
+	}
+	if u.MemoryPeak > 0 {
+		parts = append(parts, "memory peak "+formatBytes(u.MemoryPeak))
+	}
+	if u.OOMKills > 0 {
+		parts = append(parts, fmt.Sprintf("%d killed by the memory limit", u.OOMKills))
+	}
+	s := strings.Join(parts, ", ")
+	if len(u.Unenforced) > 0 {
+		s += "; not enforced: " + strings.Join(u.Unenforced, ", ")
+	}
+	return s
+}
+
+func formatBytes(n int64) string {
+	return fmt.Sprintf("%.1f MiB", float64(n)/(1<<20))
+}
+
+// limiter applies Limits to one script run and collects its Usage.
+type limiter struct {
+	limits Limits
+	cgroup *cgroup
+	// report and reportW are the pipe on which the rlimit helper names the
+	// rlimits it could not set and a failed exec.
+	report  *os.File
+	reportW *os.File
+	usage   Usage
+}
+
+// newLimiter prepares limits for a script before it starts, creating a
+// cgroup of its own when memory, CPU or process limits need one. It
+// returns nil without limits.
+func newLimiter(limits Limits, logger *slog.Logger) *limiter {
+	if limits == (Limits{}) {
+		return nil
+	}
+	l := &limiter{limits: limits}
+	l.cgroup, l.usage.Unenforced = newCgroup(limits, logger)
+	return l
+}
+
+// prepare sets cmd up so that the script starts inside its cgroup and
+// with its rlimits already in force, through the rlimit helper. withCgroup
+// false starts it outside the cgroup, for kernels that cannot start a
+// process in one.
+func (l *limiter) prepare(cmd *exec.Cmd, withCgroup bool) {
+	if l == nil {
+		return
+	}
+	l.closeReport()
+	if withCgroup {
+		if err := l.cgroup.attach(cmd); err != nil {
+			l.dropCgroup()
+		}
+	}
+	l.wrapRlimits(cmd)
+}
+
+// dropCgroup gives up the script's cgroup and records the limits that
+// needed it as not enforced.
+func (l *limiter) dropCgroup() {
+	if l == nil || l.cgroup == nil {
+		return
+	}
+	l.cgroup.started()
+	_ = l.cgroup.remove()
+	l.cgroup = nil
+	l.usage.Unenforced = append(l.usage.Unenforced, cgroupLimits(l.limits)...)
+}
+
+// canRetryStart reports whether a failed start may be retried outside the
+// script's cgroup: starting a process in a cgroup needs Linux 5.7.
+func (l *limiter) canRetryStart() bool {
+	return l != nil && l.cgroup != nil && l.cgroup.attached()
+}
+
+// started waits for the rlimit helper to exec the script and logs the
+// limits that are not enforced. It returns the helper's exec error, after
+// which the caller must still wait for the process.
+func (l *limiter) started(r *Runner, name string) error {
+	if l == nil {
+		return nil
+	}
+	l.cgroup.started()
+	var execErr error
+	if l.report != nil {
+		_ = l.reportW.Close()
+		var failed []string
+		failed, execErr = readRlimitReport(l.report)
+		l.closeReport()
+		l.usage.Unenforced = append(l.usage.Unenforced, failed...)
+	}
+	if execErr == nil && len(l.usage.Unenforced) > 0 {
+		r.logger.Warn("hook limits not enforced", "script", name, "limits", l.usage.Unenforced)
+	}
+	return execErr
+}
+
+// readRlimitReport reads the rlimit helper's report until the exec closes
+// it. Each line is "unenforced <name>" or, when the exec failed,
+// "exec <errno>".
+func readRlimitReport(f *os.File) ([]string, error) {
+	data, _ := io.ReadAll(f)
+	var failed []string
+	for _, line := range strings.Split(string(data), "\n") {
+		if name, ok := strings.CutPrefix(line, "unenforced "); ok {
+			failed = append(failed, name)
+		} else if n, ok := strings.CutPrefix(line, "exec "); ok {
+			errno, _ := strconv.Atoi(n)
+			return failed, syscall.Errno(errno)
+		}
+	}
+	return failed, nil
+}
+
+// cgroupLimits names the limits of l that only a cgroup enforces.
+func cgroupLimits(l Limits) []string {
+	var names []string
+	if l.Memory > 0 {
+		names = append(names, "memory")
+	}
+	if l.CPUs > 0 {
+		names = append(names, "cpus")
+	}
+	if l.Processes > 0 {
+		names = append(names, "processes")
+	}
+	return names
+}
+
+// finish collects the usage of the exited script and removes its cgroup.
+func (l *limiter) finish(r *Runner, state *os.ProcessState) *Usage {
+	if l == nil {
+		return nil
+	}
+	if state != nil {
+		l.usage.CPUTime = state.UserTime() + state.SystemTime()
+		l.usage.MaxRSS = maxRSS(state)
+	}
+	l.cgroup.stats(&l.usage)
+	if err := l.cgroup.remove(); err != nil {
+		r.logger.Debug("hook cgroup left in place", "error", err)
+	}
+	return &l.usage
+}
+
+// abort removes the cgroup of a script that never started and closes the
+// rlimit helper's report pipe.
+func (l *limiter) abort() {
+	if l == nil {
+		return
+	}
+	l.cgroup.started()
+	_ = l.cgroup.remove()
+	l.closeReport()
+}
+
+// closeReport closes both ends of the rlimit helper's report pipe.
+func (l *limiter) closeReport() {
+	if l.report != nil {
+		_ = l.report.Close()
+		_ = l.reportW.Close()
+		l.report, l.reportW = nil, nil
+	}
+}
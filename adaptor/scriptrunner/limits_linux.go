@@ -0,0 +1,317 @@
+//go:build linux
+
+package scriptrunner
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+)
+
+// rlimitHelperArg as the first argument makes a binary that links this
+// package run as the rlimit helper; see init.
+const rlimitHelperArg = "-codedeploy-rlimit-helper"
+
+// rlimitResources maps the rlimit names of Limits to their resources.
+var rlimitResources = map[string]int{
+	"cpu_time":      syscall.RLIMIT_CPU,
+	"address_space": syscall.RLIMIT_AS,
+	"open_files":    syscall.RLIMIT_NOFILE,
+}
+
+// rlimitSettings returns the rlimits of limits that are set, as the
+// helper's name=value arguments, and their names. The address space limit
+// goes last: once it is in force, the helper itself may not be able to grow.
+func rlimitSettings(limits Limits) (settings, names []string) {
+	for _, rl := range []struct {
+		name  string
+		value int64
+	}{
+		{"cpu_time", int64(limits.CPUTime)},
+		{"open_files", int64(limits.OpenFiles)},
+		{"address_space", limits.AddressSpace},
+	} {
+		if rl.value > 0 {
+			settings = append(settings, rl.name+"="+strconv.FormatInt(rl.value, 10))
+			names = append(names, rl.name)
+		}
+	}
+	return settings, names
+}
+
+// init runs the process as the rlimit helper when it was started as one.
+// Running it from init lets every binary that runs scripts, tests
+// included, serve as its own helper.
+func init() {
+	if len(os.Args) > 1 && os.Args[1] == rlimitHelperArg {
+		os.Exit(rlimitHelper(os.Args[2:]))
+	}
+}
+
+// rlimitHelper sets the rlimits given as name=value arguments, up to "--",
+// as both soft and hard limit and then execs the path and argv that follow,
+// so the script starts with them in force. Rlimits it cannot set, and a
+// failed exec, are reported on file descriptor 3; the exec closes it.
+func rlimitHelper(args []string) int {
+	syscall.CloseOnExec(3)
+	report := os.NewFile(3, "rlimit-report")
+	sep := slices.Index(args, "--")
+	if sep < 0 || len(args) < sep+3 {
+		fmt.Fprintln(os.Stderr, "rlimit helper: usage: name=value... -- path argv...")
+		return 127
+	}
+	path, argv, env := args[sep+1], args[sep+2:], os.Environ()
+	for _, setting := range args[:sep] {
+		name, v, _ := strings.Cut(setting, "=")
+		value, err := strconv.ParseUint(v, 10, 64)
+		resource, ok := rlimitResources[name]
+		if err != nil || !ok || syscall.Setrlimit(resource, &syscall.Rlimit{Cur: value, Max: value}) != nil {
+			fmt.Fprintf(report, "unenforced %s\n", name)
+		}
+	}
+	err := syscall.Exec(path, argv, env)
+	errno, ok := err.(syscall.Errno)
+	if !ok {
+		errno = syscall.EINVAL
+	}
+	fmt.Fprintf(report, "exec %d\n", int(errno))
+	return 127
+}
+
+// wrapRlimits makes cmd start through the rlimit helper, which applies the
+// script's rlimits before it execs the script. The script's path is already
+// resolved, so the helper needs no PATH lookup. Without its own executable
+// the helper cannot run, and the rlimits are not enforced. For RunAs the
+// command is sudo, so the helper runs as the agent before sudo switches
+// users, and the target user never has to execute the agent's binary.
+func (l *limiter) wrapRlimits(cmd *exec.Cmd) {
+	settings, names := rlimitSettings(l.limits)
+	if len(settings) == 0 || cmd.Err != nil {
+		return
+	}
+	self, err := os.Executable()
+	if err == nil {
+		l.report, l.reportW, err = os.Pipe()
+	}
+	if err != nil {
+		l.usage.Unenforced = append(l.usage.Unenforced, names...)
+		return
+	}
+	args := append([]string{self, rlimitHelperArg}, settings...)
+	cmd.Args = append(append(args, "--", cmd.Path), cmd.Args...)
+	cmd.Path = self
+	cmd.ExtraFiles = []*os.File{l.reportW}
+}
+
+// maxRSS returns the largest resident set of the exited process and the
+// descendants it waited for; Linux reports it in KiB.
+func maxRSS(state *os.ProcessState) int64 {
+	if ru, ok := state.SysUsage().(*syscall.Rusage); ok {
+		return int64(ru.Maxrss) * 1024
+	}
+	return 0
+}
+
+// cgroup is the cgroup v2 a script runs in. fd is open on its directory
+// while the script starts.
+type cgroup struct {
+	dir string
+	fd  *os.File
+}
+
+// cgroupParent is the directory hook cgroups are created in, with the
+// controllers enabled for them, found once per agent.
+var cgroupParent struct {
+	once        sync.Once
+	dir         string
+	controllers []string
+	err         error
+}
+
+// newCgroup creates the cgroup for a script with memory, CPU or process
+// limits. Without a writable cgroup v2 hierarchy it returns nil and the
+// names of the limits that need one. The first call sets up the parent
+// cgroup and logs to logger if that moves the agent.
+func newCgroup(limits Limits, logger *slog.Logger) (*cgroup, []string) {
+	settings := []struct {
+		name, controller, file, value string
+	}{
+		{"memory", "memory", "memory.max", strconv.FormatInt(limits.Memory, 10)},
+		{"cpus", "cpu", "cpu.max", fmt.Sprintf("%d 100000", max(int(limits.CPUs*100000), 1000))},
+		{"processes", "pids", "pids.max", strconv.Itoa(limits.Processes)},
+	}
+	set := []bool{limits.Memory > 0, limits.CPUs > 0, limits.Processes > 0}
+	if !slices.Contains(set, true) {
+		return nil, nil
+	}
+	var unenforced []string
+
+	cgroupParent.once.Do(func() {
+		cgroupParent.dir, cgroupParent.controllers, cgroupParent.err = setupCgroupParent(logger)
+	})
+	var c *cgroup
+	if cgroupParent.err == nil {
+		if dir, err := os.MkdirTemp(cgroupParent.dir, "hook-"); err == nil {
+			c = &cgroup{dir: dir}
+		}
+	}
+	for i, s := range settings {
+		if !set[i] {
+			continue
+		}
+		if c == nil || !slices.Contains(cgroupParent.controllers, s.controller) ||
+			os.WriteFile(filepath.Join(c.dir, s.file), []byte(s.value), 0o644) != nil {
+			unenforced = append(unenforced, s.name)
+		}
+	}
+	return c, unenforced
+}
+
+// setupCgroupParent finds the agent's own cgroup v2 and enables the
+// memory, cpu and pids controllers for its children. cgroup v2 only lets
+// a cgroup without processes of its own do that, so the agent moves itself
+// into a leaf cgroup named agent if it must, and logs the move: the service
+// manager must have delegated the cgroup to the agent, as systemd does with
+// Delegate=yes, or it may move the agent back.
+func setupCgroupParent(logger *slog.Logger) (string, []string, error) {
+	mount, err := cgroup2Mount()
+	if err != nil {
+		return "", nil, err
+	}
+	data, err := os.ReadFile("/proc/self/cgroup")
+	if err != nil {
+		return "", nil, err
+	}
+	var rel string
+	for _, line := range strings.Split(string(data), "\n") {
+		if r, ok := strings.CutPrefix(line, "0::"); ok {
+			rel = r
+		}
+	}
+	if rel == "" {
+		return "", nil, errors.New("agent is not in a cgroup v2 hierarchy")
+	}
+	dir := filepath.Join(mount, rel)
+	avail, err := os.ReadFile(filepath.Join(dir, "cgroup.controllers"))
+	if err != nil {
+		return "", nil, err
+	}
+	var wanted []string
+	for _, c := range []string{"memory", "cpu", "pids"} {
+		if slices.Contains(strings.Fields(string(avail)), c) {
+			wanted = append(wanted, c)
+		}
+	}
+	if len(wanted) == 0 {
+		return "", nil, fmt.Errorf("no memory, cpu or pids controller in %s", dir)
+	}
+	if enabled := enableControllers(dir, wanted); len(enabled) > 0 {
+		return dir, enabled, nil
+	}
+	leaf := filepath.Join(dir, "agent")
+	if err := os.Mkdir(leaf, 0o755); err != nil && !os.IsExist(err) {
+		return "", nil, err
+	}
+	if err := os.WriteFile(filepath.Join(leaf, "cgroup.procs"), []byte(strconv.Itoa(os.Getpid())), 0o644); err != nil {
+		return "", nil, fmt.Errorf("move agent to %s: %w", leaf, err)
+	}
+	logger.Info("moved agent into a leaf cgroup so hook scripts can get cgroups of their own", "cgroup", leaf)
+	if enabled := enableControllers(dir, wanted); len(enabled) > 0 {
+		return dir, enabled, nil
+	}
+	return "", nil, fmt.Errorf("cannot enable cgroup controllers in %s", dir)
+}
+
+// enableControllers enables each of controllers for the children of dir
+// and returns those it could.
+func enableControllers(dir string, controllers []string) []string {
+	var enabled []string
+	for _, c := range controllers {
+		if os.WriteFile(filepath.Join(dir, "cgroup.subtree_control"), []byte("+"+c), 0o644) == nil {
+			enabled = append(enabled, c)
+		}
+	}
+	return enabled
+}
+
+// cgroup2Mount returns where the cgroup v2 hierarchy is mounted.
+func cgroup2Mount() (string, error) {
+	data, err := os.ReadFile("/proc/self/mountinfo")
+	if err != nil {
+		return "", err
+	}
+	for _, line := range strings.Split(string(data), "\n") {
+		pre, post, ok := strings.Cut(line, " - ")
+		if fields := strings.Fields(pre); ok && len(fields) >= 5 && strings.HasPrefix(post, "cgroup2 ") {
+			return fields[4], nil
+		}
+	}
+	return "", errors.New("cgroup v2 is not mounted")
+}
+
+// attach makes cmd start directly inside the cgroup, so the script never
+// runs outside it. cmd must already have its SysProcAttr.
+func (c *cgroup) attach(cmd *exec.Cmd) error {
+	if c == nil {
+		return nil
+	}
+	fd, err := os.Open(c.dir)
+	if err != nil {
+		return err
+	}
+	c.fd = fd
+	cmd.SysProcAttr.UseCgroupFD = true
+	cmd.SysProcAttr.CgroupFD = int(fd.Fd())
+	return nil
+}
+
+// attached reports whether a command was set up to start in the cgroup.
+func (c *cgroup) attached() bool {
+	return c != nil && c.fd != nil
+}
+
+// started closes the cgroup directory once the script has started.
+func (c *cgroup) started() {
+	if c != nil && c.fd != nil {
+		_ = c.fd.Close()
+		c.fd = nil
+	}
+}
+
+// stats adds the cgroup's peak memory and OOM kills to u.
+func (c *cgroup) stats(u *Usage) {
+	if c == nil {
+		return
+	}
+	if data, err := os.ReadFile(filepath.Join(c.dir, "memory.peak")); err == nil {
+		u.MemoryPeak, _ = strconv.ParseInt(string(bytes.TrimSpace(data)), 10, 64)
+	}
+	if f, err := os.Open(filepath.Join(c.dir, "memory.events")); err == nil {
+		defer f.Close()
+		scanner := bufio.NewScanner(f)
+		for scanner.Scan() {
+			if n, ok := strings.CutPrefix(scanner.Text(), "oom_kill "); ok {
+				u.OOMKills, _ = strconv.Atoi(n)
+			}
+		}
+	}
+}
+
+// remove deletes the cgroup, which fails while processes the script left
+// running are still in it.
+func (c *cgroup) remove() error {
+	if c == nil {
+		return nil
+	}
+	return os.Remove(c.dir)
+}
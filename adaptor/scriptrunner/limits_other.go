@@ -0,0 +1,48 @@
+//go:build !linux
+
+package scriptrunner
+
+import (
+	"log/slog"
+	"os"
+	"os/exec"
+)
+
+// cgroup is never created off Linux.
+type cgroup struct{}
+
+// newCgroup returns the memory, CPU and process limits, which need Linux
+// cgroups.
+func newCgroup(limits Limits, _ *slog.Logger) (*cgroup, []string) {
+	return nil, cgroupLimits(limits)
+}
+
+func (c *cgroup) attach(*exec.Cmd) error { return nil }
+func (c *cgroup) attached() bool         { return false }
+func (c *cgroup) started()               {}
+func (c *cgroup) stats(*Usage)           {}
+func (c *cgroup) remove() error          { return nil }
+
+// wrapRlimits records the rlimits that are set as not enforced: the rlimit
+// helper is Linux only.
+func (l *limiter) wrapRlimits(*exec.Cmd) {
+	var failed []string
+	for _, rl := range []struct {
+		name  string
+		value int64
+	}{
+		{"cpu_time", int64(l.limits.CPUTime)},
+		{"address_space", l.limits.AddressSpace},
+		{"open_files", int64(l.limits.OpenFiles)},
+	} {
+		if rl.value > 0 {
+			failed = append(failed, rl.name)
+		}
+	}
+	l.usage.Unenforced = append(l.usage.Unenforced, failed...)
+}
+
+// maxRSS returns 0: resident set sizes are only recorded on Linux.
+func maxRSS(*os.ProcessState) int64 {
+	return 0
+}
@@ -0,0 +1,125 @@
+//go:build linux
+
+package scriptrunner
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"os"
+	"os/exec"
+	"slices"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// TestRunLimits_Rlimits verifies that a script's rlimits are in force from
+// its first instruction, since the rlimit helper sets them before the exec,
+// and that its usage is recorded.
+func TestRunLimits_Rlimits(t *testing.T) {
+	r := NewRunner(slog.Default())
+	limits := Limits{CPUTime: 30, OpenFiles: 64, AddressSpace: 1 << 30}
+	result, err := r.RunCommand(context.Background(), "", "ulimit -n; ulimit -t; ulimit -v", nil, 60, 0, limits)
+	if err != nil {
+		t.Fatalf("RunCommand: %v", err)
+	}
+	if want := "64\n30\n1048576\n"; result.Stdout != want {
+		t.Errorf("Stdout = %q, want %q", result.Stdout, want)
+	}
+	if result.Usage == nil || len(result.Usage.Unenforced) > 0 {
+		t.Fatalf("Usage = %+v, want recorded with every limit enforced", result.Usage)
+	}
+	if result.Usage.MaxRSS <= 0 {
+		t.Errorf("MaxRSS = %d, want the shell's resident set", result.Usage.MaxRSS)
+	}
+}
+
+// TestRunLimits_HelperExecFailure verifies that when the rlimit helper
+// cannot exec the script, the run fails to start with the exec's error
+// instead of reporting the helper's exit status as the script's.
+func TestRunLimits_HelperExecFailure(t *testing.T) {
+	r := NewRunner(slog.Default())
+	_, err := r.RunCommand(context.Background(), "/nonexistent/sh", "true", nil, 60, 0, Limits{OpenFiles: 64})
+	if !errors.Is(err, syscall.ENOENT) {
+		t.Errorf("err = %v, want ENOENT from the exec", err)
+	}
+}
+
+// TestWrapRlimits_RunsBeforeSudo verifies that the rlimit helper wraps the
+// sudo of a RunAs script rather than running under it, so it runs as the
+// agent and the target user needs no access to the agent's binary.
+func TestWrapRlimits_RunsBeforeSudo(t *testing.T) {
+	self, err := os.Executable()
+	if err != nil {
+		t.Skip("no executable:", err)
+	}
+	cmd := &exec.Cmd{Path: "/usr/bin/sudo", Args: []string{"sudo", "--preserve-env", "-u", "deploy", "/srv/hook.sh"}}
+	l := &limiter{limits: Limits{OpenFiles: 64}}
+	l.wrapRlimits(cmd)
+	defer l.closeReport()
+
+	want := []string{self, rlimitHelperArg, "open_files=64", "--", "/usr/bin/sudo", "sudo", "--preserve-env", "-u", "deploy", "/srv/hook.sh"}
+	if cmd.Path != self || !slices.Equal(cmd.Args, want) {
+		t.Errorf("cmd = %s %q, want %s %q", cmd.Path, cmd.Args, self, want)
+	}
+}
+
+// TestRunLimits_None verifies that scripts without limits record no usage,
+// so their hook logs are unchanged.
+func TestRunLimits_None(t *testing.T) {
+	r := NewRunner(slog.Default())
+	result, err := r.RunCommand(context.Background(), "", "true", nil, 60, 0, Limits{})
+	if err != nil {
+		t.Fatalf("RunCommand: %v", err)
+	}
+	if result.Usage != nil {
+		t.Errorf("Usage = %+v, want nil", result.Usage)
+	}
+}
+
+// TestRunLimits_CgroupMemory verifies that a script over its memory limit
+// is killed inside its cgroup and the kill is recorded. It needs a
+// writable cgroup v2 hierarchy with the memory controller, and like the
+// agent it may move the test process into a child cgroup and enable
+// controllers for its parent, so it only runs with CODEDEPLOY_TEST_CGROUP=1.
+func TestRunLimits_CgroupMemory(t *testing.T) {
+	if os.Getenv("CODEDEPLOY_TEST_CGROUP") != "1" {
+		t.Skip("set CODEDEPLOY_TEST_CGROUP=1 to run hook scripts in cgroups of the test process")
+	}
+	limits := Limits{Memory: 32 << 20}
+	c, unenforced := newCgroup(limits, slog.Default())
+	if slices.Contains(unenforced, "memory") {
+		t.Skip("no writable cgroup v2 memory controller")
+	}
+	_ = c.remove()
+
+	r := NewRunner(slog.Default())
+	result, err := r.RunCommand(context.Background(), "", "head -c 200000000 /dev/zero | sort >/dev/null", nil, 60, 0, limits)
+	if err != nil {
+		t.Fatalf("RunCommand: %v", err)
+	}
+	if result.ExitCode == 0 || result.Usage == nil || result.Usage.OOMKills == 0 {
+		t.Errorf("result = %+v, usage = %+v; want an OOM kill", result, result.Usage)
+	}
+}
+
+// TestCgroupLimits verifies that the process limit counts among the limits
+// that need a cgroup, so it is reported as not enforced without pids.max
+// rather than left to RLIMIT_NPROC, which root ignores.
+func TestCgroupLimits(t *testing.T) {
+	got := cgroupLimits(Limits{Memory: 1, CPUs: 1, Processes: 1, OpenFiles: 1, CPUTime: 1})
+	if want := []string{"memory", "cpus", "processes"}; !slices.Equal(got, want) {
+		t.Errorf("cgroupLimits = %v, want %v", got, want)
+	}
+}
+
+// TestUsageString verifies the hook log line for a script's usage,
+// including the limits that could not be applied.
+func TestUsageString(t *testing.T) {
+	u := Usage{CPUTime: 1250 * time.Millisecond, MaxRSS: 48 << 20, MemoryPeak: 64 << 20, OOMKills: 1, Unenforced: []string{"cpus"}}
+	want := "cpu 1.25s, max rss 48.0 MiB, memory peak 64.0 MiB, 1 killed by the memory limit; not enforced: cpus"
+	if got := u.String(); got != want {
+		t.Errorf("String() = %q, want %q", got, want)
+	}
+}
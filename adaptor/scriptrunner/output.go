@@ -0,0 +1,130 @@
+package scriptrunner
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+	"sync"
+	"time"
+)
+
+// defaultOutputWait is how long a script's output may stay open after the
+// script exits before the processes holding it are reported.
+const defaultOutputWait = 10 * time.Second
+
+// SetOutputWait sets how long to wait, once a script has exited, for the
+// processes it started to close its stdout and stderr. Processes still
+// holding them after wait are reported in the Result and killed, unless
+// leaveRunning is set. Call it before the first Run.
+//
+//	r.SetOutputWait(5*time.Second, false)
+func (r *Runner) SetOutputWait(wait time.Duration, leaveRunning bool) {
+	r.outputWait = wait
+	r.leaveRunning = leaveRunning
+}
+
+// output captures one of a script's output streams through a pipe the
+// agent reads itself, rather than one exec.Cmd copies, so that a process
+// the script left holding the pipe cannot block Wait.
+type output struct {
+	r, w   *os.File
+	id     string // pipeID of the pipe, empty where unsupported
+	closed chan struct{}
+
+	mu       sync.Mutex
+	buf      bytes.Buffer
+	lw       limitedWriter
+	detached bool
+}
+
+// newOutput creates the pipe. Its write end goes to the script.
+func newOutput() (*output, error) {
+	r, w, err := os.Pipe()
+	if err != nil {
+		return nil, fmt.Errorf("create output pipe: %w", err)
+	}
+	o := &output{r: r, w: w, id: pipeID(r), closed: make(chan struct{})}
+	o.lw = limitedWriter{w: &o.buf, remaining: maxLogBytes}
+	return o, nil
+}
+
+// start closes the agent's write end, once the script has its own, and
+// reads until every process holding the pipe has closed it.
+func (o *output) start() {
+	o.w.Close()
+	go func() {
+		_, _ = io.Copy(o, o.r)
+		o.r.Close()
+		close(o.closed)
+	}()
+}
+
+// abort closes both ends when the script never started.
+func (o *output) abort() {
+	o.w.Close()
+	o.r.Close()
+}
+
+func (o *output) Write(p []byte) (int, error) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if o.detached {
+		return len(p), nil
+	}
+	return o.lw.Write(p)
+}
+
+// detach stops capturing and returns the output captured so far. Reading
+// goes on in the background, discarding, so processes still writing to
+// the pipe do not get SIGPIPE.
+func (o *output) detach() string {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.detached = true
+	return o.buf.String()
+}
+
+// awaitOutputs waits up to the output wait for the outputs to be closed.
+// If some are still open it returns the PIDs of the processes holding
+// them, after killing those processes and what is left of the script's
+// process group unless they are left running.
+func (r *Runner) awaitOutputs(name string, pid int, outputs ...*output) (bool, []int) {
+	timer := time.NewTimer(r.outputWait)
+	defer timer.Stop()
+	for _, o := range outputs {
+		select {
+		case <-o.closed:
+			continue
+		case <-timer.C:
+		}
+		var holders []int
+		for _, o := range outputs {
+			holders = append(holders, pipeHolders(o.id)...)
+		}
+		slices.Sort(holders)
+		holders = slices.Compact(holders)
+		r.logger.Warn("script left its output open", "script", name, "pids", holders, "leave_running", r.leaveRunning)
+		if !r.leaveRunning {
+			r.killOutputHolders(pid, holders)
+		}
+		return true, holders
+	}
+	return false, nil
+}
+
+// killOutputHolders kills the script's process group and the processes
+// holding its output, which may have left the group.
+func (r *Runner) killOutputHolders(pid int, holders []int) {
+	_ = killProcessGroup(pid) // the group may already be gone
+	for _, h := range holders {
+		p, err := os.FindProcess(h)
+		if err == nil {
+			err = p.Kill()
+		}
+		if err != nil && err != os.ErrProcessDone {
+			r.logger.Warn("cannot kill process holding script output", "pid", h, "error", err)
+		}
+	}
+}
@@ -0,0 +1,56 @@
+//go:build linux
+
+package scriptrunner
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"syscall"
+)
+
+// pipeID returns the name /proc gives descriptors open on the pipe f.
+func pipeID(f *os.File) string {
+	info, err := f.Stat()
+	if err != nil {
+		return ""
+	}
+	st, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return ""
+	}
+	return fmt.Sprintf("pipe:[%d]", st.Ino)
+}
+
+// pipeHolders returns the PIDs of processes other than the agent with a
+// descriptor open on the pipe id, found by scanning /proc. Processes the
+// agent may not inspect are missed.
+func pipeHolders(id string) []int {
+	if id == "" {
+		return nil
+	}
+	dirs, err := os.ReadDir("/proc")
+	if err != nil {
+		return nil
+	}
+	self := os.Getpid()
+	var pids []int
+	for _, d := range dirs {
+		pid, err := strconv.Atoi(d.Name())
+		if err != nil || pid == self {
+			continue
+		}
+		fds, err := os.ReadDir(filepath.Join("/proc", d.Name(), "fd"))
+		if err != nil {
+			continue
+		}
+		for _, fd := range fds {
+			if link, err := os.Readlink(filepath.Join("/proc", d.Name(), "fd", fd.Name())); err == nil && link == id {
+				pids = append(pids, pid)
+				break
+			}
+		}
+	}
+	return pids
+}
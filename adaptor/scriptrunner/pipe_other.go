@@ -0,0 +1,15 @@
+//go:build !linux
+
+package scriptrunner
+
+import "os"
+
+// pipeID returns an empty id: finding a pipe's holders needs Linux's /proc.
+func pipeID(*os.File) string {
+	return ""
+}
+
+// pipeHolders returns nil; see pipeID.
+func pipeHolders(string) []int {
+	return nil
+}
@@ -0,0 +1,104 @@
+package scriptrunner
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// PreflightError reports a script that cannot be started, found by the
+// checks Run makes before starting it.
+type PreflightError struct {
+	// Path is the script's path.
+	Path string
+	// Missing is true if the script does not exist; any other failure
+	// means it exists but cannot be executed.
+	Missing bool
+	// Reason says what is wrong, phrased to follow the script's name.
+	Reason string
+}
+
+func (e *PreflightError) Error() string {
+	return fmt.Sprintf("script %s: %s", e.Path, e.Reason)
+}
+
+// SetChmodScripts sets whether scripts without executable bits are made
+// executable, as the Ruby agent does, rather than failing preflight. It is
+// on by default. Call it before the first Run.
+//
+//	r.SetChmodScripts(false)
+func (r *Runner) SetChmodScripts(chmod bool) {
+	r.noChmod = !chmod
+}
+
+// maxShebang is how much of a script is read to find its #! line, which
+// kernels cap at a few hundred bytes.
+const maxShebang = 256
+
+// preflight checks that scriptPath can be executed: it exists, is a
+// regular file and, on Unix, is executable (made so when chmod is on), is
+// not on a noexec mount, and has a #! line naming an executable
+// interpreter without a trailing carriage return. Failures are
+// *PreflightError.
+func (r *Runner) preflight(scriptPath string) error {
+	fail := func(format string, args ...any) error {
+		return &PreflightError{Path: scriptPath, Reason: fmt.Sprintf(format, args...)}
+	}
+	info, err := os.Stat(scriptPath)
+	if os.IsNotExist(err) {
+		return &PreflightError{Path: scriptPath, Missing: true, Reason: "does not exist"}
+	}
+	if err != nil {
+		return err
+	}
+	if !info.Mode().IsRegular() {
+		return fail("is not a regular file (%s)", info.Mode().Type())
+	}
+	if !unixScripts {
+		return nil
+	}
+
+	if info.Mode()&0o111 == 0 {
+		if r.noChmod {
+			return fail("is not executable (mode %s)", info.Mode().Perm())
+		}
+		if err := os.Chmod(scriptPath, info.Mode()|0o111); err != nil {
+			return fail("is not executable and cannot be made so: %v", err)
+		}
+		r.logger.Warn("made script executable", "path", scriptPath)
+	}
+	if noexecMount(scriptPath) {
+		return fail("is on a filesystem mounted noexec")
+	}
+
+	f, err := os.Open(scriptPath)
+	if err != nil {
+		return fail("cannot be read: %v", err)
+	}
+	defer f.Close()
+	line, err := bufio.NewReaderSize(f, maxShebang).ReadSlice('\n')
+	if !bytes.HasPrefix(line, []byte("#!")) {
+		return nil // a binary; run reports text files the kernel rejects
+	}
+	if err != nil && len(line) >= maxShebang {
+		return fail("has a #! line longer than %d bytes", maxShebang)
+	}
+	line = bytes.TrimSuffix(line, []byte("\n"))
+	if bytes.HasSuffix(line, []byte("\r")) {
+		return fail("has Windows (CRLF) line endings; the #! line names %q", strings.TrimSpace(string(line[2:])))
+	}
+	fields := strings.Fields(string(line[2:]))
+	if len(fields) == 0 {
+		return fail("has a #! line naming no interpreter")
+	}
+	interp, err := os.Stat(fields[0])
+	if err != nil {
+		return fail("names interpreter %s, which does not exist", fields[0])
+	}
+	if !interp.Mode().IsRegular() || interp.Mode()&0o111 == 0 {
+		return fail("names interpreter %s, which is not executable", fields[0])
+	}
+	return nil
+}
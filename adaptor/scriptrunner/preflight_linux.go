@@ -0,0 +1,15 @@
+//go:build linux
+
+package scriptrunner
+
+import "syscall"
+
+// noexecMount reports whether path is on a filesystem mounted noexec,
+// where the kernel refuses to execute it whatever its mode.
+func noexecMount(path string) bool {
+	var st syscall.Statfs_t
+	if err := syscall.Statfs(path, &st); err != nil {
+		return false
+	}
+	return st.Flags&syscall.MS_NOEXEC != 0
+}
@@ -0,0 +1,8 @@
+//go:build !linux
+
+package scriptrunner
+
+// noexecMount reports false: mount flags are only checked on Linux.
+func noexecMount(string) bool {
+	return false
+}
@@ -0,0 +1,81 @@
+//go:build !windows
+
+package scriptrunner
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// TestPreflight verifies each check Run makes before starting a script,
+// so that scripts which cannot start are reported as missing or not
+// executable, with the reason, instead of as a generic start failure.
+func TestPreflight(t *testing.T) {
+	dir := t.TempDir()
+	write := func(name, body string, mode os.FileMode) string {
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, []byte(body), mode); err != nil {
+			t.Fatal(err)
+		}
+		return path
+	}
+	interp := write("interp", "plain text\n", 0o644)
+
+	tests := []struct {
+		name    string
+		path    string
+		missing bool
+		reason  string // substring; empty means the script passes
+	}{
+		{"ok", write("ok.sh", "#!/bin/sh\necho ok\n", 0o755), false, ""},
+		{"missing", filepath.Join(dir, "nope.sh"), true, "does not exist"},
+		{"directory", dir, false, "is not a regular file"},
+		{"not executable", write("plain.sh", "#!/bin/sh\n", 0o644), false, "is not executable (mode -rw-r--r--)"},
+		{"crlf", write("crlf.sh", "#!/bin/sh\r\necho ok\r\n", 0o755), false, "CRLF"},
+		{"no interpreter", write("noint.sh", "#!/no/such/shell\n", 0o755), false, "/no/such/shell, which does not exist"},
+		{"interpreter not executable", write("badint.sh", "#!"+interp+" -x\n", 0o755), false, interp + ", which is not executable"},
+		{"empty shebang", write("empty.sh", "#!\n", 0o755), false, "naming no interpreter"},
+	}
+
+	r := NewRunner(slog.Default())
+	r.SetChmodScripts(false)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := r.preflight(tt.path)
+			if tt.reason == "" {
+				if err != nil {
+					t.Fatalf("preflight: %v", err)
+				}
+				return
+			}
+			var pe *PreflightError
+			if !errors.As(err, &pe) {
+				t.Fatalf("err = %v, want *PreflightError", err)
+			}
+			if pe.Missing != tt.missing || !strings.Contains(pe.Reason, tt.reason) {
+				t.Errorf("got Missing=%v Reason=%q, want Missing=%v and %q", pe.Missing, pe.Reason, tt.missing, tt.reason)
+			}
+		})
+	}
+}
+
+// TestRunNoShebang verifies that a text script without a #! line, which
+// the kernel refuses to execute, is reported as a PreflightError rather
+// than a generic start failure.
+func TestRunNoShebang(t *testing.T) {
+	script := filepath.Join(t.TempDir(), "bare.sh")
+	if err := os.WriteFile(script, []byte("echo ok\n"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	r := NewRunner(slog.Default())
+	_, err := r.Run(context.Background(), script, nil, 10, 0, Limits{})
+	var pe *PreflightError
+	if !errors.As(err, &pe) || pe.Missing || !strings.Contains(pe.Reason, "#! line") {
+		t.Errorf("err = %v, want a PreflightError about the #! line", err)
+	}
+}
@@ -7,14 +7,50 @@ import (
 	"syscall"
 )
 
+// unixScripts is true where scripts are started through their executable
+// bits and #! line, which preflight then checks.
+const unixScripts = true
+
+// defaultShell runs inline hook commands when the appspec and the agent
+// config name no shell.
+const defaultShell = "/bin/sh"
+
+// minimalEnv are the variables EnvMinimal passes through.
+var minimalEnv = []string{"PATH", "LANG", "LANGUAGE", "LC_*", "TZ", "HOME", "USER", "LOGNAME", "SHELL", "TMPDIR", "TERM"}
+
+// canonicalEnvName returns name as environment lookups compare it; Unix
+// names are case-sensitive.
+func canonicalEnvName(name string) string {
+	return name
+}
+
 // setSysProcAttr configures the command to run in its own process group
 // so the entire group can be killed on timeout.
 func setSysProcAttr(cmd *exec.Cmd) {
 	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
 }
 
-// killProcessGroup sends SIGTERM to the process group identified by pid.
+// TermSignal and KillSignal name the signals a timed-out script's process
+// group gets, for logs and diagnostics.
+const (
+	TermSignal = "SIGTERM"
+	KillSignal = "SIGKILL"
+)
+
+// terminateProcessGroup asks the process group identified by pid to exit.
 // The negative pid targets the entire group rather than a single process.
-func killProcessGroup(pid int) error {
+func terminateProcessGroup(pid int) error {
 	return syscall.Kill(-pid, syscall.SIGTERM)
 }
+
+// killProcessGroup kills the process group identified by pid.
+func killProcessGroup(pid int) error {
+	return syscall.Kill(-pid, syscall.SIGKILL)
+}
+
+// killExitedProcessGroup kills what is left of the process group whose
+// leader pid has exited. The group keeps its ID while any member lives, so
+// the ID cannot have been reused.
+func killExitedProcessGroup(pid int) error {
+	return killProcessGroup(pid)
+}
@@ -5,17 +5,67 @@ package scriptrunner
 import (
 	"fmt"
 	"os/exec"
+	"strings"
 	"syscall"
 )
 
+// unixScripts is false: Windows starts scripts by their extension, so
+// preflight only checks that they exist and are regular files.
+const unixScripts = false
+
+// defaultShell runs inline hook commands when the appspec and the agent
+// config name no shell.
+const defaultShell = "powershell.exe"
+
+// minimalEnv are the variables EnvMinimal passes through. Windows programs
+// fail in odd ways without SystemRoot and the profile directories.
+var minimalEnv = []string{
+	"PATH", "PATHEXT", "SystemRoot", "SystemDrive", "windir", "ComSpec",
+	"TEMP", "TMP", "USERPROFILE", "USERNAME", "APPDATA", "LOCALAPPDATA",
+	"ProgramData", "ProgramFiles", "ProgramFiles(x86)", "CommonProgramFiles",
+	"COMPUTERNAME", "NUMBER_OF_PROCESSORS", "PROCESSOR_ARCHITECTURE", "OS", "PSModulePath",
+}
+
+// canonicalEnvName returns name as environment lookups compare it; Windows
+// names are case-insensitive.
+func canonicalEnvName(name string) string {
+	return strings.ToUpper(name)
+}
+
 // setSysProcAttr configures the command to run in its own process group
 // using CREATE_NEW_PROCESS_GROUP so the entire tree can be killed on timeout.
 func setSysProcAttr(cmd *exec.Cmd) {
 	cmd.SysProcAttr = &syscall.SysProcAttr{CreationFlags: syscall.CREATE_NEW_PROCESS_GROUP}
 }
 
+// TermSignal and KillSignal name what a timed-out script's process group
+// gets, for logs and diagnostics. Console programs see CTRL_BREAK_EVENT as
+// they see Ctrl+Break; the kill is TerminateProcess through taskkill.
+const (
+	TermSignal = "CTRL_BREAK_EVENT"
+	KillSignal = "TerminateProcess"
+)
+
+var procGenerateConsoleCtrlEvent = syscall.NewLazyDLL("kernel32.dll").NewProc("GenerateConsoleCtrlEvent")
+
+// terminateProcessGroup sends CTRL_BREAK_EVENT to the process group
+// identified by pid, which CREATE_NEW_PROCESS_GROUP made its own.
+func terminateProcessGroup(pid int) error {
+	if r, _, err := procGenerateConsoleCtrlEvent.Call(syscall.CTRL_BREAK_EVENT, uintptr(pid)); r == 0 {
+		return err
+	}
+	return nil
+}
+
 // killProcessGroup terminates the process tree rooted at pid using taskkill.
 // The /T flag kills child processes and /F forces termination.
 func killProcessGroup(pid int) error {
 	return exec.Command("taskkill", "/T", "/F", "/PID", fmt.Sprint(pid)).Run()
 }
+
+// killExitedProcessGroup does nothing: once the root of a process tree has
+// exited, taskkill cannot find the tree by its PID, which may also have been
+// reused by an unrelated process.
+func killExitedProcessGroup(int) error {
+	return nil
+}
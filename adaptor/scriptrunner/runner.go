@@ -3,14 +3,15 @@
 package scriptrunner
 
 import (
-	"bytes"
 	"context"
+	"errors"
 	"fmt"
 	"io"
 	"log/slog"
-	"os"
 	"os/exec"
+	"path/filepath"
 	"strings"
+	"syscall"
 	"time"
 )
 
@@ -24,136 +25,183 @@ type Result struct {
 	ExitCode int
 	// TimedOut is true if the script was killed due to timeout.
 	TimedOut bool
+	// Signals are the signals sent to the script's process group after the
+	// timeout, in order: TermSignal then KillSignal when the script outlived
+	// its grace period, TermSignal alone when it exited within it, or
+	// KillSignal alone without a grace period.
+	Signals []string
+	// OutputsLeftOpen is true if processes the script started still held
+	// its stdout or stderr when the output wait ran out (see SetOutputWait).
+	OutputsLeftOpen bool
+	// OpenOutputPIDs are the processes that held the output, where they
+	// can be found (Linux only).
+	OpenOutputPIDs []int
+	// Usage is what the script used, recorded when it ran with limits.
+	Usage *Usage
 }
 
 const maxLogBytes = 2048
 
 // Runner executes scripts as child processes with process group isolation.
 type Runner struct {
-	logger *slog.Logger
+	logger       *slog.Logger
+	envPolicy    EnvPolicy
+	envAllowlist []string
+	outputWait   time.Duration
+	leaveRunning bool
+	noChmod      bool
 }
 
 // NewRunner creates a script runner.
 //
 //	r := scriptrunner.NewRunner(slog.Default())
-//	result, err := r.Run(ctx, "/opt/deploy/scripts/install.sh", env, 300)
+//	result, err := r.Run(ctx, "/opt/deploy/scripts/install.sh", env, 300, 10, scriptrunner.Limits{})
 func NewRunner(logger *slog.Logger) *Runner {
-	return &Runner{logger: logger}
+	return &Runner{logger: logger, outputWait: defaultOutputWait}
 }
 
 // Run executes a script with the given environment variables and timeout.
-// The script runs in its own process group so the entire group can be killed
-// on timeout. Environment vars are merged with the current environment.
+// The script runs in its own process group. At the timeout the group gets
+// TermSignal, then KillSignal once graceSeconds have passed; a grace of
+// zero kills it at once. Environment vars are merged with the agent's
+// environment as filtered by the policy (see SetEnvPolicy). limits caps
+// the script's resources, where the platform allows; Result.Usage says
+// which limits could not be applied.
 //
 //	env := map[string]string{"LIFECYCLE_EVENT": "AfterInstall"}
-//	result, err := runner.Run(ctx, "/path/to/script.sh", env, 3600)
-func (r *Runner) Run(ctx context.Context, scriptPath string, env map[string]string, timeoutSeconds int) (Result, error) {
-	if _, err := os.Stat(scriptPath); os.IsNotExist(err) {
-		return Result{ExitCode: -1}, fmt.Errorf("script does not exist: %s", scriptPath)
-	}
-
-	// Ensure script is executable
-	info, err := os.Stat(scriptPath)
-	if err != nil {
+//	result, err := runner.Run(ctx, "/path/to/script.sh", env, 3600, 30, scriptrunner.Limits{Memory: 512 << 20})
+func (r *Runner) Run(ctx context.Context, scriptPath string, env map[string]string, timeoutSeconds, graceSeconds int, limits Limits) (Result, error) {
+	if err := r.preflight(scriptPath); err != nil {
 		return Result{ExitCode: -1}, err
 	}
-	if info.Mode()&0o111 == 0 {
-		if err := os.Chmod(scriptPath, info.Mode()|0o111); err != nil {
-			return Result{ExitCode: -1}, fmt.Errorf("cannot make script executable: %s: %w", scriptPath, err)
-		}
-		r.logger.Warn("made script executable", "path", scriptPath)
-	}
-
-	timeout := time.Duration(timeoutSeconds) * time.Second
-	ctx, cancel := context.WithTimeout(ctx, timeout)
-	defer cancel()
-
-	cmd := exec.CommandContext(ctx, scriptPath)
-	cmd.Dir = "/"
-	cmd.Env = buildEnv(env)
-	setSysProcAttr(cmd)
+	return r.run(ctx, scriptPath, []string{scriptPath}, r.Environ(env), timeoutSeconds, graceSeconds, limits)
+}
 
-	var stdoutBuf, stderrBuf bytes.Buffer
-	cmd.Stdout = &limitedWriter{w: &stdoutBuf, remaining: maxLogBytes}
-	cmd.Stderr = &limitedWriter{w: &stderrBuf, remaining: maxLogBytes}
+// RunCommand executes an inline hook command through shell, with the same
+// process group, environment, timeout and output capture as Run. An empty
+// shell uses the platform default: /bin/sh, or powershell on Windows.
+//
+//	result, err := runner.RunCommand(ctx, "/bin/bash", "systemctl restart nginx", env, 60, 10, scriptrunner.Limits{})
+func (r *Runner) RunCommand(ctx context.Context, shell, command string, env map[string]string, timeoutSeconds, graceSeconds int, limits Limits) (Result, error) {
+	return r.run(ctx, "command", shellArgs(shell, command), r.Environ(env), timeoutSeconds, graceSeconds, limits)
+}
 
-	if err := cmd.Start(); err != nil {
-		return Result{ExitCode: -1}, fmt.Errorf("script start failed: %s: %w", scriptPath, err)
+// RunAs executes a script as a different user via sudo, with the timeout
+// handling of Run. The script gets the environment Run would give it,
+// preserved through sudo, except the variables naming the user, which sudo
+// sets for the target user.
+func (r *Runner) RunAs(ctx context.Context, scriptPath, user string, env map[string]string, timeoutSeconds, graceSeconds int, limits Limits) (Result, error) {
+	if user == "" {
+		return r.Run(ctx, scriptPath, env, timeoutSeconds, graceSeconds, limits)
 	}
-
-	err = cmd.Wait()
-
-	result := Result{
-		Stdout: stdoutBuf.String(),
-		Stderr: stderrBuf.String(),
+	if err := r.preflight(scriptPath); err != nil {
+		return Result{ExitCode: -1}, err
 	}
+	// The environment goes to sudo through the process rather than the
+	// command line, where other users could read it.
+	argv := []string{"sudo", "--preserve-env", "-u", user, scriptPath}
+	return r.run(ctx, scriptPath, argv, r.environ(env, userEnv), timeoutSeconds, graceSeconds, limits)
+}
 
-	if ctx.Err() == context.DeadlineExceeded {
-		// Kill the entire process group
-		_ = killProcessGroup(cmd.Process.Pid)
-		result.TimedOut = true
-		result.ExitCode = -1
-		return result, nil
+// shellArgs builds the argv that runs command through shell. PowerShell and
+// cmd take the command after their own flags; any other shell is assumed to
+// accept -c like sh.
+func shellArgs(shell, command string) []string {
+	if shell == "" {
+		shell = defaultShell
 	}
-
-	if err != nil {
-		if exitErr, ok := err.(*exec.ExitError); ok {
-			result.ExitCode = exitErr.ExitCode()
-			return result, nil
-		}
-		return result, err
+	name := strings.ToLower(strings.TrimSuffix(filepath.Base(shell), filepath.Ext(shell)))
+	switch name {
+	case "powershell", "pwsh":
+		return []string{shell, "-NoProfile", "-NonInteractive", "-Command", command}
+	case "cmd":
+		return []string{shell, "/C", command}
 	}
-
-	result.ExitCode = 0
-	return result, nil
+	return []string{shell, "-c", command}
 }
 
-// RunAs executes a script as a different user via sudo.
-func (r *Runner) RunAs(ctx context.Context, scriptPath, user string, env map[string]string, timeoutSeconds int) (Result, error) {
-	if user == "" {
-		return r.Run(ctx, scriptPath, env, timeoutSeconds)
+// run executes argv as a hook: in its own process group from /, with env,
+// stopped by stopProcessGroup after timeoutSeconds. Cancelling ctx stops
+// it the same way and returns ctx's error. Once it exits, its output is
+// read until closed or the output wait runs out (see awaitOutputs), and
+// the usage of a script with limits collected. name identifies it in
+// errors.
+func (r *Runner) run(ctx context.Context, name string, argv, env []string, timeoutSeconds, graceSeconds int, limits Limits) (Result, error) {
+	stdout, err := newOutput()
+	if err != nil {
+		return Result{ExitCode: -1}, fmt.Errorf("script start failed: %s: %w", name, err)
 	}
-
-	timeout := time.Duration(timeoutSeconds) * time.Second
-	ctx, cancel := context.WithTimeout(ctx, timeout)
-	defer cancel()
-
-	// Build env string for sudo
-	envArgs := make([]string, 0, len(env))
-	for k, v := range env {
-		envArgs = append(envArgs, k+"="+v)
+	stderr, err := newOutput()
+	if err != nil {
+		stdout.abort()
+		return Result{ExitCode: -1}, fmt.Errorf("script start failed: %s: %w", name, err)
 	}
-
-	args := []string{"-u", user}
-	args = append(args, envArgs...)
-	args = append(args, scriptPath)
-
-	cmd := exec.CommandContext(ctx, "sudo", args...)
-	setSysProcAttr(cmd)
-
-	var stdoutBuf, stderrBuf bytes.Buffer
-	cmd.Stdout = &limitedWriter{w: &stdoutBuf, remaining: maxLogBytes}
-	cmd.Stderr = &limitedWriter{w: &stderrBuf, remaining: maxLogBytes}
-
-	if err := cmd.Start(); err != nil {
-		return Result{ExitCode: -1}, fmt.Errorf("script start failed: %s: %w", scriptPath, err)
+	newCmd := func() *exec.Cmd {
+		cmd := exec.Command(argv[0], argv[1:]...)
+		cmd.Dir = "/"
+		cmd.Env = env
+		cmd.Stdout = stdout.w
+		cmd.Stderr = stderr.w
+		setSysProcAttr(cmd)
+		return cmd
 	}
-
-	err := cmd.Wait()
-
-	result := Result{
-		Stdout: stdoutBuf.String(),
-		Stderr: stderrBuf.String(),
+	lim := newLimiter(limits, r.logger)
+	cmd := newCmd()
+	lim.prepare(cmd, true)
+
+	err = cmd.Start()
+	if err != nil && lim.canRetryStart() {
+		r.logger.Warn("cannot start script in its cgroup, starting it without", "script", name, "error", err)
+		lim.dropCgroup()
+		cmd = newCmd()
+		lim.prepare(cmd, false)
+		err = cmd.Start()
 	}
-
-	if ctx.Err() == context.DeadlineExceeded {
-		_ = killProcessGroup(cmd.Process.Pid)
+	if err == nil {
+		if err = lim.started(r, name); err != nil {
+			// The rlimit helper could not exec the script.
+			_ = cmd.Wait()
+		}
+	}
+	if err != nil {
+		stdout.abort()
+		stderr.abort()
+		lim.abort()
+		if errors.Is(err, syscall.ENOEXEC) {
+			return Result{ExitCode: -1}, &PreflightError{Path: name, Reason: "is neither a binary nor a script with a #! line"}
+		}
+		return Result{ExitCode: -1}, fmt.Errorf("script start failed: %s: %w", name, err)
+	}
+	stdout.start()
+	stderr.start()
+	done := make(chan error, 1)
+	go func() { done <- cmd.Wait() }()
+
+	timer := time.NewTimer(time.Duration(timeoutSeconds) * time.Second)
+	defer timer.Stop()
+
+	var result Result
+	select {
+	case err = <-done:
+	case <-timer.C:
 		result.TimedOut = true
+		result.Signals, _ = r.stopProcessGroup(cmd.Process.Pid, graceSeconds, done)
+		r.logger.Warn("script timed out", "script", name, "signals", result.Signals)
+	case <-ctx.Done():
+		result.Signals, _ = r.stopProcessGroup(cmd.Process.Pid, graceSeconds, done)
+		err = ctx.Err()
+	}
+	result.OutputsLeftOpen, result.OpenOutputPIDs = r.awaitOutputs(name, cmd.Process.Pid, stdout, stderr)
+	result.Stdout = stdout.detach()
+	result.Stderr = stderr.detach()
+	result.Usage = lim.finish(r, cmd.ProcessState)
+
+	if result.TimedOut {
 		result.ExitCode = -1
 		return result, nil
 	}
-
 	if err != nil {
+		result.ExitCode = -1
 		if exitErr, ok := err.(*exec.ExitError); ok {
 			result.ExitCode = exitErr.ExitCode()
 			return result, nil
@@ -165,6 +213,34 @@ func (r *Runner) RunAs(ctx context.Context, scriptPath, user string, env map[str
 	return result, nil
 }
 
+// stopProcessGroup ends the process group led by pid: TermSignal, then
+// KillSignal if the leader has not exited after graceSeconds, or
+// KillSignal at once without a grace period. A leader that exits within
+// the grace period may leave processes that ignore TermSignal, so the rest
+// of the group is killed then too. It returns the signals sent to the
+// script and the leader's Wait error from done.
+func (r *Runner) stopProcessGroup(pid, graceSeconds int, done <-chan error) ([]string, error) {
+	var signals []string
+	if graceSeconds > 0 {
+		if err := terminateProcessGroup(pid); err != nil {
+			r.logger.Warn("cannot signal process group", "pid", pid, "signal", TermSignal, "error", err)
+		}
+		signals = append(signals, TermSignal)
+		grace := time.NewTimer(time.Duration(graceSeconds) * time.Second)
+		defer grace.Stop()
+		select {
+		case err := <-done:
+			_ = killExitedProcessGroup(pid)
+			return signals, err
+		case <-grace.C:
+		}
+	}
+	if err := killProcessGroup(pid); err != nil {
+		r.logger.Warn("cannot signal process group", "pid", pid, "signal", KillSignal, "error", err)
+	}
+	return append(signals, KillSignal), <-done
+}
+
 // FormatLog formats stdout and stderr into the log format expected by
 // the diagnostic payload. Lines are prefixed with [stdout] or [stderr].
 func FormatLog(stdout, stderr string) string {
@@ -186,14 +262,6 @@ func FormatLog(stdout, stderr string) string {
 	return b.String()
 }
 
-func buildEnv(extra map[string]string) []string {
-	env := os.Environ()
-	for k, v := range extra {
-		env = append(env, k+"="+v)
-	}
-	return env
-}
-
 // limitedWriter wraps a writer and stops writing after a byte limit.
 type limitedWriter struct {
 	w         io.Writer
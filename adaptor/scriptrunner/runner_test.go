@@ -5,10 +5,17 @@ package scriptrunner
 import (
 	"bytes"
 	"context"
+	"errors"
 	"log/slog"
 	"os"
 	"path/filepath"
+	"runtime"
+	"slices"
+	"strconv"
+	"strings"
+	"syscall"
 	"testing"
+	"time"
 )
 
 // TestRunSuccessfulScript verifies that a script returning exit code 0
@@ -21,7 +28,7 @@ func TestRunSuccessfulScript(t *testing.T) {
 	}
 
 	r := NewRunner(slog.Default())
-	result, err := r.Run(context.Background(), script, nil, 10)
+	result, err := r.Run(context.Background(), script, nil, 10, 0, Limits{})
 	if err != nil {
 		t.Fatalf("Run: %v", err)
 	}
@@ -43,7 +50,7 @@ func TestRunFailingScript(t *testing.T) {
 	}
 
 	r := NewRunner(slog.Default())
-	result, err := r.Run(context.Background(), script, nil, 10)
+	result, err := r.Run(context.Background(), script, nil, 10, 0, Limits{})
 	if err != nil {
 		t.Fatalf("Run: %v", err)
 	}
@@ -63,7 +70,7 @@ func TestRunScriptWithEnvVars(t *testing.T) {
 
 	r := NewRunner(slog.Default())
 	env := map[string]string{"MY_VAR": "test_value"}
-	result, err := r.Run(context.Background(), script, env, 10)
+	result, err := r.Run(context.Background(), script, env, 10, 0, Limits{})
 	if err != nil {
 		t.Fatalf("Run: %v", err)
 	}
@@ -72,6 +79,48 @@ func TestRunScriptWithEnvVars(t *testing.T) {
 	}
 }
 
+// TestRunCommand verifies that an inline command runs through the default
+// shell with the hook environment, and through a named shell when given.
+func TestRunCommand(t *testing.T) {
+	r := NewRunner(slog.Default())
+	env := map[string]string{"LIFECYCLE_EVENT": "AfterInstall"}
+	result, err := r.RunCommand(context.Background(), "", "echo $LIFECYCLE_EVENT; exit 3", env, 10, 0, Limits{})
+	if err != nil {
+		t.Fatalf("RunCommand: %v", err)
+	}
+	if result.Stdout != "AfterInstall\n" || result.ExitCode != 3 {
+		t.Errorf("result = %+v, want AfterInstall and exit 3", result)
+	}
+
+	result, err = r.RunCommand(context.Background(), "/bin/sh", "pwd", nil, 10, 0, Limits{})
+	if err != nil {
+		t.Fatalf("RunCommand: %v", err)
+	}
+	if result.Stdout != "/\n" {
+		t.Errorf("Stdout = %q, want commands to run from /", result.Stdout)
+	}
+}
+
+// TestShellArgs verifies the command flag each shell family expects.
+func TestShellArgs(t *testing.T) {
+	tests := []struct {
+		shell string
+		want  []string
+	}{
+		{"", []string{defaultShell, "-c", "x"}},
+		{"/bin/bash", []string{"/bin/bash", "-c", "x"}},
+		{"/usr/bin/pwsh", []string{"/usr/bin/pwsh", "-NoProfile", "-NonInteractive", "-Command", "x"}},
+		{"powershell.exe", []string{"powershell.exe", "-NoProfile", "-NonInteractive", "-Command", "x"}},
+		{"CMD.EXE", []string{"CMD.EXE", "/C", "x"}},
+	}
+	for _, tt := range tests {
+		got := shellArgs(tt.shell, "x")
+		if strings.Join(got, " ") != strings.Join(tt.want, " ") {
+			t.Errorf("shellArgs(%q) = %q, want %q", tt.shell, got, tt.want)
+		}
+	}
+}
+
 // TestRunTimedOutScript verifies that scripts exceeding their timeout
 // are killed and the TimedOut flag is set. This prevents runaway scripts
 // from blocking deployments indefinitely.
@@ -83,20 +132,203 @@ func TestRunTimedOutScript(t *testing.T) {
 	}
 
 	r := NewRunner(slog.Default())
-	result, err := r.Run(context.Background(), script, nil, 1)
+	result, err := r.Run(context.Background(), script, nil, 1, 0, Limits{})
 	if err != nil {
 		t.Fatalf("Run: %v", err)
 	}
 	if !result.TimedOut {
 		t.Error("expected TimedOut = true")
 	}
+	if len(result.Signals) != 1 || result.Signals[0] != KillSignal {
+		t.Errorf("Signals = %v, want SIGKILL alone without a grace period", result.Signals)
+	}
+}
+
+// TestRunTimedOut_GracePeriodAllowsCleanup verifies that with a grace period
+// the process group gets SIGTERM first, so a script's trap can clean up,
+// and no SIGKILL follows when it exits in time.
+func TestRunTimedOut_GracePeriodAllowsCleanup(t *testing.T) {
+	dir := t.TempDir()
+	marker := filepath.Join(dir, "cleaned")
+	script := filepath.Join(dir, "trap.sh")
+	body := "#!/bin/sh\ntrap 'echo cleaned > " + marker + "; exit 0' TERM\nsleep 60 &\nwait\n"
+	if err := os.WriteFile(script, []byte(body), 0o755); err != nil {
+		t.Fatal(err)
+	}
+
+	r := NewRunner(slog.Default())
+	start := time.Now()
+	result, err := r.Run(context.Background(), script, nil, 1, 10, Limits{})
+	if err != nil {
+		t.Fatalf("Run: %v", err)
+	}
+	if !result.TimedOut || strings.Join(result.Signals, ",") != TermSignal {
+		t.Errorf("TimedOut = %v, Signals = %v, want SIGTERM only", result.TimedOut, result.Signals)
+	}
+	if _, err := os.Stat(marker); err != nil {
+		t.Errorf("trap did not run: %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("Run took %v, want the script's own exit rather than the grace period", elapsed)
+	}
+}
+
+// TestRunTimedOut_KillAfterGracePeriod verifies that a script ignoring
+// SIGTERM is killed once the grace period is over.
+func TestRunTimedOut_KillAfterGracePeriod(t *testing.T) {
+	dir := t.TempDir()
+	script := filepath.Join(dir, "stubborn.sh")
+	if err := os.WriteFile(script, []byte("#!/bin/sh\ntrap '' TERM\nsleep 60\n"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+
+	r := NewRunner(slog.Default())
+	result, err := r.Run(context.Background(), script, nil, 1, 1, Limits{})
+	if err != nil {
+		t.Fatalf("Run: %v", err)
+	}
+	if want := TermSignal + "," + KillSignal; strings.Join(result.Signals, ",") != want {
+		t.Errorf("Signals = %v, want %s", result.Signals, want)
+	}
+}
+
+// TestRunTimedOut_KillsChildIgnoringTerm verifies that when a script exits
+// within its grace period, a background child that ignores SIGTERM is still
+// killed with the rest of the group rather than outliving the timeout.
+func TestRunTimedOut_KillsChildIgnoringTerm(t *testing.T) {
+	dir := t.TempDir()
+	pidFile := filepath.Join(dir, "child.pid")
+	script := filepath.Join(dir, "leaves-child.sh")
+	body := "#!/bin/sh\n(trap '' TERM; exec sleep 60) >/dev/null 2>&1 &\necho $! > " + pidFile +
+		"\ntrap 'exit 0' TERM\nsleep 60 &\nwait\n"
+	if err := os.WriteFile(script, []byte(body), 0o755); err != nil {
+		t.Fatal(err)
+	}
+
+	r := NewRunner(slog.Default())
+	result, err := r.Run(context.Background(), script, nil, 1, 10, Limits{})
+	if err != nil {
+		t.Fatalf("Run: %v", err)
+	}
+	if !result.TimedOut {
+		t.Fatal("expected TimedOut = true")
+	}
+	data, err := os.ReadFile(pidFile)
+	if err != nil {
+		t.Fatalf("read child pid: %v", err)
+	}
+	pid := strings.TrimSpace(string(data))
+	deadline := time.Now().Add(2 * time.Second)
+	for !processGone(pid) {
+		if time.Now().After(deadline) {
+			t.Fatalf("child %s ignoring SIGTERM is still running after the timeout", pid)
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+}
+
+// processGone reports whether pid has exited; a zombie waiting for its
+// reaper counts as exited.
+func processGone(pid string) bool {
+	data, err := os.ReadFile("/proc/" + pid + "/stat")
+	if err != nil {
+		return true
+	}
+	_, rest, _ := strings.Cut(string(data), ") ")
+	return strings.HasPrefix(rest, "Z")
+}
+
+// TestRunCancelled verifies that cancelling the context, as agent shutdown
+// does, stops the script's process group and reports the cancellation
+// rather than a timeout.
+func TestRunCancelled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(100*time.Millisecond, cancel)
+
+	r := NewRunner(slog.Default())
+	result, err := r.RunCommand(ctx, "", "sleep 60", nil, 60, 0, Limits{})
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("err = %v, want context.Canceled", err)
+	}
+	if result.TimedOut || len(result.Signals) != 1 {
+		t.Errorf("result = %+v, want one signal and no timeout", result)
+	}
+}
+
+// TestRunOutputsLeftOpen verifies that a script which backgrounds a
+// process without redirecting its output returns once the output wait runs
+// out instead of at the timeout, with the output captured so far and the
+// holder reported, and that the holder is killed.
+func TestRunOutputsLeftOpen(t *testing.T) {
+	r := NewRunner(slog.Default())
+	r.SetOutputWait(200*time.Millisecond, false)
+
+	start := time.Now()
+	result, err := r.RunCommand(context.Background(), "", "sleep 30 & echo $!; echo started", nil, 60, 0, Limits{})
+	if err != nil {
+		t.Fatalf("RunCommand: %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > 10*time.Second {
+		t.Errorf("took %v, want the output wait", elapsed)
+	}
+	if !result.OutputsLeftOpen || result.TimedOut || result.ExitCode != 0 {
+		t.Fatalf("result = %+v, want outputs left open and exit 0", result)
+	}
+	if !strings.HasSuffix(result.Stdout, "started\n") {
+		t.Errorf("Stdout = %q", result.Stdout)
+	}
+	if runtime.GOOS == "linux" {
+		pid := backgroundPID(t, result.Stdout)
+		if !slices.Contains(result.OpenOutputPIDs, pid) {
+			t.Errorf("OpenOutputPIDs = %v, want %d", result.OpenOutputPIDs, pid)
+		}
+		waitForExit(t, pid)
+	}
+}
+
+// TestRunOutputsLeftOpen_LeaveRunning verifies that with leaveRunning the
+// process holding the output is reported but keeps running, for daemons
+// that are meant to outlive their start script.
+func TestRunOutputsLeftOpen_LeaveRunning(t *testing.T) {
+	r := NewRunner(slog.Default())
+	r.SetOutputWait(200*time.Millisecond, true)
+
+	result, err := r.RunCommand(context.Background(), "", "sleep 30 & echo $!", nil, 60, 0, Limits{})
+	if err != nil {
+		t.Fatalf("RunCommand: %v", err)
+	}
+	if !result.OutputsLeftOpen {
+		t.Fatalf("result = %+v, want outputs left open", result)
+	}
+	pid := backgroundPID(t, result.Stdout)
+	t.Cleanup(func() { _ = syscall.Kill(pid, syscall.SIGKILL) })
+	time.Sleep(100 * time.Millisecond)
+	if !processRunning(pid) {
+		t.Errorf("process %d was killed, want it left running", pid)
+	}
+}
+
+// TestRunRedirectedBackgroundProcess verifies that a background process
+// whose output is redirected is not reported, so daemons started the
+// recommended way do not fail their hook.
+func TestRunRedirectedBackgroundProcess(t *testing.T) {
+	r := NewRunner(slog.Default())
+	r.SetOutputWait(200*time.Millisecond, false)
+
+	result, err := r.RunCommand(context.Background(), "", "sleep 2 >/dev/null 2>&1 &", nil, 60, 0, Limits{})
+	if err != nil {
+		t.Fatalf("RunCommand: %v", err)
+	}
+	if result.OutputsLeftOpen || result.ExitCode != 0 {
+		t.Errorf("result = %+v, want a clean exit", result)
+	}
 }
 
 // TestRunMissingScript verifies that a non-existent script path returns
 // an error rather than a zero exit code.
 func TestRunMissingScript(t *testing.T) {
 	r := NewRunner(slog.Default())
-	_, err := r.Run(context.Background(), "/nonexistent/script.sh", nil, 10)
+	_, err := r.Run(context.Background(), "/nonexistent/script.sh", nil, 10, 0, Limits{})
 	if err == nil {
 		t.Fatal("expected error for missing script")
 	}
@@ -113,7 +345,7 @@ func TestRunMakesScriptExecutable(t *testing.T) {
 	}
 
 	r := NewRunner(slog.Default())
-	result, err := r.Run(context.Background(), script, nil, 10)
+	result, err := r.Run(context.Background(), script, nil, 10, 0, Limits{})
 	if err != nil {
 		t.Fatalf("Run: %v", err)
 	}
@@ -143,7 +375,7 @@ func TestLimitedWriter(t *testing.T) {
 	}
 
 	r := NewRunner(slog.Default())
-	result, err := r.Run(context.Background(), script, nil, 10)
+	result, err := r.Run(context.Background(), script, nil, 10, 0, Limits{})
 	if err != nil {
 		t.Fatalf("Run: %v", err)
 	}
@@ -205,7 +437,7 @@ func TestRunScriptStderr(t *testing.T) {
 	}
 
 	r := NewRunner(slog.Default())
-	result, err := r.Run(context.Background(), script, nil, 10)
+	result, err := r.Run(context.Background(), script, nil, 10, 0, Limits{})
 	if err != nil {
 		t.Fatalf("Run: %v", err)
 	}
@@ -237,7 +469,7 @@ func TestRunAsEmptyUserDelegatesToRun(t *testing.T) {
 	}
 
 	r := NewRunner(slog.Default())
-	result, err := r.RunAs(context.Background(), script, "", nil, 10)
+	result, err := r.RunAs(context.Background(), script, "", nil, 10, 0, Limits{})
 	if err != nil {
 		t.Fatalf("RunAs: %v", err)
 	}
@@ -245,3 +477,39 @@ func TestRunAsEmptyUserDelegatesToRun(t *testing.T) {
 		t.Errorf("Stdout = %q, want delegated", result.Stdout)
 	}
 }
+
+// backgroundPID returns the PID a test command printed on its first line.
+func backgroundPID(t *testing.T, stdout string) int {
+	t.Helper()
+	line, _, _ := strings.Cut(stdout, "\n")
+	pid, err := strconv.Atoi(line)
+	if err != nil {
+		t.Fatalf("no PID in output %q", stdout)
+	}
+	return pid
+}
+
+// processRunning reports whether pid is alive and not a zombie.
+func processRunning(pid int) bool {
+	if syscall.Kill(pid, 0) != nil {
+		return false
+	}
+	stat, err := os.ReadFile(filepath.Join("/proc", strconv.Itoa(pid), "stat"))
+	if err != nil {
+		return runtime.GOOS != "linux"
+	}
+	_, rest, _ := strings.Cut(string(stat), ") ")
+	return !strings.HasPrefix(rest, "Z")
+}
+
+// waitForExit fails the test unless pid exits within a few seconds.
+func waitForExit(t *testing.T, pid int) {
+	t.Helper()
+	for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); {
+		if !processRunning(pid) {
+			return
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	t.Errorf("process %d still running", pid)
+}
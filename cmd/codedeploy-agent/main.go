@@ -6,23 +6,42 @@
 //
 //	codedeploy-agent [config-file]          Start the agent daemon
 //	codedeploy-agent install [flags]        Self-install onto this host
+//	codedeploy-agent gc [flags]             Garbage-collect the deployment root
+//	codedeploy-agent verify [flags]         Report drift from the last install
 //
 // Install flags:
 //
 //	--install-dir    Installation directory (default: /opt/codedeploy-agent)
 //	--no-start       Install without starting the service
 //
+// GC flags:
+//
+//	--config         Agent config file (default: /etc/codedeploy-agent/conf/codedeployagent.yml)
+//	--dry-run        Report what would be removed without removing anything
+//	--json           Print the report as JSON
+//
+// Verify flags:
+//
+//	--config             Agent config file (default: /etc/codedeploy-agent/conf/codedeployagent.yml)
+//	--deployment-group   Check only this deployment group (default: all)
+//	--json               Print the report as JSON
+//
+// verify exits 2 when drift is found and 1 on other errors.
+//
 // The default config file path is /etc/codedeploy-agent/conf/codedeployagent.yml.
 package main
 
 import (
 	"context"
+	"errors"
 	"flag"
 	"fmt"
 	"os"
 
 	"github.com/gurre/codedeploy-agent-go/entrypoint/agent"
+	"github.com/gurre/codedeploy-agent-go/entrypoint/housekeeping"
 	"github.com/gurre/codedeploy-agent-go/entrypoint/selfinstall"
+	"github.com/gurre/codedeploy-agent-go/entrypoint/verify"
 )
 
 const defaultConfigPath = "/etc/codedeploy-agent/conf/codedeployagent.yml"
@@ -32,6 +51,14 @@ func main() {
 		runInstall()
 		return
 	}
+	if len(os.Args) > 1 && os.Args[1] == "gc" {
+		runGC()
+		return
+	}
+	if len(os.Args) > 1 && os.Args[1] == "verify" {
+		runVerify()
+		return
+	}
 
 	configPath := defaultConfigPath
 	if len(os.Args) > 1 {
@@ -69,3 +96,51 @@ func runInstall() {
 		os.Exit(1)
 	}
 }
+
+func runGC() {
+	opts := housekeeping.DefaultOptions()
+
+	fs := flag.NewFlagSet("gc", flag.ExitOnError)
+	fs.StringVar(&opts.ConfigFile, "config", opts.ConfigFile, "Agent configuration file")
+	fs.BoolVar(&opts.DryRun, "dry-run", opts.DryRun, "Report what would be removed without removing anything")
+	fs.BoolVar(&opts.JSON, "json", opts.JSON, "Print the report as JSON")
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: codedeploy-agent gc [flags]\n\nRemoves deployment-root artifacts outside the retention policy.\n\nFlags:\n")
+		fs.PrintDefaults()
+	}
+
+	if err := fs.Parse(os.Args[2:]); err != nil {
+		os.Exit(1)
+	}
+
+	if err := housekeeping.Run(context.Background(), opts, os.Stdout); err != nil {
+		fmt.Fprintf(os.Stderr, "codedeploy-agent gc: %s\n", err)
+		os.Exit(1)
+	}
+}
+
+func runVerify() {
+	opts := verify.DefaultOptions()
+
+	fs := flag.NewFlagSet("verify", flag.ExitOnError)
+	fs.StringVar(&opts.ConfigFile, "config", opts.ConfigFile, "Agent configuration file")
+	fs.StringVar(&opts.DeploymentGroup, "deployment-group", opts.DeploymentGroup, "Check only this deployment group")
+	fs.BoolVar(&opts.JSON, "json", opts.JSON, "Print the report as JSON")
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: codedeploy-agent verify [flags]\n\nReports files that drifted from the last install.\n\nFlags:\n")
+		fs.PrintDefaults()
+	}
+
+	if err := fs.Parse(os.Args[2:]); err != nil {
+		os.Exit(1)
+	}
+
+	err := verify.Run(context.Background(), opts, os.Stdout)
+	if errors.Is(err, verify.ErrDrift) {
+		os.Exit(2)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "codedeploy-agent verify: %s\n", err)
+		os.Exit(1)
+	}
+}
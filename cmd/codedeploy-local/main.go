@@ -4,7 +4,11 @@
 //
 // Usage:
 //
-//	codedeploy-local [flags]
+//	codedeploy-local [flags]                          Run a local deployment
+//	codedeploy-local plan [flags]                     Preview a local deployment without running it
+//	codedeploy-local lint [flags]                     Check an appspec and its bundle for problems
+//	codedeploy-local schema                           Print the appspec JSON Schema
+//	codedeploy-local restore --deployment-id ID       Restore files a deployment overwrote
 //
 // Flags:
 //
@@ -17,10 +21,37 @@
 //	-e, --events            Comma-separated lifecycle events to execute
 //	-c, --agent-configuration-file  Path to codedeployagent.yml
 //	-A, --appspec-filename  AppSpec file name (default: appspec.yml)
+//	--link-mode             Local directory copy mode: copy, hardlink, reflink (default: copy)
+//	--backup-overwritten    Keep files replaced under OVERWRITE for restore
+//
+// Plan takes the deployment flags above (remote bundles and --link-mode
+// excepted) plus:
+//
+//	--json                  Print the plan as JSON
+//
+// plan exits 2 when the install would fail on existing files under DISALLOW
+// or on destinations another deployment group manages.
+//
+// Lint flags:
+//
+//	-l, --bundle-location   Local bundle (default: current directory)
+//	-t, --type              Bundle type: tar, tgz, zip, directory (default: directory)
+//	-A, --appspec-filename  AppSpec file name (default: appspec.yml)
+//	--os                    Target platform, linux or windows (default: the appspec's os)
+//	--format                text, json, or sarif (default: text)
+//
+// lint exits 2 when the appspec has errors; warnings alone exit 0.
+//
+// Restore flags:
+//
+//	--deployment-id         Deployment whose backup to restore (required)
+//	-g, --deployment-group  Deployment group ID (default: search all groups)
+//	-c, --agent-configuration-file  Path to codedeployagent.yml
 package main
 
 import (
 	"context"
+	"errors"
 	"flag"
 	"fmt"
 	"os"
@@ -30,28 +61,32 @@ import (
 )
 
 func main() {
+	if len(os.Args) > 1 && os.Args[1] == "restore" {
+		runRestore()
+		return
+	}
+	if len(os.Args) > 1 && os.Args[1] == "plan" {
+		runPlan()
+		return
+	}
+	if len(os.Args) > 1 && os.Args[1] == "lint" {
+		runLint()
+		return
+	}
+	if len(os.Args) > 1 && os.Args[1] == "schema" {
+		if err := localcli.Schema(os.Stdout); err != nil {
+			fmt.Fprintf(os.Stderr, "codedeploy-local schema: %s\n", err)
+			os.Exit(1)
+		}
+		return
+	}
+
 	opts := localcli.DefaultOptions()
 
 	var eventsStr string
-
-	flag.StringVar(&opts.BundleLocation, "l", "", "Bundle location")
-	flag.StringVar(&opts.BundleLocation, "bundle-location", "", "Bundle location")
-	flag.StringVar(&opts.BundleType, "t", opts.BundleType, "Bundle type (tar, tgz, zip, directory)")
-	flag.StringVar(&opts.BundleType, "type", opts.BundleType, "Bundle type (tar, tgz, zip, directory)")
-	flag.StringVar(&opts.FileExistsBehavior, "b", opts.FileExistsBehavior, "File exists behavior (DISALLOW, OVERWRITE, RETAIN)")
-	flag.StringVar(&opts.FileExistsBehavior, "file-exists-behavior", opts.FileExistsBehavior, "File exists behavior (DISALLOW, OVERWRITE, RETAIN)")
-	flag.StringVar(&opts.DeploymentGroup, "g", opts.DeploymentGroup, "Deployment group ID")
-	flag.StringVar(&opts.DeploymentGroup, "deployment-group", opts.DeploymentGroup, "Deployment group ID")
-	flag.StringVar(&opts.DeploymentGroupName, "d", opts.DeploymentGroupName, "Deployment group name")
-	flag.StringVar(&opts.DeploymentGroupName, "deployment-group-name", opts.DeploymentGroupName, "Deployment group name")
-	flag.StringVar(&opts.ApplicationName, "a", "", "Application name")
-	flag.StringVar(&opts.ApplicationName, "application-name", "", "Application name")
-	flag.StringVar(&eventsStr, "e", "", "Comma-separated lifecycle events")
-	flag.StringVar(&eventsStr, "events", "", "Comma-separated lifecycle events")
-	flag.StringVar(&opts.ConfigFile, "c", "", "Path to agent configuration file")
-	flag.StringVar(&opts.ConfigFile, "agent-configuration-file", "", "Path to agent configuration file")
-	flag.StringVar(&opts.AppSpecFilename, "A", opts.AppSpecFilename, "AppSpec filename")
-	flag.StringVar(&opts.AppSpecFilename, "appspec-filename", opts.AppSpecFilename, "AppSpec filename")
+	bindDeploymentFlags(flag.CommandLine, &opts, &eventsStr)
+	flag.StringVar(&opts.LinkMode, "link-mode", opts.LinkMode, "Local directory copy mode (copy, hardlink, reflink)")
+	flag.BoolVar(&opts.BackupOverwritten, "backup-overwritten", opts.BackupOverwritten, "Keep files replaced under OVERWRITE for restore")
 
 	flag.Usage = func() {
 		fmt.Fprintf(os.Stderr, "Usage: codedeploy-local [flags]\n\nRuns a local deployment without the CodeDeploy service.\n\nFlags:\n")
@@ -59,12 +94,47 @@ func main() {
 	}
 
 	flag.Parse()
+	if err := finishDeploymentFlags(&opts, eventsStr); err != nil {
+		fmt.Fprintf(os.Stderr, "codedeploy-local: %s\n", err)
+		os.Exit(1)
+	}
 
+	if err := localcli.Run(context.Background(), opts); err != nil {
+		fmt.Fprintf(os.Stderr, "codedeploy-local: %s\n", err)
+		os.Exit(1)
+	}
+}
+
+// bindDeploymentFlags registers the flags that describe a deployment, shared
+// by a local deployment and plan.
+func bindDeploymentFlags(fs *flag.FlagSet, opts *localcli.Options, eventsStr *string) {
+	fs.StringVar(&opts.BundleLocation, "l", "", "Bundle location")
+	fs.StringVar(&opts.BundleLocation, "bundle-location", "", "Bundle location")
+	fs.StringVar(&opts.BundleType, "t", opts.BundleType, "Bundle type (tar, tgz, zip, directory)")
+	fs.StringVar(&opts.BundleType, "type", opts.BundleType, "Bundle type (tar, tgz, zip, directory)")
+	fs.StringVar(&opts.FileExistsBehavior, "b", opts.FileExistsBehavior, "File exists behavior (DISALLOW, OVERWRITE, RETAIN)")
+	fs.StringVar(&opts.FileExistsBehavior, "file-exists-behavior", opts.FileExistsBehavior, "File exists behavior (DISALLOW, OVERWRITE, RETAIN)")
+	fs.StringVar(&opts.DeploymentGroup, "g", opts.DeploymentGroup, "Deployment group ID")
+	fs.StringVar(&opts.DeploymentGroup, "deployment-group", opts.DeploymentGroup, "Deployment group ID")
+	fs.StringVar(&opts.DeploymentGroupName, "d", opts.DeploymentGroupName, "Deployment group name")
+	fs.StringVar(&opts.DeploymentGroupName, "deployment-group-name", opts.DeploymentGroupName, "Deployment group name")
+	fs.StringVar(&opts.ApplicationName, "a", "", "Application name")
+	fs.StringVar(&opts.ApplicationName, "application-name", "", "Application name")
+	fs.StringVar(eventsStr, "e", "", "Comma-separated lifecycle events")
+	fs.StringVar(eventsStr, "events", "", "Comma-separated lifecycle events")
+	fs.StringVar(&opts.ConfigFile, "c", "", "Path to agent configuration file")
+	fs.StringVar(&opts.ConfigFile, "agent-configuration-file", "", "Path to agent configuration file")
+	fs.StringVar(&opts.AppSpecFilename, "A", opts.AppSpecFilename, "AppSpec filename")
+	fs.StringVar(&opts.AppSpecFilename, "appspec-filename", opts.AppSpecFilename, "AppSpec filename")
+}
+
+// finishDeploymentFlags defaults the bundle to the working directory and
+// splits the event list.
+func finishDeploymentFlags(opts *localcli.Options, eventsStr string) error {
 	if opts.BundleLocation == "" {
 		cwd, err := os.Getwd()
 		if err != nil {
-			fmt.Fprintf(os.Stderr, "codedeploy-local: %s\n", err)
-			os.Exit(1)
+			return err
 		}
 		opts.BundleLocation = cwd
 	}
@@ -75,9 +145,93 @@ func main() {
 			opts.Events[i] = strings.TrimSpace(opts.Events[i])
 		}
 	}
+	return nil
+}
 
-	if err := localcli.Run(context.Background(), opts); err != nil {
-		fmt.Fprintf(os.Stderr, "codedeploy-local: %s\n", err)
+func runPlan() {
+	opts := localcli.PlanOptions{Options: localcli.DefaultOptions()}
+
+	var eventsStr string
+	fs := flag.NewFlagSet("plan", flag.ExitOnError)
+	bindDeploymentFlags(fs, &opts.Options, &eventsStr)
+	fs.BoolVar(&opts.JSON, "json", opts.JSON, "Print the plan as JSON")
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: codedeploy-local plan [flags]\n\nPrints what a local deployment would do without changing anything.\n\nFlags:\n")
+		fs.PrintDefaults()
+	}
+
+	if err := fs.Parse(os.Args[2:]); err != nil {
+		os.Exit(1)
+	}
+	if err := finishDeploymentFlags(&opts.Options, eventsStr); err != nil {
+		fmt.Fprintf(os.Stderr, "codedeploy-local plan: %s\n", err)
+		os.Exit(1)
+	}
+
+	err := localcli.Plan(context.Background(), opts, os.Stdout)
+	if errors.Is(err, localcli.ErrPlanConflicts) {
+		os.Exit(2)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "codedeploy-local plan: %s\n", err)
+		os.Exit(1)
+	}
+}
+
+func runLint() {
+	opts := localcli.LintOptions{BundleType: "directory", AppSpecFilename: "appspec.yml", Format: "text"}
+
+	fs := flag.NewFlagSet("lint", flag.ExitOnError)
+	fs.StringVar(&opts.BundleLocation, "l", "", "Bundle location")
+	fs.StringVar(&opts.BundleLocation, "bundle-location", "", "Bundle location")
+	fs.StringVar(&opts.BundleType, "t", opts.BundleType, "Bundle type (tar, tgz, zip, directory)")
+	fs.StringVar(&opts.BundleType, "type", opts.BundleType, "Bundle type (tar, tgz, zip, directory)")
+	fs.StringVar(&opts.AppSpecFilename, "A", opts.AppSpecFilename, "AppSpec filename")
+	fs.StringVar(&opts.AppSpecFilename, "appspec-filename", opts.AppSpecFilename, "AppSpec filename")
+	fs.StringVar(&opts.OS, "os", "", "Target platform (linux, windows); default is the appspec's os")
+	fs.StringVar(&opts.Format, "format", opts.Format, "Output format (text, json, sarif)")
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: codedeploy-local lint [flags]\n\nChecks an appspec and its bundle without deploying.\n\nFlags:\n")
+		fs.PrintDefaults()
+	}
+
+	if err := fs.Parse(os.Args[2:]); err != nil {
+		os.Exit(1)
+	}
+	if opts.BundleLocation == "" {
+		opts.BundleLocation = "."
+	}
+
+	err := localcli.Lint(context.Background(), opts, os.Stdout)
+	if errors.Is(err, localcli.ErrLintFailed) {
+		os.Exit(2)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "codedeploy-local lint: %s\n", err)
+		os.Exit(1)
+	}
+}
+
+func runRestore() {
+	var opts localcli.RestoreOptions
+
+	fs := flag.NewFlagSet("restore", flag.ExitOnError)
+	fs.StringVar(&opts.DeploymentID, "deployment-id", "", "Deployment whose backup to restore")
+	fs.StringVar(&opts.DeploymentGroup, "g", "", "Deployment group ID")
+	fs.StringVar(&opts.DeploymentGroup, "deployment-group", "", "Deployment group ID")
+	fs.StringVar(&opts.ConfigFile, "c", "", "Path to agent configuration file")
+	fs.StringVar(&opts.ConfigFile, "agent-configuration-file", "", "Path to agent configuration file")
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: codedeploy-local restore --deployment-id ID [flags]\n\nRestores files that a deployment overwrote.\n\nFlags:\n")
+		fs.PrintDefaults()
+	}
+
+	if err := fs.Parse(os.Args[2:]); err != nil {
+		os.Exit(1)
+	}
+
+	if err := localcli.Restore(context.Background(), opts, os.Stdout); err != nil {
+		fmt.Fprintf(os.Stderr, "codedeploy-local restore: %s\n", err)
 		os.Exit(1)
 	}
 }
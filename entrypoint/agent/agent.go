@@ -4,6 +4,7 @@ package agent
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"io"
 	"log/slog"
@@ -11,6 +12,7 @@ import (
 	"net/url"
 	"os"
 	"os/signal"
+	"sync"
 	"syscall"
 	"time"
 
@@ -30,12 +32,16 @@ import (
 	"github.com/gurre/codedeploy-agent-go/logic/appspec"
 	"github.com/gurre/codedeploy-agent-go/logic/deployspec"
 	"github.com/gurre/codedeploy-agent-go/logic/lifecycle"
+	"github.com/gurre/codedeploy-agent-go/logic/render"
 	"github.com/gurre/codedeploy-agent-go/orchestration/executor"
 	"github.com/gurre/codedeploy-agent-go/orchestration/hookrunner"
+	"github.com/gurre/codedeploy-agent-go/orchestration/housekeeping"
 	"github.com/gurre/codedeploy-agent-go/orchestration/installer"
 	"github.com/gurre/codedeploy-agent-go/orchestration/poller"
 	"github.com/gurre/codedeploy-agent-go/orchestration/tracker"
+	"github.com/gurre/codedeploy-agent-go/orchestration/verify"
 	"github.com/gurre/codedeploy-agent-go/state/config"
+	"github.com/gurre/codedeploy-agent-go/state/deployment"
 )
 
 // Run starts the CodeDeploy agent with the given config file path.
@@ -149,6 +155,13 @@ func Run(ctx context.Context, configPath string) error {
 	unpacker := archive.NewUnpacker()
 	fileOp := filesystem.NewOperator()
 	sr := scriptrunner.NewRunner(logger)
+	envPolicy, err := scriptrunner.ParseEnvPolicy(cfg.HookEnvPolicy)
+	if err != nil {
+		return fmt.Errorf("agent: %w", err)
+	}
+	sr.SetEnvPolicy(envPolicy, cfg.HookEnvAllowlist)
+	sr.SetOutputWait(cfg.HookOutputWait, cfg.HookLeaveDaemonsRunning)
+	sr.SetChmodScripts(cfg.HookChmodScripts)
 
 	// Build orchestration components
 	ft := tracker.NewFileTracker(cfg.RootDir, cfg.OngoingDeploymentTracking, logger)
@@ -157,8 +170,30 @@ func Run(ctx context.Context, configPath string) error {
 	// Wire adaptor implementations to orchestration interfaces
 	dl := &downloaderBridge{s3: s3dl, gh: ghDl}
 	fileOpBridge := &fileOperatorBridge{op: fileOp}
-	hookBridge := &hookRunnerBridge{runner: hookrunner.NewRunner(&scriptRunnerBridge{sr: sr}, logger)}
-	instBridge := &installerBridge{inst: installer.NewInstaller(&fileOperatorInstallerBridge{op: fileOp}, logger)}
+	hooks := hookrunner.NewRunner(&scriptRunnerBridge{sr: sr}, logger)
+	hooks.SetShell(cfg.HookShell)
+	hooks.SetGracePeriod(int(cfg.HookGracePeriod / time.Second))
+	hooks.SetLimits(appspec.Limits{
+		CPUTime:      int(cfg.HookLimitCPUTime / time.Second),
+		AddressSpace: cfg.HookLimitAddressSpace,
+		OpenFiles:    cfg.HookLimitOpenFiles,
+		Processes:    cfg.HookLimitProcesses,
+		Memory:       cfg.HookLimitMemory,
+		CPUs:         cfg.HookLimitCPUs,
+	})
+	hookBridge := &hookRunnerBridge{runner: hooks}
+	incremental, err := installer.ParseIncremental(cfg.IncrementalInstall)
+	if err != nil {
+		return fmt.Errorf("agent: %w", err)
+	}
+	inst := installer.NewInstaller(&fileOperatorInstallerBridge{op: fileOp}, cfg.BackupOverwrittenFiles, incremental, logger)
+	inst.SetAllowSharedDestinations(cfg.AllowSharedDestinations)
+	templates := &templateSourceBridge{varsFile: cfg.TemplateVariablesFile}
+	if identity.StaticAccessKey == "" && identity.CredentialsFile == "" {
+		templates.imds = imds.NewClient(cfg.DisableIMDSv1, proxyTransport, logger)
+	}
+	inst.SetTemplateSource(templates)
+	instBridge := &installerBridge{inst: inst}
 
 	exec := executor.NewExecutor(
 		dl, unpacker, hookBridge, instBridge, fileOpBridge,
@@ -175,9 +210,29 @@ func Run(ctx context.Context, configPath string) error {
 		logger,
 	)
 
-	// Crash recovery: fail any in-progress deployments from before restart
+	// Crash recovery: undo half-finished installs, then fail any in-progress
+	// deployments from before restart
+	if err := inst.Recover(deployment.InstructionsDir(cfg.RootDir)); err != nil {
+		logger.Error("install rollback failed", "error", err)
+	}
 	p.RecoverFromCrash(ctx)
 
+	// Optional background garbage collection of the deployment root
+	if cfg.GCInterval > 0 {
+		collector := housekeeping.NewCollector(fileOpBridge, cfg.RootDir, cfg.OngoingDeploymentTracking,
+			housekeeping.Policy{
+				MaxRevisions:         cfg.MaxRevisions,
+				DeploymentLogsMaxAge: cfg.DeploymentLogsMaxAge,
+			}, logger)
+		go collector.RunPeriodic(ctx, cfg.GCInterval)
+	}
+
+	// Optional background drift detection against the last install
+	if cfg.VerifyInterval > 0 {
+		verifier := verify.NewVerifier(fileOp, cfg.RootDir, logger)
+		go verifier.RunPeriodic(ctx, cfg.VerifyInterval)
+	}
+
 	return p.Run(ctx)
 }
 
@@ -302,23 +357,63 @@ type fileOperatorBridge struct {
 
 func (f *fileOperatorBridge) MkdirAll(path string) error  { return f.op.MkdirAll(path) }
 func (f *fileOperatorBridge) RemoveAll(path string) error { return f.op.RemoveAll(path) }
+func (f *fileOperatorBridge) CopyTree(src, dst string) error {
+	return f.op.CopyTree(src, dst, filesystem.LinkNone)
+}
 
 // scriptRunnerBridge adapts scriptrunner.Runner to hookrunner.ScriptRunner.
 type scriptRunnerBridge struct {
 	sr *scriptrunner.Runner
 }
 
-func (s *scriptRunnerBridge) Run(ctx context.Context, scriptPath string, env map[string]string, timeoutSeconds int) (hookrunner.ScriptResult, error) {
-	result, err := s.sr.Run(ctx, scriptPath, env, timeoutSeconds)
+func (s *scriptRunnerBridge) Run(ctx context.Context, scriptPath string, env map[string]string, timeoutSeconds, graceSeconds int, limits appspec.Limits) (hookrunner.ScriptResult, error) {
+	result, err := s.sr.Run(ctx, scriptPath, env, timeoutSeconds, graceSeconds, scriptrunner.Limits(limits))
 	if err != nil {
-		return hookrunner.ScriptResult{}, err
+		return hookrunner.ScriptResult{}, preflightError(err)
 	}
-	return hookrunner.ScriptResult{
-		ExitCode: result.ExitCode,
-		Stdout:   result.Stdout,
-		Stderr:   result.Stderr,
-		TimedOut: result.TimedOut,
-	}, nil
+	out := hookrunner.ScriptResult{
+		ExitCode:        result.ExitCode,
+		Stdout:          result.Stdout,
+		Stderr:          result.Stderr,
+		TimedOut:        result.TimedOut,
+		Signals:         result.Signals,
+		OutputsLeftOpen: result.OutputsLeftOpen,
+		OpenOutputPIDs:  result.OpenOutputPIDs,
+	}
+	if result.Usage != nil {
+		out.Usage = result.Usage.String()
+	}
+	return out, nil
+}
+
+func (s *scriptRunnerBridge) RunCommand(ctx context.Context, shell, command string, env map[string]string, timeoutSeconds, graceSeconds int, limits appspec.Limits) (hookrunner.ScriptResult, error) {
+	result, err := s.sr.RunCommand(ctx, shell, command, env, timeoutSeconds, graceSeconds, scriptrunner.Limits(limits))
+	if err != nil {
+		return hookrunner.ScriptResult{}, preflightError(err)
+	}
+	out := hookrunner.ScriptResult{
+		ExitCode:        result.ExitCode,
+		Stdout:          result.Stdout,
+		Stderr:          result.Stderr,
+		TimedOut:        result.TimedOut,
+		Signals:         result.Signals,
+		OutputsLeftOpen: result.OutputsLeftOpen,
+		OpenOutputPIDs:  result.OpenOutputPIDs,
+	}
+	if result.Usage != nil {
+		out.Usage = result.Usage.String()
+	}
+	return out, nil
+}
+
+// preflightError converts a scriptrunner.PreflightError into the
+// hookrunner.PreflightError the hook runner reports with its diagnostic code.
+func preflightError(err error) error {
+	var pe *scriptrunner.PreflightError
+	if errors.As(err, &pe) {
+		return &hookrunner.PreflightError{Missing: pe.Missing, Reason: pe.Reason}
+	}
+	return err
 }
 
 // hookRunnerBridge adapts hookrunner.Runner to executor.HookRunner.
@@ -392,15 +487,70 @@ func (f *fileOperatorInstallerBridge) SetContext(path string, seUser, seType, se
 func (f *fileOperatorInstallerBridge) RemoveContext(path string) error {
 	return f.op.RemoveContext(path)
 }
-func (f *fileOperatorInstallerBridge) Remove(path string) error { return f.op.Remove(path) }
+func (f *fileOperatorInstallerBridge) Remove(path string) error    { return f.op.Remove(path) }
+func (f *fileOperatorInstallerBridge) RemoveAll(path string) error { return f.op.RemoveAll(path) }
+func (f *fileOperatorInstallerBridge) Symlink(target, link string) error {
+	return f.op.Symlink(target, link)
+}
+func (f *fileOperatorInstallerBridge) Rename(oldPath, newPath string) error {
+	return f.op.Rename(oldPath, newPath)
+}
 
 // installerBridge adapts installer.Installer to executor.Installer.
 type installerBridge struct {
 	inst *installer.Installer
 }
 
-func (i *installerBridge) Install(deploymentGroupID, archiveDir, instructionsDir string, spec appspec.Spec, fileExistsBehavior string) error {
-	return i.inst.Install(deploymentGroupID, archiveDir, instructionsDir, spec, fileExistsBehavior)
+func (i *installerBridge) Install(a executor.InstallArgs) error {
+	inst := i.inst.WithDeployment(render.Deployment{
+		ApplicationName:     a.ApplicationName,
+		DeploymentGroupName: a.DeploymentGroupName,
+		DeploymentGroupID:   a.DeploymentGroupID,
+		DeploymentID:        a.DeploymentID,
+	})
+	return inst.Install(a.DeploymentGroupID, a.DeploymentID, a.ArchiveDir, a.InstructionsDir, a.BackupDir, a.Spec, a.FileExistsBehavior)
+}
+
+// templateSourceBridge adapts IMDS and the template variables file to
+// installer.TemplateSource. Instance metadata is fetched on first use and
+// kept once it has been read.
+type templateSourceBridge struct {
+	imds     *imds.Client // nil on on-premises instances
+	varsFile string
+
+	mu       sync.Mutex
+	instance *render.Instance
+}
+
+func (t *templateSourceBridge) Instance() (render.Instance, error) {
+	if t.imds == nil {
+		return render.Instance{}, nil
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.instance != nil {
+		return *t.instance, nil
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
+	defer cancel()
+	doc, err := t.imds.IdentityDocument(ctx)
+	if err != nil {
+		return render.Instance{}, err
+	}
+	t.instance = &render.Instance{
+		InstanceID:       doc.InstanceID,
+		InstanceType:     doc.InstanceType,
+		AccountID:        doc.AccountID,
+		Region:           doc.Region,
+		AvailabilityZone: doc.AvailabilityZone,
+		PrivateIP:        doc.PrivateIP,
+		ImageID:          doc.ImageID,
+	}
+	return *t.instance, nil
+}
+
+func (t *templateSourceBridge) Variables() (map[string]any, error) {
+	return configloader.LoadTemplateVariables(t.varsFile)
 }
 
 // commandServiceBridge adapts codedeployctl.Client to poller.CommandService.
@@ -0,0 +1,94 @@
+// Package housekeeping wires the `codedeploy-agent gc` command: it loads the
+// agent configuration, runs a single deployment-root collection, and prints
+// the report as text or JSON.
+package housekeeping
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+
+	json "github.com/goccy/go-json"
+
+	"github.com/gurre/codedeploy-agent-go/adaptor/configloader"
+	"github.com/gurre/codedeploy-agent-go/adaptor/filesystem"
+	orchhousekeeping "github.com/gurre/codedeploy-agent-go/orchestration/housekeeping"
+)
+
+// Options controls a single gc run.
+type Options struct {
+	// ConfigFile is the agent configuration file providing root_dir,
+	// max_revisions and deployment_logs_retention_days.
+	ConfigFile string
+	// DryRun reports what would be removed without removing anything.
+	DryRun bool
+	// JSON prints the report as JSON instead of text.
+	JSON bool
+}
+
+// DefaultOptions returns options pointing at the default agent config.
+//
+//	opts := housekeeping.DefaultOptions()
+//	opts.DryRun = true
+func DefaultOptions() Options {
+	return Options{
+		ConfigFile: "/etc/codedeploy-agent/conf/codedeployagent.yml",
+	}
+}
+
+// Run performs one collection over the configured deployment root and writes
+// the report to out.
+//
+//	err := housekeeping.Run(ctx, opts, os.Stdout)
+func Run(ctx context.Context, opts Options, out io.Writer) error {
+	cfg, err := configloader.LoadAgent(opts.ConfigFile)
+	if err != nil {
+		return fmt.Errorf("housekeeping: load config: %w", err)
+	}
+
+	policy := orchhousekeeping.Policy{
+		MaxRevisions:         cfg.MaxRevisions,
+		DeploymentLogsMaxAge: cfg.DeploymentLogsMaxAge,
+	}
+	collector := orchhousekeeping.NewCollector(
+		filesystem.NewOperator(), cfg.RootDir, cfg.OngoingDeploymentTracking, policy, slog.Default())
+
+	report, err := collector.Collect(ctx, opts.DryRun)
+	if err != nil {
+		return err
+	}
+
+	if opts.JSON {
+		data, err := json.MarshalIndent(report, "", "  ")
+		if err != nil {
+			return fmt.Errorf("housekeeping: marshal report: %w", err)
+		}
+		_, err = fmt.Fprintf(out, "%s\n", data)
+		return err
+	}
+	return writeText(out, report)
+}
+
+func writeText(out io.Writer, report orchhousekeeping.Report) error {
+	verb := "removed"
+	if report.DryRun {
+		verb = "would remove"
+	}
+	failed := 0
+	for _, r := range report.Removed {
+		if r.Error != "" {
+			failed++
+			if _, err := fmt.Fprintf(out, "failed  %-14s %s (%s): %s\n", r.Kind, r.Path, r.Reason, r.Error); err != nil {
+				return err
+			}
+			continue
+		}
+		if _, err := fmt.Fprintf(out, "%s  %-14s %s (%s, %d bytes)\n", verb, r.Kind, r.Path, r.Reason, r.Bytes); err != nil {
+			return err
+		}
+	}
+	_, err := fmt.Fprintf(out, "%d %s, %d failed, %d bytes freed\n",
+		len(report.Removed)-failed, verb, failed, report.BytesFreed)
+	return err
+}
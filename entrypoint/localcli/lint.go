@@ -0,0 +1,244 @@
+package localcli
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+
+	json "github.com/goccy/go-json"
+
+	"github.com/gurre/codedeploy-agent-go/adaptor/archive"
+	"github.com/gurre/codedeploy-agent-go/logic/appspec"
+)
+
+// ErrLintFailed is returned by Lint, after printing the diagnostics, when the
+// appspec has errors. Warnings alone do not fail.
+var ErrLintFailed = errors.New("localcli: appspec has errors")
+
+// LintOptions selects the appspec to lint and how to print the result.
+type LintOptions struct {
+	BundleLocation  string
+	BundleType      string
+	AppSpecFilename string
+	// OS is the platform the appspec will be deployed to, linux or windows;
+	// empty uses the appspec's own os.
+	OS string
+	// Format is text, json or sarif.
+	Format string
+}
+
+// LintReport is the result of linting one appspec.
+type LintReport struct {
+	File        string               `json:"file"`
+	Errors      int                  `json:"errors"`
+	Warnings    int                  `json:"warnings"`
+	Diagnostics []appspec.Diagnostic `json:"diagnostics"`
+}
+
+// Lint validates the appspec of a local bundle against the target platform
+// in opts and prints every diagnostic, with its line and column, as text,
+// JSON or SARIF. Hook scripts and files sources are checked against the
+// bundle; archive bundles are unpacked into a temporary directory that is
+// removed afterwards.
+//
+//	err := localcli.Lint(ctx, localcli.LintOptions{BundleLocation: ".", BundleType: "directory", AppSpecFilename: "appspec.yml", Format: "text"}, os.Stdout)
+func Lint(_ context.Context, opts LintOptions, out io.Writer) error {
+	if isRemoteLocation(opts.BundleLocation) {
+		return fmt.Errorf("localcli: lint supports local bundles only, got %q", opts.BundleLocation)
+	}
+	switch opts.OS {
+	case "", "linux", "windows":
+	default:
+		return fmt.Errorf("localcli: invalid os %q (must be linux or windows)", opts.OS)
+	}
+	switch opts.Format {
+	case "text", "json", "sarif":
+	default:
+		return fmt.Errorf("localcli: invalid format %q (must be text, json, or sarif)", opts.Format)
+	}
+	if err := validate(Options{
+		BundleLocation:     opts.BundleLocation,
+		BundleType:         opts.BundleType,
+		AppSpecFilename:    opts.AppSpecFilename,
+		FileExistsBehavior: "DISALLOW",
+	}); err != nil {
+		return err
+	}
+
+	archiveDir := opts.BundleLocation
+	if opts.BundleType != "directory" {
+		tmp, err := os.MkdirTemp("", "codedeploy-lint-")
+		if err != nil {
+			return fmt.Errorf("localcli: lint: %w", err)
+		}
+		defer func() { _ = os.RemoveAll(tmp) }()
+		if err := archive.NewUnpacker().Unpack(opts.BundleLocation, tmp, opts.BundleType); err != nil {
+			return fmt.Errorf("localcli: lint: unpack: %w", err)
+		}
+		archiveDir = tmp
+	}
+
+	specPath, err := appspec.FindAppSpecFile(archiveDir, opts.AppSpecFilename)
+	if err != nil {
+		return fmt.Errorf("localcli: lint: %w", err)
+	}
+	data, err := os.ReadFile(specPath)
+	if err != nil {
+		return fmt.Errorf("localcli: lint: %w", err)
+	}
+
+	// Name the appspec as the user would find it: inside the bundle
+	// directory, or by its name inside an archive.
+	file := filepath.Base(specPath)
+	if opts.BundleType == "directory" {
+		file = filepath.Join(opts.BundleLocation, file)
+	}
+	report := LintReport{
+		File:        file,
+		Diagnostics: appspec.Lint(data, appspec.LintOptions{OS: opts.OS, Bundle: os.DirFS(archiveDir)}),
+	}
+	for _, d := range report.Diagnostics {
+		if d.Severity == appspec.SeverityError {
+			report.Errors++
+		} else {
+			report.Warnings++
+		}
+	}
+	if report.Diagnostics == nil {
+		report.Diagnostics = []appspec.Diagnostic{}
+	}
+
+	switch opts.Format {
+	case "json":
+		err = writeJSON(out, report)
+	case "sarif":
+		err = writeJSON(out, sarifLog(report))
+	default:
+		err = writeLintText(out, report)
+	}
+	if err != nil {
+		return err
+	}
+	if report.Errors > 0 {
+		return ErrLintFailed
+	}
+	return nil
+}
+
+// Schema prints the appspec JSON Schema, for editor YAML plugins and CI.
+//
+//	err := localcli.Schema(os.Stdout)
+func Schema(out io.Writer) error {
+	_, err := out.Write(appspec.Schema())
+	return err
+}
+
+func writeJSON(out io.Writer, v any) error {
+	data, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return fmt.Errorf("localcli: marshal: %w", err)
+	}
+	_, err = fmt.Fprintf(out, "%s\n", data)
+	return err
+}
+
+// writeLintText prints one file:line:col line per diagnostic, the format
+// editors and CI log viewers link to the source.
+func writeLintText(out io.Writer, r LintReport) error {
+	for _, d := range r.Diagnostics {
+		if _, err := fmt.Fprintf(out, "%s:%d:%d: %s: %s [%s]\n", r.File, d.Line, d.Column, d.Severity, d.Message, d.Rule); err != nil {
+			return err
+		}
+	}
+	_, err := fmt.Fprintf(out, "%s: %d errors, %d warnings\n", r.File, r.Errors, r.Warnings)
+	return err
+}
+
+// SARIF 2.1.0, the subset code scanning tools read.
+type sarifReport struct {
+	Schema  string     `json:"$schema"`
+	Version string     `json:"version"`
+	Runs    []sarifRun `json:"runs"`
+}
+
+type sarifRun struct {
+	Tool    sarifTool     `json:"tool"`
+	Results []sarifResult `json:"results"`
+}
+
+type sarifTool struct {
+	Driver sarifDriver `json:"driver"`
+}
+
+type sarifDriver struct {
+	Name  string      `json:"name"`
+	Rules []sarifRule `json:"rules"`
+}
+
+type sarifRule struct {
+	ID               string       `json:"id"`
+	ShortDescription sarifMessage `json:"shortDescription"`
+}
+
+type sarifMessage struct {
+	Text string `json:"text"`
+}
+
+type sarifResult struct {
+	RuleID    string          `json:"ruleId"`
+	Level     string          `json:"level"`
+	Message   sarifMessage    `json:"message"`
+	Locations []sarifLocation `json:"locations"`
+}
+
+type sarifLocation struct {
+	PhysicalLocation sarifPhysicalLocation `json:"physicalLocation"`
+}
+
+type sarifPhysicalLocation struct {
+	ArtifactLocation sarifArtifact `json:"artifactLocation"`
+	Region           *sarifRegion  `json:"region,omitempty"`
+}
+
+type sarifArtifact struct {
+	URI string `json:"uri"`
+}
+
+type sarifRegion struct {
+	StartLine   int `json:"startLine"`
+	StartColumn int `json:"startColumn,omitempty"`
+}
+
+func sarifLog(r LintReport) sarifReport {
+	rules := make([]sarifRule, len(appspec.LintRules))
+	for i, rule := range appspec.LintRules {
+		rules[i] = sarifRule{ID: rule.ID, ShortDescription: sarifMessage{Text: rule.Description}}
+	}
+	results := make([]sarifResult, 0, len(r.Diagnostics))
+	for _, d := range r.Diagnostics {
+		loc := sarifLocation{PhysicalLocation: sarifPhysicalLocation{
+			ArtifactLocation: sarifArtifact{URI: filepath.ToSlash(r.File)},
+		}}
+		// SARIF positions are 1-based; an unknown line leaves the region out.
+		if d.Line > 0 {
+			loc.PhysicalLocation.Region = &sarifRegion{StartLine: d.Line, StartColumn: d.Column}
+		}
+		results = append(results, sarifResult{
+			RuleID:    d.Rule,
+			Level:     string(d.Severity),
+			Message:   sarifMessage{Text: d.Message},
+			Locations: []sarifLocation{loc},
+		})
+	}
+	return sarifReport{
+		Schema:  "https://json.schemastore.org/sarif-2.1.0.json",
+		Version: "2.1.0",
+		Runs: []sarifRun{{
+			Tool:    sarifTool{Driver: sarifDriver{Name: "codedeploy-local", Rules: rules}},
+			Results: results,
+		}},
+	}
+}
@@ -0,0 +1,86 @@
+package localcli
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	json "github.com/goccy/go-json"
+)
+
+// TestLint_Text verifies the file:line:col output and that errors return
+// ErrLintFailed so CI can gate on the exit code.
+func TestLint_Text(t *testing.T) {
+	opts := lintFixture(t, "version: 0.0\nos: linux\nhooks:\n  AfterInstall:\n    - location: scripts/missing.sh\n")
+
+	var out bytes.Buffer
+	err := Lint(context.Background(), opts, &out)
+	if !errors.Is(err, ErrLintFailed) {
+		t.Fatalf("Lint err = %v, want ErrLintFailed", err)
+	}
+	want := filepath.Join(opts.BundleLocation, "appspec.yml") + ":5:17: error: script \"scripts/missing.sh\" is not in the bundle [script-missing]"
+	if !strings.Contains(out.String(), want) {
+		t.Errorf("output missing %q:\n%s", want, out.String())
+	}
+}
+
+// TestLint_WarningsPass verifies that warnings are reported without failing,
+// and that JSON output carries the counts and positions.
+func TestLint_WarningsPass(t *testing.T) {
+	opts := lintFixture(t, "version: 0.0\nos: linux\nhoks: {}\n")
+	opts.Format = "json"
+
+	var out bytes.Buffer
+	if err := Lint(context.Background(), opts, &out); err != nil {
+		t.Fatalf("Lint: %v", err)
+	}
+	var report LintReport
+	if err := json.Unmarshal(out.Bytes(), &report); err != nil {
+		t.Fatalf("unmarshal: %v\n%s", err, out.String())
+	}
+	if report.Errors != 0 || report.Warnings != 1 || report.Diagnostics[0].Rule != "unknown-key" || report.Diagnostics[0].Line != 3 {
+		t.Errorf("report = %+v", report)
+	}
+}
+
+// TestLint_SARIF verifies the SARIF envelope code scanning expects: one run,
+// the rule table, and results with 1-based regions.
+func TestLint_SARIF(t *testing.T) {
+	opts := lintFixture(t, "version: 0.0\nos: linux\nfile_exists_behavior: KEEP\n")
+	opts.Format = "sarif"
+	opts.OS = "windows"
+
+	var out bytes.Buffer
+	if err := Lint(context.Background(), opts, &out); !errors.Is(err, ErrLintFailed) {
+		t.Fatalf("Lint err = %v, want ErrLintFailed", err)
+	}
+	var log sarifReport
+	if err := json.Unmarshal(out.Bytes(), &log); err != nil {
+		t.Fatalf("unmarshal: %v\n%s", err, out.String())
+	}
+	if log.Version != "2.1.0" || len(log.Runs) != 1 || len(log.Runs[0].Tool.Driver.Rules) == 0 {
+		t.Fatalf("sarif envelope = %+v", log)
+	}
+	results := log.Runs[0].Results
+	if len(results) != 2 || results[0].RuleID != "os-mismatch" || results[1].RuleID != "invalid-value" {
+		t.Fatalf("results = %+v", results)
+	}
+	if r := results[1].Locations[0].PhysicalLocation.Region; r == nil || r.StartLine != 3 || r.StartColumn != 23 {
+		t.Errorf("region = %+v, want 3:23", r)
+	}
+}
+
+// lintFixture writes a bundle directory holding appspec and returns options
+// that lint it as text.
+func lintFixture(t *testing.T, appspec string) LintOptions {
+	t.Helper()
+	bundle := t.TempDir()
+	if err := os.WriteFile(filepath.Join(bundle, "appspec.yml"), []byte(appspec), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return LintOptions{BundleLocation: bundle, BundleType: "directory", AppSpecFilename: "appspec.yml", Format: "text"}
+}
@@ -6,22 +6,26 @@ package localcli
 import (
 	"context"
 	"crypto/rand"
+	"errors"
 	"fmt"
 	"log/slog"
 	"math/big"
 	"os"
 	"path/filepath"
 	"strings"
+	"time"
 
 	"github.com/gurre/codedeploy-agent-go/adaptor/archive"
 	"github.com/gurre/codedeploy-agent-go/adaptor/configloader"
 	"github.com/gurre/codedeploy-agent-go/adaptor/filesystem"
 	"github.com/gurre/codedeploy-agent-go/adaptor/githubdownload"
+	"github.com/gurre/codedeploy-agent-go/adaptor/imds"
 	"github.com/gurre/codedeploy-agent-go/adaptor/s3download"
 	"github.com/gurre/codedeploy-agent-go/adaptor/scriptrunner"
 	"github.com/gurre/codedeploy-agent-go/logic/appspec"
 	"github.com/gurre/codedeploy-agent-go/logic/deployspec"
 	"github.com/gurre/codedeploy-agent-go/logic/lifecycle"
+	"github.com/gurre/codedeploy-agent-go/logic/render"
 	"github.com/gurre/codedeploy-agent-go/orchestration/executor"
 	"github.com/gurre/codedeploy-agent-go/orchestration/hookrunner"
 	"github.com/gurre/codedeploy-agent-go/orchestration/installer"
@@ -40,6 +44,13 @@ type Options struct {
 	Events              []string
 	ConfigFile          string
 	AppSpecFilename     string
+	// LinkMode selects how Local Directory revisions are materialised in the
+	// archive directory: copy, hardlink, or reflink.
+	LinkMode string
+	// BackupOverwritten keeps files replaced under OVERWRITE so that
+	// `codedeploy-local restore` can put them back. The agent config's
+	// backup_overwritten_files also enables it.
+	BackupOverwritten bool
 }
 
 // DefaultOptions returns options with the same defaults as the Ruby CLI.
@@ -53,6 +64,7 @@ func DefaultOptions() Options {
 		DeploymentGroup:     "default-local-deployment-group",
 		DeploymentGroupName: "LocalFleet",
 		AppSpecFilename:     "appspec.yml",
+		LinkMode:            "copy",
 	}
 }
 
@@ -92,16 +104,9 @@ func Run(ctx context.Context, opts Options) error {
 		opts.BundleLocation = abs
 	}
 
-	// Load config for rootDir and max_revisions
-	rootDir := "/opt/codedeploy-agent/deployment-root"
-	maxRevisions := 5
-	if opts.ConfigFile != "" {
-		cfg, err := configloader.LoadAgent(opts.ConfigFile)
-		if err != nil {
-			return fmt.Errorf("localcli: load config: %w", err)
-		}
-		rootDir = cfg.RootDir
-		maxRevisions = cfg.MaxRevisions
+	settings, err := loadSettings(opts)
+	if err != nil {
+		return err
 	}
 
 	if opts.ApplicationName == "" {
@@ -122,9 +127,13 @@ func Run(ctx context.Context, opts Options) error {
 		"bundleLocation", opts.BundleLocation,
 		"bundleType", opts.BundleType,
 		"events", events)
+	logger.Info("hook environment",
+		"policy", settings.envPolicy.String(),
+		"inherited", settings.hookEnvironment())
 
 	// Build executor with custom events merged into hook mapping
-	exec, err := buildExecutor(ctx, rootDir, maxRevisions, opts.Events, logger)
+	linkMode, _ := filesystem.ParseLinkMode(opts.LinkMode) // checked by validate
+	exec, err := buildExecutor(ctx, settings, linkMode, opts.Events, logger)
 	if err != nil {
 		return fmt.Errorf("localcli: build executor: %w", err)
 	}
@@ -141,6 +150,106 @@ func Run(ctx context.Context, opts Options) error {
 	return nil
 }
 
+// settings are the agent config values a local deployment uses.
+type settings struct {
+	rootDir           string
+	maxRevisions      int
+	backupOverwritten bool
+	incremental       installer.Incremental
+	allowShared       bool
+	templateVarsFile  string
+	disableIMDSv1     bool
+	hookShell         string
+	hookGracePeriod   int
+	hookLimits        appspec.Limits
+	outputWait        time.Duration
+	leaveDaemons      bool
+	chmodScripts      bool
+	envPolicy         scriptrunner.EnvPolicy
+	envAllowlist      []string
+}
+
+// loadSettings reads the agent config named in opts, falling back to the
+// agent defaults when none is given.
+func loadSettings(opts Options) (settings, error) {
+	s := settings{
+		rootDir:           "/opt/codedeploy-agent/deployment-root",
+		maxRevisions:      5,
+		backupOverwritten: opts.BackupOverwritten,
+		incremental:       installer.IncrementalHash,
+		templateVarsFile:  "/etc/codedeploy-agent/conf/template-variables.yml",
+		outputWait:        10 * time.Second,
+		chmodScripts:      true,
+	}
+	if opts.ConfigFile == "" {
+		return s, nil
+	}
+	cfg, err := configloader.LoadAgent(opts.ConfigFile)
+	if err != nil {
+		return s, fmt.Errorf("localcli: load config: %w", err)
+	}
+	s.rootDir = cfg.RootDir
+	s.maxRevisions = cfg.MaxRevisions
+	s.backupOverwritten = s.backupOverwritten || cfg.BackupOverwrittenFiles
+	s.allowShared = cfg.AllowSharedDestinations
+	s.templateVarsFile = cfg.TemplateVariablesFile
+	s.disableIMDSv1 = cfg.DisableIMDSv1
+	s.hookShell = cfg.HookShell
+	s.hookGracePeriod = int(cfg.HookGracePeriod / time.Second)
+	s.hookLimits = appspec.Limits{
+		CPUTime:      int(cfg.HookLimitCPUTime / time.Second),
+		AddressSpace: cfg.HookLimitAddressSpace,
+		OpenFiles:    cfg.HookLimitOpenFiles,
+		Processes:    cfg.HookLimitProcesses,
+		Memory:       cfg.HookLimitMemory,
+		CPUs:         cfg.HookLimitCPUs,
+	}
+	s.outputWait = cfg.HookOutputWait
+	s.leaveDaemons = cfg.HookLeaveDaemonsRunning
+	s.chmodScripts = cfg.HookChmodScripts
+	s.envAllowlist = cfg.HookEnvAllowlist
+	if s.envPolicy, err = scriptrunner.ParseEnvPolicy(cfg.HookEnvPolicy); err != nil {
+		return s, fmt.Errorf("localcli: %w", err)
+	}
+	if s.incremental, err = installer.ParseIncremental(cfg.IncrementalInstall); err != nil {
+		return s, fmt.Errorf("localcli: %w", err)
+	}
+	return s, nil
+}
+
+// newInstaller returns an installer configured by s.
+func (s settings) newInstaller(fileOp *filesystem.Operator, logger *slog.Logger) *installer.Installer {
+	inst := installer.NewInstaller(&localFileOpInstallerBridge{op: fileOp}, s.backupOverwritten, s.incremental, logger)
+	inst.SetAllowSharedDestinations(s.allowShared)
+	inst.SetTemplateSource(&localTemplateSourceBridge{
+		imds:     imds.NewClient(s.disableIMDSv1, nil, logger),
+		varsFile: s.templateVarsFile,
+	})
+	return inst
+}
+
+// newScriptRunner returns a script runner with the environment policy of s.
+func (s settings) newScriptRunner(logger *slog.Logger) *scriptrunner.Runner {
+	sr := scriptrunner.NewRunner(logger)
+	sr.SetEnvPolicy(s.envPolicy, s.envAllowlist)
+	sr.SetOutputWait(s.outputWait, s.leaveDaemons)
+	sr.SetChmodScripts(s.chmodScripts)
+	return sr
+}
+
+// hookEnvironment returns the variables hook scripts inherit from this
+// process under the policy of s, with the values of secret-looking names
+// redacted for display.
+func (s settings) hookEnvironment() []string {
+	env := s.newScriptRunner(slog.Default()).Environ(nil)
+	for i, kv := range env {
+		if name, _, _ := strings.Cut(kv, "="); appspec.IsSecretName(name) {
+			env[i] = name + "=[REDACTED]"
+		}
+	}
+	return env
+}
+
 func validate(opts Options) error {
 	validTypes := map[string]bool{"tar": true, "tgz": true, "zip": true, "directory": true}
 	if !validTypes[opts.BundleType] {
@@ -152,6 +261,10 @@ func validate(opts Options) error {
 		return fmt.Errorf("localcli: invalid file-exists-behavior %q", opts.FileExistsBehavior)
 	}
 
+	if _, err := filesystem.ParseLinkMode(opts.LinkMode); err != nil {
+		return fmt.Errorf("localcli: %w", err)
+	}
+
 	if opts.BundleLocation == "" {
 		return fmt.Errorf("localcli: bundle location required")
 	}
@@ -334,10 +447,10 @@ func resolveEvents(userEvents []string) []string {
 	return result
 }
 
-func buildExecutor(ctx context.Context, rootDir string, maxRevisions int, customEvents []string, logger *slog.Logger) (*executor.Executor, error) {
+func buildExecutor(ctx context.Context, s settings, linkMode filesystem.LinkMode, customEvents []string, logger *slog.Logger) (*executor.Executor, error) {
 	unpacker := archive.NewUnpacker()
 	fileOp := filesystem.NewOperator()
-	sr := scriptrunner.NewRunner(logger)
+	sr := s.newScriptRunner(logger)
 
 	// Build S3 downloader (may not be needed for local, but wire it for S3 sources)
 	var s3dl *s3download.Downloader
@@ -358,13 +471,17 @@ func buildExecutor(ctx context.Context, rootDir string, maxRevisions int, custom
 	}
 
 	dl := &localDownloaderBridge{s3: s3dl, gh: ghDl}
-	fileOpBridge := &localFileOperatorBridge{op: fileOp}
-	hookBridge := &localHookRunnerBridge{runner: hookrunner.NewRunner(&localScriptRunnerBridge{sr: sr}, logger)}
-	instBridge := &localInstallerBridge{inst: installer.NewInstaller(&localFileOpInstallerBridge{op: fileOp}, logger)}
+	fileOpBridge := &localFileOperatorBridge{op: fileOp, linkMode: linkMode}
+	hooks := hookrunner.NewRunner(&localScriptRunnerBridge{sr: sr}, logger)
+	hooks.SetShell(s.hookShell)
+	hooks.SetGracePeriod(s.hookGracePeriod)
+	hooks.SetLimits(s.hookLimits)
+	hookBridge := &localHookRunnerBridge{runner: hooks}
+	instBridge := &localInstallerBridge{inst: s.newInstaller(fileOp, logger)}
 
 	return executor.NewExecutor(
 		dl, unpacker, hookBridge, instBridge, fileOpBridge,
-		rootDir, hookMapping, maxRevisions, logger,
+		s.rootDir, hookMapping, s.maxRevisions, logger,
 	), nil
 }
 
@@ -397,27 +514,68 @@ func (d *localDownloaderBridge) DownloadGitHub(ctx context.Context, account, rep
 }
 
 type localFileOperatorBridge struct {
-	op *filesystem.Operator
+	op       *filesystem.Operator
+	linkMode filesystem.LinkMode
 }
 
 func (f *localFileOperatorBridge) MkdirAll(path string) error  { return f.op.MkdirAll(path) }
 func (f *localFileOperatorBridge) RemoveAll(path string) error { return f.op.RemoveAll(path) }
+func (f *localFileOperatorBridge) CopyTree(src, dst string) error {
+	return f.op.CopyTree(src, dst, f.linkMode)
+}
 
 type localScriptRunnerBridge struct {
 	sr *scriptrunner.Runner
 }
 
-func (s *localScriptRunnerBridge) Run(ctx context.Context, scriptPath string, env map[string]string, timeoutSeconds int) (hookrunner.ScriptResult, error) {
-	result, err := s.sr.Run(ctx, scriptPath, env, timeoutSeconds)
+func (s *localScriptRunnerBridge) Run(ctx context.Context, scriptPath string, env map[string]string, timeoutSeconds, graceSeconds int, limits appspec.Limits) (hookrunner.ScriptResult, error) {
+	result, err := s.sr.Run(ctx, scriptPath, env, timeoutSeconds, graceSeconds, scriptrunner.Limits(limits))
 	if err != nil {
-		return hookrunner.ScriptResult{}, err
+		return hookrunner.ScriptResult{}, localPreflightError(err)
 	}
-	return hookrunner.ScriptResult{
-		ExitCode: result.ExitCode,
-		Stdout:   result.Stdout,
-		Stderr:   result.Stderr,
-		TimedOut: result.TimedOut,
-	}, nil
+	out := hookrunner.ScriptResult{
+		ExitCode:        result.ExitCode,
+		Stdout:          result.Stdout,
+		Stderr:          result.Stderr,
+		TimedOut:        result.TimedOut,
+		Signals:         result.Signals,
+		OutputsLeftOpen: result.OutputsLeftOpen,
+		OpenOutputPIDs:  result.OpenOutputPIDs,
+	}
+	if result.Usage != nil {
+		out.Usage = result.Usage.String()
+	}
+	return out, nil
+}
+
+func (s *localScriptRunnerBridge) RunCommand(ctx context.Context, shell, command string, env map[string]string, timeoutSeconds, graceSeconds int, limits appspec.Limits) (hookrunner.ScriptResult, error) {
+	result, err := s.sr.RunCommand(ctx, shell, command, env, timeoutSeconds, graceSeconds, scriptrunner.Limits(limits))
+	if err != nil {
+		return hookrunner.ScriptResult{}, localPreflightError(err)
+	}
+	out := hookrunner.ScriptResult{
+		ExitCode:        result.ExitCode,
+		Stdout:          result.Stdout,
+		Stderr:          result.Stderr,
+		TimedOut:        result.TimedOut,
+		Signals:         result.Signals,
+		OutputsLeftOpen: result.OutputsLeftOpen,
+		OpenOutputPIDs:  result.OpenOutputPIDs,
+	}
+	if result.Usage != nil {
+		out.Usage = result.Usage.String()
+	}
+	return out, nil
+}
+
+// localPreflightError converts a scriptrunner.PreflightError into the
+// hookrunner.PreflightError the hook runner reports with its diagnostic code.
+func localPreflightError(err error) error {
+	var pe *scriptrunner.PreflightError
+	if errors.As(err, &pe) {
+		return &hookrunner.PreflightError{Missing: pe.Missing, Reason: pe.Reason}
+	}
+	return err
 }
 
 type localHookRunnerBridge struct {
@@ -486,12 +644,55 @@ func (f *localFileOpInstallerBridge) SetContext(path string, seUser, seType, seR
 func (f *localFileOpInstallerBridge) RemoveContext(path string) error {
 	return f.op.RemoveContext(path)
 }
-func (f *localFileOpInstallerBridge) Remove(path string) error { return f.op.Remove(path) }
+func (f *localFileOpInstallerBridge) Remove(path string) error    { return f.op.Remove(path) }
+func (f *localFileOpInstallerBridge) RemoveAll(path string) error { return f.op.RemoveAll(path) }
+func (f *localFileOpInstallerBridge) Symlink(target, link string) error {
+	return f.op.Symlink(target, link)
+}
+func (f *localFileOpInstallerBridge) Rename(oldPath, newPath string) error {
+	return f.op.Rename(oldPath, newPath)
+}
 
 type localInstallerBridge struct {
 	inst *installer.Installer
 }
 
-func (i *localInstallerBridge) Install(deploymentGroupID, archiveDir, instructionsDir string, spec appspec.Spec, fileExistsBehavior string) error {
-	return i.inst.Install(deploymentGroupID, archiveDir, instructionsDir, spec, fileExistsBehavior)
+func (i *localInstallerBridge) Install(a executor.InstallArgs) error {
+	inst := i.inst.WithDeployment(render.Deployment{
+		ApplicationName:     a.ApplicationName,
+		DeploymentGroupName: a.DeploymentGroupName,
+		DeploymentGroupID:   a.DeploymentGroupID,
+		DeploymentID:        a.DeploymentID,
+	})
+	return inst.Install(a.DeploymentGroupID, a.DeploymentID, a.ArchiveDir, a.InstructionsDir, a.BackupDir, a.Spec, a.FileExistsBehavior)
+}
+
+// localTemplateSourceBridge adapts IMDS and the template variables file to
+// installer.TemplateSource. Local deployments often run off EC2, so the
+// metadata lookup is short and its failure leaves .Instance empty.
+type localTemplateSourceBridge struct {
+	imds     *imds.Client
+	varsFile string
+}
+
+func (t *localTemplateSourceBridge) Instance() (render.Instance, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	doc, err := t.imds.IdentityDocument(ctx)
+	if err != nil {
+		return render.Instance{}, err
+	}
+	return render.Instance{
+		InstanceID:       doc.InstanceID,
+		InstanceType:     doc.InstanceType,
+		AccountID:        doc.AccountID,
+		Region:           doc.Region,
+		AvailabilityZone: doc.AvailabilityZone,
+		PrivateIP:        doc.PrivateIP,
+		ImageID:          doc.ImageID,
+	}, nil
+}
+
+func (t *localTemplateSourceBridge) Variables() (map[string]any, error) {
+	return configloader.LoadTemplateVariables(t.varsFile)
 }
@@ -71,6 +71,21 @@ func TestValidate_InvalidFileExistsBehavior(t *testing.T) {
 	}
 }
 
+// TestValidate_InvalidLinkMode verifies that an unknown --link-mode is
+// rejected before DownloadBundle would fall back to an unexpected copy mode.
+func TestValidate_InvalidLinkMode(t *testing.T) {
+	opts := DefaultOptions()
+	opts.BundleLocation = "/tmp"
+	opts.LinkMode = "symlink"
+	err := validate(opts)
+	if err == nil {
+		t.Fatal("expected error for invalid link mode")
+	}
+	if !strings.Contains(err.Error(), "invalid link mode") {
+		t.Errorf("error = %v, want mention of link mode", err)
+	}
+}
+
 // TestValidate_MissingBundleLocation verifies that an empty bundle location
 // is rejected. The bundle location is the primary required input.
 func TestValidate_MissingBundleLocation(t *testing.T) {
@@ -0,0 +1,354 @@
+package localcli
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+
+	json "github.com/goccy/go-json"
+
+	"github.com/gurre/codedeploy-agent-go/adaptor/archive"
+	"github.com/gurre/codedeploy-agent-go/adaptor/filesystem"
+	"github.com/gurre/codedeploy-agent-go/logic/appspec"
+	"github.com/gurre/codedeploy-agent-go/logic/instruction"
+	"github.com/gurre/codedeploy-agent-go/logic/lifecycle"
+	"github.com/gurre/codedeploy-agent-go/logic/render"
+	"github.com/gurre/codedeploy-agent-go/orchestration/installer"
+	"github.com/gurre/codedeploy-agent-go/state/deployment"
+)
+
+// ErrPlanConflicts is returned by Plan, after printing the plan, when the
+// install would fail because destination files exist under DISALLOW or are
+// managed by another deployment group.
+var ErrPlanConflicts = errors.New("localcli: install would fail: destinations exist or belong to another deployment group")
+
+// PlanOptions selects the bundle to plan and how to print the plan.
+type PlanOptions struct {
+	Options
+	// JSON prints the plan as JSON instead of text.
+	JSON bool
+}
+
+// DeploymentPlan is what a local deployment of a bundle would do.
+type DeploymentPlan struct {
+	DeploymentGroupID  string `json:"deployment_group_id"`
+	Bundle             string `json:"bundle"`
+	FileExistsBehavior string `json:"file_exists_behavior"`
+	installer.Plan
+	Hooks []PlannedHook `json:"hooks"`
+	// EnvPolicy and Environment are the hook_env_policy and the variables
+	// hook scripts inherit under it, before the deployment variables and
+	// the appspec's hook environment are added. Secret-looking values are
+	// redacted.
+	EnvPolicy   string   `json:"env_policy"`
+	Environment []string `json:"environment"`
+}
+
+// PlannedHook is a lifecycle event with the scripts it would run.
+type PlannedHook struct {
+	Event          string          `json:"event"`
+	DeploymentRoot string          `json:"deployment_root"`
+	ArchiveDir     string          `json:"archive_dir"`
+	Scripts        []PlannedScript `json:"scripts"`
+}
+
+// PlannedScript is one hook script as declared in the appspec.
+type PlannedScript struct {
+	Location string `json:"location,omitempty"`
+	Command  string `json:"command,omitempty"`
+	Shell    string `json:"shell,omitempty"`
+	Timeout  int    `json:"timeout"`
+	// GracePeriod is the script's grace_period, nil when it uses the
+	// agent's hook_grace_period_seconds.
+	GracePeriod *int   `json:"grace_period,omitempty"`
+	RunAs       string `json:"runas,omitempty"`
+	// Retries is how many times a failed script is run again.
+	Retries int `json:"retries,omitempty"`
+}
+
+// Plan prints what a local deployment of opts would do: the previous
+// deployment's cleanup, the install commands, retained files, DISALLOW
+// conflicts, and the hooks of each lifecycle event with the deployment root
+// they would run from. It never runs hooks and never writes to the
+// deployment root or the destinations; archive bundles are unpacked into a
+// temporary directory that is removed afterwards. Remote bundles are not
+// supported.
+//
+//	err := localcli.Plan(ctx, localcli.PlanOptions{Options: opts}, os.Stdout)
+func Plan(_ context.Context, opts PlanOptions, out io.Writer) error {
+	if isRemoteLocation(opts.BundleLocation) {
+		return fmt.Errorf("localcli: plan supports local bundles only, got %q", opts.BundleLocation)
+	}
+	if err := validate(opts.Options); err != nil {
+		return err
+	}
+	bundle, err := filepath.Abs(opts.BundleLocation)
+	if err != nil {
+		return fmt.Errorf("localcli: resolve path: %w", err)
+	}
+	s, err := loadSettings(opts.Options)
+	if err != nil {
+		return err
+	}
+
+	archiveDir := bundle
+	if opts.BundleType != "directory" {
+		tmp, err := os.MkdirTemp("", "codedeploy-plan-")
+		if err != nil {
+			return fmt.Errorf("localcli: plan: %w", err)
+		}
+		defer func() { _ = os.RemoveAll(tmp) }()
+		if err := archive.NewUnpacker().Unpack(bundle, tmp, opts.BundleType); err != nil {
+			return fmt.Errorf("localcli: plan: unpack: %w", err)
+		}
+		archiveDir = tmp
+	}
+
+	specPath, err := appspec.FindAppSpecFile(archiveDir, opts.AppSpecFilename)
+	if err != nil {
+		return fmt.Errorf("localcli: plan: %w", err)
+	}
+	spec, err := appspec.ParseFile(specPath)
+	if err != nil {
+		return fmt.Errorf("localcli: plan: %w", err)
+	}
+
+	feb := strings.ToUpper(opts.FileExistsBehavior)
+	// Plan only reads; the file operator is never called. Templates are
+	// rendered with the deployment variables a local deployment would get.
+	appName := opts.ApplicationName
+	if appName == "" {
+		appName = opts.BundleLocation
+	}
+	inst := s.newInstaller(filesystem.NewOperator(), slog.Default()).WithDeployment(render.Deployment{
+		ApplicationName:     appName,
+		DeploymentGroupName: opts.DeploymentGroupName,
+		DeploymentGroupID:   opts.DeploymentGroup,
+		DeploymentID:        "d-plan",
+	})
+	installPlan, err := inst.Plan(opts.DeploymentGroup, "d-plan", archiveDir, deployment.InstructionsDir(s.rootDir), spec, feb)
+	if err != nil {
+		return fmt.Errorf("localcli: %w", err)
+	}
+
+	plan := DeploymentPlan{
+		DeploymentGroupID:  opts.DeploymentGroup,
+		Bundle:             bundle,
+		FileExistsBehavior: feb,
+		Plan:               installPlan,
+		Hooks:              planHooks(s.rootDir, opts, archiveDir, spec),
+		EnvPolicy:          s.envPolicy.String(),
+		Environment:        s.hookEnvironment(),
+	}
+
+	if opts.JSON {
+		data, err := json.MarshalIndent(plan, "", "  ")
+		if err != nil {
+			return fmt.Errorf("localcli: marshal plan: %w", err)
+		}
+		if _, err := fmt.Fprintf(out, "%s\n", data); err != nil {
+			return err
+		}
+	} else if err := writePlanText(out, plan, archiveDir); err != nil {
+		return err
+	}
+	if len(plan.Conflicts)+len(plan.GroupConflicts) > 0 {
+		return ErrPlanConflicts
+	}
+	return nil
+}
+
+// planHooks resolves, for every lifecycle event the deployment would run, the
+// archive its hooks come from and the scripts defined there. The selection
+// mirrors hookrunner: the previous deployment's archive for events that run
+// before install, falling back to this bundle on a first deployment.
+func planHooks(rootDir string, opts PlanOptions, archiveDir string, current appspec.Spec) []PlannedHook {
+	hooks := make([]PlannedHook, 0, len(defaultOrderedEvents))
+	for _, name := range resolveEvents(opts.Events) {
+		if name == "DownloadBundle" || name == "Install" {
+			continue
+		}
+		event := lifecycle.Event(name)
+		root := lifecycle.SelectDeploymentRoot(event, "user", "IN_PLACE")
+		hook := PlannedHook{Event: name, DeploymentRoot: root.String(), ArchiveDir: archiveDir}
+
+		spec := current
+		var pointer string
+		switch root {
+		case lifecycle.LastSuccessful:
+			pointer = deployment.LastSuccessfulFile(rootDir, opts.DeploymentGroup)
+		case lifecycle.MostRecent:
+			pointer = deployment.MostRecentFile(rootDir, opts.DeploymentGroup)
+		}
+		if pointer != "" {
+			if prev, ok := previousArchive(pointer); ok {
+				if specPath, err := appspec.FindAppSpecFile(prev, opts.AppSpecFilename); err == nil {
+					if parsed, err := appspec.ParseFile(specPath); err == nil {
+						spec, hook.ArchiveDir = parsed, prev
+					}
+				}
+			} else {
+				hook.DeploymentRoot = lifecycle.Current.String()
+			}
+		}
+		hook.Scripts = make([]PlannedScript, 0, len(spec.Hooks[name]))
+		for _, s := range spec.Hooks[name] {
+			hook.Scripts = append(hook.Scripts, PlannedScript{Location: s.Location, Command: s.Command, Shell: s.Shell, Timeout: s.Timeout, GracePeriod: s.GracePeriod, RunAs: s.RunAs, Retries: s.Retries})
+		}
+		hooks = append(hooks, hook)
+	}
+	return hooks
+}
+
+// previousArchive reads a deployment pointer file and returns the archive
+// directory it points at, if that still exists.
+func previousArchive(pointer string) (string, bool) {
+	data, err := os.ReadFile(pointer)
+	if err != nil {
+		return "", false
+	}
+	dir := filepath.Join(strings.TrimSpace(string(data)), "deployment-archive")
+	if _, err := os.Stat(dir); err != nil {
+		return "", false
+	}
+	return dir, true
+}
+
+func writePlanText(out io.Writer, plan DeploymentPlan, archiveDir string) error {
+	w := &planWriter{out: out}
+	w.printf("Plan for deployment group %s from %s (file_exists_behavior %s)\n", plan.DeploymentGroupID, plan.Bundle, plan.FileExistsBehavior)
+
+	w.printf("\nCleanup of the previous deployment:\n")
+	for _, e := range plan.Cleanup {
+		if e.Type == instruction.EntryContext {
+			w.printf("  - semanage fcontext %s\n", e.Path)
+			continue
+		}
+		w.printf("  - %s\n", e.Path)
+	}
+	for _, e := range plan.KeptChanged {
+		w.printf("  ~ %s (kept: changed since install)\n", e.Path)
+	}
+	if len(plan.Cleanup)+len(plan.KeptChanged) == 0 {
+		w.printf("  (nothing)\n")
+	}
+
+	overwrites := make(map[string]bool, len(plan.Overwrites))
+	for _, p := range plan.Overwrites {
+		overwrites[p] = true
+	}
+	var copies, unchanged int
+	w.printf("\nInstall:\n")
+	for _, c := range plan.Commands {
+		switch c.Type {
+		case instruction.TypeMkdir:
+			w.printf("  + mkdir    %s\n", c.Directory)
+		case instruction.TypeCopy:
+			src := c.Source
+			if rel, err := filepath.Rel(archiveDir, c.Source); err == nil {
+				src = rel
+			}
+			if c.Template {
+				src += " (template)"
+			}
+			switch {
+			case c.Unchanged:
+				unchanged++
+				w.printf("  = copy     %s (unchanged)\n", c.Destination)
+			case overwrites[c.Destination]:
+				copies++
+				w.printf("  ~ copy     %s <- %s (overwrite)\n", c.Destination, src)
+			default:
+				copies++
+				w.printf("  + copy     %s <- %s\n", c.Destination, src)
+			}
+		case instruction.TypeChmod:
+			w.printf("    chmod    %s %s\n", c.Mode, c.File)
+		case instruction.TypeChown:
+			w.printf("    chown    %s:%s %s\n", c.Owner, c.Group, c.File)
+		case instruction.TypeSetfacl:
+			w.printf("    setfacl  %s %s\n", strings.Join(c.ACL, ","), c.File)
+		case instruction.TypeSemanage:
+			if c.Context != nil {
+				w.printf("    semanage %s %s\n", c.Context.Type, c.File)
+			}
+		}
+	}
+
+	if len(plan.Retained) > 0 {
+		w.printf("\nRetained (RETAIN):\n")
+		for _, p := range plan.Retained {
+			w.printf("  = %s\n", p)
+		}
+	}
+	if len(plan.Conflicts) > 0 {
+		w.printf("\nConflicts (DISALLOW):\n")
+		for _, p := range plan.Conflicts {
+			w.printf("  ! %s already exists\n", p)
+		}
+	}
+	if len(plan.GroupConflicts) > 0 {
+		w.printf("\nManaged by other deployment groups:\n")
+		for _, c := range plan.GroupConflicts {
+			w.printf("  ! %s (%s)\n", c.Path, c.DeploymentGroupID)
+		}
+	}
+
+	w.printf("\nHooks:\n")
+	scripts := 0
+	for _, h := range plan.Hooks {
+		if len(h.Scripts) == 0 {
+			continue
+		}
+		scripts += len(h.Scripts)
+		w.printf("  %s (%s: %s)\n", h.Event, h.DeploymentRoot, h.ArchiveDir)
+		for _, s := range h.Scripts {
+			w.printf("    %s (timeout %ds", appspec.Script{Location: s.Location, Command: s.Command}.Name(), s.Timeout)
+			if s.GracePeriod != nil {
+				w.printf(", grace %ds", *s.GracePeriod)
+			}
+			if s.Shell != "" {
+				w.printf(", shell %s", s.Shell)
+			}
+			if s.RunAs != "" {
+				w.printf(", runas %s", s.RunAs)
+			}
+			if s.Retries > 0 {
+				w.printf(", retries %d", s.Retries)
+			}
+			w.printf(")\n")
+		}
+	}
+	if scripts == 0 {
+		w.printf("  (none)\n")
+	}
+
+	w.printf("\nHook environment (policy %s):\n", plan.EnvPolicy)
+	for _, kv := range plan.Environment {
+		w.printf("  %s\n", kv)
+	}
+	if len(plan.Environment) == 0 {
+		w.printf("  (none)\n")
+	}
+
+	w.printf("\n%d to copy, %d unchanged, %d to remove, %d retained, %d conflicts\n",
+		copies, unchanged, len(plan.Cleanup), len(plan.Retained), len(plan.Conflicts)+len(plan.GroupConflicts))
+	return w.err
+}
+
+// planWriter keeps the first write error so the text output reads linearly.
+type planWriter struct {
+	out io.Writer
+	err error
+}
+
+func (w *planWriter) printf(format string, args ...any) {
+	if w.err == nil {
+		_, w.err = fmt.Fprintf(w.out, format, args...)
+	}
+}
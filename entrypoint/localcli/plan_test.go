@@ -0,0 +1,161 @@
+package localcli
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	json "github.com/goccy/go-json"
+)
+
+// TestPlan_ReportsConflictsAndHooksWithoutDeploying verifies that plan prints
+// the copies, the DISALLOW conflict and the hooks of a directory bundle,
+// returns ErrPlanConflicts so scripts can gate on it, and leaves both the
+// destination and the deployment root untouched.
+func TestPlan_ReportsConflictsAndHooksWithoutDeploying(t *testing.T) {
+	bundle, dest, rootDir, opts := planFixture(t)
+
+	var out bytes.Buffer
+	err := Plan(context.Background(), opts, &out)
+	if !errors.Is(err, ErrPlanConflicts) {
+		t.Fatalf("Plan err = %v, want ErrPlanConflicts", err)
+	}
+
+	text := out.String()
+	for _, want := range []string{
+		"+ copy     " + filepath.Join(dest, "new.txt"),
+		"! " + filepath.Join(dest, "existing.txt") + " already exists",
+		"ApplicationStart (current: " + bundle + ")",
+		"scripts/start.sh (timeout 30s, retries 1)",
+	} {
+		if !strings.Contains(text, want) {
+			t.Errorf("plan output missing %q:\n%s", want, text)
+		}
+	}
+	if _, err := os.Lstat(filepath.Join(dest, "new.txt")); !os.IsNotExist(err) {
+		t.Errorf("plan must not copy files, Lstat err = %v", err)
+	}
+	if _, err := os.Lstat(rootDir); !os.IsNotExist(err) {
+		t.Errorf("plan must not create the deployment root, Lstat err = %v", err)
+	}
+}
+
+// TestPlan_JSON verifies that --json output decodes into DeploymentPlan with
+// the install plan fields inlined, which is what tooling consumes.
+func TestPlan_JSON(t *testing.T) {
+	_, dest, _, opts := planFixture(t)
+	opts.FileExistsBehavior = "RETAIN"
+	opts.JSON = true
+
+	var out bytes.Buffer
+	if err := Plan(context.Background(), opts, &out); err != nil {
+		t.Fatalf("Plan: %v", err)
+	}
+	var plan DeploymentPlan
+	if err := json.Unmarshal(out.Bytes(), &plan); err != nil {
+		t.Fatalf("decode plan: %v\n%s", err, out.String())
+	}
+	if len(plan.Retained) != 1 || plan.Retained[0] != filepath.Join(dest, "existing.txt") {
+		t.Errorf("Retained = %v, want existing.txt", plan.Retained)
+	}
+	if plan.Conflicts == nil || len(plan.Conflicts) != 0 {
+		t.Errorf("Conflicts = %v, want empty list", plan.Conflicts)
+	}
+	if len(plan.Commands) == 0 || len(plan.Hooks) == 0 {
+		t.Errorf("plan = %+v, want commands and hooks", plan)
+	}
+}
+
+// TestPlan_HookEnvironment verifies that the plan shows the environment
+// hook scripts would inherit under the configured policy, with secret
+// values redacted.
+func TestPlan_HookEnvironment(t *testing.T) {
+	_, _, rootDir, opts := planFixture(t)
+	opts.FileExistsBehavior = "OVERWRITE"
+	opts.JSON = true
+	config := "root_dir: " + rootDir + "\nhook_env_policy: allowlist\nhook_env_allowlist: [PLAN_TEST_*]\n"
+	if err := os.WriteFile(opts.ConfigFile, []byte(config), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("PLAN_TEST_MODE", "blue")
+	t.Setenv("PLAN_TEST_TOKEN", "s3cret")
+	t.Setenv("OTHER_TEST_VAR", "x")
+
+	var out bytes.Buffer
+	if err := Plan(context.Background(), opts, &out); err != nil {
+		t.Fatalf("Plan: %v", err)
+	}
+	var plan DeploymentPlan
+	if err := json.Unmarshal(out.Bytes(), &plan); err != nil {
+		t.Fatalf("decode plan: %v\n%s", err, out.String())
+	}
+	want := []string{"PLAN_TEST_MODE=blue", "PLAN_TEST_TOKEN=[REDACTED]"}
+	if plan.EnvPolicy != "allowlist" || strings.Join(plan.Environment, " ") != strings.Join(want, " ") {
+		t.Errorf("policy = %q, environment = %v, want %v", plan.EnvPolicy, plan.Environment, want)
+	}
+}
+
+// TestPlan_RejectsRemoteBundle verifies that plan refuses S3 and GitHub
+// bundles, since planning them would mean downloading.
+func TestPlan_RejectsRemoteBundle(t *testing.T) {
+	opts := PlanOptions{Options: DefaultOptions()}
+	opts.BundleLocation = "s3://bucket/app.zip"
+	opts.BundleType = "zip"
+	if err := Plan(context.Background(), opts, &bytes.Buffer{}); err == nil || !strings.Contains(err.Error(), "local bundles only") {
+		t.Errorf("Plan err = %v, want local bundles only", err)
+	}
+}
+
+// planFixture creates a directory bundle with two files and a hook, a
+// destination where one of the files already exists, and an agent config
+// pointing at a deployment root that does not exist yet.
+func planFixture(t *testing.T) (bundle, dest, rootDir string, opts PlanOptions) {
+	t.Helper()
+	bundle = t.TempDir()
+	dest = t.TempDir()
+	rootDir = filepath.Join(t.TempDir(), "deployment-root")
+
+	appspec := fmt.Sprintf(`version: 0.0
+os: %s
+files:
+  - source: app
+    destination: %s
+hooks:
+  ApplicationStart:
+    - location: scripts/start.sh
+      timeout: 30
+      retries: 1
+`, testOS(), dest)
+	files := map[string]string{
+		"appspec.yml":      appspec,
+		"app/new.txt":      "new",
+		"app/existing.txt": "new",
+		"scripts/start.sh": "#!/bin/sh\n",
+	}
+	for name, content := range files {
+		path := filepath.Join(bundle, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.WriteFile(filepath.Join(dest, "existing.txt"), []byte("old"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	config := filepath.Join(t.TempDir(), "agent.yml")
+	if err := os.WriteFile(config, []byte("root_dir: "+rootDir+"\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	opts = PlanOptions{Options: DefaultOptions()}
+	opts.BundleLocation = bundle
+	opts.ConfigFile = config
+	return bundle, dest, rootDir, opts
+}
@@ -0,0 +1,84 @@
+package localcli
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+
+	"github.com/gurre/codedeploy-agent-go/adaptor/configloader"
+	"github.com/gurre/codedeploy-agent-go/adaptor/filesystem"
+	"github.com/gurre/codedeploy-agent-go/orchestration/installer"
+	"github.com/gurre/codedeploy-agent-go/state/deployment"
+)
+
+// RestoreOptions selects the deployment whose overwritten files are restored.
+type RestoreOptions struct {
+	DeploymentID    string
+	DeploymentGroup string // optional; all groups are searched when empty
+	ConfigFile      string
+}
+
+// Restore puts back the files a deployment overwrote, as recorded in its
+// backup directory, and prints each restored path to out.
+//
+//	err := localcli.Restore(ctx, localcli.RestoreOptions{DeploymentID: "d-ABC123"}, os.Stdout)
+func Restore(_ context.Context, opts RestoreOptions, out io.Writer) error {
+	if opts.DeploymentID == "" {
+		return fmt.Errorf("localcli: deployment id required")
+	}
+
+	rootDir := "/opt/codedeploy-agent/deployment-root"
+	if opts.ConfigFile != "" {
+		cfg, err := configloader.LoadAgent(opts.ConfigFile)
+		if err != nil {
+			return fmt.Errorf("localcli: load config: %w", err)
+		}
+		rootDir = cfg.RootDir
+	}
+
+	backupDir, err := findBackupDir(rootDir, opts.DeploymentGroup, opts.DeploymentID)
+	if err != nil {
+		return err
+	}
+
+	inst := installer.NewInstaller(&localFileOpInstallerBridge{op: filesystem.NewOperator()}, false, installer.IncrementalOff, slog.Default())
+	restored, err := inst.Restore(backupDir)
+	for _, path := range restored {
+		if _, werr := fmt.Fprintf(out, "restored %s\n", path); werr != nil {
+			return werr
+		}
+	}
+	if err != nil {
+		return fmt.Errorf("localcli: %w", err)
+	}
+	_, err = fmt.Fprintf(out, "%d files restored from %s\n", len(restored), opts.DeploymentID)
+	return err
+}
+
+// findBackupDir locates the backup directory of a deployment. Without a group
+// every deployment group under rootDir is searched.
+func findBackupDir(rootDir, groupID, deploymentID string) (string, error) {
+	if groupID != "" {
+		dir := deployment.NewLayout(rootDir, groupID, deploymentID).BackupDir()
+		if _, err := os.Stat(dir); err != nil {
+			return "", fmt.Errorf("localcli: no backup for deployment %s in group %s", deploymentID, groupID)
+		}
+		return dir, nil
+	}
+
+	matches, err := filepath.Glob(deployment.NewLayout(rootDir, "*", deploymentID).BackupDir())
+	if err != nil {
+		return "", fmt.Errorf("localcli: search backups: %w", err)
+	}
+	switch len(matches) {
+	case 0:
+		return "", fmt.Errorf("localcli: no backup for deployment %s under %s", deploymentID, rootDir)
+	case 1:
+		return matches[0], nil
+	default:
+		return "", fmt.Errorf("localcli: deployment %s found in several groups, pass --deployment-group", deploymentID)
+	}
+}
@@ -0,0 +1,54 @@
+package localcli
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// TestFindBackupDir_SearchesGroups verifies that without a deployment group
+// the backup is found by deployment ID alone, which is all a user knows from
+// the deployment output.
+func TestFindBackupDir_SearchesGroups(t *testing.T) {
+	root := t.TempDir()
+	want := filepath.Join(root, "dg-1", "d-1", "overwritten-backup")
+	if err := os.MkdirAll(want, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.MkdirAll(filepath.Join(root, "dg-2", "d-2", "overwritten-backup"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := findBackupDir(root, "", "d-1")
+	if err != nil {
+		t.Fatalf("findBackupDir: %v", err)
+	}
+	if got != want {
+		t.Errorf("findBackupDir = %q, want %q", got, want)
+	}
+}
+
+// TestFindBackupDir_Missing verifies that a deployment without a backup is
+// reported rather than restoring nothing.
+func TestFindBackupDir_Missing(t *testing.T) {
+	root := t.TempDir()
+	if _, err := findBackupDir(root, "", "d-1"); err == nil {
+		t.Error("expected error without group")
+	}
+	if _, err := findBackupDir(root, "dg-1", "d-1"); err == nil {
+		t.Error("expected error with group")
+	}
+}
+
+// TestRestore_RequiresDeploymentID verifies that restore refuses to guess
+// which deployment to restore.
+func TestRestore_RequiresDeploymentID(t *testing.T) {
+	var out bytes.Buffer
+	err := Restore(context.Background(), RestoreOptions{}, &out)
+	if err == nil || !strings.Contains(err.Error(), "deployment id") {
+		t.Errorf("expected deployment id error, got %v", err)
+	}
+}
@@ -0,0 +1,97 @@
+// Package verify wires the `codedeploy-agent verify` command: it loads the
+// agent configuration, compares the last install of each deployment group
+// with the disk, and prints the findings as text or JSON.
+package verify
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+
+	json "github.com/goccy/go-json"
+
+	"github.com/gurre/codedeploy-agent-go/adaptor/configloader"
+	"github.com/gurre/codedeploy-agent-go/adaptor/filesystem"
+	orchverify "github.com/gurre/codedeploy-agent-go/orchestration/verify"
+)
+
+// ErrDrift is returned by Run when at least one drifted path was found.
+var ErrDrift = errors.New("verify: drift detected")
+
+// Options controls a single verify run.
+type Options struct {
+	// ConfigFile is the agent configuration file providing root_dir.
+	ConfigFile string
+	// DeploymentGroup restricts the check to one group; all groups with an
+	// install record are checked when empty.
+	DeploymentGroup string
+	// JSON prints the report as JSON instead of text.
+	JSON bool
+}
+
+// DefaultOptions returns options pointing at the default agent config.
+//
+//	opts := verify.DefaultOptions()
+//	opts.JSON = true
+func DefaultOptions() Options {
+	return Options{
+		ConfigFile: "/etc/codedeploy-agent/conf/codedeployagent.yml",
+	}
+}
+
+// Run verifies the configured deployment root and writes the report to out.
+// It returns ErrDrift after writing the report when anything drifted.
+//
+//	err := verify.Run(ctx, opts, os.Stdout)
+func Run(ctx context.Context, opts Options, out io.Writer) error {
+	cfg, err := configloader.LoadAgent(opts.ConfigFile)
+	if err != nil {
+		return fmt.Errorf("verify: load config: %w", err)
+	}
+
+	verifier := orchverify.NewVerifier(filesystem.NewOperator(), cfg.RootDir, slog.Default())
+	report, err := verifier.Verify(ctx, opts.DeploymentGroup)
+	if err != nil {
+		return err
+	}
+
+	if opts.JSON {
+		data, err := json.MarshalIndent(report, "", "  ")
+		if err != nil {
+			return fmt.Errorf("verify: marshal report: %w", err)
+		}
+		if _, err := fmt.Fprintf(out, "%s\n", data); err != nil {
+			return err
+		}
+	} else if err := writeText(out, report); err != nil {
+		return err
+	}
+	if report.Drift() {
+		return ErrDrift
+	}
+	return nil
+}
+
+func writeText(out io.Writer, report orchverify.Report) error {
+	for _, f := range report.Findings {
+		line := fmt.Sprintf("%-9s %s (%s)", f.Kind, f.Path, f.DeploymentGroupID)
+		if f.Expected != "" || f.Actual != "" {
+			line += fmt.Sprintf(": expected %s, found %s", orNone(f.Expected), orNone(f.Actual))
+		}
+		if _, err := fmt.Fprintln(out, line); err != nil {
+			return err
+		}
+	}
+	_, err := fmt.Fprintf(out, "%d paths checked in %d deployment groups, %d drifted\n",
+		report.Checked, len(report.Groups), len(report.Findings))
+	return err
+}
+
+func orNone(s string) string {
+	if s == "" {
+		return "-"
+	}
+	return s
+}
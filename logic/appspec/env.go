@@ -0,0 +1,158 @@
+package appspec
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"regexp"
+	"slices"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// DeploymentVariables are the variables the agent sets for every hook
+// script. Hook environments may reference them as ${NAME} but may not
+// redefine them. The BUNDLE_ variables are only set for S3 and GitHub
+// revisions and expand to an empty string otherwise.
+var DeploymentVariables = []string{
+	"LIFECYCLE_EVENT",
+	"DEPLOYMENT_ID",
+	"APPLICATION_NAME",
+	"DEPLOYMENT_GROUP_NAME",
+	"DEPLOYMENT_GROUP_ID",
+	"BUNDLE_BUCKET",
+	"BUNDLE_KEY",
+	"BUNDLE_VERSION",
+	"BUNDLE_ETAG",
+	"BUNDLE_COMMIT",
+}
+
+// Keys of the hooks mapping that configure every hook rather than name a
+// lifecycle event.
+const (
+	hookEnvironmentKey = "environment"
+	hookEnvFileKey     = "env_file"
+)
+
+var (
+	envNamePattern    = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+	envRefPattern     = regexp.MustCompile(`\$\{([^}]*)\}`)
+	secretNamePattern = regexp.MustCompile(`(?i)PASSW(OR)?D|SECRET|TOKEN|CREDENTIAL|PRIVATE|API_?KEY|ACCESS_?KEY`)
+)
+
+// IsSecretName reports whether an environment variable's name suggests it
+// holds a secret, so its value is kept out of logs and output.
+//
+//	appspec.IsSecretName("DB_PASSWORD") // true
+func IsSecretName(name string) bool {
+	return secretNamePattern.MatchString(name)
+}
+
+// ExpandEnv replaces each ${NAME} in value with vars[NAME]. Any other $ is
+// kept as written, so values such as passwords need no escaping.
+//
+//	appspec.ExpandEnv("/srv/${DEPLOYMENT_GROUP_NAME}", env) // "/srv/staging"
+func ExpandEnv(value string, vars map[string]string) string {
+	return envRefPattern.ReplaceAllStringFunc(value, func(ref string) string {
+		return vars[ref[2:len(ref)-1]]
+	})
+}
+
+// ParseEnvFile parses an env file: NAME=value lines, optionally prefixed
+// with export, with blank lines and # comments ignored. A value wrapped in
+// matching single or double quotes has them removed. Each variable is
+// validated as an environment entry in the appspec is.
+//
+//	env, err := appspec.ParseEnvFile(data)
+func ParseEnvFile(data []byte) (map[string]string, error) {
+	env := make(map[string]string)
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		text = strings.TrimPrefix(text, "export ")
+		name, value, ok := strings.Cut(text, "=")
+		if !ok {
+			return nil, fmt.Errorf("appspec: env file line %d: expected NAME=value", line)
+		}
+		name, value = strings.TrimSpace(name), strings.TrimSpace(value)
+		if len(value) >= 2 && (value[0] == '"' || value[0] == '\'') && value[len(value)-1] == value[0] {
+			value = value[1 : len(value)-1]
+		}
+		if err := checkEnvVar(name, value); err != nil {
+			return nil, fmt.Errorf("appspec: env file line %d: %w", line, err)
+		}
+		env[name] = value
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("appspec: env file: %w", err)
+	}
+	return env, nil
+}
+
+// parseHookEnvironment reads the environment and env_file keys of the hooks
+// mapping, which apply to every hook script.
+func parseHookEnvironment(node yaml.Node) (map[string]string, string, error) {
+	if node.Kind != yaml.MappingNode {
+		return nil, "", nil
+	}
+	var raw struct {
+		Environment map[string]string `yaml:"environment"`
+		EnvFile     string            `yaml:"env_file"`
+	}
+	if err := node.Decode(&raw); err != nil {
+		return nil, "", fmt.Errorf("appspec: hooks environment: %w", err)
+	}
+	env, envFile, err := parseEnv(raw.Environment, raw.EnvFile)
+	if err != nil {
+		return nil, "", fmt.Errorf("appspec: hooks %w", err)
+	}
+	return env, envFile, nil
+}
+
+// parseEnv validates an environment map and env_file path. The error is
+// phrased to follow the hook it belongs to.
+func parseEnv(env map[string]string, envFile string) (map[string]string, string, error) {
+	for name, value := range env {
+		if err := checkEnvVar(name, value); err != nil {
+			return nil, "", fmt.Errorf("environment: %w", err)
+		}
+	}
+	envFile = strings.TrimSpace(envFile)
+	if envFile != "" {
+		if _, ok := bundlePath(envFile); !ok {
+			return nil, "", fmt.Errorf("env_file %q is outside the bundle", envFile)
+		}
+	}
+	if len(env) == 0 {
+		env = nil
+	}
+	return env, envFile, nil
+}
+
+// checkEnvVar validates one hook environment variable: a shell-safe name
+// that is not a deployment variable, and a value whose ${NAME} references
+// are all deployment variables.
+func checkEnvVar(name, value string) error {
+	if !envNamePattern.MatchString(name) {
+		return fmt.Errorf("invalid variable name %q", name)
+	}
+	if slices.Contains(DeploymentVariables, name) {
+		return fmt.Errorf("%s is set by the agent and cannot be overridden", name)
+	}
+	if strings.ContainsRune(value, 0) {
+		return fmt.Errorf("%s contains a NUL byte", name)
+	}
+	for _, m := range envRefPattern.FindAllStringSubmatch(value, -1) {
+		if !slices.Contains(DeploymentVariables, m[1]) {
+			return fmt.Errorf("%s references ${%s}, which is not a deployment variable", name, m[1])
+		}
+	}
+	if strings.Count(value, "${") != len(envRefPattern.FindAllString(value, -1)) {
+		return fmt.Errorf("%s has an unterminated ${", name)
+	}
+	return nil
+}
@@ -0,0 +1,60 @@
+package appspec
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// TestParseEnvFile verifies the env file syntax: comments, export prefixes,
+// quoted values, and values containing = or a literal $.
+func TestParseEnvFile(t *testing.T) {
+	data := []byte(`# database
+export DB_HOST=db.internal
+DB_PASSWORD='p$ss=word'
+SITE="/srv/${DEPLOYMENT_GROUP_NAME}"
+
+EMPTY=
+`)
+	env, err := ParseEnvFile(data)
+	if err != nil {
+		t.Fatalf("ParseEnvFile: %v", err)
+	}
+	want := map[string]string{
+		"DB_HOST":     "db.internal",
+		"DB_PASSWORD": "p$ss=word",
+		"SITE":        "/srv/${DEPLOYMENT_GROUP_NAME}",
+		"EMPTY":       "",
+	}
+	if !reflect.DeepEqual(env, want) {
+		t.Errorf("env = %v, want %v", env, want)
+	}
+}
+
+// TestParseEnvFile_Errors verifies that malformed lines and invalid
+// variables are reported with their line number.
+func TestParseEnvFile_Errors(t *testing.T) {
+	for data, want := range map[string]string{
+		"A=1\nnot a variable\n":    "line 2: expected NAME=value",
+		"A-B=1\n":                  `line 1: invalid variable name "A-B"`,
+		"LIFECYCLE_EVENT=x\n":      "line 1: LIFECYCLE_EVENT is set by the agent",
+		"A=${HOME}/app\n":          "line 1: A references ${HOME}, which is not a deployment variable",
+		"A=${DEPLOYMENT_ID\n":      "line 1: A has an unterminated ${",
+		"\n\nA=x\x00\n":            "line 3: A contains a NUL byte",
+		"A=${DEPLOYMENT_ID}${X}\n": "which is not a deployment variable",
+	} {
+		_, err := ParseEnvFile([]byte(data))
+		if err == nil || !strings.Contains(err.Error(), want) {
+			t.Errorf("ParseEnvFile(%q) err = %v, want %q", data, err, want)
+		}
+	}
+}
+
+// TestExpandEnv verifies that only ${NAME} is expanded, so a bare $ in a
+// value such as a password survives.
+func TestExpandEnv(t *testing.T) {
+	vars := map[string]string{"DEPLOYMENT_GROUP_NAME": "staging"}
+	if got := ExpandEnv("/srv/${DEPLOYMENT_GROUP_NAME}/$HOME/${BUNDLE_KEY}", vars); got != "/srv/staging/$HOME/" {
+		t.Errorf("ExpandEnv = %q", got)
+	}
+}
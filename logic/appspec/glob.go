@@ -0,0 +1,167 @@
+package appspec
+
+import (
+	"fmt"
+	"path"
+	"strings"
+)
+
+// FileFilter selects the files of a directory source by include and exclude
+// globs. Paths are slash-separated and relative to the source directory.
+type FileFilter struct {
+	Include []string
+	Exclude []string
+}
+
+// Empty reports whether the filter selects everything.
+func (f FileFilter) Empty() bool {
+	return len(f.Include) == 0 && len(f.Exclude) == 0
+}
+
+// Excluded reports whether rel matches an exclude pattern. An excluded
+// directory is skipped with everything below it.
+func (f FileFilter) Excluded(rel string) bool {
+	return matchAny(f.Exclude, rel)
+}
+
+// Included reports whether rel matches an include pattern, or whether there
+// are none. An included directory brings in everything below it that is not
+// excluded.
+//
+//	f := appspec.FileFilter{Include: []string{"**/*.php"}, Exclude: []string{"**/tests/**"}}
+//	f.Included("src/index.php") // true
+func (f FileFilter) Included(rel string) bool {
+	return len(f.Include) == 0 || matchAny(f.Include, rel)
+}
+
+func matchAny(patterns []string, rel string) bool {
+	for _, p := range patterns {
+		if MatchGlob(p, rel) {
+			return true
+		}
+	}
+	return false
+}
+
+// MatchGlob reports whether name, a slash-separated relative path, matches
+// pattern in doublestar syntax: * and ? match within one path segment, [...]
+// matches a character class, {a,b} matches either alternative, and a **
+// segment matches any number of segments, including none. Malformed patterns
+// match nothing; ValidateGlob reports them.
+//
+//	appspec.MatchGlob("**/*.log", "var/app.log") // true
+//	appspec.MatchGlob("*.log", "var/app.log")    // false
+func MatchGlob(pattern, name string) bool {
+	alternatives, err := expandBraces(pattern)
+	if err != nil {
+		return false
+	}
+	nameSegs := strings.Split(name, "/")
+	for _, alt := range alternatives {
+		if matchSegments(strings.Split(alt, "/"), nameSegs) {
+			return true
+		}
+	}
+	return false
+}
+
+// ValidateGlob checks that pattern is a well-formed relative glob.
+func ValidateGlob(pattern string) error {
+	if pattern == "" {
+		return fmt.Errorf("empty pattern")
+	}
+	if strings.HasPrefix(pattern, "/") {
+		return fmt.Errorf("pattern %q must be relative to the source", pattern)
+	}
+	alternatives, err := expandBraces(pattern)
+	if err != nil {
+		return fmt.Errorf("pattern %q: %w", pattern, err)
+	}
+	for _, alt := range alternatives {
+		for _, seg := range strings.Split(alt, "/") {
+			if _, err := path.Match(seg, ""); err != nil {
+				return fmt.Errorf("pattern %q: %w", pattern, err)
+			}
+		}
+	}
+	return nil
+}
+
+func matchSegments(pat, name []string) bool {
+	for len(pat) > 0 {
+		if pat[0] == "**" {
+			for len(pat) > 1 && pat[1] == "**" {
+				pat = pat[1:]
+			}
+			for i := 0; i <= len(name); i++ {
+				if matchSegments(pat[1:], name[i:]) {
+					return true
+				}
+			}
+			return false
+		}
+		if len(name) == 0 {
+			return false
+		}
+		if ok, err := path.Match(pat[0], name[0]); err != nil || !ok {
+			return false
+		}
+		pat, name = pat[1:], name[1:]
+	}
+	return len(name) == 0
+}
+
+// expandBraces expands {a,b} alternatives, including nested ones, into plain
+// patterns. A backslash escapes the next character.
+func expandBraces(pattern string) ([]string, error) {
+	open := -1
+	for i := 0; i < len(pattern); i++ {
+		switch pattern[i] {
+		case '\\':
+			i++
+		case '{':
+			open = i
+		case '}':
+			return nil, fmt.Errorf("unmatched '}'")
+		}
+		if open >= 0 {
+			break
+		}
+	}
+	if open < 0 {
+		return []string{pattern}, nil
+	}
+
+	depth, start := 0, open+1
+	var options []string
+	for i := open; i < len(pattern); i++ {
+		switch pattern[i] {
+		case '\\':
+			i++
+		case '{':
+			depth++
+		case ',':
+			if depth == 1 {
+				options = append(options, pattern[start:i])
+				start = i + 1
+			}
+		case '}':
+			depth--
+			if depth > 0 {
+				continue
+			}
+			options = append(options, pattern[start:i])
+			prefix, suffix := pattern[:open], pattern[i+1:]
+			var out []string
+			for _, opt := range options {
+				expanded, err := expandBraces(prefix + opt + suffix)
+				if err != nil {
+					return nil, err
+				}
+				out = append(out, expanded...)
+			}
+			return out, nil
+		}
+	}
+	return nil, fmt.Errorf("unmatched '{'")
+}
@@ -0,0 +1,68 @@
+package appspec
+
+import "testing"
+
+// TestMatchGlob covers the doublestar rules files filters rely on: * stays
+// within a segment, ** spans any number of segments including none, and
+// braces and classes expand as in shells.
+func TestMatchGlob(t *testing.T) {
+	cases := []struct {
+		pattern, name string
+		want          bool
+	}{
+		{"*.log", "app.log", true},
+		{"*.log", "var/app.log", false},
+		{"**/*.log", "app.log", true},
+		{"**/*.log", "var/log/app.log", true},
+		{".git", ".git", true},
+		{"**/.git", "vendor/lib/.git", true},
+		{"tests/**", "tests", true},
+		{"tests/**", "tests/unit/a_test.go", true},
+		{"tests/**", "testsuite/a", false},
+		{"src/**/*.php", "src/index.php", true},
+		{"src/**/*.php", "src/a/b/index.php", true},
+		{"*.{js,css}", "app.css", true},
+		{"*.{js,css}", "app.html", false},
+		{"{public,assets/{img,css}}/**", "assets/css/site.css", true},
+		{"file-?.txt", "file-1.txt", true},
+		{"file-[0-9].txt", "file-x.txt", false},
+		{`\*.txt`, "*.txt", true},
+		{`\*.txt`, "a.txt", false},
+	}
+	for _, c := range cases {
+		if got := MatchGlob(c.pattern, c.name); got != c.want {
+			t.Errorf("MatchGlob(%q, %q) = %v, want %v", c.pattern, c.name, got, c.want)
+		}
+	}
+}
+
+// TestValidateGlob verifies that malformed patterns are caught, so that a
+// typo fails the appspec at parse time instead of silently matching nothing.
+func TestValidateGlob(t *testing.T) {
+	for _, ok := range []string{"**/*.go", "{a,b}/c", "[a-z]*", `\{literal\}`} {
+		if err := ValidateGlob(ok); err != nil {
+			t.Errorf("ValidateGlob(%q) = %v, want nil", ok, err)
+		}
+	}
+	for _, bad := range []string{"", "/etc/*", "[a-", "{a,b", "a}", "src/[", "{a,[}"} {
+		if err := ValidateGlob(bad); err == nil {
+			t.Errorf("ValidateGlob(%q) = nil, want error", bad)
+		}
+	}
+}
+
+// TestFileFilter verifies that an empty include list includes everything and
+// that excludes are checked independently of includes.
+func TestFileFilter(t *testing.T) {
+	all := FileFilter{Exclude: []string{".git"}}
+	if !all.Included("any/file") || !all.Excluded(".git") || all.Excluded("src/.git") {
+		t.Errorf("exclude-only filter misbehaves: %+v", all)
+	}
+	php := FileFilter{Include: []string{"**/*.php"}}
+	if php.Included("README.md") || !php.Included("src/index.php") {
+		t.Errorf("include filter misbehaves: %+v", php)
+	}
+	if (FileFilter{}).Empty() != true || php.Empty() {
+		t.Error("Empty misreports")
+	}
+}
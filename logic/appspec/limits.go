@@ -0,0 +1,146 @@
+package appspec
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// Limits caps the resources of a hook script. Zero fields are unset.
+type Limits struct {
+	// CPUTime is the CPU time in seconds each process of the script may
+	// use before it is killed (RLIMIT_CPU).
+	CPUTime int
+	// AddressSpace caps each process's virtual memory in bytes (RLIMIT_AS).
+	AddressSpace int64
+	// OpenFiles caps each process's open file descriptors (RLIMIT_NOFILE).
+	OpenFiles int
+	// Processes caps the processes of the script and its descendants
+	// together, through the cgroup v2 pids.max.
+	Processes int
+	// Memory caps the memory of the script and its descendants together in
+	// bytes, through the cgroup v2 memory.max.
+	Memory int64
+	// CPUs caps the CPU of the script and its descendants together, in
+	// CPUs, through the cgroup v2 cpu.max.
+	CPUs float64
+}
+
+// IsZero reports whether no limit is set.
+func (l Limits) IsZero() bool {
+	return l == Limits{}
+}
+
+// Or returns l with its unset fields taken from def, so a script's limits
+// override the agent's one by one.
+//
+//	limits := script.Limits.Or(agentLimits)
+func (l Limits) Or(def Limits) Limits {
+	if l.CPUTime == 0 {
+		l.CPUTime = def.CPUTime
+	}
+	if l.AddressSpace == 0 {
+		l.AddressSpace = def.AddressSpace
+	}
+	if l.OpenFiles == 0 {
+		l.OpenFiles = def.OpenFiles
+	}
+	if l.Processes == 0 {
+		l.Processes = def.Processes
+	}
+	if l.Memory == 0 {
+		l.Memory = def.Memory
+	}
+	if l.CPUs == 0 {
+		l.CPUs = def.CPUs
+	}
+	return l
+}
+
+type rawLimits struct {
+	CPUTime      interface{} `yaml:"cpu_time"`
+	AddressSpace interface{} `yaml:"address_space"`
+	OpenFiles    interface{} `yaml:"open_files"`
+	Processes    interface{} `yaml:"processes"`
+	Memory       interface{} `yaml:"memory"`
+	CPUs         interface{} `yaml:"cpus"`
+}
+
+// ParseSize parses a byte size: a whole number of bytes, or one followed by
+// K, M, G or T for powers of 1024.
+//
+//	n, err := appspec.ParseSize("512M") // 536870912
+func ParseSize(v interface{}) (int64, error) {
+	var s string
+	switch sv := v.(type) {
+	case int:
+		s = strconv.Itoa(sv)
+	case string:
+		s = strings.TrimSpace(sv)
+	default:
+		return 0, fmt.Errorf("invalid size %v", v)
+	}
+	shift := 0
+	if i := strings.IndexAny(s, "KMGTkmgt"); i >= 0 && i == len(s)-1 {
+		shift = 10 * (strings.IndexByte("KMGT", strings.ToUpper(s[i:])[0]) + 1)
+		s = s[:i]
+	}
+	n, err := strconv.ParseInt(s, 10, 64)
+	if err != nil || n <= 0 || n > math.MaxInt64>>shift {
+		return 0, fmt.Errorf("invalid size %v, want a positive number of bytes with an optional K, M, G or T suffix", v)
+	}
+	return n << shift, nil
+}
+
+// parseLimits validates a script's limits mapping.
+func parseLimits(raw *rawLimits, hookName string) (Limits, error) {
+	var l Limits
+	if raw == nil {
+		return l, nil
+	}
+	count := func(key string, v interface{}, dst *int) error {
+		if v == nil {
+			return nil
+		}
+		if n, ok := v.(int); ok && n > 0 {
+			*dst = n
+			return nil
+		}
+		return fmt.Errorf("appspec: invalid limits %s value (%v) in hook %q, must be a positive integer", key, v, hookName)
+	}
+	size := func(key string, v interface{}, dst *int64) error {
+		if v == nil {
+			return nil
+		}
+		n, err := ParseSize(v)
+		if err != nil {
+			return fmt.Errorf("appspec: limits %s in hook %q: %w", key, hookName, err)
+		}
+		*dst = n
+		return nil
+	}
+	for _, err := range []error{
+		count("cpu_time", raw.CPUTime, &l.CPUTime),
+		size("address_space", raw.AddressSpace, &l.AddressSpace),
+		count("open_files", raw.OpenFiles, &l.OpenFiles),
+		count("processes", raw.Processes, &l.Processes),
+		size("memory", raw.Memory, &l.Memory),
+	} {
+		if err != nil {
+			return Limits{}, err
+		}
+	}
+	if raw.CPUs != nil {
+		switch cv := raw.CPUs.(type) {
+		case int:
+			l.CPUs = float64(cv)
+		case float64:
+			l.CPUs = cv
+		}
+		if l.CPUs <= 0 {
+			return Limits{}, fmt.Errorf("appspec: invalid limits cpus value (%v) in hook %q, must be a positive number", raw.CPUs, hookName)
+		}
+	}
+	return l, nil
+}
@@ -0,0 +1,596 @@
+package appspec
+
+import (
+	"fmt"
+	"io/fs"
+	"path"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Severity grades a lint diagnostic. Errors fail a deployment; warnings
+// point at something the agent ignores or works around.
+type Severity string
+
+const (
+	SeverityError   Severity = "error"
+	SeverityWarning Severity = "warning"
+)
+
+// Diagnostic is one problem Lint found, positioned at the YAML node it
+// concerns. Line and Column are 1-based; zero means the position is unknown.
+type Diagnostic struct {
+	Rule     string   `json:"rule"`
+	Severity Severity `json:"severity"`
+	Message  string   `json:"message"`
+	Line     int      `json:"line"`
+	Column   int      `json:"column"`
+}
+
+// LintRule describes a rule Lint reports.
+type LintRule struct {
+	ID          string
+	Description string
+}
+
+// LintRules lists every rule Lint reports, in a stable order.
+var LintRules = []LintRule{
+	{"syntax", "The appspec is not valid YAML."},
+	{"missing-key", "A required key is missing or empty."},
+	{"invalid-value", "A value that is malformed or out of range."},
+	{"unknown-key", "A key the agent does not recognise and ignores."},
+	{"os-mismatch", "The appspec os differs from the target platform."},
+	{"platform", "A setting the target platform does not support."},
+	{"total-timeout", "The scripts of one lifecycle event may run for more than 3600 seconds in total."},
+	{"script-missing", "A hook script is not in the bundle."},
+	{"script-not-executable", "A hook script lacks the executable bit, which the agent adds before running it."},
+	{"source-missing", "A files source is not in the bundle."},
+	{"env-file-missing", "A hook env_file is not in the bundle."},
+}
+
+// LintOptions configures Lint.
+type LintOptions struct {
+	// OS is the platform the appspec will be deployed to, linux or windows.
+	// Empty lints against the appspec's own os.
+	OS string
+	// Bundle is the revision the appspec belongs to, rooted at the directory
+	// holding the appspec. Nil skips the checks of hook scripts, env files
+	// and files sources.
+	Bundle fs.FS
+}
+
+var (
+	topLevelKeys  = keySet("version", "os", "hooks", "files", "permissions", "file_exists_behavior", "release", "allow_shared_destinations")
+	scriptKeys    = keySet("location", "command", "shell", "runas", "sudo", "timeout", "grace_period", "environment", "env_file", "limits", "retries", "retry_delay", "retry_on_exit_codes")
+	limitKeys     = keySet("cpu_time", "address_space", "open_files", "processes", "memory", "cpus")
+	fileKeys      = keySet("source", "destination", "include", "exclude", "file_exists_behavior", "templates")
+	permKeys      = keySet("object", "pattern", "except", "type", "owner", "group", "mode", "acls", "context")
+	releaseKeys   = keySet("root", "keep")
+	yamlErrorLine = regexp.MustCompile(`line (\d+): `)
+)
+
+// Lint validates an appspec without stopping at the first problem and
+// without regard to the platform it runs on. Unlike Parse it reports every
+// error and warning with its position, flags unknown keys, and, given the
+// bundle, checks that hook scripts and files sources exist. Diagnostics are
+// ordered by position.
+//
+//	diags := appspec.Lint(data, appspec.LintOptions{OS: "linux", Bundle: os.DirFS(bundleDir)})
+//	for _, d := range diags { fmt.Printf("%d:%d: %s\n", d.Line, d.Column, d.Message) }
+func Lint(data []byte, opts LintOptions) []Diagnostic {
+	l := &linter{opts: opts, target: opts.OS}
+	l.lint(data)
+	sort.SliceStable(l.diags, func(i, j int) bool {
+		a, b := l.diags[i], l.diags[j]
+		if a.Line != b.Line {
+			return a.Line < b.Line
+		}
+		return a.Column < b.Column
+	})
+	return l.diags
+}
+
+type linter struct {
+	opts   LintOptions
+	target string // platform checks are skipped while empty
+	diags  []Diagnostic
+}
+
+func (l *linter) add(n *yaml.Node, sev Severity, rule, format string, args ...any) {
+	d := Diagnostic{Rule: rule, Severity: sev, Message: fmt.Sprintf(format, args...)}
+	if n != nil {
+		d.Line, d.Column = n.Line, n.Column
+	}
+	l.diags = append(l.diags, d)
+}
+
+// fail reports a validation error from the parser at n.
+func (l *linter) fail(n *yaml.Node, rule string, err error) {
+	l.add(n, SeverityError, rule, "%s", strings.TrimPrefix(err.Error(), "appspec: "))
+}
+
+// decode decodes n into v, reporting a type mismatch at n.
+func (l *linter) decode(n *yaml.Node, v any) bool {
+	if err := n.Decode(v); err != nil {
+		msg := strings.TrimPrefix(err.Error(), "yaml: unmarshal errors:\n")
+		msg = yamlErrorLine.ReplaceAllString(strings.TrimSpace(msg), "")
+		l.add(n, SeverityError, "invalid-value", "%s", msg)
+		return false
+	}
+	return true
+}
+
+func (l *linter) lint(data []byte) {
+	var doc yaml.Node
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		d := Diagnostic{Rule: "syntax", Severity: SeverityError, Message: strings.TrimPrefix(err.Error(), "yaml: ")}
+		if m := yamlErrorLine.FindStringSubmatch(d.Message); m != nil {
+			d.Line, _ = strconv.Atoi(m[1])
+			d.Message = yamlErrorLine.ReplaceAllString(d.Message, "")
+		}
+		l.diags = append(l.diags, d)
+		return
+	}
+	if len(doc.Content) == 0 {
+		l.add(nil, SeverityError, "missing-key", "appspec is empty")
+		return
+	}
+	root := doc.Content[0]
+	if root.Kind != yaml.MappingNode {
+		l.add(root, SeverityError, "invalid-value", "appspec must be a mapping")
+		return
+	}
+	fields := l.mapping(root, topLevelKeys, "")
+
+	if n := fields["version"]; n == nil {
+		l.add(root, SeverityError, "missing-key", "version is required")
+	} else {
+		var v interface{}
+		if l.decode(n, &v) {
+			if _, err := parseVersion(v); err != nil {
+				l.fail(n, "invalid-value", err)
+			}
+		}
+	}
+
+	if n := fields["os"]; n == nil {
+		l.add(root, SeverityError, "missing-key", "os is required")
+	} else {
+		var v string
+		if l.decode(n, &v) {
+			if _, err := parseOS(v); err != nil {
+				l.fail(n, "invalid-value", err)
+			} else if l.target == "" {
+				l.target = v
+			} else if v != l.target {
+				l.add(n, SeverityError, "os-mismatch", "appspec specifies %q but the target is %q", v, l.target)
+			}
+		}
+	}
+
+	if n := fields["file_exists_behavior"]; n != nil {
+		var v string
+		if l.decode(n, &v) {
+			if _, err := parseFileExistsBehavior(v); err != nil {
+				l.fail(n, "invalid-value", err)
+			}
+		}
+	}
+	if n := fields["allow_shared_destinations"]; n != nil {
+		var v bool
+		l.decode(n, &v)
+	}
+	if n := fields["hooks"]; n != nil {
+		l.lintHooks(n)
+	}
+	if n := fields["files"]; n != nil {
+		l.lintFiles(n)
+	}
+	if n := fields["permissions"]; n != nil {
+		l.lintPermissions(n, keyNode(root, "permissions"))
+	}
+	if n := fields["release"]; n != nil {
+		l.lintRelease(n, keyNode(root, "release"))
+	}
+}
+
+// mapping returns the values of a mapping node by key and warns about keys
+// outside known. where names the enclosing entry in the warning.
+func (l *linter) mapping(n *yaml.Node, known map[string]bool, where string) map[string]*yaml.Node {
+	fields := make(map[string]*yaml.Node, len(n.Content)/2)
+	for i := 0; i+1 < len(n.Content); i += 2 {
+		key := n.Content[i]
+		if !known[key.Value] {
+			if where == "" {
+				l.add(key, SeverityWarning, "unknown-key", "unknown key %q is ignored", key.Value)
+			} else {
+				l.add(key, SeverityWarning, "unknown-key", "unknown key %q in %s is ignored", key.Value, where)
+			}
+			continue
+		}
+		fields[key.Value] = n.Content[i+1]
+	}
+	return fields
+}
+
+func (l *linter) lintHooks(n *yaml.Node) {
+	if isNull(n) {
+		return
+	}
+	if n.Kind != yaml.MappingNode {
+		l.add(n, SeverityError, "invalid-value", "hooks must be a mapping")
+		return
+	}
+	for i := 0; i+1 < len(n.Content); i += 2 {
+		key, scripts := n.Content[i], n.Content[i+1]
+		event := key.Value
+		switch event {
+		case hookEnvironmentKey:
+			l.lintEnvironment(scripts, "hooks")
+			continue
+		case hookEnvFileKey:
+			l.lintEnvFile(scripts, "hooks")
+			continue
+		}
+		if isNull(scripts) {
+			continue
+		}
+		if scripts.Kind != yaml.SequenceNode {
+			l.add(scripts, SeverityWarning, "invalid-value", "hook %q is not a list of scripts and is ignored", event)
+			continue
+		}
+		total := 0
+		for _, s := range scripts.Content {
+			total += l.lintScript(event, s)
+		}
+		if total > maxLifecycleEventTimeout {
+			l.add(key, SeverityError, "total-timeout", "total timeout for %s (%d seconds) exceeds maximum of %d seconds",
+				event, total, maxLifecycleEventTimeout)
+		}
+	}
+}
+
+// lintScript checks one hook script and returns its share of the event's
+// timeout budget: its timeout for every attempt, and the waits between.
+func (l *linter) lintScript(event string, n *yaml.Node) int {
+	if n.Kind != yaml.MappingNode {
+		l.add(n, SeverityError, "invalid-value", "script in hook %q must be a mapping", event)
+		return 0
+	}
+	fields := l.mapping(n, scriptKeys, "hook "+event)
+
+	// Fields are decoded in a fixed order so that diagnostics sharing a
+	// position come out the same on every run.
+	var location, command, shell string
+	for _, f := range []struct {
+		key string
+		dst *string
+	}{{"location", &location}, {"command", &command}, {"shell", &shell}} {
+		if v := fields[f.key]; v != nil && l.decode(v, f.dst) {
+			*f.dst = strings.TrimSpace(*f.dst)
+		}
+	}
+	if err := checkScriptForm(location, command, shell, event); err != nil {
+		rule := "invalid-value"
+		if location == "" && command == "" {
+			rule = "missing-key"
+		}
+		l.fail(n, rule, err)
+	} else if location != "" {
+		l.checkScript(fields["location"], location)
+	}
+
+	timeout := 3600
+	if v := fields["timeout"]; v != nil {
+		var raw interface{}
+		if l.decode(v, &raw) {
+			t, err := parseTimeout(raw, event)
+			if err != nil {
+				l.fail(v, "invalid-value", err)
+			} else {
+				timeout = t
+			}
+		}
+	}
+	var grace *int
+	if v := fields["grace_period"]; v != nil {
+		var raw interface{}
+		if l.decode(v, &raw) {
+			g, err := parseGracePeriod(raw, event)
+			if err != nil {
+				l.fail(v, "invalid-value", err)
+			}
+			grace = g
+		}
+	}
+	if v := fields["runas"]; v != nil {
+		var runas string
+		if l.decode(v, &runas) && strings.TrimSpace(runas) != "" && l.target == "windows" {
+			l.add(v, SeverityError, "platform", "runas is not supported on Windows (event %s)", event)
+		}
+	}
+	if v := fields["sudo"]; v != nil {
+		var sudo bool
+		l.decode(v, &sudo)
+	}
+	if v := fields["environment"]; v != nil {
+		l.lintEnvironment(v, fmt.Sprintf("hook %q", event))
+	}
+	if v := fields["env_file"]; v != nil {
+		l.lintEnvFile(v, fmt.Sprintf("hook %q", event))
+	}
+	if v := fields["limits"]; v != nil {
+		l.lintLimits(v, event)
+	}
+
+	script := Script{Timeout: timeout, GracePeriod: grace}
+	var rs rawScript
+	for _, f := range []struct {
+		key string
+		dst *interface{}
+	}{{"retries", &rs.Retries}, {"retry_delay", &rs.RetryDelay}, {"retry_on_exit_codes", &rs.RetryOnExitCodes}} {
+		if v := fields[f.key]; v != nil {
+			l.decode(v, f.dst)
+		}
+	}
+	var err error
+	if script.Retries, script.RetryDelay, _, err = parseRetry(rs, event); err != nil {
+		l.fail(n, "invalid-value", err)
+	}
+	return script.Budget()
+}
+
+// lintLimits checks a script's limits mapping.
+func (l *linter) lintLimits(n *yaml.Node, event string) {
+	if isNull(n) {
+		return
+	}
+	if n.Kind != yaml.MappingNode {
+		l.add(n, SeverityError, "invalid-value", "limits in hook %q must be a mapping", event)
+		return
+	}
+	if l.target == "windows" {
+		l.add(n, SeverityError, "platform", "limits are not supported on Windows (event %s)", event)
+	}
+	l.mapping(n, limitKeys, fmt.Sprintf("limits in hook %q", event))
+	var raw rawLimits
+	if l.decode(n, &raw) {
+		if _, err := parseLimits(&raw, event); err != nil {
+			l.fail(n, "invalid-value", err)
+		}
+	}
+}
+
+// lintEnvironment checks each variable of an environment mapping.
+func (l *linter) lintEnvironment(n *yaml.Node, where string) {
+	if isNull(n) {
+		return
+	}
+	if n.Kind != yaml.MappingNode {
+		l.add(n, SeverityError, "invalid-value", "%s environment must be a mapping", where)
+		return
+	}
+	for i := 0; i+1 < len(n.Content); i += 2 {
+		key, value := n.Content[i], n.Content[i+1]
+		var v string
+		if !l.decode(value, &v) {
+			continue
+		}
+		if err := checkEnvVar(key.Value, v); err != nil {
+			l.add(key, SeverityError, "invalid-value", "%s environment: %v", where, err)
+		}
+	}
+}
+
+// lintEnvFile checks an env_file path and, given the bundle, that the file
+// exists and parses.
+func (l *linter) lintEnvFile(n *yaml.Node, where string) {
+	var envFile string
+	if !l.decode(n, &envFile) {
+		return
+	}
+	if _, _, err := parseEnv(nil, envFile); err != nil {
+		l.add(n, SeverityError, "invalid-value", "%s %v", where, err)
+		return
+	}
+	envFile = strings.TrimSpace(envFile)
+	if envFile == "" || l.opts.Bundle == nil {
+		return
+	}
+	name, _ := bundlePath(envFile)
+	data, err := fs.ReadFile(l.opts.Bundle, name)
+	if err != nil {
+		l.add(n, SeverityError, "env-file-missing", "env_file %q is not in the bundle", envFile)
+		return
+	}
+	if _, err := ParseEnvFile(data); err != nil {
+		l.add(n, SeverityError, "invalid-value", "%s env_file %q: %s", where, envFile, strings.TrimPrefix(err.Error(), "appspec: env file "))
+	}
+}
+
+// checkScript reports a hook script that is missing from the bundle or, on
+// linux, not executable.
+func (l *linter) checkScript(n *yaml.Node, location string) {
+	if l.opts.Bundle == nil {
+		return
+	}
+	name, ok := bundlePath(location)
+	if !ok {
+		l.add(n, SeverityError, "script-missing", "script %q is outside the bundle", location)
+		return
+	}
+	info, err := fs.Stat(l.opts.Bundle, name)
+	switch {
+	case err != nil:
+		l.add(n, SeverityError, "script-missing", "script %q is not in the bundle", location)
+	case info.IsDir():
+		l.add(n, SeverityError, "script-missing", "script %q is a directory", location)
+	case l.target == "linux" && info.Mode()&0o111 == 0:
+		l.add(n, SeverityWarning, "script-not-executable", "script %q is not executable", location)
+	}
+}
+
+func (l *linter) lintFiles(n *yaml.Node) {
+	if isNull(n) {
+		return
+	}
+	if n.Kind != yaml.SequenceNode {
+		l.add(n, SeverityError, "invalid-value", "files must be a list")
+		return
+	}
+	for _, entry := range n.Content {
+		if entry.Kind != yaml.MappingNode {
+			l.add(entry, SeverityError, "invalid-value", "files entry must be a mapping")
+			continue
+		}
+		fields := l.mapping(entry, fileKeys, "files entry")
+
+		var source, destination string
+		if v := fields["source"]; v != nil {
+			l.decode(v, &source)
+		}
+		if v := fields["destination"]; v != nil {
+			l.decode(v, &destination)
+		}
+		if source == "" {
+			l.add(entry, SeverityError, "missing-key", "file entry missing source")
+		}
+		if destination == "" {
+			l.add(entry, SeverityError, "missing-key", "file entry for source %q missing destination", source)
+		}
+
+		filtered := false
+		for _, key := range []string{"include", "exclude", "templates"} {
+			v := fields[key]
+			if v == nil {
+				continue
+			}
+			var patterns []string
+			if !l.decode(v, &patterns) {
+				continue
+			}
+			if key != "templates" && len(patterns) > 0 {
+				filtered = true
+			}
+			for i, pattern := range patterns {
+				if err := ValidateGlob(pattern); err != nil {
+					l.add(v.Content[i], SeverityError, "invalid-value", "%s: %s", key, err)
+				}
+			}
+		}
+		if v := fields["file_exists_behavior"]; v != nil {
+			var feb string
+			if l.decode(v, &feb) {
+				if _, err := parseFileExistsBehavior(feb); err != nil {
+					l.fail(v, "invalid-value", err)
+				}
+			}
+		}
+		if source != "" {
+			l.checkSource(fields["source"], source, filtered)
+		}
+	}
+}
+
+// checkSource reports a files source that is missing from the bundle, or a
+// filtered source that is not a directory.
+func (l *linter) checkSource(n *yaml.Node, source string, filtered bool) {
+	if l.opts.Bundle == nil {
+		return
+	}
+	name, ok := bundlePath(source)
+	if !ok {
+		l.add(n, SeverityError, "source-missing", "source %q is outside the bundle", source)
+		return
+	}
+	info, err := fs.Stat(l.opts.Bundle, name)
+	switch {
+	case err != nil:
+		l.add(n, SeverityError, "source-missing", "source %q is not in the bundle", source)
+	case filtered && !info.IsDir():
+		l.add(n, SeverityError, "invalid-value", "source %q: include and exclude need a directory source", source)
+	}
+}
+
+func (l *linter) lintPermissions(n, key *yaml.Node) {
+	if isNull(n) {
+		return
+	}
+	if n.Kind != yaml.SequenceNode {
+		l.add(n, SeverityError, "invalid-value", "permissions must be a list")
+		return
+	}
+	if l.target == "windows" && len(n.Content) > 0 {
+		l.add(key, SeverityError, "platform", "permissions are only supported on linux")
+	}
+	for _, entry := range n.Content {
+		if entry.Kind != yaml.MappingNode {
+			l.add(entry, SeverityError, "invalid-value", "permissions entry must be a mapping")
+			continue
+		}
+		l.mapping(entry, permKeys, "permissions entry")
+		var rp rawPerm
+		if !l.decode(entry, &rp) {
+			continue
+		}
+		if strings.TrimSpace(rp.Object) == "" {
+			l.add(entry, SeverityError, "missing-key", "permission entry missing object")
+			continue
+		}
+		if _, err := parsePermission(rp); err != nil {
+			l.fail(entry, "invalid-value", err)
+		}
+	}
+}
+
+func (l *linter) lintRelease(n, key *yaml.Node) {
+	if isNull(n) {
+		return
+	}
+	if n.Kind != yaml.MappingNode {
+		l.add(n, SeverityError, "invalid-value", "release must be a mapping")
+		return
+	}
+	if l.target == "windows" {
+		l.add(key, SeverityError, "platform", "release is only supported on linux")
+	}
+	l.mapping(n, releaseKeys, "release")
+	var raw rawRelease
+	if l.decode(n, &raw) {
+		if _, err := parseRelease(&raw, "linux"); err != nil {
+			l.fail(n, "invalid-value", err)
+		}
+	}
+}
+
+// bundlePath maps an appspec path, relative to the bundle root with or
+// without a leading slash, to an fs.FS name.
+func bundlePath(p string) (string, bool) {
+	name := path.Clean(strings.TrimPrefix(strings.ReplaceAll(p, "\\", "/"), "/"))
+	return name, fs.ValidPath(name)
+}
+
+func keyNode(mapping *yaml.Node, key string) *yaml.Node {
+	for i := 0; i+1 < len(mapping.Content); i += 2 {
+		if mapping.Content[i].Value == key {
+			return mapping.Content[i]
+		}
+	}
+	return mapping
+}
+
+func isNull(n *yaml.Node) bool {
+	return n.Kind == yaml.ScalarNode && n.Tag == "!!null"
+}
+
+func keySet(keys ...string) map[string]bool {
+	m := make(map[string]bool, len(keys))
+	for _, k := range keys {
+		m[k] = true
+	}
+	return m
+}
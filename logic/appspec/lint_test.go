@@ -0,0 +1,224 @@
+package appspec
+
+import (
+	"testing"
+	"testing/fstest"
+)
+
+// lintHit is the rule, severity and position of a diagnostic, for compact
+// comparison.
+type lintHit struct {
+	Rule     string
+	Severity Severity
+	Line     int
+	Column   int
+}
+
+func lintHits(diags []Diagnostic) []lintHit {
+	hits := make([]lintHit, len(diags))
+	for i, d := range diags {
+		hits[i] = lintHit{d.Rule, d.Severity, d.Line, d.Column}
+	}
+	return hits
+}
+
+func assertHits(t *testing.T, diags []Diagnostic, want []lintHit) {
+	t.Helper()
+	got := lintHits(diags)
+	if len(got) != len(want) {
+		t.Fatalf("got %d diagnostics, want %d:\n%+v", len(got), len(want), diags)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("diagnostic %d = %+v (%s), want %+v", i, got[i], diags[i].Message, want[i])
+		}
+	}
+}
+
+// TestLint_CollectsAllProblemsWithPositions verifies that Lint keeps going
+// after the first problem, where Parse stops, and positions each diagnostic
+// at the offending node so an editor can jump to it.
+func TestLint_CollectsAllProblemsWithPositions(t *testing.T) {
+	data := []byte(`version: 1.0
+os: linux
+file_exist_behavior: OVERWRITE
+files:
+  - source: app
+    destination: /opt/app
+    exclude: ["{a,b"]
+    file_exists_behavior: KEEP
+  - source: conf
+hooks:
+  AfterInstall:
+    - location: scripts/start.sh
+      timeout: -5
+      user: deploy
+`)
+	assertHits(t, Lint(data, LintOptions{}), []lintHit{
+		{"invalid-value", SeverityError, 1, 10},
+		{"unknown-key", SeverityWarning, 3, 1},
+		{"invalid-value", SeverityError, 7, 15},
+		{"invalid-value", SeverityError, 8, 27},
+		{"missing-key", SeverityError, 9, 5},
+		{"invalid-value", SeverityError, 13, 16},
+		{"unknown-key", SeverityWarning, 14, 7},
+	})
+}
+
+// TestLint_ValidAppspecIsClean verifies that an appspec Parse accepts, with
+// its scripts and sources present in the bundle, yields no diagnostics.
+func TestLint_ValidAppspecIsClean(t *testing.T) {
+	data := []byte(`version: 0.0
+os: linux
+files:
+  - source: /
+    destination: /opt/app
+    exclude: ["scripts/**"]
+permissions:
+  - object: /opt/app
+    mode: "0755"
+hooks:
+  ApplicationStart:
+    - location: scripts/start.sh
+      timeout: 300
+      runas: deploy
+`)
+	bundle := fstest.MapFS{"scripts/start.sh": {Mode: 0o755}}
+	if diags := Lint(data, LintOptions{OS: "linux", Bundle: bundle}); len(diags) != 0 {
+		t.Errorf("unexpected diagnostics: %+v", diags)
+	}
+}
+
+// TestLint_TargetOS verifies that the target platform comes from the
+// option rather than the host, so a Windows appspec can be linted on Linux,
+// and that settings the target does not support are flagged.
+func TestLint_TargetOS(t *testing.T) {
+	data := []byte(`version: 0.0
+os: windows
+permissions:
+  - object: C:\app
+hooks:
+  AfterInstall:
+    - location: scripts\install.ps1
+      runas: Administrator
+`)
+	assertHits(t, Lint(data, LintOptions{}), []lintHit{
+		{"platform", SeverityError, 3, 1},
+		{"platform", SeverityError, 8, 14},
+	})
+	assertHits(t, Lint(data, LintOptions{OS: "linux"}), []lintHit{
+		{"os-mismatch", SeverityError, 2, 5},
+	})
+}
+
+// TestLint_Bundle verifies the checks against the bundle: missing and
+// non-executable scripts, missing sources, filters on a file source, and
+// the 3600-second budget of one lifecycle event.
+func TestLint_Bundle(t *testing.T) {
+	data := []byte(`version: 0.0
+os: linux
+files:
+  - source: missing
+    destination: /opt/app
+  - source: config.yml
+    destination: /etc/app
+    include: ["*.yml"]
+hooks:
+  BeforeInstall:
+    - location: scripts/stop.sh
+      timeout: 3000
+    - location: scripts/gone.sh
+      timeout: 900
+`)
+	bundle := fstest.MapFS{
+		"config.yml":      {Mode: 0o644},
+		"scripts/stop.sh": {Mode: 0o644},
+	}
+	assertHits(t, Lint(data, LintOptions{Bundle: bundle}), []lintHit{
+		{"source-missing", SeverityError, 4, 13},
+		{"invalid-value", SeverityError, 6, 13},
+		{"total-timeout", SeverityError, 10, 3},
+		{"script-not-executable", SeverityWarning, 11, 17},
+		{"script-missing", SeverityError, 13, 17},
+	})
+}
+
+// TestLint_CommandHooks verifies that inline commands are not looked up in
+// the bundle and that the location/command/shell combination is checked.
+func TestLint_CommandHooks(t *testing.T) {
+	data := []byte(`version: 0.0
+os: linux
+hooks:
+  ApplicationStart:
+    - command: systemctl restart nginx
+      timeout: 60
+    - command: echo hi
+      location: scripts/hi.sh
+      timeout: 60
+    - timeout: 60
+    - location: scripts/hi.sh
+      shell: /bin/bash
+      timeout: 60
+`)
+	assertHits(t, Lint(data, LintOptions{Bundle: fstest.MapFS{"scripts/hi.sh": {Mode: 0o755}}}), []lintHit{
+		{"invalid-value", SeverityError, 7, 7},
+		{"missing-key", SeverityError, 10, 7},
+		{"invalid-value", SeverityError, 11, 7},
+	})
+}
+
+// TestLint_Retries verifies that invalid retry settings are flagged and that
+// every attempt of a retried script counts toward the event's budget.
+func TestLint_Retries(t *testing.T) {
+	data := []byte(`version: 0.0
+os: linux
+hooks:
+  AfterInstall:
+    - command: apt-get update
+      timeout: 1200
+      retries: 2
+    - command: echo hi
+      timeout: 60
+      retries: 1
+      retry_on_exit_codes: [0]
+`)
+	assertHits(t, Lint(data, LintOptions{}), []lintHit{
+		{"total-timeout", SeverityError, 4, 3},
+		{"invalid-value", SeverityError, 8, 7},
+	})
+}
+
+// TestLint_HookEnvironment verifies the checks of environment variables
+// and env files at the hooks and script level, including the contents of
+// env files found in the bundle.
+func TestLint_HookEnvironment(t *testing.T) {
+	data := []byte(`version: 0.0
+os: linux
+hooks:
+  environment:
+    DEPLOYMENT_ID: x
+  env_file: config/hooks.env
+  AfterInstall:
+    - command: ./migrate
+      timeout: 60
+      environment:
+        URL: https://${HOST}/
+      env_file: config/missing.env
+`)
+	bundle := fstest.MapFS{"config/hooks.env": {Data: []byte("A=1\nB\n")}}
+	assertHits(t, Lint(data, LintOptions{Bundle: bundle}), []lintHit{
+		{"invalid-value", SeverityError, 5, 5},
+		{"invalid-value", SeverityError, 6, 13},
+		{"invalid-value", SeverityError, 11, 9},
+		{"env-file-missing", SeverityError, 12, 17},
+	})
+}
+
+// TestLint_SyntaxError verifies that malformed YAML yields a single
+// diagnostic carrying the line the parser reported.
+func TestLint_SyntaxError(t *testing.T) {
+	diags := Lint([]byte("version: 0.0\nos: linux\nhooks:\n  - a\n  b: c\n"), LintOptions{})
+	if len(diags) != 1 || diags[0].Rule != "syntax" || diags[0].Line == 0 {
+		t.Errorf("diagnostics = %+v, want one syntax error with a line", diags)
+	}
+}
@@ -20,20 +20,85 @@ type Spec struct {
 	Files              []FileMapping
 	Permissions        []Permission
 	FileExistsBehavior string
+	Release            *Release // nil unless the appspec opts into releases-style installs
+	// AllowSharedDestinations lets the files section install to paths that
+	// another deployment group on the host already manages.
+	AllowSharedDestinations bool
+	// Environment and EnvFile, from the environment and env_file keys of
+	// the hooks section, apply to every hook script. A script's own
+	// settings override them.
+	Environment map[string]string
+	EnvFile     string
 }
 
-// Script holds one hook script entry from the appspec hooks section.
+// Script holds one hook script entry from the appspec hooks section. It runs
+// either the file at Location or, for an inline hook, Command through Shell.
 type Script struct {
 	Location string
-	RunAs    string
-	Timeout  int // seconds, default 3600
-	Sudo     bool
+	// Command is an inline command line run in place of a script file.
+	Command string
+	// Shell runs Command; empty uses the agent's configured shell.
+	Shell   string
+	RunAs   string
+	Timeout int // seconds, default 3600
+	// GracePeriod is how many seconds the script gets to exit after the
+	// timeout's SIGTERM before it is killed; nil uses the agent's
+	// hook_grace_period_seconds.
+	GracePeriod *int
+	Sudo        bool
+	// Environment holds extra variables for the script; values may
+	// reference deployment variables as ${NAME}.
+	Environment map[string]string
+	// EnvFile is a file in the revision holding further variables, which
+	// Environment overrides.
+	EnvFile string
+	// Limits caps the script's resources; unset fields use the agent's
+	// hook limits.
+	Limits Limits
+	// Retries is how many more times the script runs after a failed
+	// attempt; zero runs it once.
+	Retries int
+	// RetryDelay is the wait in seconds before the first retry, doubling
+	// for each later one.
+	RetryDelay int
+	// RetryOnExitCodes limits retries to attempts that exit with one of
+	// these codes; empty retries every failure, timeouts included.
+	RetryOnExitCodes []int
+}
+
+// Name identifies the hook in logs and diagnostics: the script location,
+// or the first line of an inline command.
+//
+//	appspec.Script{Command: "systemctl restart nginx"}.Name() // `command "systemctl restart nginx"`
+func (s Script) Name() string {
+	if s.Command == "" {
+		return s.Location
+	}
+	line, _, more := strings.Cut(s.Command, "\n")
+	if more {
+		line += " ..."
+	}
+	return fmt.Sprintf("command %q", line)
 }
 
 // FileMapping holds one source→destination entry from the appspec files section.
+// The filter narrows what a directory source copies.
 type FileMapping struct {
 	Source      string
 	Destination string
+	FileFilter
+	// FileExistsBehavior overrides the deployment's behavior for this entry;
+	// empty inherits it. See Spec.FileExistsBehaviorFor.
+	FileExistsBehavior string
+	// Templates are globs selecting files that are rendered with
+	// text/template during install instead of being copied verbatim.
+	Templates []string
+}
+
+// IsTemplate reports whether the file at rel, relative to the entry's source
+// (or the base name for a file source), is rendered as a template.
+func (fm FileMapping) IsTemplate(rel string) bool {
+	return matchAny(fm.Templates, rel)
 }
 
 // rawSpec mirrors the YAML structure for unmarshalling.
@@ -44,18 +109,33 @@ type rawSpec struct {
 	Files              []rawFile   `yaml:"files"`
 	Permissions        []rawPerm   `yaml:"permissions"`
 	FileExistsBehavior string      `yaml:"file_exists_behavior"`
+	Release            *rawRelease `yaml:"release"`
+	AllowShared        bool        `yaml:"allow_shared_destinations"`
 }
 
 type rawFile struct {
-	Source      string `yaml:"source"`
-	Destination string `yaml:"destination"`
+	Source      string   `yaml:"source"`
+	Destination string   `yaml:"destination"`
+	Include     []string `yaml:"include"`
+	Exclude     []string `yaml:"exclude"`
+	FEB         string   `yaml:"file_exists_behavior"`
+	Templates   []string `yaml:"templates"`
 }
 
 type rawScript struct {
-	Location string      `yaml:"location"`
-	RunAs    string      `yaml:"runas"`
-	Sudo     bool        `yaml:"sudo"`
-	Timeout  interface{} `yaml:"timeout"`
+	Location         string            `yaml:"location"`
+	Command          string            `yaml:"command"`
+	Shell            string            `yaml:"shell"`
+	RunAs            string            `yaml:"runas"`
+	Sudo             bool              `yaml:"sudo"`
+	Timeout          interface{}       `yaml:"timeout"`
+	GracePeriod      interface{}       `yaml:"grace_period"`
+	Environment      map[string]string `yaml:"environment"`
+	EnvFile          string            `yaml:"env_file"`
+	Limits           *rawLimits        `yaml:"limits"`
+	Retries          interface{}       `yaml:"retries"`
+	RetryDelay       interface{}       `yaml:"retry_delay"`
+	RetryOnExitCodes interface{}       `yaml:"retry_on_exit_codes"`
 }
 
 type rawPerm struct {
@@ -116,6 +196,11 @@ func runtimeOS() string {
 //	spec, err := appspec.Parse(data)
 //	for hook, scripts := range spec.Hooks { ... }
 func Parse(data []byte) (Spec, error) {
+	return parse(data, runtimeOS())
+}
+
+// parse is Parse for an agent running on hostOS.
+func parse(data []byte, hostOS string) (Spec, error) {
 	var raw rawSpec
 	if err := yaml.Unmarshal(data, &raw); err != nil {
 		return Spec{}, fmt.Errorf("appspec: YAML parse error: %w", err)
@@ -136,17 +221,20 @@ func Parse(data []byte) (Spec, error) {
 		return Spec{}, err
 	}
 
-	currentOS := runtimeOS()
-	if spec.OS != currentOS {
+	if spec.OS != hostOS {
 		return Spec{}, fmt.Errorf(
 			"appspec: platform mismatch - appspec specifies %q but agent is running on %q",
-			spec.OS, currentOS)
+			spec.OS, hostOS)
 	}
 
 	spec.Hooks, err = parseHooks(raw.Hooks)
 	if err != nil {
 		return Spec{}, err
 	}
+	spec.Environment, spec.EnvFile, err = parseHookEnvironment(raw.Hooks)
+	if err != nil {
+		return Spec{}, err
+	}
 
 	// Validate platform-specific hook restrictions
 	if err := validateHookPlatform(spec.Hooks, spec.OS); err != nil {
@@ -168,9 +256,31 @@ func Parse(data []byte) (Spec, error) {
 		return Spec{}, err
 	}
 
+	spec.Release, err = parseRelease(raw.Release, spec.OS)
+	if err != nil {
+		return Spec{}, err
+	}
+	spec.AllowSharedDestinations = raw.AllowShared
+
 	return spec, nil
 }
 
+// FileExistsBehaviorFor resolves the file_exists_behavior that applies to a
+// files entry. The most specific setting wins: the entry's own, then the
+// appspec's top-level one, then deploymentDefault, which comes from the
+// deployment (the console, CLI or --file-exists-behavior).
+//
+//	feb := spec.FileExistsBehaviorFor(spec.Files[0], "DISALLOW")
+func (s Spec) FileExistsBehaviorFor(fm FileMapping, deploymentDefault string) string {
+	if fm.FileExistsBehavior != "" {
+		return fm.FileExistsBehavior
+	}
+	if s.FileExistsBehavior != "" {
+		return s.FileExistsBehavior
+	}
+	return deploymentDefault
+}
+
 // ParseFile reads and parses an appspec file from disk.
 //
 //	spec, err := appspec.ParseFile("/opt/deployment/appspec.yml")
@@ -261,6 +371,9 @@ func parseHooks(node yaml.Node) (map[string][]Script, error) {
 	for i := 0; i < len(node.Content)-1; i += 2 {
 		hookName := node.Content[i].Value
 		scriptsNode := node.Content[i+1]
+		if hookName == hookEnvironmentKey || hookName == hookEnvFileKey {
+			continue
+		}
 
 		// Skip null/empty hooks
 		if scriptsNode.Kind == yaml.ScalarNode && scriptsNode.Tag == "!!null" {
@@ -278,30 +391,45 @@ func parseHooks(node yaml.Node) (map[string][]Script, error) {
 			}
 
 			loc := strings.TrimSpace(rs.Location)
-			if loc == "" {
-				return nil, fmt.Errorf("appspec: hook %q has a script with no location", hookName)
+			command := strings.TrimSpace(rs.Command)
+			if err := checkScriptForm(loc, command, strings.TrimSpace(rs.Shell), hookName); err != nil {
+				return nil, err
 			}
-
-			timeout := 3600
-			if rs.Timeout != nil {
-				switch tv := rs.Timeout.(type) {
-				case int:
-					timeout = tv
-				case float64:
-					timeout = int(tv)
-				default:
-					return nil, fmt.Errorf("appspec: invalid timeout value in hook %q", hookName)
-				}
+			timeout, err := parseTimeout(rs.Timeout, hookName)
+			if err != nil {
+				return nil, err
 			}
-			if timeout <= 0 {
-				return nil, fmt.Errorf("appspec: invalid timeout value (%d) in hook %q", timeout, hookName)
+			grace, err := parseGracePeriod(rs.GracePeriod, hookName)
+			if err != nil {
+				return nil, err
+			}
+			env, envFile, err := parseEnv(rs.Environment, rs.EnvFile)
+			if err != nil {
+				return nil, fmt.Errorf("appspec: hook %q %w", hookName, err)
+			}
+			limits, err := parseLimits(rs.Limits, hookName)
+			if err != nil {
+				return nil, err
+			}
+			retries, retryDelay, retryCodes, err := parseRetry(rs, hookName)
+			if err != nil {
+				return nil, err
 			}
 
 			scripts = append(scripts, Script{
-				Location: loc,
-				RunAs:    strings.TrimSpace(rs.RunAs),
-				Sudo:     rs.Sudo,
-				Timeout:  timeout,
+				Location:         loc,
+				Command:          command,
+				Shell:            strings.TrimSpace(rs.Shell),
+				RunAs:            strings.TrimSpace(rs.RunAs),
+				Sudo:             rs.Sudo,
+				Timeout:          timeout,
+				GracePeriod:      grace,
+				Environment:      env,
+				EnvFile:          envFile,
+				Limits:           limits,
+				Retries:          retries,
+				RetryDelay:       retryDelay,
+				RetryOnExitCodes: retryCodes,
 			})
 		}
 
@@ -313,6 +441,61 @@ func parseHooks(node yaml.Node) (map[string][]Script, error) {
 	return hooks, nil
 }
 
+// checkScriptForm requires a hook script to name exactly one of a script
+// location and an inline command, and a shell only for a command.
+func checkScriptForm(location, command, shell, hookName string) error {
+	switch {
+	case location != "" && command != "":
+		return fmt.Errorf("appspec: hook %q has a script with both location and command", hookName)
+	case location == "" && command == "":
+		return fmt.Errorf("appspec: hook %q has a script with no location or command", hookName)
+	case shell != "" && command == "":
+		return fmt.Errorf("appspec: hook %q sets shell on a script without command", hookName)
+	}
+	return nil
+}
+
+// parseTimeout validates a script timeout in seconds; nil means the default
+// of 3600.
+func parseTimeout(v interface{}, hookName string) (int, error) {
+	timeout := 3600
+	if v != nil {
+		switch tv := v.(type) {
+		case int:
+			timeout = tv
+		case float64:
+			timeout = int(tv)
+		default:
+			return 0, fmt.Errorf("appspec: invalid timeout value in hook %q", hookName)
+		}
+	}
+	if timeout <= 0 {
+		return 0, fmt.Errorf("appspec: invalid timeout value (%d) in hook %q", timeout, hookName)
+	}
+	return timeout, nil
+}
+
+// parseGracePeriod validates a script grace period in seconds; nil leaves
+// it to the agent.
+func parseGracePeriod(v interface{}, hookName string) (*int, error) {
+	if v == nil {
+		return nil, nil
+	}
+	var grace int
+	switch gv := v.(type) {
+	case int:
+		grace = gv
+	case float64:
+		grace = int(gv)
+	default:
+		return nil, fmt.Errorf("appspec: invalid grace_period value in hook %q", hookName)
+	}
+	if grace < 0 || grace > maxLifecycleEventTimeout {
+		return nil, fmt.Errorf("appspec: invalid grace_period value (%d) in hook %q, must be 0 to %d seconds", grace, hookName, maxLifecycleEventTimeout)
+	}
+	return &grace, nil
+}
+
 func parseFiles(raw []rawFile) ([]FileMapping, error) {
 	files := make([]FileMapping, 0, len(raw))
 	for _, f := range raw {
@@ -322,7 +505,24 @@ func parseFiles(raw []rawFile) ([]FileMapping, error) {
 		if f.Destination == "" {
 			return nil, fmt.Errorf("appspec: file entry for source %q missing destination", f.Source)
 		}
-		files = append(files, FileMapping(f))
+		for _, list := range [][]string{f.Include, f.Exclude, f.Templates} {
+			for _, pattern := range list {
+				if err := ValidateGlob(pattern); err != nil {
+					return nil, fmt.Errorf("appspec: file entry for source %q: %w", f.Source, err)
+				}
+			}
+		}
+		feb, err := parseFileExistsBehavior(f.FEB)
+		if err != nil {
+			return nil, fmt.Errorf("%w (file entry for source %q)", err, f.Source)
+		}
+		files = append(files, FileMapping{
+			Source:             f.Source,
+			Destination:        f.Destination,
+			FileFilter:         FileFilter{Include: f.Include, Exclude: f.Exclude},
+			FileExistsBehavior: feb,
+			Templates:          f.Templates,
+		})
 	}
 	return files, nil
 }
@@ -336,65 +536,73 @@ func parsePermissions(raw []rawPerm, osTarget string) ([]Permission, error) {
 		if osTarget != "linux" {
 			return nil, fmt.Errorf("appspec: permissions are only supported on linux")
 		}
-
-		pattern := rp.Pattern
-		if pattern == "" || pattern == "**" {
-			pattern = "**"
+		perm, err := parsePermission(rp)
+		if err != nil {
+			return nil, err
 		}
+		perms = append(perms, perm)
+	}
+	return perms, nil
+}
 
-		types := rp.Type
-		if len(types) == 0 {
-			types = []string{"file", "directory"}
-		}
-		for _, t := range types {
-			valid := false
-			for _, st := range supportedTypes {
-				if t == st {
-					valid = true
-					break
-				}
-			}
-			if !valid {
-				return nil, fmt.Errorf("appspec: unsupported permission type %q", t)
+// parsePermission validates one permissions entry that has an object.
+func parsePermission(rp rawPerm) (Permission, error) {
+	pattern := rp.Pattern
+	if pattern == "" || pattern == "**" {
+		pattern = "**"
+	}
+
+	types := rp.Type
+	if len(types) == 0 {
+		types = []string{"file", "directory"}
+	}
+	for _, t := range types {
+		valid := false
+		for _, st := range supportedTypes {
+			if t == st {
+				valid = true
+				break
 			}
 		}
-
-		perm := Permission{
-			Object:  strings.TrimSpace(rp.Object),
-			Pattern: pattern,
-			Except:  rp.Except,
-			Type:    types,
-			Owner:   rp.Owner,
-			Group:   rp.Group,
+		if !valid {
+			return Permission{}, fmt.Errorf("appspec: unsupported permission type %q", t)
 		}
+	}
 
-		if rp.Mode != nil {
-			mode, err := ParseMode(rp.Mode)
-			if err != nil {
-				return nil, err
-			}
-			perm.Mode = &mode
-		}
+	perm := Permission{
+		Object:  strings.TrimSpace(rp.Object),
+		Pattern: pattern,
+		Except:  rp.Except,
+		Type:    types,
+		Owner:   rp.Owner,
+		Group:   rp.Group,
+	}
 
-		if rp.ACLs != nil {
-			acl, err := ParseACL(rp.ACLs.Entries)
-			if err != nil {
-				return nil, err
-			}
-			perm.ACLs = &acl
+	if rp.Mode != nil {
+		mode, err := ParseMode(rp.Mode)
+		if err != nil {
+			return Permission{}, err
 		}
+		perm.Mode = &mode
+	}
 
-		if rp.Context != nil {
-			ctx, err := ParseContext(*rp.Context)
-			if err != nil {
-				return nil, err
-			}
-			perm.Context = &ctx
+	if rp.ACLs != nil {
+		acl, err := ParseACL(rp.ACLs.Entries)
+		if err != nil {
+			return Permission{}, err
 		}
+		perm.ACLs = &acl
+	}
 
-		perms = append(perms, perm)
+	if rp.Context != nil {
+		ctx, err := ParseContext(*rp.Context)
+		if err != nil {
+			return Permission{}, err
+		}
+		perm.Context = &ctx
 	}
-	return perms, nil
+
+	return perm, nil
 }
 
 func parseFileExistsBehavior(val string) (string, error) {
@@ -410,16 +618,21 @@ func parseFileExistsBehavior(val string) (string, error) {
 }
 
 // validateHookPlatform validates platform-specific hook restrictions matching AWS behavior.
-// Windows does not support runas. All lifecycle events have a cumulative timeout limit.
+// Windows does not support runas or limits. All lifecycle events have a cumulative timeout limit,
+// which counts every attempt of a retried script, its grace_period and the waits between them.
+// The agent-wide default grace period is not known here; the hook runner counts it with EventBudget.
 func validateHookPlatform(hooks map[string][]Script, os string) error {
 	for event, scripts := range hooks {
 		totalTimeout := 0
 		for _, script := range scripts {
 			// AWS CodeDeploy does not support runas on Windows Server
 			if os == "windows" && script.RunAs != "" {
-				return fmt.Errorf("appspec: runas is not supported on Windows (event %s, script %s)", event, script.Location)
+				return fmt.Errorf("appspec: runas is not supported on Windows (event %s, script %s)", event, script.Name())
+			}
+			if os == "windows" && !script.Limits.IsZero() {
+				return fmt.Errorf("appspec: limits are not supported on Windows (event %s, script %s)", event, script.Name())
 			}
-			totalTimeout += script.Timeout
+			totalTimeout += script.Budget()
 		}
 		// AWS limits total timeout per lifecycle event to 3600 seconds
 		if totalTimeout > maxLifecycleEventTimeout {
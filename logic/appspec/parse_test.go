@@ -5,8 +5,10 @@ import (
 	"os"
 	"path/filepath"
 	"runtime"
+	"slices"
 	"strings"
 	"testing"
+	"time"
 )
 
 // testOS returns the appropriate OS value for test appspecs based on runtime.
@@ -1215,3 +1217,429 @@ hooks:
 		t.Errorf("expected 2 scripts, got %d", len(scripts))
 	}
 }
+
+// TestParse_Release verifies that the release section is parsed with its
+// defaults and that Rewrite maps paths under the current link, and only those,
+// into the deployment's release directory.
+func TestParse_Release(t *testing.T) {
+	if testOS() != "linux" {
+		t.Skip("release installs are linux-only")
+	}
+	spec, err := Parse([]byte(`
+version: 0.0
+os: linux
+release:
+  root: /var/www/app/
+`))
+	if err != nil {
+		t.Fatalf("Parse: %v", err)
+	}
+	if spec.Release == nil {
+		t.Fatal("Release should be set")
+	}
+	if spec.Release.Root != "/var/www/app" || spec.Release.Keep != 5 {
+		t.Errorf("Release = %+v, want root /var/www/app keep 5", *spec.Release)
+	}
+
+	cases := []struct {
+		in   string
+		want string
+		ok   bool
+	}{
+		{"/var/www/app/current", "/var/www/app/releases/d-1", true},
+		{"/var/www/app/current/public/index.html", "/var/www/app/releases/d-1/public/index.html", true},
+		{"/var/www/app/current-old/x", "/var/www/app/current-old/x", false},
+		{"/etc/app.conf", "/etc/app.conf", false},
+	}
+	for _, c := range cases {
+		got, ok := spec.Release.Rewrite(c.in, "d-1")
+		if got != c.want || ok != c.ok {
+			t.Errorf("Rewrite(%q) = %q, %v; want %q, %v", c.in, got, ok, c.want, c.ok)
+		}
+	}
+}
+
+// TestParse_ReleaseInvalid verifies that a relative root, a missing root, and
+// a non-positive keep are rejected at parse time rather than at install time.
+func TestParse_ReleaseInvalid(t *testing.T) {
+	if testOS() != "linux" {
+		t.Skip("release installs are linux-only")
+	}
+	for _, section := range []string{
+		"release:\n  keep: 3\n",
+		"release:\n  root: var/www/app\n",
+		"release:\n  root: /var/www/app\n  keep: 0\n",
+	} {
+		if _, err := Parse([]byte("version: 0.0\nos: linux\n" + section)); err == nil {
+			t.Errorf("expected error for %q", section)
+		}
+	}
+}
+
+// TestParse_AllowSharedDestinations verifies that the opt-in to sharing
+// destinations with other deployment groups is parsed and off by default.
+func TestParse_AllowSharedDestinations(t *testing.T) {
+	base := "version: 0.0\nos: " + testOS() + "\n"
+	spec, err := Parse([]byte(base))
+	if err != nil {
+		t.Fatalf("Parse: %v", err)
+	}
+	if spec.AllowSharedDestinations {
+		t.Error("AllowSharedDestinations should default to false")
+	}
+	spec, err = Parse([]byte(base + "allow_shared_destinations: true\n"))
+	if err != nil {
+		t.Fatalf("Parse: %v", err)
+	}
+	if !spec.AllowSharedDestinations {
+		t.Error("AllowSharedDestinations should be true")
+	}
+}
+
+// TestParse_FilesIncludeExclude verifies that include and exclude globs are
+// parsed per files entry and that a malformed glob fails the appspec.
+func TestParse_FilesIncludeExclude(t *testing.T) {
+	base := "version: 0.0\nos: " + testOS() + "\nfiles:\n  - source: app\n    destination: /srv/app\n"
+	spec, err := Parse([]byte(base + "    include: ['**/*.php']\n    exclude: ['.git', '**/tests/**']\n"))
+	if err != nil {
+		t.Fatalf("Parse: %v", err)
+	}
+	fm := spec.Files[0]
+	if len(fm.Include) != 1 || fm.Include[0] != "**/*.php" || len(fm.Exclude) != 2 {
+		t.Errorf("filter = %+v", fm.FileFilter)
+	}
+
+	_, err = Parse([]byte(base + "    exclude: ['{a,b']\n"))
+	if err == nil || !strings.Contains(err.Error(), `source "app"`) {
+		t.Errorf("Parse err = %v, want error naming the source", err)
+	}
+}
+
+// TestParse_FilesFileExistsBehavior verifies that file_exists_behavior is
+// accepted per files entry and validated like the top-level setting.
+func TestParse_FilesFileExistsBehavior(t *testing.T) {
+	base := "version: 0.0\nos: " + testOS() + "\nfiles:\n  - source: config\n    destination: /etc/app\n"
+	spec, err := Parse([]byte(base + "    file_exists_behavior: RETAIN\n"))
+	if err != nil {
+		t.Fatalf("Parse: %v", err)
+	}
+	if got := spec.Files[0].FileExistsBehavior; got != "RETAIN" {
+		t.Errorf("FileExistsBehavior = %q, want RETAIN", got)
+	}
+	if _, err := Parse([]byte(base + "    file_exists_behavior: KEEP\n")); err == nil {
+		t.Error("expected error for invalid per-entry file_exists_behavior")
+	}
+}
+
+// TestFileExistsBehaviorFor verifies the precedence of file_exists_behavior:
+// files entry, then appspec, then deployment.
+func TestFileExistsBehaviorFor(t *testing.T) {
+	cases := []struct {
+		mapping, spec, deployment, want string
+	}{
+		{"", "", "DISALLOW", "DISALLOW"},
+		{"", "OVERWRITE", "DISALLOW", "OVERWRITE"},
+		{"RETAIN", "OVERWRITE", "DISALLOW", "RETAIN"},
+		{"RETAIN", "", "OVERWRITE", "RETAIN"},
+	}
+	for _, c := range cases {
+		spec := Spec{FileExistsBehavior: c.spec}
+		got := spec.FileExistsBehaviorFor(FileMapping{FileExistsBehavior: c.mapping}, c.deployment)
+		if got != c.want {
+			t.Errorf("FileExistsBehaviorFor(%q, %q, %q) = %q, want %q", c.mapping, c.spec, c.deployment, got, c.want)
+		}
+	}
+}
+
+// TestParse_FilesTemplates verifies that templates globs are parsed and
+// validated, and that IsTemplate matches paths relative to the source.
+func TestParse_FilesTemplates(t *testing.T) {
+	base := "version: 0.0\nos: " + testOS() + "\nfiles:\n  - source: config\n    destination: /etc/app\n"
+	spec, err := Parse([]byte(base + "    templates:\n      - \"**/*.tmpl\"\n"))
+	if err != nil {
+		t.Fatalf("Parse: %v", err)
+	}
+	fm := spec.Files[0]
+	if !fm.IsTemplate("nginx/site.conf.tmpl") || fm.IsTemplate("nginx/site.conf") {
+		t.Errorf("IsTemplate mismatch for templates %v", fm.Templates)
+	}
+	if _, err := Parse([]byte(base + "    templates:\n      - \"/etc/*.conf\"\n")); err == nil {
+		t.Error("expected error for absolute templates pattern")
+	}
+}
+
+// TestParse_CommandHooks verifies that a hook script may be an inline command
+// with an optional shell, and that it must be exactly one of location and
+// command.
+func TestParse_CommandHooks(t *testing.T) {
+	base := "version: 0.0\nos: " + testOS() + "\nhooks:\n  ApplicationStart:\n"
+	spec, err := Parse([]byte(base + "    - command: systemctl restart nginx\n      shell: /bin/bash\n"))
+	if err != nil {
+		t.Fatalf("Parse: %v", err)
+	}
+	s := spec.Hooks["ApplicationStart"][0]
+	if s.Command != "systemctl restart nginx" || s.Shell != "/bin/bash" || s.Location != "" || s.Timeout != 3600 {
+		t.Errorf("script = %+v", s)
+	}
+	if s.Name() != `command "systemctl restart nginx"` {
+		t.Errorf("Name = %q", s.Name())
+	}
+
+	for _, bad := range []string{
+		"    - command: x\n      location: scripts/x.sh\n",
+		"    - timeout: 10\n",
+		"    - location: scripts/x.sh\n      shell: /bin/bash\n",
+	} {
+		if _, err := Parse([]byte(base + bad)); err == nil {
+			t.Errorf("expected error for %q", bad)
+		}
+	}
+}
+
+// TestParse_HookEnvironment verifies the environment and env_file keys at
+// the hooks level, which are not lifecycle events, and on a script, and that
+// invalid variables are rejected at parse time.
+func TestParse_HookEnvironment(t *testing.T) {
+	base := "version: 0.0\nos: " + testOS() + "\nhooks:\n"
+	spec, err := Parse([]byte(base + `  environment:
+    PORT: 8080
+  env_file: config/hooks.env
+  AfterInstall:
+    - location: scripts/install.sh
+      environment:
+        SITE: /srv/${DEPLOYMENT_GROUP_NAME}
+      env_file: /config/install.env
+`))
+	if err != nil {
+		t.Fatalf("Parse: %v", err)
+	}
+	if len(spec.Hooks) != 1 {
+		t.Errorf("hooks = %v, want only AfterInstall", spec.Hooks)
+	}
+	if spec.Environment["PORT"] != "8080" || spec.EnvFile != "config/hooks.env" {
+		t.Errorf("hooks environment = %v, env_file = %q", spec.Environment, spec.EnvFile)
+	}
+	s := spec.Hooks["AfterInstall"][0]
+	if s.Environment["SITE"] != "/srv/${DEPLOYMENT_GROUP_NAME}" || s.EnvFile != "/config/install.env" {
+		t.Errorf("script = %+v", s)
+	}
+
+	for bad, want := range map[string]string{
+		"  environment:\n    DEPLOYMENT_ID: x\n":                                          `hooks environment: DEPLOYMENT_ID is set by the agent`,
+		"  env_file: ../secrets.env\n":                                                    `hooks env_file "../secrets.env" is outside the bundle`,
+		"  AfterInstall:\n    - location: a.sh\n      environment:\n        A: ${HOME}\n": `hook "AfterInstall" environment: A references ${HOME}`,
+	} {
+		_, err := Parse([]byte(base + bad))
+		if err == nil || !strings.Contains(err.Error(), want) {
+			t.Errorf("Parse(%q) err = %v, want %q", bad, err, want)
+		}
+	}
+}
+
+// TestParse_GracePeriod verifies that grace_period is optional, so the
+// agent default applies, that an explicit 0 is kept apart from unset, and
+// that out-of-range values are rejected.
+func TestParse_GracePeriod(t *testing.T) {
+	base := "version: 0.0\nos: " + testOS() + "\nhooks:\n  ApplicationStop:\n    - location: scripts/stop.sh\n      timeout: 60\n"
+	spec, err := Parse([]byte(base + "    - location: scripts/drain.sh\n      grace_period: 0\n      timeout: 60\n    - location: scripts/flush.sh\n      grace_period: 30\n      timeout: 60\n"))
+	if err != nil {
+		t.Fatalf("Parse: %v", err)
+	}
+	scripts := spec.Hooks["ApplicationStop"]
+	if scripts[0].GracePeriod != nil {
+		t.Errorf("unset grace_period = %d, want nil", *scripts[0].GracePeriod)
+	}
+	if scripts[1].GracePeriod == nil || *scripts[1].GracePeriod != 0 || scripts[2].GracePeriod == nil || *scripts[2].GracePeriod != 30 {
+		t.Errorf("grace periods = %v, %v", scripts[1].GracePeriod, scripts[2].GracePeriod)
+	}
+	for _, bad := range []string{"-1", "3601", "soon"} {
+		if _, err := Parse([]byte(base + "      grace_period: " + bad + "\n")); err == nil {
+			t.Errorf("expected error for grace_period %s", bad)
+		}
+	}
+}
+
+// TestParse_Limits verifies that a script's limits are parsed with sizes in
+// bytes or with a K/M/G/T suffix, that a script without limits has none so
+// the agent's apply, and that Windows appspecs may not set them.
+func TestParse_Limits(t *testing.T) {
+	err := validateHookPlatform(map[string][]Script{"ValidateService": {{Location: "v.ps1", Timeout: 60, Limits: Limits{Memory: 1 << 30}}}}, "windows")
+	if err == nil || !strings.Contains(err.Error(), "limits are not supported on Windows") {
+		t.Errorf("windows limits: err = %v", err)
+	}
+	if runtime.GOOS == "windows" {
+		return
+	}
+
+	spec, err := Parse([]byte(`version: 0.0
+os: linux
+hooks:
+  ValidateService:
+    - location: scripts/validate.sh
+      timeout: 60
+      limits:
+        cpu_time: 30
+        address_space: 2G
+        open_files: 256
+        processes: 32
+        memory: 512m
+        cpus: 1.5
+    - location: scripts/other.sh
+      timeout: 60
+`))
+	if err != nil {
+		t.Fatalf("Parse: %v", err)
+	}
+	scripts := spec.Hooks["ValidateService"]
+	want := Limits{CPUTime: 30, AddressSpace: 2 << 30, OpenFiles: 256, Processes: 32, Memory: 512 << 20, CPUs: 1.5}
+	if scripts[0].Limits != want {
+		t.Errorf("Limits = %+v, want %+v", scripts[0].Limits, want)
+	}
+	if !scripts[1].Limits.IsZero() {
+		t.Errorf("unset Limits = %+v, want zero", scripts[1].Limits)
+	}
+}
+
+// TestParseSize verifies the size forms limits accept and that zero,
+// fractions and overflowing values are rejected rather than wrapped.
+func TestParseSize(t *testing.T) {
+	for in, want := range map[interface{}]int64{1024: 1024, "1024": 1024, "4K": 4096, "64M": 64 << 20, " 2g ": 2 << 30, "1T": 1 << 40} {
+		if got, err := ParseSize(in); err != nil || got != want {
+			t.Errorf("ParseSize(%v) = %d, %v; want %d", in, got, err, want)
+		}
+	}
+	for _, in := range []interface{}{0, "0", "-1M", "1.5G", "M", "1MB", "9999999999T", 2.5, nil} {
+		if _, err := ParseSize(in); err == nil {
+			t.Errorf("ParseSize(%v) succeeded, want an error", in)
+		}
+	}
+}
+
+// TestLimitsOr verifies that a script's limits override the agent's one
+// field at a time, so setting memory alone keeps the agent's other limits.
+func TestLimitsOr(t *testing.T) {
+	agent := Limits{CPUTime: 600, OpenFiles: 4096, Memory: 1 << 30}
+	got := Limits{Memory: 256 << 20, CPUs: 0.5}.Or(agent)
+	want := Limits{CPUTime: 600, OpenFiles: 4096, Memory: 256 << 20, CPUs: 0.5}
+	if got != want {
+		t.Errorf("Or = %+v, want %+v", got, want)
+	}
+}
+
+// TestParse_Retries verifies the retry fields and their defaults, and that
+// the event's 3600-second budget counts every attempt and the longest wait
+// before each retry, so a retried script cannot overrun it.
+func TestParse_Retries(t *testing.T) {
+	spec, err := Parse([]byte(fmt.Sprintf(`version: 0.0
+os: %s
+hooks:
+  AfterInstall:
+    - location: scripts/install.sh
+      timeout: 300
+      retries: 2
+      retry_on_exit_codes: [75, 111]
+    - location: scripts/migrate.sh
+      timeout: 60
+      retries: 1
+      retry_delay: 0
+    - location: scripts/other.sh
+      timeout: 60
+`, testOS())))
+	if err != nil {
+		t.Fatalf("Parse: %v", err)
+	}
+	scripts := spec.Hooks["AfterInstall"]
+	if s := scripts[0]; s.Retries != 2 || s.RetryDelay != 10 || !slices.Equal(s.RetryOnExitCodes, []int{75, 111}) {
+		t.Errorf("install.sh: Retries = %d, RetryDelay = %d, RetryOnExitCodes = %v", s.Retries, s.RetryDelay, s.RetryOnExitCodes)
+	}
+	if s := scripts[1]; s.Retries != 1 || s.RetryDelay != 0 || s.Attempts() != 2 {
+		t.Errorf("migrate.sh: Retries = %d, RetryDelay = %d", s.Retries, s.RetryDelay)
+	}
+	if s := scripts[2]; s.Retries != 0 || s.Attempts() != 1 || s.Budget() != 60 {
+		t.Errorf("other.sh: Retries = %d, Budget = %d", s.Retries, s.Budget())
+	}
+	// Three 300-second attempts with waits of up to 10 and 20 seconds.
+	if got := scripts[0].Budget(); got != 930 {
+		t.Errorf("Budget = %d, want 930", got)
+	}
+
+	err = validateHookPlatform(map[string][]Script{"AfterInstall": {
+		{Location: "a.sh", Timeout: 1000, Retries: 2, RetryDelay: 10},
+		{Location: "b.sh", Timeout: 600},
+	}}, "linux")
+	if err == nil || !strings.Contains(err.Error(), "total timeout for AfterInstall (3630 seconds)") {
+		t.Errorf("over budget: err = %v", err)
+	}
+}
+
+// TestBudget_GracePeriod verifies that a script's own grace_period counts
+// once per attempt, since each attempt may run that long past its timeout,
+// and that an unset grace period adds nothing.
+func TestBudget_GracePeriod(t *testing.T) {
+	grace := 30
+	s := Script{Timeout: 300, Retries: 2, RetryDelay: 10, GracePeriod: &grace}
+	// Three attempts of 300 + 30 seconds with waits of up to 10 and 20.
+	if got := s.Budget(); got != 1020 {
+		t.Errorf("Budget = %d, want 1020", got)
+	}
+	s.GracePeriod = nil
+	if got := s.Budget(); got != 930 {
+		t.Errorf("Budget without grace period = %d, want 930", got)
+	}
+
+	err := validateHookPlatform(map[string][]Script{"AfterInstall": {
+		{Location: "a.sh", Timeout: 1000, Retries: 2, RetryDelay: 10, GracePeriod: &grace},
+		{Location: "b.sh", Timeout: 500},
+	}}, "linux")
+	if err == nil || !strings.Contains(err.Error(), "(3620 seconds)") {
+		t.Errorf("over budget with grace period: err = %v", err)
+	}
+}
+
+// TestBudget_DefaultGracePeriod verifies that a script without a
+// grace_period is counted with the default it is given on every attempt,
+// and that its own grace_period wins over the default.
+func TestBudget_DefaultGracePeriod(t *testing.T) {
+	s := Script{Timeout: 300, Retries: 2, RetryDelay: 10}
+	if got := s.BudgetWithGrace(30); got != 1020 {
+		t.Errorf("BudgetWithGrace(30) = %d, want 1020", got)
+	}
+	zero := 0
+	s.GracePeriod = &zero
+	if got := s.BudgetWithGrace(30); got != 930 {
+		t.Errorf("BudgetWithGrace(30) with grace_period 0 = %d, want 930", got)
+	}
+
+	scripts := []Script{{Timeout: 1800}, {Timeout: 1790}}
+	if seconds, ok := EventBudget(scripts, 0); seconds != 3590 || !ok {
+		t.Errorf("EventBudget(0) = %d, %v; want 3590, true", seconds, ok)
+	}
+	if seconds, ok := EventBudget(scripts, 10); seconds != 3610 || ok {
+		t.Errorf("EventBudget(10) = %d, %v; want 3610, false", seconds, ok)
+	}
+}
+
+// TestRetryable verifies which failed attempts are retried: every failure
+// without retry_on_exit_codes, and only the listed exit codes with it, since
+// a timeout has no exit code to match.
+func TestRetryable(t *testing.T) {
+	every := Script{Retries: 1}
+	if !every.Retryable(1, false) || !every.Retryable(-1, true) {
+		t.Error("a script without retry_on_exit_codes should retry every failure")
+	}
+	listed := Script{Retries: 1, RetryOnExitCodes: []int{75}}
+	if !listed.Retryable(75, false) || listed.Retryable(1, false) || listed.Retryable(75, true) {
+		t.Error("a script with retry_on_exit_codes should retry only those exit codes")
+	}
+}
+
+// TestRetryBackoff verifies that waits double from retry_delay up to five
+// minutes, or up to retry_delay itself when that is longer.
+func TestRetryBackoff(t *testing.T) {
+	if base, maxDelay := (Script{RetryDelay: 10}).RetryBackoff(); base != 10*time.Second || maxDelay != 300*time.Second {
+		t.Errorf("RetryBackoff = %v, %v; want 10s, 5m", base, maxDelay)
+	}
+	if base, maxDelay := (Script{RetryDelay: 600}).RetryBackoff(); base != 600*time.Second || maxDelay != 600*time.Second {
+		t.Errorf("RetryBackoff = %v, %v; want 10m, 10m", base, maxDelay)
+	}
+}
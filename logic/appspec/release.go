@@ -0,0 +1,83 @@
+package appspec
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+)
+
+// defaultReleaseKeep is the number of releases retained when keep is unset.
+const defaultReleaseKeep = 5
+
+// Release configures releases-style installs. Files destined for the current
+// link are assembled into a per-deployment directory under Root/releases, and
+// Root/current is switched to it in a single rename once the copy succeeds.
+type Release struct {
+	Root string
+	Keep int // releases retained including the active one, default 5
+}
+
+type rawRelease struct {
+	Root string `yaml:"root"`
+	Keep *int   `yaml:"keep"`
+}
+
+// CurrentLink returns the path of the symlink that points at the active release.
+//
+//	rel.CurrentLink() // "/var/www/app/current"
+func (r Release) CurrentLink() string {
+	return filepath.Join(r.Root, "current")
+}
+
+// ReleasesDir returns the directory holding all release directories.
+func (r Release) ReleasesDir() string {
+	return filepath.Join(r.Root, "releases")
+}
+
+// Dir returns the release directory for a deployment.
+//
+//	rel.Dir("d-ABC123") // "/var/www/app/releases/d-ABC123"
+func (r Release) Dir(deploymentID string) string {
+	return filepath.Join(r.ReleasesDir(), deploymentID)
+}
+
+// Rewrite maps a path at or below the current link into the release directory
+// of the given deployment. Paths outside the current link are returned
+// unchanged with ok set to false.
+//
+//	rel.Rewrite("/var/www/app/current/index.html", "d-1") // "/var/www/app/releases/d-1/index.html", true
+func (r Release) Rewrite(path, deploymentID string) (string, bool) {
+	path = filepath.Clean(path)
+	link := r.CurrentLink()
+	if path == link {
+		return r.Dir(deploymentID), true
+	}
+	if rest, ok := strings.CutPrefix(path, link+string(filepath.Separator)); ok {
+		return filepath.Join(r.Dir(deploymentID), rest), true
+	}
+	return path, false
+}
+
+func parseRelease(raw *rawRelease, osTarget string) (*Release, error) {
+	if raw == nil {
+		return nil, nil
+	}
+	if osTarget != "linux" {
+		return nil, fmt.Errorf("appspec: release is only supported on linux")
+	}
+	root := strings.TrimSpace(raw.Root)
+	if root == "" {
+		return nil, fmt.Errorf("appspec: release missing root")
+	}
+	if !filepath.IsAbs(root) {
+		return nil, fmt.Errorf("appspec: release root %q must be an absolute path", root)
+	}
+	keep := defaultReleaseKeep
+	if raw.Keep != nil {
+		if *raw.Keep < 1 {
+			return nil, fmt.Errorf("appspec: release keep must be at least 1, got %d", *raw.Keep)
+		}
+		keep = *raw.Keep
+	}
+	return &Release{Root: filepath.Clean(root), Keep: keep}, nil
+}
@@ -0,0 +1,118 @@
+package appspec
+
+import (
+	"fmt"
+	"slices"
+	"time"
+
+	"github.com/gurre/codedeploy-agent-go/logic/backoff"
+)
+
+const (
+	// maxRetries caps how many times a script may be retried.
+	maxRetries = 10
+	// defaultRetryDelay is the retry_delay of a script with retries but
+	// none set, in seconds.
+	defaultRetryDelay = 10
+	// maxRetryDelay caps the doubling wait between attempts, unless the
+	// script's retry_delay is longer.
+	maxRetryDelay = 300 * time.Second
+)
+
+// Attempts returns how many times the script runs at most.
+func (s Script) Attempts() int {
+	return s.Retries + 1
+}
+
+// RetryBackoff returns the base and maximum wait between attempts of the
+// script, for backoff.Duration.
+//
+//	base, maxDelay := script.RetryBackoff()
+//	wait := backoff.Duration(attempt-1, base, maxDelay)
+func (s Script) RetryBackoff() (base, maxDelay time.Duration) {
+	base = time.Duration(s.RetryDelay) * time.Second
+	return base, max(base, maxRetryDelay)
+}
+
+// Retryable reports whether a failed attempt, which exited with exitCode or
+// timed out, may be retried. Without retry_on_exit_codes every failure may
+// be; with it only those exit codes, and not timeouts.
+func (s Script) Retryable(exitCode int, timedOut bool) bool {
+	if len(s.RetryOnExitCodes) == 0 {
+		return true
+	}
+	return !timedOut && slices.Contains(s.RetryOnExitCodes, exitCode)
+}
+
+// Budget returns the most seconds the script can take: its timeout and
+// grace_period for every attempt and the longest wait before each retry.
+// The scripts of one lifecycle event share a budget of 3600 seconds. A
+// script without a grace_period is counted without one, since the agent's
+// default is not known to the appspec; see BudgetWithGrace.
+func (s Script) Budget() int {
+	return s.BudgetWithGrace(0)
+}
+
+// BudgetWithGrace is Budget with defaultGrace seconds counted for every
+// attempt when the script sets no grace_period, as the agent running it
+// would give.
+//
+//	seconds := script.BudgetWithGrace(cfgGraceSeconds)
+func (s Script) BudgetWithGrace(defaultGrace int) int {
+	base, maxDelay := s.RetryBackoff()
+	var wait time.Duration
+	for i := range s.Retries {
+		wait += backoff.Max(i, base, maxDelay)
+	}
+	grace := defaultGrace
+	if s.GracePeriod != nil {
+		grace = *s.GracePeriod
+	}
+	return (s.Timeout+grace)*s.Attempts() + int((wait+time.Second-1)/time.Second)
+}
+
+// EventBudget returns the most seconds the scripts of one lifecycle event
+// can take with defaultGrace seconds for those without a grace_period, and
+// whether that fits the event's budget of 3600 seconds.
+//
+//	if seconds, ok := appspec.EventBudget(scripts, graceSeconds); !ok { ... }
+func EventBudget(scripts []Script, defaultGrace int) (seconds int, ok bool) {
+	for _, s := range scripts {
+		seconds += s.BudgetWithGrace(defaultGrace)
+	}
+	return seconds, seconds <= maxLifecycleEventTimeout
+}
+
+// parseRetry validates a script's retries, retry_delay and
+// retry_on_exit_codes.
+func parseRetry(rs rawScript, hookName string) (retries, delay int, codes []int, err error) {
+	if rs.Retries != nil {
+		n, ok := rs.Retries.(int)
+		if !ok || n < 0 || n > maxRetries {
+			return 0, 0, nil, fmt.Errorf("appspec: invalid retries value (%v) in hook %q, must be 0 to %d", rs.Retries, hookName, maxRetries)
+		}
+		retries = n
+	}
+	delay = defaultRetryDelay
+	if rs.RetryDelay != nil {
+		n, ok := rs.RetryDelay.(int)
+		if !ok || n < 0 || n > maxLifecycleEventTimeout {
+			return 0, 0, nil, fmt.Errorf("appspec: invalid retry_delay value (%v) in hook %q, must be 0 to %d seconds", rs.RetryDelay, hookName, maxLifecycleEventTimeout)
+		}
+		delay = n
+	}
+	if rs.RetryOnExitCodes != nil {
+		list, ok := rs.RetryOnExitCodes.([]interface{})
+		if !ok {
+			return 0, 0, nil, fmt.Errorf("appspec: retry_on_exit_codes in hook %q must be a list of exit codes", hookName)
+		}
+		for _, v := range list {
+			code, ok := v.(int)
+			if !ok || code == 0 {
+				return 0, 0, nil, fmt.Errorf("appspec: invalid retry_on_exit_codes entry (%v) in hook %q, must be a non-zero exit code", v, hookName)
+			}
+			codes = append(codes, code)
+		}
+	}
+	return retries, delay, codes, nil
+}
@@ -0,0 +1,19 @@
+package appspec
+
+import (
+	"bytes"
+	_ "embed"
+)
+
+//go:embed appspec.schema.json
+var schemaJSON []byte
+
+// Schema returns the JSON Schema (draft 2020-12) of the appspec format this
+// package parses, for editors and CI. It cannot express the 3600-second
+// total per lifecycle event or the well-formedness of globs; Lint checks
+// those.
+//
+//	os.Stdout.Write(appspec.Schema())
+func Schema() []byte {
+	return bytes.Clone(schemaJSON)
+}
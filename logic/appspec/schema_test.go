@@ -0,0 +1,427 @@
+package appspec
+
+import (
+	"math"
+	"os"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"sort"
+	"strings"
+	"testing"
+	"unicode/utf8"
+
+	json "github.com/goccy/go-json"
+	"gopkg.in/yaml.v3"
+)
+
+// schemaFixtures are appspecs on both sides of the line Parse draws. Values
+// the schema cannot express (total timeouts, glob syntax, ${NAME}
+// references, env_file paths outside the bundle, degenerate empty strings)
+// are left to Lint and kept out of the corpus.
+var schemaFixtures = map[string]string{
+	"minimal linux":   "version: 0.0\nos: linux\n",
+	"minimal windows": "version: 0.0\nos: windows\n",
+	"integer version": "version: 0\nos: linux\n",
+	"unknown key":     "version: 0.0\nos: linux\nfile_exist_behavior: OVERWRITE\n",
+	"null sections":   "version: 0.0\nos: linux\nfiles:\npermissions:\nhooks:\n  BeforeInstall:\n",
+	"full linux": `version: 0.0
+os: linux
+file_exists_behavior: OVERWRITE
+allow_shared_destinations: true
+files:
+  - source: /
+    destination: /opt/app
+    include: ["**/*.php", "public"]
+    exclude: ["**/tests/**"]
+    templates: ["config/*.conf"]
+    file_exists_behavior: RETAIN
+permissions:
+  - object: /opt/app
+    pattern: "**"
+    except: [cache]
+    type: [file, directory]
+    owner: deploy
+    group: web
+    mode: "0755"
+    acls:
+      entries:
+        - user:deploy:rwx
+        - d:group:web:r-x
+    context:
+      user: system_u
+      role: object_r
+      type: httpd_sys_content_t
+      range:
+        low: s0
+        high: s0:c0.c1023
+  - object: /opt/app/bin
+    mode: 644
+hooks:
+  AfterInstall:
+    - location: scripts/install.sh
+      timeout: 1800
+      runas: deploy
+      sudo: true
+    - location: scripts/migrate.sh
+      timeout: 1770
+      grace_period: 30
+release:
+  root: /var/www/app
+  keep: 3
+`,
+	"inline command": "version: 0.0\nos: linux\nhooks:\n  ApplicationStart:\n    - command: systemctl restart nginx\n      timeout: 60\n    - command: |\n        set -e\n        curl -fsS localhost/health\n      shell: /bin/bash\n      runas: deploy\n      timeout: 60\n",
+	"hook environment": `version: 0.0
+os: linux
+hooks:
+  environment:
+    PORT: 8080
+    DEBUG: false
+    SITE: /srv/${DEPLOYMENT_GROUP_NAME}
+  env_file: config/hooks.env
+  ApplicationStart:
+    - command: systemctl restart app
+      environment:
+        DB_PASSWORD: "p$ss"
+      env_file: config/start.env
+`,
+	"windows hooks": "version: 0.0\nos: windows\nfiles:\n  - source: app\n    destination: C:\\app\nhooks:\n  AfterInstall:\n    - location: scripts\\install.ps1\n      timeout: 300\n",
+
+	"version 1":              "version: 1.0\nos: linux\n",
+	"version string":         "version: \"0.0\"\nos: linux\n",
+	"missing version":        "os: linux\n",
+	"missing os":             "version: 0.0\n",
+	"unsupported os":         "version: 0.0\nos: macos\n",
+	"bad file_exists":        "version: 0.0\nos: linux\nfile_exists_behavior: KEEP\n",
+	"allow_shared list":      "version: 0.0\nos: linux\nallow_shared_destinations: [true]\n",
+	"file no destination":    "version: 0.0\nos: linux\nfiles:\n  - source: app\n",
+	"file no source":         "version: 0.0\nos: linux\nfiles:\n  - destination: /opt/app\n",
+	"absolute include":       "version: 0.0\nos: linux\nfiles:\n  - source: app\n    destination: /opt/app\n    include: [\"/etc/*\"]\n",
+	"file bad feb":           "version: 0.0\nos: linux\nfiles:\n  - source: app\n    destination: /opt/app\n    file_exists_behavior: keep\n",
+	"hooks list":             "version: 0.0\nos: linux\nhooks:\n  - location: a.sh\n",
+	"script no location":     "version: 0.0\nos: linux\nhooks:\n  AfterInstall:\n    - timeout: 30\n",
+	"script blank location":  "version: 0.0\nos: linux\nhooks:\n  AfterInstall:\n    - location: \"  \"\n",
+	"command and location":   "version: 0.0\nos: linux\nhooks:\n  AfterInstall:\n    - location: a.sh\n      command: echo hi\n",
+	"shell without command":  "version: 0.0\nos: linux\nhooks:\n  AfterInstall:\n    - location: a.sh\n      shell: /bin/bash\n",
+	"env bad name":           "version: 0.0\nos: linux\nhooks:\n  environment:\n    1PORT: x\n",
+	"env deployment var":     "version: 0.0\nos: linux\nhooks:\n  AfterInstall:\n    - location: a.sh\n      environment:\n        DEPLOYMENT_ID: x\n",
+	"env nested value":       "version: 0.0\nos: linux\nhooks:\n  AfterInstall:\n    - location: a.sh\n      environment:\n        A: {b: c}\n",
+	"env list":               "version: 0.0\nos: linux\nhooks:\n  environment: [A=b]\n",
+	"env_file list":          "version: 0.0\nos: linux\nhooks:\n  env_file: [a.env]\n",
+	"grace negative":         "version: 0.0\nos: linux\nhooks:\n  AfterInstall:\n    - location: a.sh\n      grace_period: -1\n",
+	"grace string":           "version: 0.0\nos: linux\nhooks:\n  AfterInstall:\n    - location: a.sh\n      grace_period: soon\n",
+	"limits":                 "version: 0.0\nos: linux\nhooks:\n  AfterInstall:\n    - location: a.sh\n      limits:\n        cpu_time: 60\n        address_space: 4G\n        open_files: 1024\n        processes: 64\n        memory: 536870912\n        cpus: 0.5\n",
+	"limits null":            "version: 0.0\nos: linux\nhooks:\n  AfterInstall:\n    - location: a.sh\n      limits:\n",
+	"limits zero":            "version: 0.0\nos: linux\nhooks:\n  AfterInstall:\n    - location: a.sh\n      limits:\n        open_files: 0\n",
+	"limits fraction":        "version: 0.0\nos: linux\nhooks:\n  AfterInstall:\n    - location: a.sh\n      limits:\n        cpu_time: 2.5\n",
+	"limits bad size":        "version: 0.0\nos: linux\nhooks:\n  AfterInstall:\n    - location: a.sh\n      limits:\n        memory: 1.5G\n",
+	"retries":                "version: 0.0\nos: linux\nhooks:\n  AfterInstall:\n    - location: a.sh\n      timeout: 60\n      retries: 3\n      retry_delay: 5\n      retry_on_exit_codes: [1, 75]\n",
+	"retries negative":       "version: 0.0\nos: linux\nhooks:\n  AfterInstall:\n    - location: a.sh\n      timeout: 60\n      retries: -1\n",
+	"retries over limit":     "version: 0.0\nos: linux\nhooks:\n  AfterInstall:\n    - location: a.sh\n      timeout: 60\n      retries: 11\n",
+	"retry_delay string":     "version: 0.0\nos: linux\nhooks:\n  AfterInstall:\n    - location: a.sh\n      timeout: 60\n      retries: 1\n      retry_delay: soon\n",
+	"retry exit code zero":   "version: 0.0\nos: linux\nhooks:\n  AfterInstall:\n    - location: a.sh\n      timeout: 60\n      retries: 1\n      retry_on_exit_codes: [0]\n",
+	"retry exit codes int":   "version: 0.0\nos: linux\nhooks:\n  AfterInstall:\n    - location: a.sh\n      timeout: 60\n      retries: 1\n      retry_on_exit_codes: 1\n",
+	"limits cpus zero":       "version: 0.0\nos: linux\nhooks:\n  AfterInstall:\n    - location: a.sh\n      limits:\n        cpus: 0\n",
+	"limits windows":         "version: 0.0\nos: windows\nhooks:\n  AfterInstall:\n    - location: a.ps1\n      limits:\n        memory: 1G\n",
+	"timeout zero":           "version: 0.0\nos: linux\nhooks:\n  AfterInstall:\n    - location: a.sh\n      timeout: 0\n",
+	"timeout negative":       "version: 0.0\nos: linux\nhooks:\n  AfterInstall:\n    - location: a.sh\n      timeout: -5\n",
+	"timeout string":         "version: 0.0\nos: linux\nhooks:\n  AfterInstall:\n    - location: a.sh\n      timeout: long\n",
+	"timeout over limit":     "version: 0.0\nos: linux\nhooks:\n  AfterInstall:\n    - location: a.sh\n      timeout: 4000\n",
+	"windows permissions":    "version: 0.0\nos: windows\npermissions:\n  - object: C:\\app\n",
+	"windows runas":          "version: 0.0\nos: windows\nhooks:\n  AfterInstall:\n    - location: a.ps1\n      runas: Administrator\n",
+	"windows release":        "version: 0.0\nos: windows\nrelease:\n  root: C:\\app\n",
+	"release relative":       "version: 0.0\nos: linux\nrelease:\n  root: var/www\n",
+	"release keep zero":      "version: 0.0\nos: linux\nrelease:\n  root: /var/www\n  keep: 0\n",
+	"permission no object":   "version: 0.0\nos: linux\npermissions:\n  - mode: \"0644\"\n",
+	"permission bad type":    "version: 0.0\nos: linux\npermissions:\n  - object: /opt/app\n    type: [socket]\n",
+	"mode not octal":         "version: 0.0\nos: linux\npermissions:\n  - object: /opt/app\n    mode: \"0999\"\n",
+	"mode too long":          "version: 0.0\nos: linux\npermissions:\n  - object: /opt/app\n    mode: \"12345\"\n",
+	"acl without colon":      "version: 0.0\nos: linux\npermissions:\n  - object: /opt/app\n    acls:\n      entries: [rwx]\n",
+	"context without type":   "version: 0.0\nos: linux\npermissions:\n  - object: /opt/app\n    context:\n      user: system_u\n",
+	"context range no low":   "version: 0.0\nos: linux\npermissions:\n  - object: /opt/app\n    context:\n      type: t\n      range:\n        high: s0\n",
+	"permission mode object": "version: 0.0\nos: linux\npermissions:\n  - object: /opt/app\n    mode: {a: 1}\n",
+}
+
+// TestSchema_AgreesWithParse verifies that the published schema accepts
+// exactly the appspecs Parse accepts on some platform, over the fixture
+// corpus and the integration bundles, so editor validation matches what the
+// agent will do.
+func TestSchema_AgreesWithParse(t *testing.T) {
+	fixtures := make(map[string]string, len(schemaFixtures))
+	for name, data := range schemaFixtures {
+		fixtures[name] = data
+	}
+	bundles, err := filepath.Glob(filepath.Join("..", "..", "integration", "bundles", "*", "appspec.yml"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, p := range bundles {
+		data, err := os.ReadFile(p)
+		if err != nil {
+			t.Fatal(err)
+		}
+		fixtures[p] = string(data)
+	}
+
+	v := newSchemaValidator(t)
+	var accepted, rejected int
+	for name, data := range fixtures {
+		_, errLinux := parse([]byte(data), "linux")
+		_, errWindows := parse([]byte(data), "windows")
+		parsed := errLinux == nil || errWindows == nil
+
+		var doc any
+		if err := yaml.Unmarshal([]byte(data), &doc); err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		if got := v.valid(v.root, doc); got != parsed {
+			t.Errorf("%s: schema valid = %v, Parse accepted = %v (linux: %v, windows: %v)", name, got, parsed, errLinux, errWindows)
+		}
+		if parsed {
+			accepted++
+		} else {
+			rejected++
+		}
+	}
+	if accepted < 5 || rejected < 20 {
+		t.Errorf("corpus has %d accepted and %d rejected appspecs, want both sides covered", accepted, rejected)
+	}
+}
+
+// TestSchema_KeysMatchParser verifies that the schema declares exactly the
+// keys Lint recognises, which mirror the parser's raw types, so a key added
+// to the parser is not forgotten in the schema.
+func TestSchema_KeysMatchParser(t *testing.T) {
+	v := newSchemaValidator(t)
+	defs := v.root["$defs"].(map[string]any)
+	for where, c := range map[string]struct {
+		schema map[string]any
+		keys   map[string]bool
+	}{
+		"top level":   {v.root, topLevelKeys},
+		"files entry": {defs["file"].(map[string]any), fileKeys},
+		"script":      {defs["script"].(map[string]any), scriptKeys},
+		"permission":  {defs["permission"].(map[string]any), permKeys},
+		"release":     {defs["release"].(map[string]any), releaseKeys},
+		"limits":      {defs["limits"].(map[string]any), limitKeys},
+	} {
+		var got, want []string
+		for k := range c.schema["properties"].(map[string]any) {
+			got = append(got, k)
+		}
+		for k := range c.keys {
+			want = append(want, k)
+		}
+		sort.Strings(got)
+		sort.Strings(want)
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("%s: schema properties %v, parser keys %v", where, got, want)
+		}
+	}
+}
+
+// schemaValidator checks YAML documents against the JSON Schema keywords
+// appspec.schema.json uses. It is deliberately small; an unknown keyword
+// fails the test rather than being ignored.
+type schemaValidator struct {
+	t    *testing.T
+	root map[string]any
+}
+
+func newSchemaValidator(t *testing.T) *schemaValidator {
+	t.Helper()
+	var root map[string]any
+	if err := json.Unmarshal(Schema(), &root); err != nil {
+		t.Fatalf("schema is not valid JSON: %v", err)
+	}
+	return &schemaValidator{t: t, root: root}
+}
+
+func (v *schemaValidator) valid(schema any, x any) bool {
+	if b, ok := schema.(bool); ok {
+		return b
+	}
+	s := schema.(map[string]any)
+	for kw, arg := range s {
+		if !v.keyword(s, kw, arg, x) {
+			return false
+		}
+	}
+	return true
+}
+
+func (v *schemaValidator) keyword(s map[string]any, kw string, arg, x any) bool {
+	switch kw {
+	case "$schema", "$id", "$defs", "title", "description", "then", "else":
+		return true
+	case "$ref":
+		name := strings.TrimPrefix(arg.(string), "#/$defs/")
+		return v.valid(v.root["$defs"].(map[string]any)[name], x)
+	case "type":
+		if list, ok := arg.([]any); ok {
+			for _, ty := range list {
+				if isType(ty.(string), x) {
+					return true
+				}
+			}
+			return false
+		}
+		return isType(arg.(string), x)
+	case "const":
+		return jsonEqual(arg, x)
+	case "enum":
+		for _, e := range arg.([]any) {
+			if jsonEqual(e, x) {
+				return true
+			}
+		}
+		return false
+	case "pattern":
+		str, ok := x.(string)
+		return !ok || regexp.MustCompile(arg.(string)).MatchString(str)
+	case "minLength":
+		str, ok := x.(string)
+		return !ok || utf8.RuneCountInString(str) >= int(arg.(float64))
+	case "minimum":
+		n, ok := number(x)
+		return !ok || n >= arg.(float64)
+	case "exclusiveMinimum":
+		n, ok := number(x)
+		return !ok || n > arg.(float64)
+	case "maximum":
+		n, ok := number(x)
+		return !ok || n <= arg.(float64)
+	case "maxItems":
+		list, ok := x.([]any)
+		return !ok || len(list) <= int(arg.(float64))
+	case "items":
+		list, _ := x.([]any)
+		for _, item := range list {
+			if !v.valid(arg, item) {
+				return false
+			}
+		}
+		return true
+	case "required":
+		obj, ok := x.(map[string]any)
+		if !ok {
+			return true
+		}
+		for _, k := range arg.([]any) {
+			if _, ok := obj[k.(string)]; !ok {
+				return false
+			}
+		}
+		return true
+	case "properties":
+		obj, _ := x.(map[string]any)
+		for k, sub := range arg.(map[string]any) {
+			if val, ok := obj[k]; ok && !v.valid(sub, val) {
+				return false
+			}
+		}
+		return true
+	case "additionalProperties":
+		obj, _ := x.(map[string]any)
+		props, _ := s["properties"].(map[string]any)
+		for k, val := range obj {
+			if _, declared := props[k]; !declared && !v.valid(arg, val) {
+				return false
+			}
+		}
+		return true
+	case "allOf":
+		for _, sub := range arg.([]any) {
+			if !v.valid(sub, x) {
+				return false
+			}
+		}
+		return true
+	case "dependentRequired":
+		obj, _ := x.(map[string]any)
+		for k, deps := range arg.(map[string]any) {
+			if _, ok := obj[k]; !ok {
+				continue
+			}
+			for _, d := range deps.([]any) {
+				if _, ok := obj[d.(string)]; !ok {
+					return false
+				}
+			}
+		}
+		return true
+	case "propertyNames":
+		obj, _ := x.(map[string]any)
+		for k := range obj {
+			if !v.valid(arg, k) {
+				return false
+			}
+		}
+		return true
+	case "not":
+		return !v.valid(arg, x)
+	case "oneOf":
+		n := 0
+		for _, sub := range arg.([]any) {
+			if v.valid(sub, x) {
+				n++
+			}
+		}
+		return n == 1
+	case "if":
+		if v.valid(arg, x) {
+			if then, ok := s["then"]; ok {
+				return v.valid(then, x)
+			}
+		} else if els, ok := s["else"]; ok {
+			return v.valid(els, x)
+		}
+		return true
+	}
+	v.t.Fatalf("schema uses keyword %q the test validator does not implement", kw)
+	return false
+}
+
+func isType(ty string, x any) bool {
+	switch ty {
+	case "null":
+		return x == nil
+	case "boolean":
+		_, ok := x.(bool)
+		return ok
+	case "string":
+		_, ok := x.(string)
+		return ok
+	case "object":
+		_, ok := x.(map[string]any)
+		return ok
+	case "array":
+		_, ok := x.([]any)
+		return ok
+	case "number":
+		_, ok := number(x)
+		return ok
+	case "integer":
+		n, ok := number(x)
+		return ok && n == math.Trunc(n)
+	}
+	return false
+}
+
+func number(x any) (float64, bool) {
+	switch n := x.(type) {
+	case int:
+		return float64(n), true
+	case float64:
+		return n, true
+	}
+	return 0, false
+}
+
+func jsonEqual(a, b any) bool {
+	if na, ok := number(a); ok {
+		nb, ok := number(b)
+		return ok && na == nb
+	}
+	return reflect.DeepEqual(a, b)
+}
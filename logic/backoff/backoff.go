@@ -12,7 +12,8 @@
 //   - Zero allocations on the hot path (called on every poll error).
 //   - Negative and overflow-prone inputs are handled defensively since Duration is public.
 //
-// Used by orchestration/poller to determine retry delays after poll failures.
+// Used by orchestration/poller to determine retry delays after poll failures,
+// and by orchestration/hookrunner between attempts of a retried hook script.
 // Throttle detection is handled separately by the caller; this package only provides
 // the ThrottleDelay constant for that case.
 package backoff
@@ -56,6 +57,20 @@ const maxShift = 10
 //	backoff.Duration(1, 30*time.Second, 90*time.Second) // [30s, 60s]
 //	backoff.Duration(2, 30*time.Second, 90*time.Second) // [45s, 90s] (clamped)
 func Duration(count int, baseDelay, maxDelay time.Duration) time.Duration {
+	delay := Max(count, baseDelay, maxDelay)
+	// Equal jitter: deterministic floor (delay/2) + uniform random in [0, delay/2].
+	// Int64N(half+1) makes the upper bound inclusive so the full delay is reachable.
+	half := delay / 2
+	jitter := time.Duration(rand.Int64N(int64(half + 1)))
+	return half + jitter
+}
+
+// Max returns the longest delay Duration can return for the same arguments:
+// the exponential delay clamped to maxDelay, before jitter. Callers that must
+// budget for a retry loop up front sum it over the retries.
+//
+//	backoff.Max(2, 10*time.Second, 300*time.Second) // 40s
+func Max(count int, baseDelay, maxDelay time.Duration) time.Duration {
 	// Guard: public API accepts int, so negative values are possible.
 	shift := count
 	if shift < 0 {
@@ -70,10 +85,5 @@ func Duration(count int, baseDelay, maxDelay time.Duration) time.Duration {
 	if delay > maxDelay {
 		delay = maxDelay
 	}
-
-	// Equal jitter: deterministic floor (delay/2) + uniform random in [0, delay/2].
-	// Int64N(half+1) makes the upper bound inclusive so the full delay is reachable.
-	half := delay / 2
-	jitter := time.Duration(rand.Int64N(int64(half + 1)))
-	return half + jitter
+	return delay
 }
@@ -112,6 +112,22 @@ func TestDuration_LargeCount_NoPanic(t *testing.T) {
 	}
 }
 
+// TestMax_BoundsDuration verifies that Max is the exponential delay clamped
+// to maxDelay and that Duration never exceeds it. Callers budget retry loops
+// with Max, so a Duration above it would overrun their budget.
+func TestMax_BoundsDuration(t *testing.T) {
+	for count, want := range []time.Duration{testBase, 2 * testBase, testMax, testMax} {
+		if got := Max(count, testBase, testMax); got != want {
+			t.Errorf("Max(%d) = %v, want %v", count, got, want)
+		}
+		for range iterations {
+			if d := Duration(count, testBase, testMax); d > want {
+				t.Fatalf("count=%d: Duration=%v > Max %v", count, d, want)
+			}
+		}
+	}
+}
+
 // TestThrottleDelay_Is60Seconds verifies the throttle constant matches the
 // Ruby agent's fixed 60-second wait for rate-limit responses.
 func TestThrottleDelay_Is60Seconds(t *testing.T) {
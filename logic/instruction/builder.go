@@ -55,6 +55,16 @@ func (b *Builder) Copy(source, destination string) error {
 	return nil
 }
 
+// CopyTemplate adds a copy instruction whose source is rendered as a
+// template during install.
+func (b *Builder) CopyTemplate(source, destination string) error {
+	if err := b.Copy(source, destination); err != nil {
+		return err
+	}
+	b.commands[len(b.commands)-1].Template = true
+	return nil
+}
+
 // Mkdir adds a directory creation instruction. Skips if directory is already
 // tracked as a mkdir target (idempotent for recursive directory creation).
 func (b *Builder) Mkdir(directory string) error {
@@ -0,0 +1,99 @@
+package instruction
+
+import (
+	"bytes"
+	"fmt"
+
+	json "github.com/goccy/go-json"
+)
+
+// CleanupVersion is the version of the JSON cleanup format written by this
+// agent. Version 0 denotes the legacy line-based format.
+const CleanupVersion = 1
+
+// EntryType is the kind of path recorded in a cleanup file.
+type EntryType string
+
+const (
+	EntryFile      EntryType = "file"
+	EntrySymlink   EntryType = "symlink"
+	EntryDirectory EntryType = "directory"
+	// EntryContext is an SELinux file context registered with semanage.
+	EntryContext EntryType = "context"
+)
+
+// CleanupEntry is a path installed by a deployment, to be removed before the
+// next deployment of the group.
+type CleanupEntry struct {
+	Path string    `json:"path"`
+	Type EntryType `json:"type,omitempty"`
+	// DeploymentID is the deployment that wrote the path.
+	DeploymentID string `json:"deployment_id,omitempty"`
+	// SHA256 is the content hash of a file when it was installed, if known.
+	SHA256 string `json:"sha256,omitempty"`
+	// Preexisting marks a path that existed before the agent first installed
+	// it, i.e. an original overwritten under OVERWRITE.
+	Preexisting bool `json:"preexisting,omitempty"`
+}
+
+// Cleanup is the content of a deployment group's cleanup file, listing
+// entries in install order.
+type Cleanup struct {
+	Version           int            `json:"version"`
+	DeploymentGroupID string         `json:"deployment_group_id,omitempty"`
+	Entries           []CleanupEntry `json:"entries"`
+}
+
+// ParseCleanup parses a cleanup file in either the JSON format or the legacy
+// line-based format inherited from the Ruby agent. Legacy files parse to
+// Version 0 with untyped entries (contexts excepted); writing the result with
+// ToJSON migrates them.
+//
+//	c, err := instruction.ParseCleanup(data)
+//	for _, e := range c.RemovalOrder() { ... }
+func ParseCleanup(data []byte) (Cleanup, error) {
+	trimmed := bytes.TrimSpace(data)
+	if len(trimmed) == 0 || trimmed[0] != '{' {
+		return parseLegacyCleanup(string(data)), nil
+	}
+
+	var c Cleanup
+	if err := json.Unmarshal(trimmed, &c); err != nil {
+		return Cleanup{}, fmt.Errorf("instruction: parse cleanup: %w", err)
+	}
+	if c.Version < 1 || c.Version > CleanupVersion {
+		return Cleanup{}, fmt.Errorf("instruction: unsupported cleanup version %d", c.Version)
+	}
+	return c, nil
+}
+
+func parseLegacyCleanup(data string) Cleanup {
+	removals := ParseRemoveCommands(data)
+	c := Cleanup{Entries: make([]CleanupEntry, 0, len(removals))}
+	for i := len(removals) - 1; i >= 0; i-- {
+		e := CleanupEntry{Path: removals[i].Path}
+		if removals[i].IsContext {
+			e.Type = EntryContext
+		}
+		c.Entries = append(c.Entries, e)
+	}
+	return c
+}
+
+// RemovalOrder returns the entries in reverse install order, so that files
+// are removed before their parent directories.
+func (c Cleanup) RemovalOrder() []CleanupEntry {
+	out := make([]CleanupEntry, len(c.Entries))
+	for i, e := range c.Entries {
+		out[len(out)-1-i] = e
+	}
+	return out
+}
+
+// ToJSON serializes the cleanup file in the current format version.
+//
+//	data, err := cleanup.ToJSON()
+func (c Cleanup) ToJSON() ([]byte, error) {
+	c.Version = CleanupVersion
+	return json.MarshalIndent(c, "", "  ")
+}
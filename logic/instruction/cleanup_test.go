@@ -0,0 +1,73 @@
+package instruction
+
+import (
+	"strings"
+	"testing"
+)
+
+// TestParseCleanup_RoundTrip verifies that a cleanup written with ToJSON
+// parses back with every field, stamped with the current version.
+func TestParseCleanup_RoundTrip(t *testing.T) {
+	in := Cleanup{
+		DeploymentGroupID: "dg-1",
+		Entries: []CleanupEntry{
+			{Path: "/opt/app", Type: EntryDirectory, DeploymentID: "d-1"},
+			{Path: "/opt/app/a.txt", Type: EntryFile, DeploymentID: "d-1", SHA256: "abc", Preexisting: true},
+		},
+	}
+	data, err := in.ToJSON()
+	if err != nil {
+		t.Fatal(err)
+	}
+	out, err := ParseCleanup(data)
+	if err != nil {
+		t.Fatalf("ParseCleanup: %v", err)
+	}
+	if out.Version != CleanupVersion || out.DeploymentGroupID != "dg-1" || len(out.Entries) != 2 {
+		t.Fatalf("parsed = %+v", out)
+	}
+	if out.Entries[1] != in.Entries[1] {
+		t.Errorf("entry = %+v, want %+v", out.Entries[1], in.Entries[1])
+	}
+}
+
+// TestParseCleanup_Legacy verifies that the line-based format is read in
+// install order, with semanage lines typed as contexts and a torn final line
+// dropped, as ParseRemoveCommands does.
+func TestParseCleanup_Legacy(t *testing.T) {
+	c, err := ParseCleanup([]byte("/opt/app\n/opt/app/a.txt\nsemanage\x00/opt/app/a.txt\n/opt/app/par"))
+	if err != nil {
+		t.Fatalf("ParseCleanup: %v", err)
+	}
+	if c.Version != 0 || len(c.Entries) != 3 {
+		t.Fatalf("parsed = %+v", c)
+	}
+	if c.Entries[0].Path != "/opt/app" || c.Entries[0].Type != "" {
+		t.Errorf("first entry = %+v", c.Entries[0])
+	}
+	if c.Entries[2].Type != EntryContext || c.Entries[2].Path != "/opt/app/a.txt" {
+		t.Errorf("context entry = %+v", c.Entries[2])
+	}
+}
+
+// TestParseCleanup_RejectsUnknownVersion verifies that a cleanup file from a
+// newer agent is refused instead of being half understood.
+func TestParseCleanup_RejectsUnknownVersion(t *testing.T) {
+	_, err := ParseCleanup([]byte(`{"version": 99, "entries": []}`))
+	if err == nil || !strings.Contains(err.Error(), "unsupported cleanup version") {
+		t.Errorf("err = %v, want unsupported version", err)
+	}
+}
+
+// TestCleanup_RemovalOrder verifies that removal runs in reverse install
+// order so that files go before their parent directories.
+func TestCleanup_RemovalOrder(t *testing.T) {
+	c := Cleanup{Entries: []CleanupEntry{{Path: "/a"}, {Path: "/a/b"}, {Path: "/a/b/c"}}}
+	got := c.RemovalOrder()
+	if got[0].Path != "/a/b/c" || got[2].Path != "/a" {
+		t.Errorf("RemovalOrder = %+v", got)
+	}
+	if c.Entries[0].Path != "/a" {
+		t.Error("RemovalOrder must not modify the cleanup")
+	}
+}
@@ -4,6 +4,11 @@
 package instruction
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"os"
+
 	json "github.com/goccy/go-json"
 )
 
@@ -31,6 +36,52 @@ type Command struct {
 	Group       string      `json:"group,omitempty"`
 	ACL         []string    `json:"acl,omitempty"`
 	Context     *ContextCmd `json:"context,omitempty"`
+	// Stats identifies the content of a copied file so that the next install
+	// can skip it when unchanged. Unchanged marks a copy that was skipped.
+	Stats     *FileStats `json:"stats,omitempty"`
+	Unchanged bool       `json:"unchanged,omitempty"`
+	// Template marks a copy whose source is rendered with text/template.
+	// Rendered is the rendered output the copy reads, set during install.
+	Template bool   `json:"template,omitempty"`
+	Rendered string `json:"-"`
+}
+
+// CopySource returns the file a copy reads: the rendered output of a
+// template once rendered, otherwise Source.
+func (c *Command) CopySource() string {
+	if c.Rendered != "" {
+		return c.Rendered
+	}
+	return c.Source
+}
+
+// FileStats describes a copied file. Size, Mode, Mtime and SHA256 describe
+// what the copy reads, which for a template is the rendered output;
+// InstalledMtime is the destination's modification time after the
+// install, which reveals later local edits. Times are Unix nanoseconds.
+type FileStats struct {
+	SHA256         string `json:"sha256,omitempty"`
+	Size           int64  `json:"size"`
+	Mtime          int64  `json:"mtime,omitempty"`
+	InstalledMtime int64  `json:"installed_mtime,omitempty"`
+	Mode           uint32 `json:"mode,omitempty"`
+}
+
+// HashFile returns the hex SHA-256 of a file's content, as recorded in
+// FileStats.SHA256.
+//
+//	sum, err := instruction.HashFile("/var/www/index.html")
+func HashFile(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer func() { _ = f.Close() }()
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
 }
 
 // ContextCmd holds SELinux context fields for semanage commands.
@@ -64,14 +115,15 @@ func ParseInstallCommands(data []byte) ([]Command, error) {
 	return inst.Commands, nil
 }
 
-// RemoveEntry represents a single entry in the cleanup file.
+// RemoveEntry represents a single entry in a legacy line-based cleanup file.
 // Regular entries are file/directory paths. Semanage entries start with "semanage\0".
 type RemoveEntry struct {
 	Path      string
 	IsContext bool // true if this was a semanage fcontext entry
 }
 
-// ParseRemoveCommands parses a cleanup file into reverse-ordered removal entries.
+// ParseRemoveCommands parses a legacy cleanup file into reverse-ordered
+// removal entries. ParseCleanup reads both the legacy and the JSON format.
 // Incomplete last lines (without trailing newline) are discarded to avoid
 // processing partially-written paths from interrupted deployments.
 //
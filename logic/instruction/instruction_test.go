@@ -1,6 +1,8 @@
 package instruction
 
 import (
+	"os"
+	"path/filepath"
 	"testing"
 
 	json "github.com/goccy/go-json"
@@ -328,3 +330,22 @@ func TestParseInstallCommandsInvalidJSON(t *testing.T) {
 		t.Fatal("expected error for invalid JSON")
 	}
 }
+
+// TestHashFile verifies the recorded hash format: lowercase hex SHA-256 of the
+// file content, so that installer and verifier agree on it.
+func TestHashFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "f")
+	if err := os.WriteFile(path, []byte("abc"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	got, err := HashFile(path)
+	if err != nil {
+		t.Fatalf("HashFile: %v", err)
+	}
+	if want := "ba7816bf8f01cfea414140de5dae2223b00361a396177a9cb410ff61f20015ad"; got != want {
+		t.Errorf("HashFile = %s, want %s", got, want)
+	}
+	if _, err := HashFile(filepath.Join(t.TempDir(), "missing")); err == nil {
+		t.Error("expected error for missing file")
+	}
+}
@@ -2,6 +2,8 @@
 // hook-to-deployment-root mappings, and deployment root selection.
 package lifecycle
 
+import "fmt"
+
 // Event represents a deployment lifecycle event name.
 type Event string
 
@@ -29,6 +31,19 @@ const (
 	Current
 )
 
+// String returns the root's name as used in logs and plans.
+func (r DeploymentRoot) String() string {
+	switch r {
+	case LastSuccessful:
+		return "last-successful"
+	case MostRecent:
+		return "most-recent"
+	case Current:
+		return "current"
+	}
+	return fmt.Sprintf("DeploymentRoot(%d)", int(r))
+}
+
 // DefaultHookMapping returns the standard command-to-lifecycle-event mapping.
 // Each command name maps to the lifecycle events it triggers.
 //
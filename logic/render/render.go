@@ -0,0 +1,59 @@
+// Package render renders config files marked as templates in the appspec
+// files section with text/template.
+package render
+
+import (
+	"bytes"
+	"fmt"
+	"text/template"
+)
+
+// Deployment identifies the deployment being installed.
+type Deployment struct {
+	ApplicationName     string
+	DeploymentGroupName string
+	DeploymentGroupID   string
+	DeploymentID        string
+}
+
+// Instance holds EC2 instance metadata. Fields are empty on hosts without
+// instance metadata, such as on-premises instances.
+type Instance struct {
+	InstanceID       string
+	InstanceType     string
+	AccountID        string
+	Region           string
+	AvailabilityZone string
+	PrivateIP        string
+	ImageID          string
+}
+
+// Data is what a template sees: {{ .Deployment.DeploymentID }},
+// {{ .Instance.Region }} and {{ .Vars.key }} for values from the host-local
+// variables file.
+type Data struct {
+	Deployment Deployment
+	Instance   Instance
+	Vars       map[string]any
+}
+
+// Render executes src as a text/template named name. A reference to a
+// variable that is not defined is an error rather than an empty string, so a
+// host missing a variable fails the install instead of shipping a broken
+// config file.
+//
+//	out, err := render.Render("app.conf", src, render.Data{Vars: vars})
+func Render(name string, src []byte, data Data) ([]byte, error) {
+	if data.Vars == nil {
+		data.Vars = map[string]any{}
+	}
+	tmpl, err := template.New(name).Option("missingkey=error").Parse(string(src))
+	if err != nil {
+		return nil, fmt.Errorf("render: %w", err)
+	}
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, data); err != nil {
+		return nil, fmt.Errorf("render: %w", err)
+	}
+	return buf.Bytes(), nil
+}
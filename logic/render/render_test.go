@@ -0,0 +1,44 @@
+package render
+
+import (
+	"strings"
+	"testing"
+)
+
+// TestRender_Data verifies that deployment, instance and host variables are
+// all reachable from a template under their documented names.
+func TestRender_Data(t *testing.T) {
+	src := []byte("app={{ .Deployment.ApplicationName }} group={{ .Deployment.DeploymentGroupName }} " +
+		"id={{ .Deployment.DeploymentID }} region={{ .Instance.Region }} db={{ .Vars.db_host }}:{{ .Vars.db.port }}")
+	data := Data{
+		Deployment: Deployment{ApplicationName: "shop", DeploymentGroupName: "prod", DeploymentID: "d-1"},
+		Instance:   Instance{Region: "eu-north-1"},
+		Vars:       map[string]any{"db_host": "db.internal", "db": map[string]any{"port": 5432}},
+	}
+
+	out, err := Render("app.conf", src, data)
+	if err != nil {
+		t.Fatalf("Render: %v", err)
+	}
+	want := "app=shop group=prod id=d-1 region=eu-north-1 db=db.internal:5432"
+	if string(out) != want {
+		t.Errorf("Render = %q, want %q", out, want)
+	}
+}
+
+// TestRender_MissingVariable verifies that a variable the host does not
+// define fails rendering instead of producing an empty value.
+func TestRender_MissingVariable(t *testing.T) {
+	_, err := Render("app.conf", []byte("db={{ .Vars.db_host }}"), Data{})
+	if err == nil || !strings.Contains(err.Error(), "db_host") {
+		t.Errorf("Render err = %v, want missing db_host", err)
+	}
+}
+
+// TestRender_ParseError verifies that a malformed template names the file.
+func TestRender_ParseError(t *testing.T) {
+	_, err := Render("app.conf", []byte("{{ .Vars.x "), Data{})
+	if err == nil || !strings.Contains(err.Error(), "app.conf") {
+		t.Errorf("Render err = %v, want parse error naming app.conf", err)
+	}
+}
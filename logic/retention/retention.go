@@ -0,0 +1,49 @@
+// Package retention decides which deployment revisions fall outside the
+// max_revisions retention window. It is pure computation: callers supply the
+// revision inventory (paths and modification times) and act on the result.
+package retention
+
+import (
+	"sort"
+	"time"
+)
+
+// Revision is a deployment directory considered for retention.
+type Revision struct {
+	// Path is the absolute deployment root directory.
+	Path string
+	// ModTime is the directory's last modification time.
+	ModTime time.Time
+}
+
+// Expired returns the paths of revisions that should be removed so that at
+// most keep revisions remain. Protected paths (last successful, ongoing
+// deployments) are never returned, but they still count towards keep, which
+// matches the Ruby agent's cleanup: protection can leave more than keep
+// revisions on disk, never fewer. Oldest revisions are returned first.
+//
+//	expired := retention.Expired(revs, 4, map[string]bool{lastSuccessful: true})
+func Expired(revisions []Revision, keep int, protected map[string]bool) []string {
+	extra := len(revisions) - max(keep, 0)
+	if extra <= 0 {
+		return nil
+	}
+
+	candidates := make([]Revision, 0, len(revisions))
+	for _, r := range revisions {
+		if !protected[r.Path] {
+			candidates = append(candidates, r)
+		}
+	}
+
+	sort.SliceStable(candidates, func(i, j int) bool {
+		return candidates[i].ModTime.Before(candidates[j].ModTime)
+	})
+
+	n := min(extra, len(candidates))
+	expired := make([]string, 0, n)
+	for _, r := range candidates[:n] {
+		expired = append(expired, r.Path)
+	}
+	return expired
+}
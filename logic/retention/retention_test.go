@@ -0,0 +1,66 @@
+package retention
+
+import (
+	"slices"
+	"testing"
+	"time"
+)
+
+func revs(names ...string) []Revision {
+	base := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	out := make([]Revision, 0, len(names))
+	for i, n := range names {
+		out = append(out, Revision{Path: n, ModTime: base.Add(time.Duration(i) * time.Hour)})
+	}
+	return out
+}
+
+// TestExpired_WithinWindow verifies that nothing is removed while the number
+// of revisions does not exceed keep.
+func TestExpired_WithinWindow(t *testing.T) {
+	if got := Expired(revs("a", "b", "c"), 3, nil); len(got) != 0 {
+		t.Errorf("Expired = %v, want none", got)
+	}
+}
+
+// TestExpired_OldestFirst verifies that the oldest revisions are selected
+// when the window is exceeded, regardless of input order.
+func TestExpired_OldestFirst(t *testing.T) {
+	in := revs("a", "b", "c", "d", "e")
+	slices.Reverse(in)
+	got := Expired(in, 2, nil)
+	want := []string{"a", "b", "c"}
+	if !slices.Equal(got, want) {
+		t.Errorf("Expired = %v, want %v", got, want)
+	}
+}
+
+// TestExpired_ProtectedCountsTowardsKeep verifies that a protected revision
+// is never removed but still occupies a slot in the window. This mirrors the
+// executor's long-standing behavior of preserving last-successful without
+// removing an extra revision to compensate.
+func TestExpired_ProtectedCountsTowardsKeep(t *testing.T) {
+	got := Expired(revs("a", "b", "c", "d"), 2, map[string]bool{"a": true})
+	want := []string{"b", "c"}
+	if !slices.Equal(got, want) {
+		t.Errorf("Expired = %v, want %v", got, want)
+	}
+}
+
+// TestExpired_AllProtected verifies that protection wins over the window:
+// when every revision is protected, none are returned.
+func TestExpired_AllProtected(t *testing.T) {
+	got := Expired(revs("a", "b"), 0, map[string]bool{"a": true, "b": true})
+	if len(got) != 0 {
+		t.Errorf("Expired = %v, want none", got)
+	}
+}
+
+// TestExpired_NegativeKeep verifies that a negative keep is treated as zero
+// rather than panicking on slice bounds.
+func TestExpired_NegativeKeep(t *testing.T) {
+	got := Expired(revs("a", "b"), -1, nil)
+	if len(got) != 2 {
+		t.Errorf("Expired = %v, want both", got)
+	}
+}
@@ -93,3 +93,6 @@ type benchFileOp struct{}
 
 func (f *benchFileOp) MkdirAll(path string) error  { return os.MkdirAll(path, 0o755) }
 func (f *benchFileOp) RemoveAll(path string) error { return os.RemoveAll(path) }
+func (f *benchFileOp) CopyTree(src, dst string) error {
+	return os.CopyFS(dst, os.DirFS(src))
+}
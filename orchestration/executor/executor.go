@@ -8,12 +8,12 @@ import (
 	"log/slog"
 	"os"
 	"path/filepath"
-	"sort"
 	"time"
 
 	"github.com/gurre/codedeploy-agent-go/logic/appspec"
 	"github.com/gurre/codedeploy-agent-go/logic/deployspec"
 	"github.com/gurre/codedeploy-agent-go/logic/lifecycle"
+	"github.com/gurre/codedeploy-agent-go/logic/retention"
 	"github.com/gurre/codedeploy-agent-go/state/deployment"
 )
 
@@ -56,15 +56,31 @@ type HookResult struct {
 	Log    string
 }
 
+// InstallArgs describes one Install command.
+type InstallArgs struct {
+	DeploymentGroupID   string
+	DeploymentID        string
+	ApplicationName     string
+	DeploymentGroupName string
+	ArchiveDir          string
+	InstructionsDir     string
+	BackupDir           string
+	Spec                appspec.Spec
+	FileExistsBehavior  string
+}
+
 // Installer handles the Install command.
 type Installer interface {
-	Install(deploymentGroupID, archiveDir, instructionsDir string, spec appspec.Spec, fileExistsBehavior string) error
+	Install(args InstallArgs) error
 }
 
 // FileOperator for local file operations during DownloadBundle.
 type FileOperator interface {
 	MkdirAll(path string) error
 	RemoveAll(path string) error
+	// CopyTree copies a Local Directory revision into the archive directory,
+	// preserving symlinks, modes, mtimes and (when root) ownership.
+	CopyTree(src, dst string) error
 }
 
 // Executor dispatches deployment commands.
@@ -178,7 +194,7 @@ func (e *Executor) downloadBundle(ctx context.Context, spec deployspec.Spec, lay
 			return fmt.Errorf("executor: symlink local file: %w", err)
 		}
 	case deployspec.RevisionLocalDirectory:
-		if err := copyDir(spec.LocalLocation, layout.ArchiveDir()); err != nil {
+		if err := e.fileOp.CopyTree(spec.LocalLocation, layout.ArchiveDir()); err != nil {
 			return fmt.Errorf("executor: copy local directory: %w", err)
 		}
 	default:
@@ -219,7 +235,18 @@ func (e *Executor) install(_ context.Context, spec deployspec.Spec, layout deplo
 		return err
 	}
 
-	if err := e.installer.Install(spec.DeploymentGroupID, layout.ArchiveDir(), instructionsDir, appSpec, spec.FileExistsBehavior); err != nil {
+	err = e.installer.Install(InstallArgs{
+		DeploymentGroupID:   spec.DeploymentGroupID,
+		DeploymentID:        spec.DeploymentID,
+		ApplicationName:     spec.ApplicationName,
+		DeploymentGroupName: spec.DeploymentGroupName,
+		ArchiveDir:          layout.ArchiveDir(),
+		InstructionsDir:     instructionsDir,
+		BackupDir:           layout.BackupDir(),
+		Spec:                appSpec,
+		FileExistsBehavior:  spec.FileExistsBehavior,
+	})
+	if err != nil {
 		return err
 	}
 
@@ -273,7 +300,7 @@ func (e *Executor) cleanupOldArchives(spec deployspec.Spec) {
 	currentRoot := filepath.Join(groupDir, spec.DeploymentID)
 	lastSuccess := readPointer(deployment.LastSuccessfulFile(e.rootDir, spec.DeploymentGroupID))
 
-	candidates := make([]string, 0, len(entries))
+	candidates := make([]retention.Revision, 0, len(entries))
 	for _, entry := range entries {
 		if !entry.IsDir() {
 			continue
@@ -282,38 +309,18 @@ func (e *Executor) cleanupOldArchives(spec deployspec.Spec) {
 		if full == currentRoot {
 			continue
 		}
-		candidates = append(candidates, full)
-	}
-
-	extra := len(candidates) - e.maxRevisions + 1
-	if extra <= 0 {
-		return
-	}
-
-	// Remove last successful from cleanup candidates
-	filtered := make([]string, 0, len(candidates))
-	for _, c := range candidates {
-		if c != lastSuccess {
-			filtered = append(filtered, c)
-		}
-	}
-
-	// Collect modification times to avoid repeated os.Stat during sort
-	modTimes := make(map[string]time.Time, len(filtered))
-	for _, path := range filtered {
-		if info, err := os.Stat(path); err == nil {
-			modTimes[path] = info.ModTime()
+		rev := retention.Revision{Path: full}
+		if info, err := entry.Info(); err == nil {
+			rev.ModTime = info.ModTime()
 		}
+		candidates = append(candidates, rev)
 	}
 
-	// Sort by modification time, oldest first
-	sort.Slice(filtered, func(i, j int) bool {
-		return modTimes[filtered[i]].Before(modTimes[filtered[j]])
-	})
-
-	for i := range min(extra, len(filtered)) {
-		e.logger.Info("removing old archive", "path", filtered[i])
-		_ = os.RemoveAll(filtered[i])
+	// The current deployment occupies one slot of the retention window.
+	protected := map[string]bool{lastSuccess: true}
+	for _, path := range retention.Expired(candidates, e.maxRevisions-1, protected) {
+		e.logger.Info("removing old archive", "path", path)
+		_ = os.RemoveAll(path)
 	}
 }
 
@@ -386,21 +393,3 @@ func (e *Executor) appendScriptLog(layout deployment.Layout, log string) {
 		e.logger.Warn("failed to write script log", "error", err)
 	}
 }
-
-func copyDir(src, dst string) error {
-	return filepath.Walk(src, func(path string, info os.FileInfo, err error) error {
-		if err != nil {
-			return err
-		}
-		rel, _ := filepath.Rel(src, path)
-		target := filepath.Join(dst, rel)
-		if info.IsDir() {
-			return os.MkdirAll(target, info.Mode())
-		}
-		data, err := os.ReadFile(path)
-		if err != nil {
-			return err
-		}
-		return os.WriteFile(target, data, info.Mode())
-	})
-}
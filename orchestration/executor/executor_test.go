@@ -11,7 +11,6 @@ import (
 	"testing"
 	"time"
 
-	"github.com/gurre/codedeploy-agent-go/logic/appspec"
 	"github.com/gurre/codedeploy-agent-go/logic/deployspec"
 	"github.com/gurre/codedeploy-agent-go/logic/lifecycle"
 	"github.com/gurre/codedeploy-agent-go/state/deployment"
@@ -242,22 +241,25 @@ func TestExecute_Install(t *testing.T) {
 		t.Fatalf("expected 1 install call, got %d", len(inst.calls))
 	}
 	ic := inst.calls[0]
-	if ic.deploymentGroupID != spec.DeploymentGroupID {
-		t.Errorf("install deploymentGroupID = %q, want %q", ic.deploymentGroupID, spec.DeploymentGroupID)
+	if ic.DeploymentGroupID != spec.DeploymentGroupID {
+		t.Errorf("install deploymentGroupID = %q, want %q", ic.DeploymentGroupID, spec.DeploymentGroupID)
 	}
-	if ic.archiveDir != layout.ArchiveDir() {
-		t.Errorf("install archiveDir = %q, want %q", ic.archiveDir, layout.ArchiveDir())
+	if ic.ArchiveDir != layout.ArchiveDir() {
+		t.Errorf("install archiveDir = %q, want %q", ic.ArchiveDir, layout.ArchiveDir())
 	}
-	if ic.fileExistsBehavior != spec.FileExistsBehavior {
-		t.Errorf("install fileExistsBehavior = %q, want %q", ic.fileExistsBehavior, spec.FileExistsBehavior)
+	if ic.FileExistsBehavior != spec.FileExistsBehavior {
+		t.Errorf("install fileExistsBehavior = %q, want %q", ic.FileExistsBehavior, spec.FileExistsBehavior)
+	}
+	if ic.ApplicationName != "myapp" || ic.DeploymentGroupName != "prod" {
+		t.Errorf("install names = %q/%q, want myapp/prod for templates", ic.ApplicationName, ic.DeploymentGroupName)
 	}
 
 	// Verify the parsed appspec has the expected file mapping
-	if len(ic.spec.Files) != 1 {
-		t.Fatalf("expected 1 file mapping in parsed appspec, got %d", len(ic.spec.Files))
+	if len(ic.Spec.Files) != 1 {
+		t.Fatalf("expected 1 file mapping in parsed appspec, got %d", len(ic.Spec.Files))
 	}
-	if ic.spec.Files[0].Destination != "/opt/app" {
-		t.Errorf("file destination = %q, want %q", ic.spec.Files[0].Destination, "/opt/app")
+	if ic.Spec.Files[0].Destination != "/opt/app" {
+		t.Errorf("file destination = %q, want %q", ic.Spec.Files[0].Destination, "/opt/app")
 	}
 
 	// Verify last-successful pointer was written
@@ -713,10 +715,10 @@ func TestExecute_LifecycleEventsDispatchInOrder(t *testing.T) {
 
 // TestExecute_DownloadBundle_LocalDirectory_ProducesRegularFiles verifies that
 // a local directory deployment copies files as regular files, not symlinks.
-// copyDir (executor.go:386-402) uses os.ReadFile + os.WriteFile, producing
-// independent file copies. This validates Ruby Scenarios 1 & 3 where local
-// directory deployments must produce independent file copies, and guards against
-// regression if copyDir were ever changed to use symlinks.
+// The executor hands the copy to FileOperator.CopyTree rather than linking
+// LocalLocation into place. This validates Ruby Scenarios 1 & 3 where local
+// directory deployments must produce independent file copies, and guards
+// against regression if DownloadBundle were ever changed to symlink the source.
 func TestExecute_DownloadBundle_LocalDirectory_ProducesRegularFiles(t *testing.T) {
 	rootDir := t.TempDir()
 
@@ -859,28 +861,13 @@ func (f *fakeHookRunner) IsNoop(args HookRunArgs) (bool, error) {
 	return noop, nil
 }
 
-// installCall records the parameters of a single Install invocation.
-type installCall struct {
-	deploymentGroupID  string
-	archiveDir         string
-	instructionsDir    string
-	spec               appspec.Spec
-	fileExistsBehavior string
-}
-
 // fakeInstaller records install calls without performing real file operations.
 type fakeInstaller struct {
-	calls []installCall
+	calls []InstallArgs
 }
 
-func (f *fakeInstaller) Install(deploymentGroupID, archiveDir, instructionsDir string, spec appspec.Spec, fileExistsBehavior string) error {
-	f.calls = append(f.calls, installCall{
-		deploymentGroupID:  deploymentGroupID,
-		archiveDir:         archiveDir,
-		instructionsDir:    instructionsDir,
-		spec:               spec,
-		fileExistsBehavior: fileExistsBehavior,
-	})
+func (f *fakeInstaller) Install(args InstallArgs) error {
+	f.calls = append(f.calls, args)
 	return nil
 }
 
@@ -890,6 +877,9 @@ type realFileOperator struct{}
 
 func (r *realFileOperator) MkdirAll(path string) error  { return os.MkdirAll(path, 0o755) }
 func (r *realFileOperator) RemoveAll(path string) error { return os.RemoveAll(path) }
+func (r *realFileOperator) CopyTree(src, dst string) error {
+	return os.CopyFS(dst, os.DirFS(src))
+}
 
 // TestNewExecutor_InvalidMaxRevisions verifies that maxRevisions < 1 is
 // corrected to the default of 5. This guards against misconfiguration that
@@ -9,6 +9,7 @@ import (
 	"runtime"
 	"testing"
 
+	"github.com/gurre/codedeploy-agent-go/logic/appspec"
 	"github.com/gurre/codedeploy-agent-go/logic/lifecycle"
 )
 
@@ -68,6 +69,10 @@ hooks:
 // benchScriptRunner returns a fixed result without executing any process.
 type benchScriptRunner struct{}
 
-func (s *benchScriptRunner) Run(_ context.Context, _ string, _ map[string]string, _ int) (ScriptResult, error) {
+func (s *benchScriptRunner) Run(_ context.Context, _ string, _ map[string]string, _, _ int, _ appspec.Limits) (ScriptResult, error) {
+	return ScriptResult{Stdout: "ok\n", ExitCode: 0}, nil
+}
+
+func (s *benchScriptRunner) RunCommand(_ context.Context, _, _ string, _ map[string]string, _, _ int, _ appspec.Limits) (ScriptResult, error) {
 	return ScriptResult{Stdout: "ok\n", ExitCode: 0}, nil
 }
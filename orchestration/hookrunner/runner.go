@@ -4,27 +4,57 @@ package hookrunner
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"log/slog"
+	"maps"
 	"os"
 	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/gurre/codedeploy-agent-go/logic/appspec"
+	"github.com/gurre/codedeploy-agent-go/logic/backoff"
 	"github.com/gurre/codedeploy-agent-go/logic/diagnostic"
 	"github.com/gurre/codedeploy-agent-go/logic/lifecycle"
 )
 
-// ScriptRunner executes a script and returns the result.
+// ScriptRunner executes a script, or an inline command through a shell, and
+// returns the result. An empty shell is the platform default. At the
+// timeout the script is asked to exit, and killed after graceSeconds.
 type ScriptRunner interface {
-	Run(ctx context.Context, scriptPath string, env map[string]string, timeoutSeconds int) (ScriptResult, error)
+	Run(ctx context.Context, scriptPath string, env map[string]string, timeoutSeconds, graceSeconds int, limits appspec.Limits) (ScriptResult, error)
+	RunCommand(ctx context.Context, shell, command string, env map[string]string, timeoutSeconds, graceSeconds int, limits appspec.Limits) (ScriptResult, error)
 }
 
+// PreflightError is returned by a ScriptRunner for a script that cannot be
+// started. The hook fails with ScriptMissing or ScriptNotExecutable.
+type PreflightError struct {
+	// Missing is true if the script does not exist.
+	Missing bool
+	// Reason says what is wrong, phrased to follow the script's name.
+	Reason string
+}
+
+func (e *PreflightError) Error() string { return e.Reason }
+
 // ScriptResult holds the outcome of a script execution.
 type ScriptResult struct {
 	Stdout   string
 	Stderr   string
 	ExitCode int
 	TimedOut bool
+	// Signals are the signals a timed-out script was sent, in order.
+	Signals []string
+	// OutputsLeftOpen is true if processes the script started still held
+	// its stdout or stderr after it exited.
+	OutputsLeftOpen bool
+	// OpenOutputPIDs are those processes, where they could be found.
+	OpenOutputPIDs []int
+	// Usage describes the resources a script with limits used; empty
+	// without limits.
+	Usage string
 }
 
 // HookResult holds the outcome of executing all scripts for a lifecycle event.
@@ -36,6 +66,9 @@ type HookResult struct {
 // Runner executes lifecycle hook scripts for a deployment.
 type Runner struct {
 	scriptRunner ScriptRunner
+	shell        string
+	graceSeconds int
+	limits       appspec.Limits
 	logger       *slog.Logger
 }
 
@@ -49,6 +82,32 @@ func NewRunner(sr ScriptRunner, logger *slog.Logger) *Runner {
 	}
 }
 
+// SetShell sets the shell that runs inline command hooks which name none;
+// empty leaves the choice to the ScriptRunner. Call it before the first Run.
+//
+//	r.SetShell(cfg.HookShell)
+func (r *Runner) SetShell(shell string) {
+	r.shell = shell
+}
+
+// SetGracePeriod sets how many seconds a timed-out script gets to exit
+// before it is killed, for scripts whose appspec entry sets no
+// grace_period. Call it before the first Run.
+//
+//	r.SetGracePeriod(30)
+func (r *Runner) SetGracePeriod(seconds int) {
+	r.graceSeconds = seconds
+}
+
+// SetLimits sets the resource limits of every script, which the limits in
+// a script's appspec entry override one by one. Call it before the first
+// Run.
+//
+//	r.SetLimits(appspec.Limits{Memory: 512 << 20})
+func (r *Runner) SetLimits(limits appspec.Limits) {
+	r.limits = limits
+}
+
 // RunArgs holds the arguments for running a lifecycle event's hooks.
 type RunArgs struct {
 	LifecycleEvent      lifecycle.Event
@@ -93,26 +152,51 @@ func (r *Runner) Run(ctx context.Context, args RunArgs) (HookResult, error) {
 		return HookResult{IsNoop: true}, nil
 	}
 
-	env := buildEnv(args)
+	deployEnv := buildEnv(args)
 	var logOutput string
 
-	for _, script := range scripts {
-		scriptPath := filepath.Join(archiveDir, script.Location)
-
-		r.logger.Info("executing hook script", "event", eventName, "script", script.Location)
+	// The appspec's budget check cannot count the agent's default grace
+	// period; warn when it pushes the event past the budget.
+	if seconds, ok := appspec.EventBudget(scripts, r.graceSeconds); !ok {
+		logOutput += fmt.Sprintf("Warning: with the default grace period of %d seconds the scripts of %s may run for %d seconds, more than the 3600 allowed\n", r.graceSeconds, eventName, seconds)
+		r.logger.Warn("hook scripts may outlast the lifecycle event budget", "event", eventName, "seconds", seconds, "defaultGracePeriod", r.graceSeconds)
+	}
 
-		result, err := r.scriptRunner.Run(ctx, scriptPath, env, script.Timeout)
+	for _, script := range scripts {
+		name := script.Name()
+		env, secret, err := scriptEnv(archiveDir, spec, script, deployEnv)
 		if err != nil {
-			return HookResult{}, fmt.Errorf("hookrunner: %s: %w", script.Location, err)
+			return HookResult{Log: logOutput}, fmt.Errorf("hookrunner: %s: %w", name, err)
 		}
+		r.logger.Info("executing hook script", "event", eventName, "script", name)
+		r.logger.Debug("hook environment", "event", eventName, "script", name, "env", redactEnv(env, secret))
 
-		logOutput += formatScriptLog(script.Location, result.Stdout, result.Stderr)
+		result, attempt, err := r.runAttempts(ctx, archiveDir, script, env, &logOutput)
+		var pe *PreflightError
+		if errors.As(err, &pe) {
+			code := diagnostic.ScriptNotExecutable
+			if pe.Missing {
+				code = diagnostic.ScriptMissing
+			}
+			message := fmt.Sprintf("%s %s", describe(script), pe.Reason)
+			logOutput += fmt.Sprintf("Script - %s\n%s\n", name, message)
+			return HookResult{Log: logOutput}, &diagnostic.ScriptError{
+				Code:       code,
+				ScriptName: name,
+				Message:    message,
+				Log:        logOutput,
+			}
+		}
+		if err != nil {
+			return HookResult{}, fmt.Errorf("hookrunner: %s: %w", name, err)
+		}
 
 		if result.TimedOut {
+			stopped := describeSignals(result.Signals, r.gracePeriod(script))
 			return HookResult{Log: logOutput}, &diagnostic.ScriptError{
 				Code:       diagnostic.ScriptTimedOut,
-				ScriptName: script.Location,
-				Message:    fmt.Sprintf("script at %s timed out after %d seconds", script.Location, script.Timeout),
+				ScriptName: name,
+				Message:    fmt.Sprintf("%s timed out after %d seconds%s: %s", describe(script), script.Timeout, describeAttempt(script, attempt), stopped),
 				Log:        logOutput,
 			}
 		}
@@ -120,8 +204,17 @@ func (r *Runner) Run(ctx context.Context, args RunArgs) (HookResult, error) {
 		if result.ExitCode != 0 {
 			return HookResult{Log: logOutput}, &diagnostic.ScriptError{
 				Code:       diagnostic.ScriptFailed,
-				ScriptName: script.Location,
-				Message:    fmt.Sprintf("script at %s failed with exit code %d", script.Location, result.ExitCode),
+				ScriptName: name,
+				Message:    fmt.Sprintf("%s failed with exit code %d%s", describe(script), result.ExitCode, describeAttempt(script, attempt)),
+				Log:        logOutput,
+			}
+		}
+
+		if result.OutputsLeftOpen {
+			return HookResult{Log: logOutput}, &diagnostic.ScriptError{
+				Code:       diagnostic.OutputsLeftOpen,
+				ScriptName: name,
+				Message:    fmt.Sprintf("%s exited but left stdout or stderr open in %s; redirect the output of background processes", describe(script), describePIDs(result.OpenOutputPIDs)),
 				Log:        logOutput,
 			}
 		}
@@ -130,6 +223,116 @@ func (r *Runner) Run(ctx context.Context, args RunArgs) (HookResult, error) {
 	return HookResult{Log: logOutput}, nil
 }
 
+// runAttempts runs a script, retrying failed attempts as the script's
+// retry settings allow, and appends each attempt's output to log. It
+// returns the last attempt's result and number.
+func (r *Runner) runAttempts(ctx context.Context, archiveDir string, script appspec.Script, env map[string]string, log *string) (ScriptResult, int, error) {
+	name := script.Name()
+	base, maxDelay := script.RetryBackoff()
+	for attempt := 1; ; attempt++ {
+		result, err := r.runScript(ctx, archiveDir, script, env)
+		if err != nil {
+			return result, attempt, err
+		}
+
+		header := name
+		if script.Retries > 0 {
+			header += fmt.Sprintf(" (attempt %d of %d)", attempt, script.Attempts())
+		}
+		*log += formatScriptLog(header, result.Stdout, result.Stderr)
+		if result.Usage != "" {
+			*log += fmt.Sprintf("Resource usage: %s\n", result.Usage)
+		}
+		if result.OutputsLeftOpen {
+			*log += fmt.Sprintf("Output left open by %s\n", describePIDs(result.OpenOutputPIDs))
+		}
+		if result.TimedOut {
+			*log += fmt.Sprintf("Timed out after %d seconds: %s\n", script.Timeout, describeSignals(result.Signals, r.gracePeriod(script)))
+		}
+
+		failed := result.TimedOut || result.ExitCode != 0
+		if !failed || attempt == script.Attempts() || !script.Retryable(result.ExitCode, result.TimedOut) {
+			return result, attempt, nil
+		}
+		wait := backoff.Duration(attempt-1, base, maxDelay)
+		*log += fmt.Sprintf("Retrying in %s\n", wait.Round(time.Second))
+		r.logger.Warn("hook script failed, retrying", "script", name, "attempt", attempt, "exitCode", result.ExitCode, "timedOut", result.TimedOut, "wait", wait)
+		select {
+		case <-ctx.Done():
+			return result, attempt, ctx.Err()
+		case <-time.After(wait):
+		}
+	}
+}
+
+// runScript runs a script file from the archive, or an inline command
+// through its shell.
+func (r *Runner) runScript(ctx context.Context, archiveDir string, script appspec.Script, env map[string]string) (ScriptResult, error) {
+	if script.Command != "" {
+		shell := script.Shell
+		if shell == "" {
+			shell = r.shell
+		}
+		return r.scriptRunner.RunCommand(ctx, shell, script.Command, env, script.Timeout, r.gracePeriod(script), script.Limits.Or(r.limits))
+	}
+	return r.scriptRunner.Run(ctx, filepath.Join(archiveDir, script.Location), env, script.Timeout, r.gracePeriod(script), script.Limits.Or(r.limits))
+}
+
+// gracePeriod returns the script's grace period, or the runner's default.
+func (r *Runner) gracePeriod(script appspec.Script) int {
+	if script.GracePeriod != nil {
+		return *script.GracePeriod
+	}
+	return r.graceSeconds
+}
+
+// describeAttempt says which attempt of a retried script failed, for its
+// diagnostic message; empty for a script without retries.
+func describeAttempt(script appspec.Script, attempt int) string {
+	if script.Retries == 0 {
+		return ""
+	}
+	return fmt.Sprintf(" on attempt %d of %d", attempt, script.Attempts())
+}
+
+// describePIDs names the processes that held a script's output.
+func describePIDs(pids []int) string {
+	switch len(pids) {
+	case 0:
+		return "a background process"
+	case 1:
+		return fmt.Sprintf("process %d", pids[0])
+	}
+	s := make([]string, len(pids))
+	for i, pid := range pids {
+		s[i] = strconv.Itoa(pid)
+	}
+	return "processes " + strings.Join(s, ", ")
+}
+
+// describeSignals tells how a timed-out script was stopped, from the
+// signals it was sent.
+func describeSignals(signals []string, graceSeconds int) string {
+	switch len(signals) {
+	case 0:
+		return "stopped"
+	case 1:
+		if graceSeconds > 0 {
+			return fmt.Sprintf("sent %s, exited within the %d-second grace period", signals[0], graceSeconds)
+		}
+		return "sent " + signals[0]
+	}
+	return fmt.Sprintf("sent %s, then %s after the %d-second grace period", signals[0], signals[1], graceSeconds)
+}
+
+// describe names a script in diagnostic messages.
+func describe(script appspec.Script) string {
+	if script.Command != "" {
+		return script.Name()
+	}
+	return "script at " + script.Location
+}
+
 // IsNoop checks whether a lifecycle event has any scripts to run.
 func (r *Runner) IsNoop(args RunArgs) (bool, error) {
 	archiveDir := selectDeploymentRoot(args)
@@ -196,6 +399,56 @@ func buildEnv(args RunArgs) map[string]string {
 	return env
 }
 
+// scriptEnv layers the appspec's hook environment onto the deployment
+// variables in base: the hooks-wide env_file and environment, then the
+// script's own, each overriding the last. Values expand ${NAME} against
+// base. secret holds the names whose values came from an env file.
+func scriptEnv(archiveDir string, spec appspec.Spec, script appspec.Script, base map[string]string) (env map[string]string, secret map[string]bool, err error) {
+	env = maps.Clone(base)
+	secret = make(map[string]bool)
+	layers := []struct {
+		file string
+		vars map[string]string
+	}{
+		{spec.EnvFile, spec.Environment},
+		{script.EnvFile, script.Environment},
+	}
+	for _, layer := range layers {
+		if layer.file != "" {
+			data, err := os.ReadFile(filepath.Join(archiveDir, layer.file))
+			if err != nil {
+				return nil, nil, fmt.Errorf("env_file: %w", err)
+			}
+			vars, err := appspec.ParseEnvFile(data)
+			if err != nil {
+				return nil, nil, fmt.Errorf("env_file %s: %w", layer.file, err)
+			}
+			for k, v := range vars {
+				env[k] = appspec.ExpandEnv(v, base)
+				secret[k] = true
+			}
+		}
+		for k, v := range layer.vars {
+			env[k] = appspec.ExpandEnv(v, base)
+			delete(secret, k)
+		}
+	}
+	return env, secret, nil
+}
+
+// redactEnv returns env for logging, with the values of secret names, and of
+// names that look like they hold a secret, replaced.
+func redactEnv(env map[string]string, secret map[string]bool) map[string]string {
+	out := make(map[string]string, len(env))
+	for k, v := range env {
+		if secret[k] || appspec.IsSecretName(k) {
+			v = "[REDACTED]"
+		}
+		out[k] = v
+	}
+	return out
+}
+
 func formatScriptLog(scriptName, stdout, stderr string) string {
 	return fmt.Sprintf("Script - %s\n%s%s", scriptName, stdout, stderr)
 }
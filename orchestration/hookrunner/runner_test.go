@@ -8,9 +8,12 @@ import (
 	"os"
 	"path/filepath"
 	"runtime"
+	"slices"
 	"strings"
 	"testing"
+	"time"
 
+	"github.com/gurre/codedeploy-agent-go/logic/appspec"
 	"github.com/gurre/codedeploy-agent-go/logic/diagnostic"
 	"github.com/gurre/codedeploy-agent-go/logic/lifecycle"
 )
@@ -18,17 +21,52 @@ import (
 // fakeScriptRunner implements ScriptRunner for testing without executing real processes.
 type fakeScriptRunner struct {
 	calls    []string
+	commands []fakeCommand
+	graces   []int
+	limits   []appspec.Limits
 	exitCode int
 	timedOut bool
+	signals  []string // sent when timedOut
+	openPIDs []int    // holding the output, when non-nil
+	usage    string
 }
 
-func (f *fakeScriptRunner) Run(_ context.Context, scriptPath string, _ map[string]string, _ int) (ScriptResult, error) {
+// fakeCommand records one RunCommand call.
+type fakeCommand struct {
+	shell, command string
+	env            map[string]string
+	timeout        int
+}
+
+func (f *fakeScriptRunner) Run(_ context.Context, scriptPath string, _ map[string]string, _, graceSeconds int, limits appspec.Limits) (ScriptResult, error) {
 	f.calls = append(f.calls, scriptPath)
-	return ScriptResult{
+	f.graces = append(f.graces, graceSeconds)
+	f.limits = append(f.limits, limits)
+	return f.result(), nil
+}
+
+func (f *fakeScriptRunner) RunCommand(_ context.Context, shell, command string, env map[string]string, timeoutSeconds, graceSeconds int, limits appspec.Limits) (ScriptResult, error) {
+	f.commands = append(f.commands, fakeCommand{shell: shell, command: command, env: env, timeout: timeoutSeconds})
+	f.graces = append(f.graces, graceSeconds)
+	f.limits = append(f.limits, limits)
+	return f.result(), nil
+}
+
+func (f *fakeScriptRunner) result() ScriptResult {
+	r := ScriptResult{
 		ExitCode: f.exitCode,
 		Stdout:   "ok\n",
 		TimedOut: f.timedOut,
-	}, nil
+		Usage:    f.usage,
+	}
+	if f.timedOut {
+		r.Signals = f.signals
+	}
+	if f.openPIDs != nil {
+		r.OutputsLeftOpen = true
+		r.OpenOutputPIDs = f.openPIDs
+	}
+	return r
 }
 
 // testOS returns the appropriate OS value for test appspecs based on runtime.
@@ -217,6 +255,498 @@ hooks:
 	}
 }
 
+// TestRunGracePeriod verifies that a script's grace_period overrides the
+// runner's default, and that a timed-out script's diagnostic and log say
+// which signals stopped it, so operators can tell a clean shutdown from a
+// kill.
+func TestRunGracePeriod(t *testing.T) {
+	appspec := fmt.Sprintf(`
+version: 0.0
+os: %s
+hooks:
+  BeforeInstall:
+    - location: scripts/install.sh
+      timeout: 60
+    - location: scripts/install.sh
+      timeout: 60
+      grace_period: 0
+    - location: scripts/install.sh
+      timeout: 60
+      grace_period: 5
+`, testOS())
+	deployDir := setupDeployment(t, appspec)
+	args := RunArgs{
+		LifecycleEvent:    lifecycle.BeforeInstall,
+		DeploymentID:      "d-123",
+		AppSpecPath:       "appspec.yml",
+		DeploymentRootDir: deployDir,
+	}
+
+	sr := &fakeScriptRunner{}
+	runner := NewRunner(sr, slog.Default())
+	runner.SetGracePeriod(30)
+	if _, err := runner.Run(context.Background(), args); err != nil {
+		t.Fatalf("Run: %v", err)
+	}
+	if !slices.Equal(sr.graces, []int{30, 0, 5}) {
+		t.Errorf("grace periods = %v, want [30 0 5]", sr.graces)
+	}
+
+	sr = &fakeScriptRunner{timedOut: true, signals: []string{"SIGTERM", "SIGKILL"}}
+	runner = NewRunner(sr, slog.Default())
+	runner.SetGracePeriod(30)
+	_, err := runner.Run(context.Background(), args)
+	var se *diagnostic.ScriptError
+	if !errors.As(err, &se) {
+		t.Fatalf("expected *diagnostic.ScriptError, got %v", err)
+	}
+	want := "sent SIGTERM, then SIGKILL after the 30-second grace period"
+	if !strings.HasSuffix(se.Message, "timed out after 60 seconds: "+want) {
+		t.Errorf("Message = %q", se.Message)
+	}
+	if !strings.Contains(se.Log, want) {
+		t.Errorf("Log = %q, want it to mention %q", se.Log, want)
+	}
+}
+
+// TestRunGracePeriod_DefaultOverBudget verifies that scripts without a
+// grace_period are counted with the runner's default against the event's
+// budget, which the appspec cannot check, and that going over it is written
+// to the hook log while the scripts still run.
+func TestRunGracePeriod_DefaultOverBudget(t *testing.T) {
+	appspec := fmt.Sprintf(`
+version: 0.0
+os: %s
+hooks:
+  BeforeInstall:
+    - location: scripts/install.sh
+      timeout: 1800
+    - location: scripts/install.sh
+      timeout: 1780
+      grace_period: 0
+`, testOS())
+	deployDir := setupDeployment(t, appspec)
+	args := RunArgs{
+		LifecycleEvent:    lifecycle.BeforeInstall,
+		DeploymentID:      "d-123",
+		AppSpecPath:       "appspec.yml",
+		DeploymentRootDir: deployDir,
+	}
+
+	for _, tc := range []struct {
+		grace int
+		warn  bool
+	}{{0, false}, {20, false}, {21, true}} {
+		sr := &fakeScriptRunner{}
+		runner := NewRunner(sr, slog.Default())
+		runner.SetGracePeriod(tc.grace)
+		result, err := runner.Run(context.Background(), args)
+		if err != nil {
+			t.Fatalf("grace %d: Run: %v", tc.grace, err)
+		}
+		if len(sr.graces) != 2 {
+			t.Errorf("grace %d: ran %d scripts, want 2", tc.grace, len(sr.graces))
+		}
+		if got := strings.Contains(result.Log, "more than the 3600 allowed"); got != tc.warn {
+			t.Errorf("grace %d: warned = %v, want %v; log %q", tc.grace, got, tc.warn, result.Log)
+		}
+	}
+}
+
+// TestRunLimits verifies that a script's limits override the runner's one
+// by one, that scripts without limits get the runner's, and that the usage
+// the ScriptRunner reports is written to the hook log.
+func TestRunLimits(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("appspec limits are not supported on Windows")
+	}
+	spec := fmt.Sprintf(`
+version: 0.0
+os: %s
+hooks:
+  BeforeInstall:
+    - location: scripts/install.sh
+      timeout: 60
+    - location: scripts/install.sh
+      timeout: 60
+      limits:
+        memory: 1G
+        open_files: 256
+`, testOS())
+	args := RunArgs{
+		LifecycleEvent:    lifecycle.BeforeInstall,
+		DeploymentID:      "d-123",
+		AppSpecPath:       "appspec.yml",
+		DeploymentRootDir: setupDeployment(t, spec),
+	}
+
+	sr := &fakeScriptRunner{usage: "cpu 10ms"}
+	runner := NewRunner(sr, slog.Default())
+	runner.SetLimits(appspec.Limits{Memory: 256 << 20, CPUTime: 30})
+	result, err := runner.Run(context.Background(), args)
+	if err != nil {
+		t.Fatalf("Run: %v", err)
+	}
+	want := []appspec.Limits{
+		{Memory: 256 << 20, CPUTime: 30},
+		{Memory: 1 << 30, CPUTime: 30, OpenFiles: 256},
+	}
+	if !slices.Equal(sr.limits, want) {
+		t.Errorf("limits = %+v, want %+v", sr.limits, want)
+	}
+	if n := strings.Count(result.Log, "Resource usage: cpu 10ms\n"); n != 2 {
+		t.Errorf("Log = %q, want a usage line per script", result.Log)
+	}
+}
+
+// TestRunRetries verifies that failed attempts are retried as the script's
+// retry settings allow, that each attempt's output is logged under its own
+// header, and that the diagnostic names the attempt that finally failed.
+func TestRunRetries(t *testing.T) {
+	tests := []struct {
+		name      string
+		retry     string
+		results   []ScriptResult
+		wantCalls int
+		wantMsg   string // empty means the hook succeeds
+	}{
+		{
+			name:      "succeeds on retry",
+			retry:     "retries: 2",
+			results:   []ScriptResult{{ExitCode: 1}, {ExitCode: 1}, {ExitCode: 0}},
+			wantCalls: 3,
+		},
+		{
+			name:      "retries exhausted",
+			retry:     "retries: 1",
+			results:   []ScriptResult{{ExitCode: 1}, {ExitCode: 2}},
+			wantCalls: 2,
+			wantMsg:   "failed with exit code 2 on attempt 2 of 2",
+		},
+		{
+			name:      "exit code not listed",
+			retry:     "retries: 2\n      retry_on_exit_codes: [75]",
+			results:   []ScriptResult{{ExitCode: 75}, {ExitCode: 1}},
+			wantCalls: 2,
+			wantMsg:   "failed with exit code 1 on attempt 2 of 3",
+		},
+		{
+			name:      "timeout not listed",
+			retry:     "retries: 2\n      retry_on_exit_codes: [75]",
+			results:   []ScriptResult{{TimedOut: true, ExitCode: -1, Signals: []string{"SIGKILL"}}},
+			wantCalls: 1,
+			wantMsg:   "timed out after 60 seconds on attempt 1 of 3",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			spec := fmt.Sprintf(`
+version: 0.0
+os: %s
+hooks:
+  BeforeInstall:
+    - location: scripts/install.sh
+      timeout: 60
+      retry_delay: 0
+      %s
+`, testOS(), tt.retry)
+			args := RunArgs{
+				LifecycleEvent:    lifecycle.BeforeInstall,
+				DeploymentID:      "d-123",
+				AppSpecPath:       "appspec.yml",
+				DeploymentRootDir: setupDeployment(t, spec),
+			}
+			sr := &sequentialScriptRunner{results: tt.results}
+			result, err := NewRunner(sr, slog.Default()).Run(context.Background(), args)
+			if sr.calls != tt.wantCalls {
+				t.Errorf("calls = %d, want %d", sr.calls, tt.wantCalls)
+			}
+			log := result.Log
+			if tt.wantMsg == "" {
+				if err != nil {
+					t.Fatalf("Run: %v", err)
+				}
+			} else {
+				var se *diagnostic.ScriptError
+				if !errors.As(err, &se) || !strings.Contains(se.Message, tt.wantMsg) {
+					t.Fatalf("err = %v, want a ScriptError containing %q", err, tt.wantMsg)
+				}
+				log = se.Log
+			}
+			for i := 1; i <= tt.wantCalls; i++ {
+				if !strings.Contains(log, fmt.Sprintf("Script - scripts/install.sh (attempt %d of ", i)) {
+					t.Errorf("Log = %q, want a header for attempt %d", log, i)
+				}
+			}
+		})
+	}
+}
+
+// TestRunRetryCanceled verifies that cancelling the deployment during the
+// wait before a retry stops the hook at once rather than after the wait.
+func TestRunRetryCanceled(t *testing.T) {
+	spec := fmt.Sprintf(`
+version: 0.0
+os: %s
+hooks:
+  BeforeInstall:
+    - location: scripts/install.sh
+      timeout: 60
+      retries: 1
+      retry_delay: 600
+`, testOS())
+	args := RunArgs{
+		LifecycleEvent:    lifecycle.BeforeInstall,
+		DeploymentID:      "d-123",
+		AppSpecPath:       "appspec.yml",
+		DeploymentRootDir: setupDeployment(t, spec),
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(50*time.Millisecond, cancel)
+	sr := &sequentialScriptRunner{results: []ScriptResult{{ExitCode: 1}}}
+	_, err := NewRunner(sr, slog.Default()).Run(ctx, args)
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("err = %v, want context.Canceled", err)
+	}
+	if sr.calls != 1 {
+		t.Errorf("calls = %d, want 1", sr.calls)
+	}
+}
+
+// TestRunOutputsLeftOpen verifies that a script which exits cleanly but
+// leaves its output open fails the hook with OutputsLeftOpen, naming the
+// processes that held it, so the console points at the backgrounded
+// process rather than reporting a timeout.
+func TestRunOutputsLeftOpen(t *testing.T) {
+	appspec := fmt.Sprintf(`
+version: 0.0
+os: %s
+hooks:
+  ApplicationStart:
+    - location: scripts/install.sh
+`, testOS())
+	deployDir := setupDeployment(t, appspec)
+	sr := &fakeScriptRunner{openPIDs: []int{4242, 4243}}
+	runner := NewRunner(sr, slog.Default())
+
+	_, err := runner.Run(context.Background(), RunArgs{
+		LifecycleEvent:    lifecycle.ApplicationStart,
+		DeploymentID:      "d-123",
+		AppSpecPath:       "appspec.yml",
+		DeploymentRootDir: deployDir,
+	})
+	var se *diagnostic.ScriptError
+	if !errors.As(err, &se) {
+		t.Fatalf("expected *diagnostic.ScriptError, got %v", err)
+	}
+	if se.Code != diagnostic.OutputsLeftOpen {
+		t.Errorf("Code = %d, want %d", se.Code, diagnostic.OutputsLeftOpen)
+	}
+	if !strings.Contains(se.Message, "processes 4242, 4243") {
+		t.Errorf("Message = %q", se.Message)
+	}
+	if !strings.Contains(se.Log, "Output left open by processes 4242, 4243") {
+		t.Errorf("Log = %q", se.Log)
+	}
+}
+
+// TestDescribeSignals verifies the wording for each way a timed-out
+// script can end: killed outright, exited after SIGTERM, or killed once
+// the grace period ran out.
+func TestDescribeSignals(t *testing.T) {
+	tests := []struct {
+		signals []string
+		grace   int
+		want    string
+	}{
+		{[]string{"SIGKILL"}, 0, "sent SIGKILL"},
+		{[]string{"SIGTERM"}, 10, "sent SIGTERM, exited within the 10-second grace period"},
+		{[]string{"SIGTERM", "SIGKILL"}, 10, "sent SIGTERM, then SIGKILL after the 10-second grace period"},
+		{nil, 0, "stopped"},
+	}
+	for _, tt := range tests {
+		if got := describeSignals(tt.signals, tt.grace); got != tt.want {
+			t.Errorf("describeSignals(%v, %d) = %q, want %q", tt.signals, tt.grace, got, tt.want)
+		}
+	}
+}
+
+// TestRunCommandHooks verifies that inline command hooks go to RunCommand
+// with the configured shell unless the script names its own, the same
+// lifecycle environment and timeout as script hooks, and that a failure
+// carries a ScriptError naming the command.
+func TestRunCommandHooks(t *testing.T) {
+	appspec := fmt.Sprintf(`
+version: 0.0
+os: %s
+hooks:
+  ApplicationStart:
+    - command: systemctl restart nginx
+      timeout: 30
+    - command: |
+        echo one
+        echo two
+      shell: /bin/bash
+      timeout: 60
+`, testOS())
+	deployDir := setupDeployment(t, appspec)
+	sr := &fakeScriptRunner{}
+	runner := NewRunner(sr, slog.Default())
+	runner.SetShell("/bin/dash")
+
+	args := RunArgs{
+		LifecycleEvent:      lifecycle.ApplicationStart,
+		DeploymentID:        "d-123",
+		ApplicationName:     "app",
+		DeploymentGroupName: "grp",
+		DeploymentCreator:   "user",
+		DeploymentType:      "IN_PLACE",
+		AppSpecPath:         "appspec.yml",
+		DeploymentRootDir:   deployDir,
+	}
+	result, err := runner.Run(context.Background(), args)
+	if err != nil {
+		t.Fatalf("Run: %v", err)
+	}
+	if len(sr.calls) != 0 || len(sr.commands) != 2 {
+		t.Fatalf("calls = %v, commands = %+v", sr.calls, sr.commands)
+	}
+	first, second := sr.commands[0], sr.commands[1]
+	if first.shell != "/bin/dash" || first.command != "systemctl restart nginx" || first.timeout != 30 {
+		t.Errorf("first command = %+v", first)
+	}
+	if first.env["LIFECYCLE_EVENT"] != "ApplicationStart" || first.env["DEPLOYMENT_ID"] != "d-123" {
+		t.Errorf("env = %v", first.env)
+	}
+	if second.shell != "/bin/bash" || second.command != "echo one\necho two" || second.timeout != 60 {
+		t.Errorf("second command = %+v", second)
+	}
+	if !strings.Contains(result.Log, `command "systemctl restart nginx"`) {
+		t.Errorf("log should name the command:\n%s", result.Log)
+	}
+
+	sr.exitCode = 3
+	_, err = runner.Run(context.Background(), args)
+	var se *diagnostic.ScriptError
+	if !errors.As(err, &se) {
+		t.Fatalf("expected *diagnostic.ScriptError, got %v", err)
+	}
+	if se.ScriptName != `command "systemctl restart nginx"` {
+		t.Errorf("ScriptName = %q", se.ScriptName)
+	}
+	if se.Message != `command "systemctl restart nginx" failed with exit code 3` {
+		t.Errorf("Message = %q", se.Message)
+	}
+}
+
+// TestRunHookEnvironment verifies how the hook environment is layered:
+// hooks-wide env_file, hooks-wide environment, then the script's own, with
+// ${NAME} expanded from the deployment variables, which cannot be replaced.
+func TestRunHookEnvironment(t *testing.T) {
+	appspec := fmt.Sprintf(`
+version: 0.0
+os: %s
+hooks:
+  env_file: config/hooks.env
+  environment:
+    PORT: 8080
+    LEVEL: info
+  ApplicationStart:
+    - command: ./start
+      timeout: 60
+      environment:
+        LEVEL: debug
+        SITE: /srv/${DEPLOYMENT_GROUP_NAME}
+`, testOS())
+	deployDir := setupDeployment(t, appspec)
+	configDir := filepath.Join(deployDir, "deployment-archive", "config")
+	if err := os.MkdirAll(configDir, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(configDir, "hooks.env"), []byte("PORT=80\nDB_PASSWORD='p$ss'\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	sr := &fakeScriptRunner{}
+	runner := NewRunner(sr, slog.Default())
+
+	_, err := runner.Run(context.Background(), RunArgs{
+		LifecycleEvent:      lifecycle.ApplicationStart,
+		DeploymentID:        "d-123",
+		DeploymentGroupName: "staging",
+		DeploymentCreator:   "user",
+		DeploymentType:      "IN_PLACE",
+		AppSpecPath:         "appspec.yml",
+		DeploymentRootDir:   deployDir,
+	})
+	if err != nil {
+		t.Fatalf("Run: %v", err)
+	}
+	env := sr.commands[0].env
+	for k, want := range map[string]string{
+		"PORT":                  "8080",
+		"LEVEL":                 "debug",
+		"SITE":                  "/srv/staging",
+		"DB_PASSWORD":           "p$ss",
+		"DEPLOYMENT_GROUP_NAME": "staging",
+		"LIFECYCLE_EVENT":       "ApplicationStart",
+	} {
+		if env[k] != want {
+			t.Errorf("env[%s] = %q, want %q", k, env[k], want)
+		}
+	}
+}
+
+// TestRunHookEnvironment_MissingEnvFile verifies that a missing env_file
+// fails the hook before the script runs.
+func TestRunHookEnvironment_MissingEnvFile(t *testing.T) {
+	appspec := fmt.Sprintf(`
+version: 0.0
+os: %s
+hooks:
+  BeforeInstall:
+    - location: scripts/install.sh
+      env_file: config/missing.env
+`, testOS())
+	deployDir := setupDeployment(t, appspec)
+	sr := &fakeScriptRunner{}
+	runner := NewRunner(sr, slog.Default())
+
+	_, err := runner.Run(context.Background(), RunArgs{
+		LifecycleEvent:    lifecycle.BeforeInstall,
+		DeploymentID:      "d-123",
+		AppSpecPath:       "appspec.yml",
+		DeploymentRootDir: deployDir,
+	})
+	if err == nil || !strings.Contains(err.Error(), "env_file") {
+		t.Fatalf("err = %v, want env_file error", err)
+	}
+	if len(sr.calls) != 0 {
+		t.Errorf("script ran despite the missing env_file")
+	}
+}
+
+// TestRedactEnv verifies that values from env files and values of names
+// that look like secrets are kept out of the logged environment.
+func TestRedactEnv(t *testing.T) {
+	env := map[string]string{
+		"FROM_FILE":      "a",
+		"DB_PASSWORD":    "b",
+		"GITHUB_TOKEN":   "c",
+		"AWS_ACCESS_KEY": "d",
+		"PORT":           "8080",
+		"BUNDLE_KEY":     "app.zip",
+	}
+	got := redactEnv(env, map[string]bool{"FROM_FILE": true})
+	for k, redacted := range map[string]bool{"FROM_FILE": true, "DB_PASSWORD": true, "GITHUB_TOKEN": true, "AWS_ACCESS_KEY": true, "PORT": false, "BUNDLE_KEY": false} {
+		if (got[k] == "[REDACTED]") != redacted {
+			t.Errorf("%s = %q, redacted want %v", k, got[k], redacted)
+		}
+	}
+	if env["DB_PASSWORD"] != "b" {
+		t.Error("redactEnv modified its input")
+	}
+}
+
 // TestIsNoop verifies the noop check without running scripts.
 func TestIsNoop(t *testing.T) {
 	appspec := fmt.Sprintf(`
@@ -445,7 +975,7 @@ type sequentialScriptRunner struct {
 	calls   int
 }
 
-func (s *sequentialScriptRunner) Run(_ context.Context, _ string, _ map[string]string, _ int) (ScriptResult, error) {
+func (s *sequentialScriptRunner) Run(_ context.Context, _ string, _ map[string]string, _, _ int, _ appspec.Limits) (ScriptResult, error) {
 	i := s.calls
 	s.calls++
 	if i < len(s.results) {
@@ -454,13 +984,21 @@ func (s *sequentialScriptRunner) Run(_ context.Context, _ string, _ map[string]s
 	return ScriptResult{}, nil
 }
 
+func (s *sequentialScriptRunner) RunCommand(ctx context.Context, _, _ string, env map[string]string, timeoutSeconds, graceSeconds int, limits appspec.Limits) (ScriptResult, error) {
+	return s.Run(ctx, "", env, timeoutSeconds, graceSeconds, limits)
+}
+
 // errScriptRunner is a ScriptRunner that returns a configured error.
 // Used to test that Run propagates ScriptRunner errors correctly.
 type errScriptRunner struct {
 	err error
 }
 
-func (e *errScriptRunner) Run(_ context.Context, _ string, _ map[string]string, _ int) (ScriptResult, error) {
+func (e *errScriptRunner) Run(_ context.Context, _ string, _ map[string]string, _, _ int, _ appspec.Limits) (ScriptResult, error) {
+	return ScriptResult{}, e.err
+}
+
+func (e *errScriptRunner) RunCommand(_ context.Context, _, _ string, _ map[string]string, _, _ int, _ appspec.Limits) (ScriptResult, error) {
 	return ScriptResult{}, e.err
 }
 
@@ -553,6 +1091,46 @@ hooks:
 	}
 }
 
+// TestRunPreflightError verifies that a PreflightError from the
+// ScriptRunner becomes a ScriptError with ScriptMissing or
+// ScriptNotExecutable, so the console says why the script never started
+// instead of reporting an unknown error.
+func TestRunPreflightError(t *testing.T) {
+	appspec := fmt.Sprintf(`
+version: 0.0
+os: %s
+hooks:
+  BeforeInstall:
+    - location: scripts/install.sh
+      timeout: 60
+`, testOS())
+	deployDir := setupDeployment(t, appspec)
+	tests := []struct {
+		err  *PreflightError
+		code diagnostic.ErrorCode
+	}{
+		{&PreflightError{Missing: true, Reason: "does not exist"}, diagnostic.ScriptMissing},
+		{&PreflightError{Reason: "has Windows (CRLF) line endings"}, diagnostic.ScriptNotExecutable},
+	}
+	for _, tt := range tests {
+		runner := NewRunner(&errScriptRunner{err: tt.err}, slog.Default())
+		_, err := runner.Run(context.Background(), RunArgs{
+			LifecycleEvent:    lifecycle.BeforeInstall,
+			DeploymentID:      "d-preflight",
+			AppSpecPath:       "appspec.yml",
+			DeploymentRootDir: deployDir,
+		})
+		var se *diagnostic.ScriptError
+		if !errors.As(err, &se) {
+			t.Fatalf("expected *diagnostic.ScriptError, got %v", err)
+		}
+		want := "script at scripts/install.sh " + tt.err.Reason
+		if se.Code != tt.code || se.Message != want || !strings.Contains(se.Log, want) {
+			t.Errorf("got %d %q, log %q; want %d %q", se.Code, se.Message, se.Log, tt.code, want)
+		}
+	}
+}
+
 // TestRunScriptRunnerError_IsNotScriptError verifies that when the ScriptRunner
 // itself returns an error (e.g. binary not found), the error is NOT a
 // *diagnostic.ScriptError. This ensures the poller's reportError fallback path
@@ -0,0 +1,345 @@
+// Package housekeeping garbage-collects the deployment root: revisions beyond
+// max_revisions, orphaned archive scratch directories, dangling deployment
+// pointers, and expired deployment-logs files. It applies the same retention
+// policy the executor uses on DownloadBundle, but across all deployment groups
+// and independent of the next deployment.
+package housekeeping
+
+import (
+	"context"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/gurre/codedeploy-agent-go/logic/retention"
+	"github.com/gurre/codedeploy-agent-go/state/deployment"
+)
+
+// Kind classifies a removed deployment-root artifact.
+type Kind string
+
+const (
+	KindRevision      Kind = "revision"
+	KindTempDir       Kind = "temp-dir"
+	KindPointer       Kind = "pointer"
+	KindDeploymentLog Kind = "deployment-log"
+)
+
+// FileOperator removes paths from the deployment root.
+type FileOperator interface {
+	RemoveAll(path string) error
+}
+
+// Policy holds the retention settings applied by a collection run.
+type Policy struct {
+	// DeploymentLogsMaxAge is the age after which non-active files in
+	// deployment-logs are removed. Zero keeps them forever.
+	DeploymentLogsMaxAge time.Duration
+	// MaxRevisions is the number of deployment directories kept per group.
+	MaxRevisions int
+}
+
+// Removal describes one artifact that was (or, in a dry run, would be) removed.
+type Removal struct {
+	Kind              Kind   `json:"kind"`
+	Path              string `json:"path"`
+	DeploymentGroupID string `json:"deployment_group_id,omitempty"`
+	Reason            string `json:"reason"`
+	Error             string `json:"error,omitempty"`
+	Bytes             int64  `json:"bytes"`
+}
+
+// Report summarises a collection run.
+type Report struct {
+	Removed    []Removal `json:"removed"`
+	BytesFreed int64     `json:"bytes_freed"`
+	DryRun     bool      `json:"dry_run"`
+}
+
+// Collector scans a deployment root and removes artifacts that fall outside
+// the retention policy.
+type Collector struct {
+	fileOp         FileOperator
+	logger         *slog.Logger
+	now            func() time.Time
+	rootDir        string
+	trackingSubdir string
+	policy         Policy
+}
+
+// NewCollector creates a collector for the given deployment root.
+//
+//	c := housekeeping.NewCollector(fileOp, cfg.RootDir, cfg.OngoingDeploymentTracking, policy, logger)
+//	report, err := c.Collect(ctx, false)
+func NewCollector(fileOp FileOperator, rootDir, trackingSubdir string, policy Policy, logger *slog.Logger) *Collector {
+	if policy.MaxRevisions < 1 {
+		logger.Error("invalid max_revisions, using default", "value", policy.MaxRevisions)
+		policy.MaxRevisions = 5
+	}
+	return &Collector{
+		fileOp:         fileOp,
+		logger:         logger,
+		now:            time.Now,
+		rootDir:        rootDir,
+		trackingSubdir: trackingSubdir,
+		policy:         policy,
+	}
+}
+
+// Collect applies the retention policy across every deployment group under
+// the root directory. Deployments with an ongoing tracking file, and the
+// last-successful and most-recent revisions of each group, are never removed.
+// With dryRun set, nothing is removed and the report lists what would be.
+// Individual removal failures are recorded in the report rather than
+// aborting the run.
+func (c *Collector) Collect(ctx context.Context, dryRun bool) (Report, error) {
+	report := Report{DryRun: dryRun, Removed: make([]Removal, 0, 8)}
+
+	entries, err := os.ReadDir(c.rootDir)
+	if os.IsNotExist(err) {
+		return report, nil
+	}
+	if err != nil {
+		return report, fmt.Errorf("housekeeping: read root: %w", err)
+	}
+
+	ongoing := c.ongoingDeployments()
+	reserved := map[string]bool{
+		filepath.Base(deployment.InstructionsDir(c.rootDir)):   true,
+		filepath.Base(deployment.DeploymentLogsDir(c.rootDir)): true,
+		c.trackingSubdir: true,
+	}
+
+	var candidates []Removal
+	for _, entry := range entries {
+		if !entry.IsDir() || reserved[entry.Name()] {
+			continue
+		}
+		if err := ctx.Err(); err != nil {
+			return report, fmt.Errorf("housekeeping: cancelled: %w", err)
+		}
+		candidates = append(candidates, c.groupCandidates(entry.Name(), ongoing)...)
+	}
+	candidates = append(candidates, c.pointerCandidates()...)
+	candidates = append(candidates, c.deploymentLogCandidates()...)
+
+	for _, r := range candidates {
+		r.Bytes = diskUsage(r.Path)
+		if !dryRun {
+			if err := c.fileOp.RemoveAll(r.Path); err != nil {
+				r.Error = err.Error()
+				c.logger.Warn("housekeeping: remove failed", "path", r.Path, "error", err)
+				report.Removed = append(report.Removed, r)
+				continue
+			}
+			c.logger.Info("housekeeping: removed", "kind", r.Kind, "path", r.Path, "reason", r.Reason)
+		}
+		report.BytesFreed += r.Bytes
+		report.Removed = append(report.Removed, r)
+	}
+	return report, nil
+}
+
+// RunPeriodic runs Collect every interval until ctx is cancelled. Each run's
+// outcome is logged; errors never stop the loop.
+//
+//	go collector.RunPeriodic(ctx, cfg.GCInterval)
+func (c *Collector) RunPeriodic(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			report, err := c.Collect(ctx, false)
+			if err != nil {
+				c.logger.Warn("housekeeping: collection failed", "error", err)
+				continue
+			}
+			c.logger.Info("housekeeping: collection finished",
+				"removed", len(report.Removed), "bytesFreed", report.BytesFreed)
+		}
+	}
+}
+
+// groupCandidates returns expired revisions and orphaned archive scratch
+// directories for one deployment group.
+func (c *Collector) groupCandidates(groupID string, ongoing map[string]bool) []Removal {
+	groupDir := filepath.Join(c.rootDir, groupID)
+	entries, err := os.ReadDir(groupDir)
+	if err != nil {
+		return nil
+	}
+
+	protected := map[string]bool{
+		readPointer(deployment.LastSuccessfulFile(c.rootDir, groupID)): true,
+		readPointer(deployment.MostRecentFile(c.rootDir, groupID)):     true,
+	}
+
+	revisions := make([]retention.Revision, 0, len(entries))
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			continue
+		}
+		full := filepath.Join(groupDir, entry.Name())
+		if ongoing[entry.Name()] {
+			protected[full] = true
+		}
+		rev := retention.Revision{Path: full}
+		if info, err := entry.Info(); err == nil {
+			rev.ModTime = info.ModTime()
+		}
+		revisions = append(revisions, rev)
+	}
+
+	expired := retention.Expired(revisions, c.policy.MaxRevisions, protected)
+	removals := make([]Removal, 0, len(expired))
+	expiredSet := make(map[string]bool, len(expired))
+	for _, path := range expired {
+		expiredSet[path] = true
+		removals = append(removals, Removal{
+			Kind:              KindRevision,
+			Path:              path,
+			DeploymentGroupID: groupID,
+			Reason:            fmt.Sprintf("exceeds max_revisions (%d)", c.policy.MaxRevisions),
+		})
+	}
+
+	// A scratch directory only survives an interrupted unpack. Skip revisions
+	// that are being removed anyway and deployments that are still running.
+	for _, rev := range revisions {
+		deploymentID := filepath.Base(rev.Path)
+		if expiredSet[rev.Path] || ongoing[deploymentID] {
+			continue
+		}
+		tmp := deployment.NewLayout(c.rootDir, groupID, deploymentID).ArchiveTempDir()
+		if _, err := os.Lstat(tmp); err == nil {
+			removals = append(removals, Removal{
+				Kind:              KindTempDir,
+				Path:              tmp,
+				DeploymentGroupID: groupID,
+				Reason:            "orphaned archive scratch directory",
+			})
+		}
+	}
+	return removals
+}
+
+// pointerCandidates returns last-successful and most-recent pointer files
+// whose target deployment directory no longer exists.
+func (c *Collector) pointerCandidates() []Removal {
+	instructionsDir := deployment.InstructionsDir(c.rootDir)
+	entries, err := os.ReadDir(instructionsDir)
+	if err != nil {
+		return nil
+	}
+
+	var removals []Removal
+	for _, entry := range entries {
+		if entry.IsDir() {
+			continue
+		}
+		name := entry.Name()
+		groupID, ok := strings.CutSuffix(name, "_last_successful_install")
+		if !ok {
+			groupID, ok = strings.CutSuffix(name, "_most_recent_install")
+		}
+		if !ok {
+			continue
+		}
+		path := filepath.Join(instructionsDir, name)
+		target := readPointer(path)
+		if target != "" {
+			if _, err := os.Stat(target); err == nil {
+				continue
+			}
+		}
+		removals = append(removals, Removal{
+			Kind:              KindPointer,
+			Path:              path,
+			DeploymentGroupID: groupID,
+			Reason:            "points to a missing deployment directory",
+		})
+	}
+	return removals
+}
+
+// deploymentLogCandidates returns files in deployment-logs, other than the
+// active deployments log, that are older than the configured maximum age.
+func (c *Collector) deploymentLogCandidates() []Removal {
+	if c.policy.DeploymentLogsMaxAge <= 0 {
+		return nil
+	}
+	logsDir := deployment.DeploymentLogsDir(c.rootDir)
+	entries, err := os.ReadDir(logsDir)
+	if err != nil {
+		return nil
+	}
+
+	active := filepath.Base(deployment.DeploymentLogFile(c.rootDir))
+	cutoff := c.now().Add(-c.policy.DeploymentLogsMaxAge)
+
+	var removals []Removal
+	for _, entry := range entries {
+		if entry.IsDir() || entry.Name() == active {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil || !info.ModTime().Before(cutoff) {
+			continue
+		}
+		removals = append(removals, Removal{
+			Kind:   KindDeploymentLog,
+			Path:   filepath.Join(logsDir, entry.Name()),
+			Reason: fmt.Sprintf("older than %s", c.policy.DeploymentLogsMaxAge),
+		})
+	}
+	return removals
+}
+
+// ongoingDeployments returns the deployment IDs that have a tracking file.
+func (c *Collector) ongoingDeployments() map[string]bool {
+	entries, err := os.ReadDir(deployment.OngoingDeploymentDir(c.rootDir, c.trackingSubdir))
+	if err != nil {
+		return nil
+	}
+	ids := make(map[string]bool, len(entries))
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			ids[entry.Name()] = true
+		}
+	}
+	return ids
+}
+
+func readPointer(path string) string {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(data))
+}
+
+// diskUsage returns the apparent size of all regular files under path.
+// Symlinks are not followed.
+func diskUsage(path string) int64 {
+	var total int64
+	_ = filepath.WalkDir(path, func(_ string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return nil
+		}
+		if d.Type().IsRegular() {
+			if info, err := d.Info(); err == nil {
+				total += info.Size()
+			}
+		}
+		return nil
+	})
+	return total
+}
@@ -0,0 +1,288 @@
+package housekeeping
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/gurre/codedeploy-agent-go/state/deployment"
+)
+
+var baseTime = time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+
+// TestCollect_RetainsMaxRevisions verifies that each group is trimmed to
+// max_revisions, oldest first, while last-successful and most-recent
+// revisions survive regardless of age.
+func TestCollect_RetainsMaxRevisions(t *testing.T) {
+	rootDir := t.TempDir()
+	groupDir := filepath.Join(rootDir, "dg-1")
+	names := []string{"d-1", "d-2", "d-3", "d-4", "d-5"}
+	for i, name := range names {
+		mkdirAt(t, filepath.Join(groupDir, name), baseTime.Add(time.Duration(i)*time.Hour))
+	}
+	writePointer(t, deployment.LastSuccessfulFile(rootDir, "dg-1"), filepath.Join(groupDir, "d-1"))
+	writePointer(t, deployment.MostRecentFile(rootDir, "dg-1"), filepath.Join(groupDir, "d-5"))
+
+	c := newTestCollector(rootDir, 2)
+	report, err := c.Collect(context.Background(), false)
+	if err != nil {
+		t.Fatalf("Collect: %v", err)
+	}
+
+	for _, name := range []string{"d-1", "d-5"} {
+		if !exists(filepath.Join(groupDir, name)) {
+			t.Errorf("%s should be preserved", name)
+		}
+	}
+	for _, name := range []string{"d-2", "d-3", "d-4"} {
+		if exists(filepath.Join(groupDir, name)) {
+			t.Errorf("%s should have been removed", name)
+		}
+	}
+	if got := countKind(report, KindRevision); got != 3 {
+		t.Errorf("revision removals = %d, want 3: %+v", got, report.Removed)
+	}
+}
+
+// TestCollect_DryRunRemovesNothing verifies that a dry run reports the same
+// removals as a real run without touching the filesystem.
+func TestCollect_DryRunRemovesNothing(t *testing.T) {
+	rootDir := t.TempDir()
+	groupDir := filepath.Join(rootDir, "dg-1")
+	for i, name := range []string{"d-1", "d-2", "d-3"} {
+		dir := filepath.Join(groupDir, name)
+		mkdirAt(t, dir, baseTime.Add(time.Duration(i)*time.Hour))
+		if err := os.WriteFile(filepath.Join(dir, "bundle.tar"), make([]byte, 100), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		// Writing the bundle bumps the mtime; restore the staggered order.
+		setMtime(t, dir, baseTime.Add(time.Duration(i)*time.Hour))
+	}
+
+	c := newTestCollector(rootDir, 1)
+	report, err := c.Collect(context.Background(), true)
+	if err != nil {
+		t.Fatalf("Collect: %v", err)
+	}
+	if !report.DryRun {
+		t.Error("report should be marked as dry run")
+	}
+	if len(report.Removed) != 2 {
+		t.Fatalf("removals = %d, want 2: %+v", len(report.Removed), report.Removed)
+	}
+	if report.BytesFreed != 200 {
+		t.Errorf("BytesFreed = %d, want 200", report.BytesFreed)
+	}
+	for _, name := range []string{"d-1", "d-2", "d-3"} {
+		if !exists(filepath.Join(groupDir, name)) {
+			t.Errorf("%s should not be removed in a dry run", name)
+		}
+	}
+}
+
+// TestCollect_SkipsOngoingDeployments verifies that a deployment with a
+// tracking file is neither expired nor stripped of its scratch directory,
+// because the background collector can run concurrently with a deployment.
+func TestCollect_SkipsOngoingDeployments(t *testing.T) {
+	rootDir := t.TempDir()
+	groupDir := filepath.Join(rootDir, "dg-1")
+	mkdirAt(t, filepath.Join(groupDir, "d-old"), baseTime)
+	mkdirAt(t, filepath.Join(groupDir, "d-new"), baseTime.Add(time.Hour))
+	tmp := deployment.NewLayout(rootDir, "dg-1", "d-old").ArchiveTempDir()
+	mkdirAt(t, tmp, baseTime)
+
+	trackingFile := deployment.OngoingDeploymentFile(rootDir, "ongoing-deployment", "d-old")
+	if err := os.MkdirAll(filepath.Dir(trackingFile), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(trackingFile, []byte("hci"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	c := newTestCollector(rootDir, 1)
+	if _, err := c.Collect(context.Background(), false); err != nil {
+		t.Fatalf("Collect: %v", err)
+	}
+	if !exists(tmp) {
+		t.Error("scratch directory of an ongoing deployment must survive")
+	}
+	if exists(filepath.Join(groupDir, "d-new")) {
+		t.Error("d-new should be expired in place of the ongoing d-old")
+	}
+}
+
+// TestCollect_OrphanedTempDir verifies that a deployment-archive-temp left
+// behind by an interrupted unpack is removed from a retained revision.
+func TestCollect_OrphanedTempDir(t *testing.T) {
+	rootDir := t.TempDir()
+	layout := deployment.NewLayout(rootDir, "dg-1", "d-1")
+	mkdirAt(t, layout.ArchiveDir(), baseTime)
+	mkdirAt(t, layout.ArchiveTempDir(), baseTime)
+
+	c := newTestCollector(rootDir, 5)
+	report, err := c.Collect(context.Background(), false)
+	if err != nil {
+		t.Fatalf("Collect: %v", err)
+	}
+	if exists(layout.ArchiveTempDir()) {
+		t.Error("orphaned scratch directory should be removed")
+	}
+	if !exists(layout.ArchiveDir()) {
+		t.Error("archive directory must be preserved")
+	}
+	if got := countKind(report, KindTempDir); got != 1 {
+		t.Errorf("temp-dir removals = %d, want 1", got)
+	}
+}
+
+// TestCollect_DanglingPointers verifies that pointer files referencing a
+// deployment directory that no longer exists are removed, while valid
+// pointers and cleanup files are left alone.
+func TestCollect_DanglingPointers(t *testing.T) {
+	rootDir := t.TempDir()
+	live := deployment.NewLayout(rootDir, "dg-live", "d-1").DeploymentRootDir()
+	mkdirAt(t, live, baseTime)
+
+	writePointer(t, deployment.LastSuccessfulFile(rootDir, "dg-live"), live)
+	writePointer(t, deployment.MostRecentFile(rootDir, "dg-gone"), filepath.Join(rootDir, "dg-gone", "d-9"))
+	writePointer(t, deployment.CleanupFile(rootDir, "dg-gone"), "/opt/app/file\n")
+
+	c := newTestCollector(rootDir, 5)
+	report, err := c.Collect(context.Background(), false)
+	if err != nil {
+		t.Fatalf("Collect: %v", err)
+	}
+	if exists(deployment.MostRecentFile(rootDir, "dg-gone")) {
+		t.Error("dangling pointer should be removed")
+	}
+	if !exists(deployment.LastSuccessfulFile(rootDir, "dg-live")) {
+		t.Error("valid pointer must be preserved")
+	}
+	if !exists(deployment.CleanupFile(rootDir, "dg-gone")) {
+		t.Error("cleanup file must be preserved: it tracks files still on disk")
+	}
+	if got := countKind(report, KindPointer); got != 1 {
+		t.Errorf("pointer removals = %d, want 1", got)
+	}
+}
+
+// TestCollect_ExpiredDeploymentLogs verifies that old rotated files in
+// deployment-logs are removed but the active deployments log never is.
+func TestCollect_ExpiredDeploymentLogs(t *testing.T) {
+	rootDir := t.TempDir()
+	active := deployment.DeploymentLogFile(rootDir)
+	rotated := filepath.Join(deployment.DeploymentLogsDir(rootDir), "codedeploy-agent-deployments.log.1")
+	fresh := filepath.Join(deployment.DeploymentLogsDir(rootDir), "codedeploy-agent-deployments.log.2")
+	for _, p := range []string{active, rotated, fresh} {
+		writePointer(t, p, "entry\n")
+	}
+	old := time.Now().Add(-30 * 24 * time.Hour)
+	setMtime(t, active, old)
+	setMtime(t, rotated, old)
+
+	c := newTestCollector(rootDir, 5)
+	if _, err := c.Collect(context.Background(), false); err != nil {
+		t.Fatalf("Collect: %v", err)
+	}
+	if !exists(active) {
+		t.Error("active deployments log must never be removed")
+	}
+	if exists(rotated) {
+		t.Error("expired rotated log should be removed")
+	}
+	if !exists(fresh) {
+		t.Error("recent rotated log should be kept")
+	}
+}
+
+// TestCollect_RemoveErrorRecorded verifies that a failed removal is reported
+// per entry and does not abort the run or count towards bytes freed.
+func TestCollect_RemoveErrorRecorded(t *testing.T) {
+	rootDir := t.TempDir()
+	layout := deployment.NewLayout(rootDir, "dg-1", "d-1")
+	mkdirAt(t, layout.ArchiveTempDir(), baseTime)
+
+	c := NewCollector(&failingFileOperator{}, rootDir, "ongoing-deployment", Policy{MaxRevisions: 5}, slog.Default())
+	report, err := c.Collect(context.Background(), false)
+	if err != nil {
+		t.Fatalf("Collect: %v", err)
+	}
+	if len(report.Removed) != 1 || report.Removed[0].Error == "" {
+		t.Fatalf("expected one removal with an error, got %+v", report.Removed)
+	}
+	if report.BytesFreed != 0 {
+		t.Errorf("BytesFreed = %d, want 0", report.BytesFreed)
+	}
+}
+
+// TestCollect_MissingRoot verifies that a host which never deployed (no
+// deployment root) yields an empty report rather than an error.
+func TestCollect_MissingRoot(t *testing.T) {
+	c := newTestCollector(filepath.Join(t.TempDir(), "absent"), 5)
+	report, err := c.Collect(context.Background(), false)
+	if err != nil {
+		t.Fatalf("Collect: %v", err)
+	}
+	if len(report.Removed) != 0 {
+		t.Errorf("expected no removals, got %+v", report.Removed)
+	}
+}
+
+// --- helpers ---
+
+func newTestCollector(rootDir string, maxRevisions int) *Collector {
+	policy := Policy{MaxRevisions: maxRevisions, DeploymentLogsMaxAge: 7 * 24 * time.Hour}
+	return NewCollector(&realFileOperator{}, rootDir, "ongoing-deployment", policy, slog.Default())
+}
+
+func mkdirAt(t *testing.T, path string, mtime time.Time) {
+	t.Helper()
+	if err := os.MkdirAll(path, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	setMtime(t, path, mtime)
+}
+
+func setMtime(t *testing.T, path string, mtime time.Time) {
+	t.Helper()
+	if err := os.Chtimes(path, mtime, mtime); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func writePointer(t *testing.T, path, value string) {
+	t.Helper()
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, []byte(value), 0o644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func exists(path string) bool {
+	_, err := os.Lstat(path)
+	return err == nil
+}
+
+func countKind(r Report, k Kind) int {
+	n := 0
+	for _, rm := range r.Removed {
+		if rm.Kind == k {
+			n++
+		}
+	}
+	return n
+}
+
+type realFileOperator struct{}
+
+func (r *realFileOperator) RemoveAll(path string) error { return os.RemoveAll(path) }
+
+type failingFileOperator struct{}
+
+func (f *failingFileOperator) RemoveAll(string) error { return errors.New("permission denied") }
@@ -0,0 +1,121 @@
+package installer
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	json "github.com/goccy/go-json"
+)
+
+// backupManifestName is the manifest file inside a deployment's backup directory.
+const backupManifestName = "manifest.json"
+
+// BackupManifest lists the files a deployment overwrote and where their
+// originals were kept.
+type BackupManifest struct {
+	DeploymentGroupID string        `json:"deployment_group_id"`
+	DeploymentID      string        `json:"deployment_id"`
+	Created           time.Time     `json:"created"`
+	Files             []BackupEntry `json:"files"`
+}
+
+// BackupEntry maps an overwritten destination to its original, stored under
+// the backup directory by file name.
+type BackupEntry struct {
+	Path   string `json:"path"`
+	Backup string `json:"backup"`
+}
+
+// keepBackups writes the manifest for originals that the agent did not
+// install itself and drops the rest. Files listed in the previous
+// deployment's cleanup file, and files inside a release directory, were put
+// there by the agent and are not worth keeping.
+func (inst *Installer) keepBackups(j *journal, deploymentGroupID, deploymentID string, agentInstalled map[string]bool, untracked string) error {
+	manifest := BackupManifest{
+		DeploymentGroupID: deploymentGroupID,
+		DeploymentID:      deploymentID,
+		Created:           time.Now().UTC(),
+		Files:             make([]BackupEntry, 0, len(j.replaced)),
+	}
+	for _, e := range j.replaced {
+		if agentInstalled[e.Path] || isWithin(e.Path, untracked) {
+			_ = os.Remove(e.Backup)
+			continue
+		}
+		manifest.Files = append(manifest.Files, BackupEntry{Path: e.Path, Backup: filepath.Base(e.Backup)})
+	}
+	if len(manifest.Files) == 0 {
+		_ = os.Remove(j.backupDir)
+		return nil
+	}
+
+	data, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(filepath.Join(j.backupDir, backupManifestName), data, 0o600); err != nil {
+		return err
+	}
+	inst.logger.Info("backed up overwritten files", "dir", j.backupDir, "count", len(manifest.Files))
+	return nil
+}
+
+// Restore moves the originals recorded in backupDir back over the files that
+// replaced them. It returns the restored paths. The backup is consumed: once
+// every file is back, the backup directory is removed. Entries that fail are
+// left in place and reported in the error so that Restore can be retried.
+//
+//	restored, err := inst.Restore(layout.BackupDir())
+func (inst *Installer) Restore(backupDir string) ([]string, error) {
+	data, err := os.ReadFile(filepath.Join(backupDir, backupManifestName))
+	if err != nil {
+		return nil, fmt.Errorf("installer: read backup manifest: %w", err)
+	}
+	var manifest BackupManifest
+	if err := json.Unmarshal(data, &manifest); err != nil {
+		return nil, fmt.Errorf("installer: parse backup manifest: %w", err)
+	}
+
+	restored := make([]string, 0, len(manifest.Files))
+	remaining := make([]BackupEntry, 0)
+	var firstErr error
+	for _, e := range manifest.Files {
+		backup := filepath.Join(backupDir, e.Backup)
+		if err := inst.restoreFile(backup, e.Path); err != nil {
+			inst.logger.Warn("restore failed", "path", e.Path, "error", err)
+			if firstErr == nil {
+				firstErr = fmt.Errorf("installer: restore %s: %w", e.Path, err)
+			}
+			remaining = append(remaining, e)
+			continue
+		}
+		restored = append(restored, e.Path)
+	}
+
+	if len(remaining) == 0 {
+		if err := inst.fileOp.RemoveAll(backupDir); err != nil && firstErr == nil {
+			firstErr = fmt.Errorf("installer: remove backup: %w", err)
+		}
+		return restored, firstErr
+	}
+	manifest.Files = remaining
+	if data, err := json.MarshalIndent(manifest, "", "  "); err == nil {
+		_ = os.WriteFile(filepath.Join(backupDir, backupManifestName), data, 0o600)
+	}
+	return restored, firstErr
+}
+
+func (inst *Installer) restoreFile(backup, path string) error {
+	if _, err := os.Lstat(backup); err != nil {
+		return err
+	}
+	if err := inst.fileOp.MkdirAll(filepath.Dir(path)); err != nil {
+		return err
+	}
+	if err := inst.fileOp.Remove(path); err != nil {
+		return err
+	}
+	return inst.moveFile(backup, path)
+}
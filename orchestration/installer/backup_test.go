@@ -0,0 +1,139 @@
+package installer
+
+import (
+	"log/slog"
+	"os"
+	"path/filepath"
+	"testing"
+
+	json "github.com/goccy/go-json"
+
+	"github.com/gurre/codedeploy-agent-go/logic/appspec"
+)
+
+// TestInstall_BackupOverwritten_KeepsOriginal verifies that with backups
+// enabled, a pre-existing file replaced under OVERWRITE is kept in the
+// deployment's backup directory and listed in its manifest.
+func TestInstall_BackupOverwritten_KeepsOriginal(t *testing.T) {
+	archiveDir, instructionsDir, destDir := setupDirs(t)
+	backupDir := filepath.Join(t.TempDir(), "overwritten-backup")
+	createFile(t, filepath.Join(archiveDir, "app.conf"), "new")
+	createFile(t, filepath.Join(destDir, "app.conf"), "hand-edited")
+
+	inst := NewInstaller(&diskFileOp{mockFileOp: newMockFileOp()}, true, IncrementalOff, slog.Default())
+	spec := appspec.Spec{Files: []appspec.FileMapping{{Source: "app.conf", Destination: destDir}}}
+	if err := inst.Install("dg-1", "d-1", archiveDir, instructionsDir, backupDir, spec, "OVERWRITE"); err != nil {
+		t.Fatalf("Install: %v", err)
+	}
+
+	manifest := readManifest(t, backupDir)
+	if manifest.DeploymentID != "d-1" || len(manifest.Files) != 1 {
+		t.Fatalf("manifest = %+v, want one file for d-1", manifest)
+	}
+	entry := manifest.Files[0]
+	if entry.Path != filepath.Join(destDir, "app.conf") {
+		t.Errorf("manifest path = %q", entry.Path)
+	}
+	got, err := os.ReadFile(filepath.Join(backupDir, entry.Backup))
+	if err != nil || string(got) != "hand-edited" {
+		t.Errorf("backup = %q (%v), want hand-edited", got, err)
+	}
+	assertNoJournal(t, instructionsDir, "dg-1")
+}
+
+// TestInstall_BackupOverwritten_SkipsAgentInstalled verifies that files the
+// previous deployment installed are not backed up: they come from an older
+// revision that is already on disk and are not worth keeping.
+func TestInstall_BackupOverwritten_SkipsAgentInstalled(t *testing.T) {
+	archiveDir, instructionsDir, destDir := setupDirs(t)
+	createFile(t, filepath.Join(archiveDir, "app.conf"), "v1")
+
+	inst := NewInstaller(&diskFileOp{mockFileOp: newMockFileOp()}, true, IncrementalOff, slog.Default())
+	spec := appspec.Spec{Files: []appspec.FileMapping{{Source: "app.conf", Destination: destDir}}}
+	if err := inst.Install("dg-1", "d-1", archiveDir, instructionsDir, filepath.Join(t.TempDir(), "b1"), spec, "OVERWRITE"); err != nil {
+		t.Fatalf("first Install: %v", err)
+	}
+
+	writeFile(t, filepath.Join(archiveDir, "app.conf"), "v2")
+	backupDir := filepath.Join(t.TempDir(), "b2")
+	if err := inst.Install("dg-1", "d-2", archiveDir, instructionsDir, backupDir, spec, "OVERWRITE"); err != nil {
+		t.Fatalf("second Install: %v", err)
+	}
+	if _, err := os.Stat(backupDir); !os.IsNotExist(err) {
+		t.Errorf("backup dir should not exist, stat err = %v", err)
+	}
+}
+
+// TestInstall_BackupDisabled_DiscardsOriginal verifies that without the
+// option, OVERWRITE keeps its historical behaviour and leaves no backup.
+func TestInstall_BackupDisabled_DiscardsOriginal(t *testing.T) {
+	archiveDir, instructionsDir, destDir := setupDirs(t)
+	backupDir := filepath.Join(t.TempDir(), "overwritten-backup")
+	createFile(t, filepath.Join(archiveDir, "app.conf"), "new")
+	createFile(t, filepath.Join(destDir, "app.conf"), "old")
+
+	inst := NewInstaller(&diskFileOp{mockFileOp: newMockFileOp()}, false, IncrementalOff, slog.Default())
+	spec := appspec.Spec{Files: []appspec.FileMapping{{Source: "app.conf", Destination: destDir}}}
+	if err := inst.Install("dg-1", "d-1", archiveDir, instructionsDir, backupDir, spec, "OVERWRITE"); err != nil {
+		t.Fatalf("Install: %v", err)
+	}
+	if _, err := os.Stat(backupDir); !os.IsNotExist(err) {
+		t.Errorf("backup dir should not exist, stat err = %v", err)
+	}
+}
+
+// TestRestore_PutsOriginalsBack verifies that Restore moves every backed-up
+// original over the deployed file and consumes the backup directory.
+func TestRestore_PutsOriginalsBack(t *testing.T) {
+	archiveDir, instructionsDir, destDir := setupDirs(t)
+	backupDir := filepath.Join(t.TempDir(), "overwritten-backup")
+	createFile(t, filepath.Join(archiveDir, "conf", "a.conf"), "new-a")
+	createFile(t, filepath.Join(archiveDir, "conf", "b.conf"), "new-b")
+	createFile(t, filepath.Join(destDir, "a.conf"), "old-a")
+	createFile(t, filepath.Join(destDir, "b.conf"), "old-b")
+
+	inst := NewInstaller(&diskFileOp{mockFileOp: newMockFileOp()}, true, IncrementalOff, slog.Default())
+	spec := appspec.Spec{Files: []appspec.FileMapping{{Source: "conf", Destination: destDir}}}
+	if err := inst.Install("dg-1", "d-1", archiveDir, instructionsDir, backupDir, spec, "OVERWRITE"); err != nil {
+		t.Fatalf("Install: %v", err)
+	}
+
+	restored, err := inst.Restore(backupDir)
+	if err != nil {
+		t.Fatalf("Restore: %v", err)
+	}
+	if len(restored) != 2 {
+		t.Errorf("restored = %v, want 2 paths", restored)
+	}
+	for name, want := range map[string]string{"a.conf": "old-a", "b.conf": "old-b"} {
+		got, err := os.ReadFile(filepath.Join(destDir, name))
+		if err != nil || string(got) != want {
+			t.Errorf("%s = %q (%v), want %q", name, got, err, want)
+		}
+	}
+	if _, err := os.Stat(backupDir); !os.IsNotExist(err) {
+		t.Errorf("backup dir should be removed, stat err = %v", err)
+	}
+}
+
+// TestRestore_MissingManifest verifies that restoring a deployment without a
+// backup reports an error instead of silently doing nothing.
+func TestRestore_MissingManifest(t *testing.T) {
+	inst := NewInstaller(newMockFileOp(), false, IncrementalOff, slog.Default())
+	if _, err := inst.Restore(t.TempDir()); err == nil {
+		t.Fatal("expected error for missing manifest")
+	}
+}
+
+func readManifest(t *testing.T, backupDir string) BackupManifest {
+	t.Helper()
+	data, err := os.ReadFile(filepath.Join(backupDir, backupManifestName))
+	if err != nil {
+		t.Fatalf("read manifest: %v", err)
+	}
+	var m BackupManifest
+	if err := json.Unmarshal(data, &m); err != nil {
+		t.Fatalf("parse manifest: %v", err)
+	}
+	return m
+}
@@ -1,10 +1,12 @@
 package installer
 
 import (
+	"fmt"
 	"log/slog"
 	"os"
 	"path/filepath"
 	"testing"
+	"time"
 
 	"github.com/gurre/codedeploy-agent-go/logic/appspec"
 )
@@ -43,14 +45,58 @@ func BenchmarkInstall(b *testing.B) {
 	}
 
 	fop := &benchFileOperator{}
-	inst := NewInstaller(fop, logger)
+	inst := NewInstaller(fop, false, IncrementalOff, logger)
 
 	b.ResetTimer()
 	for range b.N {
-		_ = inst.Install("dg-bench", archiveDir, instructionsDir, spec, "OVERWRITE")
+		_ = inst.Install("dg-bench", "d-1", archiveDir, instructionsDir, "", spec, "OVERWRITE")
 	}
 }
 
+// BenchmarkInstall_SlowDisk measures a 500-file install against a file
+// operator whose copies take 200µs each, approximating a network-backed disk,
+// sequentially and with the default worker pool.
+func BenchmarkInstall_SlowDisk(b *testing.B) {
+	logger := slog.New(slog.NewTextHandler(os.Stderr, &slog.HandlerOptions{Level: slog.LevelError}))
+
+	archiveDir := b.TempDir()
+	instructionsDir := b.TempDir()
+	for d := range 10 {
+		subdir := filepath.Join(archiveDir, "src", fmt.Sprintf("dir%d", d))
+		_ = os.MkdirAll(subdir, 0o755)
+		for f := range 50 {
+			_ = os.WriteFile(filepath.Join(subdir, fmt.Sprintf("file%02d.txt", f)), []byte("content"), 0o644)
+		}
+	}
+	destBase := b.TempDir()
+	spec := appspec.Spec{
+		OS:    "linux",
+		Files: []appspec.FileMapping{{Source: "src", Destination: destBase}},
+	}
+
+	for _, workers := range []int{1, defaultWorkers} {
+		b.Run(fmt.Sprintf("workers=%d", workers), func(b *testing.B) {
+			inst := NewInstaller(&slowBenchFileOperator{latency: 200 * time.Microsecond}, false, IncrementalOff, logger)
+			inst.workers = workers
+			b.ResetTimer()
+			for range b.N {
+				_ = inst.Install("dg-bench", "d-1", archiveDir, instructionsDir, "", spec, "OVERWRITE")
+			}
+		})
+	}
+}
+
+// slowBenchFileOperator adds a fixed latency to every copy.
+type slowBenchFileOperator struct {
+	benchFileOperator
+	latency time.Duration
+}
+
+func (f *slowBenchFileOperator) Copy(_, _ string) error {
+	time.Sleep(f.latency)
+	return nil
+}
+
 // benchFileOperator performs real mkdir and records copy calls without actual I/O.
 type benchFileOperator struct{}
 
@@ -63,3 +109,6 @@ func (f *benchFileOperator) SetACL(_ string, _ []string) error   { return nil }
 func (f *benchFileOperator) SetContext(_, _, _, _ string) error  { return nil }
 func (f *benchFileOperator) RemoveContext(_ string) error        { return nil }
 func (f *benchFileOperator) Remove(_ string) error               { return nil }
+func (f *benchFileOperator) RemoveAll(_ string) error            { return nil }
+func (f *benchFileOperator) Symlink(_, _ string) error           { return nil }
+func (f *benchFileOperator) Rename(_, _ string) error            { return nil }
@@ -0,0 +1,154 @@
+package installer
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/gurre/codedeploy-agent-go/logic/instruction"
+)
+
+// readCleanup parses the group's cleanup file. A missing file yields an empty
+// cleanup; a legacy line-based file is read and is replaced by the JSON format
+// when this install writes its own cleanup file.
+func (inst *Installer) readCleanup(cleanupPath string) (instruction.Cleanup, error) {
+	data, err := os.ReadFile(cleanupPath)
+	if os.IsNotExist(err) {
+		return instruction.Cleanup{}, nil
+	}
+	if err != nil {
+		return instruction.Cleanup{}, err
+	}
+	c, err := instruction.ParseCleanup(data)
+	if err != nil {
+		return instruction.Cleanup{}, err
+	}
+	if c.Version == 0 && len(c.Entries) > 0 {
+		inst.logger.Info("migrating legacy cleanup file", "path", cleanupPath, "entries", len(c.Entries))
+	}
+	return c, nil
+}
+
+// installedPaths returns the file paths listed in a cleanup, which are the
+// paths the previous deployment of the group installed.
+func installedPaths(c instruction.Cleanup) map[string]bool {
+	paths := make(map[string]bool, len(c.Entries))
+	for _, e := range c.Entries {
+		if e.Type != instruction.EntryContext {
+			paths[filepath.Clean(e.Path)] = true
+		}
+	}
+	return paths
+}
+
+// previousContexts returns the file context rules added by the group's
+// previous install.json, keyed by path, so that cleanup can journal what it
+// removes. A missing or unreadable file yields nil.
+func previousContexts(installPath string) map[string]*instruction.ContextCmd {
+	data, err := os.ReadFile(installPath)
+	if err != nil {
+		return nil
+	}
+	cmds, err := instruction.ParseInstallCommands(data)
+	if err != nil {
+		return nil
+	}
+	contexts := make(map[string]*instruction.ContextCmd)
+	for _, c := range cmds {
+		if c.Type == instruction.TypeSemanage && c.Context != nil {
+			contexts[filepath.Clean(c.File)] = c.Context
+		}
+	}
+	return contexts
+}
+
+// changedSinceInstall reports whether a file recorded with a hash now has
+// different content. Files without a recorded hash, and missing files, are
+// never reported as changed.
+func changedSinceInstall(e instruction.CleanupEntry) bool {
+	if e.Type != instruction.EntryFile || e.SHA256 == "" {
+		return false
+	}
+	info, err := os.Lstat(e.Path)
+	if err != nil || !info.Mode().IsRegular() {
+		return false
+	}
+	sum, err := instruction.HashFile(e.Path)
+	return err == nil && sum != e.SHA256
+}
+
+// cleanupRecord collects the cleanup entries of an install. Entries are kept
+// in command order regardless of scheduling.
+type cleanupRecord struct {
+	groupID      string
+	deploymentID string
+	prev         map[string]instruction.CleanupEntry
+	cmds         []instruction.Command
+	tracked      []int
+	existed      []bool
+}
+
+func newCleanupRecord(groupID, deploymentID string, prev instruction.Cleanup, cmds []instruction.Command) *cleanupRecord {
+	byPath := make(map[string]instruction.CleanupEntry, len(prev.Entries))
+	for _, e := range prev.Entries {
+		byPath[filepath.Clean(e.Path)] = e
+	}
+	return &cleanupRecord{
+		groupID:      groupID,
+		deploymentID: deploymentID,
+		prev:         byPath,
+		cmds:         cmds,
+		existed:      make([]bool, len(cmds)),
+	}
+}
+
+// track records that command idx installed a path. existed is set separately
+// for copies, which learn it only when they run.
+func (r *cleanupRecord) track(idx int) {
+	r.tracked = append(r.tracked, idx)
+}
+
+// entries builds the cleanup entries. It must be called once every tracked
+// command has finished. A path the previous deployment installed keeps its
+// preexisting flag, and an unchanged copy keeps the deployment that wrote it.
+func (r *cleanupRecord) entries() []instruction.CleanupEntry {
+	out := make([]instruction.CleanupEntry, 0, len(r.tracked))
+	for _, idx := range r.tracked {
+		cmd := r.cmds[idx]
+		e := instruction.CleanupEntry{DeploymentID: r.deploymentID, Preexisting: r.existed[idx]}
+		switch cmd.Type {
+		case instruction.TypeCopy:
+			e.Path, e.Type = cmd.Destination, instruction.EntryFile
+			if cmd.Stats != nil {
+				e.SHA256 = cmd.Stats.SHA256
+			} else if info, err := os.Lstat(cmd.Source); err == nil && info.Mode()&os.ModeSymlink != 0 {
+				e.Type = instruction.EntrySymlink
+			}
+		case instruction.TypeMkdir:
+			e.Path, e.Type = cmd.Directory, instruction.EntryDirectory
+		case instruction.TypeSemanage:
+			e.Path, e.Type, e.Preexisting = cmd.File, instruction.EntryContext, false
+		}
+		if p, ok := r.prev[filepath.Clean(e.Path)]; ok && (p.Type == e.Type || p.Type == "") {
+			e.Preexisting = p.Preexisting
+			if cmd.Unchanged {
+				e.DeploymentID = p.DeploymentID
+			}
+		}
+		out = append(out, e)
+	}
+	return out
+}
+
+// write stores the cleanup file, replacing it atomically.
+func (r *cleanupRecord) write(cleanupPath string) error {
+	data, err := instruction.Cleanup{DeploymentGroupID: r.groupID, Entries: r.entries()}.ToJSON()
+	if err != nil {
+		return fmt.Errorf("marshal cleanup: %w", err)
+	}
+	tmp := cleanupPath + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, cleanupPath)
+}
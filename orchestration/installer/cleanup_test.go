@@ -0,0 +1,158 @@
+package installer
+
+import (
+	"log/slog"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/gurre/codedeploy-agent-go/logic/appspec"
+	"github.com/gurre/codedeploy-agent-go/logic/instruction"
+)
+
+// TestInstall_Cleanup_RecordsEntryMetadata verifies that the JSON cleanup
+// file records each path's type, the deployment that wrote it, its hash and
+// whether it overwrote a file the agent did not install.
+func TestInstall_Cleanup_RecordsEntryMetadata(t *testing.T) {
+	archiveDir, instructionsDir, destDir := setupDirs(t)
+	createFile(t, filepath.Join(archiveDir, "app", "new.txt"), "new")
+	createFile(t, filepath.Join(archiveDir, "app", "conf", "app.conf"), "conf")
+	createFile(t, filepath.Join(destDir, "new.txt"), "hand made")
+	spec := appspec.Spec{Files: []appspec.FileMapping{{Source: "app", Destination: destDir}}}
+
+	inst := NewInstaller(&diskFileOp{mockFileOp: newMockFileOp()}, false, IncrementalHash, slog.Default())
+	if err := inst.Install("dg-1", "d-1", archiveDir, instructionsDir, "", spec, "OVERWRITE"); err != nil {
+		t.Fatalf("Install: %v", err)
+	}
+
+	cleanup := readCleanupFile(t, instructionsDir, "dg-1")
+	if cleanup.Version != instruction.CleanupVersion || cleanup.DeploymentGroupID != "dg-1" {
+		t.Errorf("cleanup header = version %d group %q", cleanup.Version, cleanup.DeploymentGroupID)
+	}
+	entries := make(map[string]instruction.CleanupEntry)
+	for _, e := range cleanup.Entries {
+		entries[e.Path] = e
+	}
+
+	replaced := entries[filepath.Join(destDir, "new.txt")]
+	if replaced.Type != instruction.EntryFile || !replaced.Preexisting || replaced.SHA256 == "" || replaced.DeploymentID != "d-1" {
+		t.Errorf("overwritten file entry = %+v", replaced)
+	}
+	if dir := entries[filepath.Join(destDir, "conf")]; dir.Type != instruction.EntryDirectory || dir.Preexisting {
+		t.Errorf("directory entry = %+v", dir)
+	}
+	if created := entries[filepath.Join(destDir, "conf", "app.conf")]; created.Preexisting {
+		t.Errorf("created file entry = %+v, want not preexisting", created)
+	}
+}
+
+// TestInstall_Cleanup_KeepsFileChangedSinceInstall verifies that a file the
+// next revision drops is removed when untouched but kept when it was edited
+// after install, so that local changes are not silently deleted.
+func TestInstall_Cleanup_KeepsFileChangedSinceInstall(t *testing.T) {
+	archiveDir, instructionsDir, destDir := setupDirs(t)
+	createFile(t, filepath.Join(archiveDir, "app", "kept.txt"), "v1")
+	createFile(t, filepath.Join(archiveDir, "app", "edited.txt"), "v1")
+	createFile(t, filepath.Join(archiveDir, "app", "dropped.txt"), "v1")
+	spec := appspec.Spec{Files: []appspec.FileMapping{{Source: "app", Destination: destDir}}}
+
+	inst := NewInstaller(&diskFileOp{mockFileOp: newMockFileOp()}, false, IncrementalHash, slog.Default())
+	if err := inst.Install("dg-1", "d-1", archiveDir, instructionsDir, "", spec, "OVERWRITE"); err != nil {
+		t.Fatalf("first Install: %v", err)
+	}
+	writeFile(t, filepath.Join(destDir, "edited.txt"), "local change")
+
+	next := filepath.Join(t.TempDir(), "archive")
+	createFile(t, filepath.Join(next, "app", "kept.txt"), "v2")
+	if err := inst.Install("dg-1", "d-2", next, instructionsDir, "", spec, "OVERWRITE"); err != nil {
+		t.Fatalf("second Install: %v", err)
+	}
+
+	if got, err := os.ReadFile(filepath.Join(destDir, "edited.txt")); err != nil || string(got) != "local change" {
+		t.Errorf("edited.txt = %q (%v), want local change kept", got, err)
+	}
+	if _, err := os.Lstat(filepath.Join(destDir, "dropped.txt")); !os.IsNotExist(err) {
+		t.Errorf("dropped.txt should be removed, Lstat err = %v", err)
+	}
+	if got, _ := os.ReadFile(filepath.Join(destDir, "kept.txt")); string(got) != "v2" {
+		t.Errorf("kept.txt = %q, want v2", got)
+	}
+}
+
+// TestInstall_Cleanup_MigratesLegacyFile verifies that a line-based cleanup
+// file left by an older agent is honoured and replaced by the JSON format.
+func TestInstall_Cleanup_MigratesLegacyFile(t *testing.T) {
+	archiveDir, instructionsDir, destDir := setupDirs(t)
+	createFile(t, filepath.Join(archiveDir, "new.txt"), "new")
+	old := filepath.Join(destDir, "old.txt")
+	createFile(t, old, "old")
+	writeFile(t, filepath.Join(instructionsDir, "dg-1-cleanup"), old+"\n")
+
+	inst := NewInstaller(&diskFileOp{mockFileOp: newMockFileOp()}, false, IncrementalOff, slog.Default())
+	spec := appspec.Spec{Files: []appspec.FileMapping{{Source: "new.txt", Destination: destDir}}}
+	if err := inst.Install("dg-1", "d-1", archiveDir, instructionsDir, "", spec, "OVERWRITE"); err != nil {
+		t.Fatalf("Install: %v", err)
+	}
+
+	if _, err := os.Lstat(old); !os.IsNotExist(err) {
+		t.Errorf("legacy entry should be removed, Lstat err = %v", err)
+	}
+	cleanup := readCleanupFile(t, instructionsDir, "dg-1")
+	if cleanup.Version != instruction.CleanupVersion || len(cleanup.Entries) != 1 {
+		t.Errorf("cleanup = %+v, want one entry in version %d", cleanup, instruction.CleanupVersion)
+	}
+}
+
+// TestInstall_Cleanup_UnchangedKeepsWriter verifies that a file skipped by an
+// incremental install keeps the deployment that actually wrote it.
+func TestInstall_Cleanup_UnchangedKeepsWriter(t *testing.T) {
+	archiveDir, instructionsDir, destDir := setupDirs(t)
+	createFile(t, filepath.Join(archiveDir, "app.conf"), "same")
+	spec := appspec.Spec{Files: []appspec.FileMapping{{Source: "app.conf", Destination: destDir}}}
+
+	inst := NewInstaller(&diskFileOp{mockFileOp: newMockFileOp()}, false, IncrementalHash, slog.Default())
+	for _, id := range []string{"d-1", "d-2"} {
+		if err := inst.Install("dg-1", id, archiveDir, instructionsDir, "", spec, "OVERWRITE"); err != nil {
+			t.Fatalf("Install %s: %v", id, err)
+		}
+	}
+
+	cleanup := readCleanupFile(t, instructionsDir, "dg-1")
+	if len(cleanup.Entries) != 1 || cleanup.Entries[0].DeploymentID != "d-1" || cleanup.Entries[0].Preexisting {
+		t.Errorf("entries = %+v, want app.conf written by d-1", cleanup.Entries)
+	}
+}
+
+func readCleanupFile(t *testing.T, instructionsDir, groupID string) instruction.Cleanup {
+	t.Helper()
+	data, err := os.ReadFile(filepath.Join(instructionsDir, groupID+"-cleanup"))
+	if err != nil {
+		t.Fatalf("read cleanup file: %v", err)
+	}
+	cleanup, err := instruction.ParseCleanup(data)
+	if err != nil {
+		t.Fatalf("parse cleanup file: %v", err)
+	}
+	return cleanup
+}
+
+// TestPlanCleanup_KeepsShortNamedFile verifies that a kept file whose path
+// relative to a removed directory is shorter than "../" keeps that directory
+// instead of crashing the ancestor check.
+func TestPlanCleanup_KeepsShortNamedFile(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "app")
+	file := filepath.Join(dir, "a")
+	createFile(t, file, "local change")
+	prev := instruction.Cleanup{Version: instruction.CleanupVersion, Entries: []instruction.CleanupEntry{
+		{Path: dir, Type: instruction.EntryDirectory},
+		{Path: file, Type: instruction.EntryFile, SHA256: "0000"},
+	}}
+
+	remove, kept := planCleanup(prev, nil, nil)
+	if len(kept) != 1 || kept[0].Path != file {
+		t.Errorf("kept = %+v, want %s", kept, file)
+	}
+	if len(remove) != 0 {
+		t.Errorf("remove = %+v, want the directory of the kept file left in place", remove)
+	}
+}
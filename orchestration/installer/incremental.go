@@ -0,0 +1,156 @@
+package installer
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/gurre/codedeploy-agent-go/logic/instruction"
+)
+
+// Incremental selects how an install recognises files that are unchanged
+// since the previous deployment of the group. Unchanged files are not copied
+// again, but permissions are still applied to them.
+type Incremental int
+
+const (
+	// IncrementalOff copies every file.
+	IncrementalOff Incremental = iota
+	// IncrementalMtime treats a file as unchanged when the source size, mode
+	// and modification time match the previous install.
+	IncrementalMtime
+	// IncrementalHash treats a file as unchanged when the source size, mode
+	// and SHA-256 match the previous install.
+	IncrementalHash
+)
+
+// ParseIncremental parses the incremental_install setting: "hash", "mtime"
+// or "off".
+//
+//	mode, err := installer.ParseIncremental(cfg.IncrementalInstall)
+func ParseIncremental(s string) (Incremental, error) {
+	switch s {
+	case "hash", "":
+		return IncrementalHash, nil
+	case "mtime":
+		return IncrementalMtime, nil
+	case "off":
+		return IncrementalOff, nil
+	}
+	return IncrementalOff, fmt.Errorf("installer: invalid incremental install mode %q (want hash, mtime or off)", s)
+}
+
+// previousStats returns the file stats recorded in the group's previous
+// install.json, keyed by destination. Missing or unreadable files yield nil,
+// which makes every file count as changed.
+func previousStats(installPath string) map[string]*instruction.FileStats {
+	data, err := os.ReadFile(installPath)
+	if err != nil {
+		return nil
+	}
+	cmds, err := instruction.ParseInstallCommands(data)
+	if err != nil {
+		return nil
+	}
+	stats := make(map[string]*instruction.FileStats, len(cmds))
+	for _, c := range cmds {
+		if c.Type == instruction.TypeCopy && c.Stats != nil && c.Stats.InstalledMtime != 0 {
+			stats[c.Destination] = c.Stats
+		}
+	}
+	return stats
+}
+
+// attachStats describes every regular-file copy in cmds by the file it reads,
+// which for a template is the rendered output, so that install.json records
+// what is actually installed. cmds is modified in place.
+func (inst *Installer) attachStats(cmds []instruction.Command) {
+	for i := range cmds {
+		c := &cmds[i]
+		if c.Type != instruction.TypeCopy {
+			continue
+		}
+		stats, err := sourceStats(c.CopySource())
+		if err != nil {
+			inst.logger.Debug("no stats for copy", "source", c.Source, "error", err)
+			continue
+		}
+		c.Stats = stats
+	}
+}
+
+// markUnchanged marks copies whose stats, attached by attachStats, and
+// destination both match prev as unchanged. A copy only reaches this point
+// with an existing destination under OVERWRITE, and such destinations are
+// already kept out of cleanup. cmds is modified in place. It returns the
+// number of unchanged copies.
+func (inst *Installer) markUnchanged(cmds []instruction.Command, prev map[string]*instruction.FileStats, mode Incremental) int {
+	unchanged := 0
+	for i := range cmds {
+		c := &cmds[i]
+		if c.Type != instruction.TypeCopy || c.Stats == nil {
+			continue
+		}
+		if p := prev[c.Destination]; p != nil && sameContent(c.Stats, p, mode) && untouched(c.Destination, p) {
+			c.Stats.InstalledMtime = p.InstalledMtime
+			c.Unchanged = true
+			unchanged++
+		}
+	}
+	return unchanged
+}
+
+// sourceStats describes a regular source file; other file types get no
+// stats and are always copied.
+func sourceStats(path string) (*instruction.FileStats, error) {
+	info, err := os.Lstat(path)
+	if err != nil {
+		return nil, err
+	}
+	if !info.Mode().IsRegular() {
+		return nil, fmt.Errorf("not a regular file")
+	}
+	sum, err := instruction.HashFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return &instruction.FileStats{
+		SHA256: sum,
+		Size:   info.Size(),
+		Mode:   uint32(info.Mode().Perm()),
+		Mtime:  info.ModTime().UnixNano(),
+	}, nil
+}
+
+func sameContent(cur, prev *instruction.FileStats, mode Incremental) bool {
+	if cur.Size != prev.Size || cur.Mode != prev.Mode {
+		return false
+	}
+	if mode == IncrementalHash {
+		return prev.SHA256 != "" && cur.SHA256 == prev.SHA256
+	}
+	return cur.Mtime == prev.Mtime
+}
+
+// untouched reports whether the destination is still the file the previous
+// install wrote, judged by its size and modification time.
+func untouched(path string, prev *instruction.FileStats) bool {
+	info, err := os.Lstat(path)
+	if err != nil || !info.Mode().IsRegular() {
+		return false
+	}
+	return info.Size() == prev.Size && info.ModTime().UnixNano() == prev.InstalledMtime
+}
+
+// recordInstalled stores each copied file's modification time after the
+// install so that the next install can tell whether it was edited since.
+func recordInstalled(cmds []instruction.Command) {
+	for i := range cmds {
+		c := &cmds[i]
+		if c.Type != instruction.TypeCopy || c.Stats == nil {
+			continue
+		}
+		if info, err := os.Lstat(c.Destination); err == nil {
+			c.Stats.InstalledMtime = info.ModTime().UnixNano()
+		}
+	}
+}
@@ -0,0 +1,181 @@
+package installer
+
+import (
+	"log/slog"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/gurre/codedeploy-agent-go/logic/appspec"
+	"github.com/gurre/codedeploy-agent-go/logic/instruction"
+)
+
+// TestInstall_Incremental_SkipsUnchanged verifies that a redeploy of an
+// identical file under OVERWRITE does not copy it again, keeps it through
+// cleanup, still applies permissions, and records it as unchanged.
+func TestInstall_Incremental_SkipsUnchanged(t *testing.T) {
+	archiveDir, instructionsDir, destDir := setupDirs(t)
+	createFile(t, filepath.Join(archiveDir, "app.conf"), "same")
+	mode, err := appspec.ParseMode("0640")
+	if err != nil {
+		t.Fatal(err)
+	}
+	dest := filepath.Join(destDir, "app.conf")
+	spec := appspec.Spec{
+		Files:       []appspec.FileMapping{{Source: "app.conf", Destination: destDir}},
+		Permissions: []appspec.Permission{{Object: dest, Pattern: "**", Type: []string{"file"}, Mode: &mode}},
+	}
+
+	first := &diskFileOp{mockFileOp: newMockFileOp()}
+	if err := NewInstaller(first, false, IncrementalHash, slog.Default()).Install("dg-1", "d-1", archiveDir, instructionsDir, "", spec, "OVERWRITE"); err != nil {
+		t.Fatalf("first Install: %v", err)
+	}
+
+	second := &diskFileOp{mockFileOp: newMockFileOp()}
+	if err := NewInstaller(second, false, IncrementalHash, slog.Default()).Install("dg-1", "d-2", archiveDir, instructionsDir, "", spec, "OVERWRITE"); err != nil {
+		t.Fatalf("second Install: %v", err)
+	}
+
+	if second.copiedTo(dest) {
+		t.Error("unchanged file was copied again")
+	}
+	if !second.chmodCalled(dest) {
+		t.Error("permissions should still be applied to unchanged files")
+	}
+	if got, err := os.ReadFile(dest); err != nil || string(got) != "same" {
+		t.Errorf("app.conf = %q (%v), want it kept through cleanup", got, err)
+	}
+	cmd := installedCopy(t, instructionsDir, dest)
+	if !cmd.Unchanged || cmd.Stats == nil || cmd.Stats.SHA256 == "" || cmd.Stats.InstalledMtime == 0 {
+		t.Errorf("install.json copy = %+v, stats %+v", cmd, cmd.Stats)
+	}
+}
+
+// TestInstall_Incremental_CopiesChanged verifies that changed content, and a
+// destination edited on the host since the last install, are copied.
+func TestInstall_Incremental_CopiesChanged(t *testing.T) {
+	for _, tc := range []struct {
+		name   string
+		mutate func(t *testing.T, source, dest string)
+	}{
+		{"source changed", func(t *testing.T, source, _ string) {
+			writeFile(t, source, "v2")
+		}},
+		{"destination edited", func(t *testing.T, _, dest string) {
+			future := time.Now().Add(time.Hour)
+			if err := os.Chtimes(dest, future, future); err != nil {
+				t.Fatal(err)
+			}
+		}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			archiveDir, instructionsDir, destDir := setupDirs(t)
+			source := filepath.Join(archiveDir, "app.conf")
+			dest := filepath.Join(destDir, "app.conf")
+			createFile(t, source, "v1")
+			spec := appspec.Spec{Files: []appspec.FileMapping{{Source: "app.conf", Destination: destDir}}}
+
+			inst := NewInstaller(&diskFileOp{mockFileOp: newMockFileOp()}, false, IncrementalHash, slog.Default())
+			if err := inst.Install("dg-1", "d-1", archiveDir, instructionsDir, "", spec, "OVERWRITE"); err != nil {
+				t.Fatalf("first Install: %v", err)
+			}
+			tc.mutate(t, source, dest)
+
+			op := &diskFileOp{mockFileOp: newMockFileOp()}
+			if err := NewInstaller(op, false, IncrementalHash, slog.Default()).Install("dg-1", "d-2", archiveDir, instructionsDir, "", spec, "OVERWRITE"); err != nil {
+				t.Fatalf("second Install: %v", err)
+			}
+			if !op.copiedTo(dest) {
+				t.Error("expected the file to be copied")
+			}
+		})
+	}
+}
+
+// TestInstall_Incremental_MtimeMode verifies that mtime mode trusts the
+// source modification time instead of the hash: a re-extracted bundle with
+// identical content but a new mtime is copied.
+func TestInstall_Incremental_MtimeMode(t *testing.T) {
+	archiveDir, instructionsDir, destDir := setupDirs(t)
+	source := filepath.Join(archiveDir, "app.conf")
+	dest := filepath.Join(destDir, "app.conf")
+	createFile(t, source, "same")
+	spec := appspec.Spec{Files: []appspec.FileMapping{{Source: "app.conf", Destination: destDir}}}
+
+	install := func(id string) *diskFileOp {
+		op := &diskFileOp{mockFileOp: newMockFileOp()}
+		if err := NewInstaller(op, false, IncrementalMtime, slog.Default()).Install("dg-1", id, archiveDir, instructionsDir, "", spec, "OVERWRITE"); err != nil {
+			t.Fatalf("Install %s: %v", id, err)
+		}
+		return op
+	}
+	install("d-1")
+	if install("d-2").copiedTo(dest) {
+		t.Error("file with unchanged mtime was copied")
+	}
+	later := time.Now().Add(time.Hour)
+	if err := os.Chtimes(source, later, later); err != nil {
+		t.Fatal(err)
+	}
+	if !install("d-3").copiedTo(dest) {
+		t.Error("file with new mtime should be copied")
+	}
+}
+
+// TestInstall_Incremental_Off verifies that disabling incremental installs
+// copies every file but still records its stats, which verification relies
+// on.
+func TestInstall_Incremental_Off(t *testing.T) {
+	archiveDir, instructionsDir, destDir := setupDirs(t)
+	dest := filepath.Join(destDir, "app.conf")
+	createFile(t, filepath.Join(archiveDir, "app.conf"), "same")
+	spec := appspec.Spec{Files: []appspec.FileMapping{{Source: "app.conf", Destination: destDir}}}
+
+	for _, id := range []string{"d-1", "d-2"} {
+		op := &diskFileOp{mockFileOp: newMockFileOp()}
+		if err := NewInstaller(op, false, IncrementalOff, slog.Default()).Install("dg-1", id, archiveDir, instructionsDir, "", spec, "OVERWRITE"); err != nil {
+			t.Fatalf("Install %s: %v", id, err)
+		}
+		if !op.copiedTo(dest) {
+			t.Errorf("%s: expected copy", id)
+		}
+	}
+	sum, _ := instruction.HashFile(dest)
+	if cmd := installedCopy(t, instructionsDir, dest); cmd.Stats == nil || cmd.Stats.SHA256 != sum || cmd.Stats.Size != 4 || cmd.Stats.InstalledMtime == 0 {
+		t.Errorf("stats = %+v, want size 4, sha256 %s and installed mtime", cmd.Stats, sum)
+	}
+}
+
+// TestParseIncremental verifies the accepted config values and that the
+// default is hashing.
+func TestParseIncremental(t *testing.T) {
+	for in, want := range map[string]Incremental{"": IncrementalHash, "hash": IncrementalHash, "mtime": IncrementalMtime, "off": IncrementalOff} {
+		got, err := ParseIncremental(in)
+		if err != nil || got != want {
+			t.Errorf("ParseIncremental(%q) = %v, %v; want %v", in, got, err, want)
+		}
+	}
+	if _, err := ParseIncremental("always"); err == nil {
+		t.Error("expected error for unknown mode")
+	}
+}
+
+func installedCopy(t *testing.T, instructionsDir, dest string) instruction.Command {
+	t.Helper()
+	data, err := os.ReadFile(filepath.Join(instructionsDir, "dg-1-install.json"))
+	if err != nil {
+		t.Fatalf("read install.json: %v", err)
+	}
+	cmds, err := instruction.ParseInstallCommands(data)
+	if err != nil {
+		t.Fatalf("parse install.json: %v", err)
+	}
+	for _, c := range cmds {
+		if c.Type == instruction.TypeCopy && c.Destination == dest {
+			return c
+		}
+	}
+	t.Fatalf("no copy to %s in install.json", dest)
+	return instruction.Command{}
+}
@@ -3,14 +3,16 @@
 package installer
 
 import (
-	"bufio"
 	"fmt"
 	"log/slog"
 	"os"
+	"path"
 	"path/filepath"
+	"strings"
 
 	"github.com/gurre/codedeploy-agent-go/logic/appspec"
 	"github.com/gurre/codedeploy-agent-go/logic/instruction"
+	"github.com/gurre/codedeploy-agent-go/logic/render"
 )
 
 // FileOperator performs file system operations during installation.
@@ -24,31 +26,75 @@ type FileOperator interface {
 	SetContext(path string, seUser, seType, seRange string) error
 	RemoveContext(path string) error
 	Remove(path string) error
+	RemoveAll(path string) error
+	Symlink(target, link string) error
+	Rename(oldPath, newPath string) error
 }
 
 // Installer manages the install/cleanup lifecycle for deployments.
 type Installer struct {
-	fileOp FileOperator
-	logger *slog.Logger
+	fileOp            FileOperator
+	logger            *slog.Logger
+	workers           int
+	incremental       Incremental
+	backupOverwritten bool
+
+	allowSharedDestinations bool
+	templates               TemplateSource
+	deployment              render.Deployment
 }
 
-// NewInstaller creates an installer with the given file operator.
+// NewInstaller creates an installer with the given file operator. With
+// backupOverwritten set, files that OVERWRITE replaces and that the agent did
+// not install itself are kept in the deployment's backup directory.
+// incremental selects how files unchanged since the previous install are
+// recognised and skipped.
 //
-//	inst := installer.NewInstaller(filesystem.NewOperator(), slog.Default())
-func NewInstaller(fileOp FileOperator, logger *slog.Logger) *Installer {
+//	inst := installer.NewInstaller(filesystem.NewOperator(), false, installer.IncrementalHash, slog.Default())
+func NewInstaller(fileOp FileOperator, backupOverwritten bool, incremental Incremental, logger *slog.Logger) *Installer {
 	return &Installer{
-		fileOp: fileOp,
-		logger: logger,
+		fileOp:            fileOp,
+		logger:            logger,
+		workers:           defaultWorkers,
+		incremental:       incremental,
+		backupOverwritten: backupOverwritten,
 	}
 }
 
+// SetAllowSharedDestinations lets every install copy to paths that another
+// deployment group manages, as if each appspec set allow_shared_destinations.
+// Call it before the first Install.
+//
+//	inst.SetAllowSharedDestinations(cfg.AllowSharedDestinations)
+func (inst *Installer) SetAllowSharedDestinations(allow bool) {
+	inst.allowSharedDestinations = allow
+}
+
 // Install performs cleanup of the previous deployment and installs the new one.
 // It generates instructions from the appspec, writes them to instruction files,
 // and executes the copy/permission commands.
+//
+// Files marked as templates in the appspec are rendered with the deployment
+// variables (see WithDeployment) and the host data of the TemplateSource, and
+// the rendered content is installed and recorded in their place.
+//
+// Install fails before changing anything when it would copy to a path that
+// another deployment group's last install copied to, unless the appspec or
+// SetAllowSharedDestinations allows sharing.
+//
+// When the appspec has a release section, files destined for the current link
+// are assembled in the deployment's release directory instead, and the link is
+// switched to it only after every command has succeeded. Release contents are
+// not recorded in the cleanup file; old releases are pruned by count instead.
+//
+// backupDir receives the originals of overwritten files when the installer
+// was created with backupOverwritten; it is ignored otherwise.
 func (inst *Installer) Install(
 	deploymentGroupID string,
+	deploymentID string,
 	archiveDir string,
 	instructionsDir string,
+	backupDir string,
 	spec appspec.Spec,
 	fileExistsBehavior string,
 ) error {
@@ -56,54 +102,197 @@ func (inst *Installer) Install(
 		return fmt.Errorf("installer: mkdir instructions: %w", err)
 	}
 
+	// Undo a previous install of this group that crashed midway before
+	// deciding what already exists.
+	journalFile := journalPath(instructionsDir, deploymentGroupID)
+	if _, err := os.Stat(journalFile); err == nil {
+		inst.logger.Warn("rolling back interrupted install", "journal", journalFile)
+		if err := inst.rollback(journalFile); err != nil {
+			return fmt.Errorf("installer: rollback interrupted install: %w", err)
+		}
+	}
+
+	// Stats of the previous install identify files that need no copy. A
+	// release directory is new each time, so there is nothing to compare.
+	installPath := filepath.Join(instructionsDir, deploymentGroupID+"-install.json")
+	incremental := inst.incremental
+	if spec.Release != nil {
+		incremental = IncrementalOff
+	}
+	var prev map[string]*instruction.FileStats
+	if incremental != IncrementalOff {
+		prev = previousStats(installPath)
+	}
+
+	// In release mode, stage into the release directory and keep its contents
+	// out of the cleanup file.
+	untracked := ""
+	if spec.Release != nil {
+		if err := inst.prepareRelease(*spec.Release, deploymentID); err != nil {
+			return fmt.Errorf("installer: release: %w", err)
+		}
+		untracked = spec.Release.Dir(deploymentID)
+		spec = releaseSpec(spec, deploymentID)
+	}
+
 	// Generate instructions from appspec (determines which files to retain)
-	builder, err := inst.generateInstructions(archiveDir, spec, fileExistsBehavior)
+	builder, err := inst.generateInstructions(archiveDir, spec, fileExistsBehavior, nil)
 	if err != nil {
 		return fmt.Errorf("installer: generate: %w", err)
 	}
+	commands := builder.Commands()
+	if err := inst.checkDestinations(instructionsDir, deploymentGroupID, commands, spec.AllowSharedDestinations); err != nil {
+		return fmt.Errorf("installer: %w", err)
+	}
 
-	// Execute cleanup from previous deployment (skip retained files)
+	// Render templates before anything changes, so a template error fails
+	// the install with the host untouched.
+	rendered := renderedDir(instructionsDir, deploymentGroupID)
+	_ = os.RemoveAll(rendered)
+	defer func() { _ = os.RemoveAll(rendered) }()
+	if err := inst.renderTemplates(commands, rendered, deploymentGroupID, deploymentID); err != nil {
+		return fmt.Errorf("installer: render: %w", err)
+	}
+	inst.attachStats(commands)
+	if incremental != IncrementalOff {
+		inst.markUnchanged(commands, prev, incremental)
+	}
+
+	// Execute cleanup from previous deployment (skip retained files). Note
+	// what it installed first: those files are not backed up when overwritten.
 	cleanupPath := filepath.Join(instructionsDir, deploymentGroupID+"-cleanup")
-	if err := inst.executeCleanup(cleanupPath, builder.SkippedPaths()); err != nil {
-		return fmt.Errorf("installer: cleanup: %w", err)
+	prevCleanup, err := inst.readCleanup(cleanupPath)
+	if err != nil {
+		return fmt.Errorf("installer: read cleanup: %w", err)
+	}
+	var agentInstalled map[string]bool
+	if !inst.backupOverwritten {
+		backupDir = ""
+	} else {
+		agentInstalled = installedPaths(prevCleanup)
+	}
+	contexts := previousContexts(installPath)
+
+	// Every change from here on is journaled first so a failure can be
+	// undone, including the cleanup of the previous deployment and the
+	// group's install and cleanup files.
+	j, err := openJournal(journalFile, backupDir)
+	if err != nil {
+		return fmt.Errorf("installer: open journal: %w", err)
+	}
+	fail := func(err error) error {
+		_ = j.close()
+		if rbErr := inst.rollback(journalFile); rbErr != nil {
+			inst.logger.Error("rollback incomplete", "journal", journalFile, "error", rbErr)
+		}
+		if untracked != "" {
+			// The release was never live; discard the partial copy.
+			_ = inst.fileOp.RemoveAll(untracked)
+		}
+		return err
+	}
+	for _, path := range []string{installPath, cleanupPath} {
+		if err := inst.saveJournaled(j, path); err != nil {
+			return fail(fmt.Errorf("installer: save %s: %w", filepath.Base(path), err))
+		}
+	}
+	if err := inst.executeCleanup(j, cleanupPath, prevCleanup, contexts, builder.SkippedPaths(), builder.CopyTargets()); err != nil {
+		return fail(fmt.Errorf("installer: cleanup: %w", err))
 	}
 
 	// Write install instructions JSON
 	instructions := builder.Build()
 	installData, err := instructions.ToJSON()
 	if err != nil {
-		return fmt.Errorf("installer: marshal instructions: %w", err)
+		return fail(fmt.Errorf("installer: marshal instructions: %w", err))
 	}
-	installPath := filepath.Join(instructionsDir, deploymentGroupID+"-install.json")
 	if err := os.WriteFile(installPath, installData, 0o644); err != nil {
-		return fmt.Errorf("installer: write install file: %w", err)
+		return fail(fmt.Errorf("installer: write install file: %w", err))
 	}
 
-	// Execute commands and write cleanup file
-	if err := inst.executeCommands(builder.Commands(), cleanupPath); err != nil {
-		return fmt.Errorf("installer: execute: %w", err)
+	// Execute commands and write cleanup file.
+	record := newCleanupRecord(deploymentGroupID, deploymentID, prevCleanup, commands)
+	if err := inst.executeCommands(commands, cleanupPath, untracked, j, record); err != nil {
+		return fail(fmt.Errorf("installer: execute: %w", err))
+	}
+	if backupDir != "" {
+		if err := inst.keepBackups(j, deploymentGroupID, deploymentID, agentInstalled, untracked); err != nil {
+			inst.logger.Error("failed to write backup manifest", "dir", backupDir, "error", err)
+		}
+	}
+	// Switch the current link last, while the journal can still undo the
+	// install: a release that cannot be activated must not leave the host
+	// with the new cleanup file and the old release live.
+	if spec.Release != nil {
+		if err := inst.activateRelease(*spec.Release, deploymentID); err != nil {
+			return fail(fmt.Errorf("installer: activate release: %w", err))
+		}
+	}
+	j.commit()
+
+	// Record what was installed, for verification and so that the next
+	// install can skip unchanged files.
+	recordInstalled(commands)
+	if data, err := instructions.ToJSON(); err == nil {
+		if err := os.WriteFile(installPath, data, 0o644); err != nil {
+			inst.logger.Warn("failed to record installed file stats", "path", installPath, "error", err)
+		}
+	}
+	inst.logSummary(commands)
+
+	if spec.Release != nil {
+		inst.pruneReleases(*spec.Release, deploymentID)
 	}
 
 	return nil
 }
 
-func (inst *Installer) executeCleanup(cleanupPath string, skippedPaths []string) error {
-	data, err := os.ReadFile(cleanupPath)
-	if os.IsNotExist(err) {
+// executeCleanup removes the paths recorded by the previous deployment, as
+// decided by planCleanup, and then the cleanup file itself. Removals are
+// journaled in j so that rollback restores them; contexts holds the file
+// context rules the previous install added, keyed by path.
+func (inst *Installer) executeCleanup(j *journal, cleanupPath string, prev instruction.Cleanup, contexts map[string]*instruction.ContextCmd, skippedPaths, copyTargets []string) error {
+	if _, err := os.Lstat(cleanupPath); os.IsNotExist(err) {
 		return nil
 	}
-	if err != nil {
-		return err
+
+	remove, kept := planCleanup(prev, skippedPaths, copyTargets)
+	for _, entry := range kept {
+		inst.logger.Warn("keeping file changed since install", "path", entry.Path, "deployment", entry.DeploymentID)
+	}
+	for _, entry := range remove {
+		var err error
+		if entry.Type == instruction.EntryContext {
+			err = inst.removeContextJournaled(j, entry.Path, contexts[filepath.Clean(entry.Path)])
+		} else {
+			err = inst.removeJournaled(j, entry.Path)
+		}
+		if err != nil {
+			return err
+		}
 	}
 
+	return os.Remove(cleanupPath)
+}
+
+// planCleanup splits the previous deployment's entries, in removal order,
+// into those to remove and files kept because they were edited since install.
+// Retained paths and their ancestors are in neither, nor are the directories
+// holding a kept file. A changed file is only kept when the new deployment
+// does not copy it again.
+func planCleanup(prev instruction.Cleanup, skippedPaths, copyTargets []string) (remove, kept []instruction.CleanupEntry) {
 	// Build skip set for O(1) lookups
 	skipSet := make(map[string]bool, len(skippedPaths))
 	for _, p := range skippedPaths {
 		skipSet[filepath.Clean(p)] = true
 	}
+	copySet := make(map[string]bool, len(copyTargets))
+	for _, p := range copyTargets {
+		copySet[filepath.Clean(p)] = true
+	}
 
-	entries := instruction.ParseRemoveCommands(string(data))
-	for _, entry := range entries {
+	var keptPaths []string
+	for _, entry := range prev.RemovalOrder() {
 		cleanPath := filepath.Clean(entry.Path)
 
 		// Skip if path is retained
@@ -116,14 +305,19 @@ func (inst *Installer) executeCleanup(cleanupPath string, skippedPaths []string)
 			continue
 		}
 
-		if entry.IsContext {
-			_ = inst.fileOp.RemoveContext(entry.Path)
-		} else {
-			_ = inst.fileOp.Remove(entry.Path)
+		if !copySet[cleanPath] && changedSinceInstall(entry) {
+			kept = append(kept, entry)
+			keptPaths = append(keptPaths, cleanPath)
+			continue
 		}
+		// Entries come children first, so a kept file is seen before its
+		// directories.
+		if isAncestorOfAny(cleanPath, keptPaths) {
+			continue
+		}
+		remove = append(remove, entry)
 	}
-
-	return os.Remove(cleanupPath)
+	return remove, kept
 }
 
 // isAncestorOfAny checks if path is an ancestor directory of any retained path.
@@ -132,30 +326,29 @@ func isAncestorOfAny(path string, retained []string) bool {
 	path = filepath.Clean(path)
 	for _, r := range retained {
 		r = filepath.Clean(r)
-		// Check if path is a prefix of r (parent directory)
-		if len(path) < len(r) {
-			rel, err := filepath.Rel(path, r)
-			if err == nil && !filepath.IsAbs(rel) && rel != ".." && rel[:3] != ".."+string(filepath.Separator) {
-				return true
-			}
+		// A shorter path containing r is one of its parent directories.
+		if len(path) < len(r) && isWithin(r, path) {
+			return true
 		}
 	}
 	return false
 }
 
+// generateInstructions builds the install commands for spec against the
+// current destination tree. Under DISALLOW an existing destination is an
+// error, unless conflicts is non-nil, in which case it is appended there and
+// the file is left out of the commands.
 func (inst *Installer) generateInstructions(
 	archiveDir string,
 	spec appspec.Spec,
 	fileExistsBehavior string,
+	conflicts *[]string,
 ) (*instruction.Builder, error) {
 	builder := instruction.NewBuilder()
 
 	for _, fm := range spec.Files {
 		sourcePath := filepath.Join(archiveDir, fm.Source)
-		feb := spec.FileExistsBehavior
-		if feb == "" {
-			feb = fileExistsBehavior
-		}
+		feb := spec.FileExistsBehaviorFor(fm, fileExistsBehavior)
 
 		info, err := os.Stat(sourcePath)
 		if err != nil {
@@ -163,18 +356,21 @@ func (inst *Installer) generateInstructions(
 		}
 
 		if info.IsDir() {
-			if err := inst.fillMissingAncestors(builder, fm.Destination); err != nil {
-				return nil, err
-			}
-			if err := inst.generateDirectoryCopy(builder, sourcePath, fm.Destination, feb); err != nil {
+			destination := fm.Destination
+			ensure := lazyMkdir(builder, destination, func() error {
+				return inst.fillMissingAncestors(builder, destination)
+			})
+			if err := inst.generateDirectoryCopy(builder, sourcePath, fm.Destination, "", fm, ensure, feb, conflicts); err != nil {
 				return nil, err
 			}
+		} else if !fm.FileFilter.Empty() {
+			return nil, fmt.Errorf("source %q: include and exclude need a directory source", fm.Source)
 		} else {
 			fileDestination := filepath.Join(fm.Destination, filepath.Base(sourcePath))
 			if err := inst.fillMissingAncestors(builder, fileDestination); err != nil {
 				return nil, err
 			}
-			if err := inst.generateFileCopy(builder, sourcePath, fileDestination, feb); err != nil {
+			if err := inst.generateFileCopy(builder, sourcePath, fileDestination, fm.IsTemplate(filepath.Base(sourcePath)), feb, conflicts); err != nil {
 				return nil, err
 			}
 		}
@@ -190,13 +386,28 @@ func (inst *Installer) generateInstructions(
 	return builder, nil
 }
 
+// generateDirectoryCopy copies the directory sourcePath, found at rel within
+// the source of fm, to destination. ensure creates destination and its
+// missing ancestors. It runs up front for the mapping's own destination and
+// for directories fm includes whole, and otherwise only before the first
+// file copied into it, so that include patterns do not leave empty
+// directories behind.
 func (inst *Installer) generateDirectoryCopy(
-	builder *instruction.Builder, sourcePath, destination, feb string,
+	builder *instruction.Builder, sourcePath, destination, rel string, fm appspec.FileMapping,
+	ensure func() error, feb string, conflicts *[]string,
 ) error {
-	if _, err := os.Stat(destination); os.IsNotExist(err) {
-		if err := builder.Mkdir(destination); err != nil {
+	switch {
+	case rel == "":
+		// The mapping's destination is created even when nothing matches.
+		if err := ensure(); err != nil {
 			return err
 		}
+	case fm.Included(rel):
+		if err := ensure(); err != nil {
+			return err
+		}
+		// Everything below an included directory is included.
+		fm.Include = nil
 	}
 
 	entries, err := os.ReadDir(sourcePath)
@@ -207,32 +418,72 @@ func (inst *Installer) generateDirectoryCopy(
 	for _, entry := range entries {
 		entrySource := filepath.Join(sourcePath, entry.Name())
 		entryDest := filepath.Join(destination, entry.Name())
+		entryRel := path.Join(rel, entry.Name())
+		if fm.Excluded(entryRel) {
+			continue
+		}
 
 		if entry.IsDir() {
-			if err := inst.generateDirectoryCopy(builder, entrySource, entryDest, feb); err != nil {
-				return err
-			}
-		} else {
-			if err := inst.generateFileCopy(builder, entrySource, entryDest, feb); err != nil {
+			entryEnsure := lazyMkdir(builder, entryDest, ensure)
+			if err := inst.generateDirectoryCopy(builder, entrySource, entryDest, entryRel, fm, entryEnsure, feb, conflicts); err != nil {
 				return err
 			}
+			continue
+		}
+		if !fm.Included(entryRel) {
+			continue
+		}
+		if err := ensure(); err != nil {
+			return err
+		}
+		if err := inst.generateFileCopy(builder, entrySource, entryDest, fm.IsTemplate(entryRel), feb, conflicts); err != nil {
+			return err
 		}
 	}
 	return nil
 }
 
+// lazyMkdir returns a function that, on its first call, runs parent and then
+// adds a mkdir for dir unless it already exists. Later calls do nothing.
+func lazyMkdir(builder *instruction.Builder, dir string, parent func() error) func() error {
+	done := false
+	return func() error {
+		if done {
+			return nil
+		}
+		if err := parent(); err != nil {
+			return err
+		}
+		done = true
+		if _, err := os.Stat(dir); os.IsNotExist(err) {
+			return builder.Mkdir(dir)
+		}
+		return nil
+	}
+}
+
+// generateFileCopy adds the copy of source to destination, as a template to
+// render when template is set, according to feb.
 func (inst *Installer) generateFileCopy(
-	builder *instruction.Builder, source, destination, feb string,
+	builder *instruction.Builder, source, destination string, template bool, feb string, conflicts *[]string,
 ) error {
+	copyFile := builder.Copy
+	if template {
+		copyFile = builder.CopyTemplate
+	}
 	if _, err := os.Stat(destination); err == nil {
 		// File exists
 		switch feb {
 		case "DISALLOW":
+			if conflicts != nil {
+				*conflicts = append(*conflicts, filepath.Clean(destination))
+				return nil
+			}
 			return fmt.Errorf("file already exists at %s", destination)
 		case "OVERWRITE":
 			// Add to skip list to prevent cleanup from deleting before overwrite
 			builder.AddSkippedPath(destination)
-			return builder.Copy(source, destination)
+			return copyFile(source, destination)
 		case "RETAIN":
 			builder.AddSkippedPath(destination)
 			return nil // Skip
@@ -240,7 +491,7 @@ func (inst *Installer) generateFileCopy(
 			return fmt.Errorf("invalid file_exists_behavior: %s", feb)
 		}
 	}
-	return builder.Copy(source, destination)
+	return copyFile(source, destination)
 }
 
 func (inst *Installer) fillMissingAncestors(builder *instruction.Builder, destination string) error {
@@ -313,52 +564,165 @@ func (inst *Installer) setPermissions(builder *instruction.Builder, path string,
 	}
 }
 
-func (inst *Installer) executeCommands(commands []instruction.Command, cleanupPath string) error {
-	cleanupFile, err := os.Create(cleanupPath)
-	if err != nil {
-		return err
-	}
-	defer func() { _ = cleanupFile.Close() }()
+// executeCommands runs the install commands and records created paths in the
+// cleanup file, which is written even when a command fails. Copies and
+// directories at or below untracked (when non-empty) are not recorded. Copies
+// and mkdirs are journaled to j before they run.
+//
+// Copies run in parallel. Directories are created inline, before the copies
+// that follow them are started, and permission commands wait for every
+// pending copy; they then run in parallel across paths but in order for each
+// path, since a chown after a chmod clears setuid bits. The cleanup file is
+// written in command order regardless of scheduling.
+func (inst *Installer) executeCommands(commands []instruction.Command, cleanupPath, untracked string, j *journal, record *cleanupRecord) error {
+	err := inst.runCommands(commands, untracked, j, record)
+	if werr := record.write(cleanupPath); werr != nil && err == nil {
+		err = fmt.Errorf("write cleanup: %w", werr)
+	}
+	return err
+}
 
-	bw := bufio.NewWriter(cleanupFile)
-	defer func() { _ = bw.Flush() }()
+// runCommands executes commands for executeCommands. Every copy it started
+// has finished when it returns.
+func (inst *Installer) runCommands(commands []instruction.Command, untracked string, j *journal, record *cleanupRecord) error {
+	pool := newWorkPool(inst.workers)
+	defer pool.Close()
+	var perms permissionBatch
+	flushPerms := func() error {
+		perms.run(pool, inst.applyPermission)
+		perms = permissionBatch{}
+		return pool.Wait()
+	}
 
-	for _, cmd := range commands {
+	for i, cmd := range commands {
 		switch cmd.Type {
 		case instruction.TypeCopy:
-			_, _ = fmt.Fprintln(bw, cmd.Destination)
-			if err := inst.fileOp.Copy(cmd.Source, cmd.Destination); err != nil {
-				return err
+			if perms.pending() {
+				if err := flushPerms(); err != nil {
+					return err
+				}
 			}
-		case instruction.TypeMkdir:
-			if err := inst.fileOp.Mkdir(cmd.Directory); err != nil {
-				return err
+			if !isWithin(cmd.Destination, untracked) {
+				record.track(i)
 			}
-			_, _ = fmt.Fprintln(bw, cmd.Directory)
-		case instruction.TypeChmod:
-			mode, _ := appspec.ParseMode(cmd.Mode)
-			if err := inst.fileOp.Chmod(cmd.File, os.FileMode(mode.Value)); err != nil {
-				return err
+			if cmd.Unchanged {
+				continue
 			}
-		case instruction.TypeChown:
-			if err := inst.fileOp.Chown(cmd.File, cmd.Owner, cmd.Group); err != nil {
+			pool.Go(i, func() error {
+				replaced, err := inst.copyJournaled(j, cmd.CopySource(), cmd.Destination)
+				record.existed[i] = replaced
 				return err
+			})
+		case instruction.TypeMkdir:
+			if perms.pending() {
+				if err := flushPerms(); err != nil {
+					return err
+				}
 			}
-		case instruction.TypeSetfacl:
-			if err := inst.fileOp.SetACL(cmd.File, cmd.ACL); err != nil {
+			_, statErr := os.Lstat(cmd.Directory)
+			if err := inst.mkdirJournaled(j, cmd.Directory); err != nil {
+				_ = pool.Wait()
 				return err
 			}
-		case instruction.TypeSemanage:
-			if cmd.Context == nil {
-				continue
+			if !isWithin(cmd.Directory, untracked) {
+				record.existed[i] = statErr == nil
+				record.track(i)
 			}
-			if err := inst.fileOp.SetContext(cmd.File, cmd.Context.User, cmd.Context.Type, cmd.Context.Range); err != nil {
-				return err
+		case instruction.TypeChmod, instruction.TypeChown, instruction.TypeSetfacl, instruction.TypeSemanage:
+			if cmd.Type == instruction.TypeSemanage {
+				if cmd.Context == nil {
+					continue
+				}
+				record.track(i)
+			}
+			if !perms.pending() {
+				// Permissions apply to copied files; wait for them.
+				if err := pool.Wait(); err != nil {
+					return err
+				}
 			}
-			_, _ = fmt.Fprintf(bw, "semanage\x00%s\n", cmd.File)
+			perms.add(i, cmd)
+		}
+		if pool.failed() {
+			break
 		}
 	}
-	return bw.Flush()
+	return flushPerms()
+}
+
+// logSummary logs what the install did.
+func (inst *Installer) logSummary(commands []instruction.Command) {
+	var copied, unchanged, dirs, perms int
+	for _, c := range commands {
+		switch {
+		case c.Type == instruction.TypeCopy && c.Unchanged:
+			unchanged++
+		case c.Type == instruction.TypeCopy:
+			copied++
+		case c.Type == instruction.TypeMkdir:
+			dirs++
+		default:
+			perms++
+		}
+	}
+	inst.logger.Info("install complete", "copied", copied, "unchanged", unchanged, "directories", dirs, "permissions", perms)
+}
+
+// applyPermission runs a single chmod, chown, setfacl or semanage command.
+func (inst *Installer) applyPermission(cmd instruction.Command) error {
+	switch cmd.Type {
+	case instruction.TypeChmod:
+		mode, _ := appspec.ParseMode(cmd.Mode)
+		return inst.fileOp.Chmod(cmd.File, os.FileMode(mode.Value))
+	case instruction.TypeChown:
+		return inst.fileOp.Chown(cmd.File, cmd.Owner, cmd.Group)
+	case instruction.TypeSetfacl:
+		return inst.fileOp.SetACL(cmd.File, cmd.ACL)
+	case instruction.TypeSemanage:
+		return inst.fileOp.SetContext(cmd.File, cmd.Context.User, cmd.Context.Type, cmd.Context.Range)
+	}
+	return nil
+}
+
+// permissionBatch groups consecutive permission commands by path, keeping
+// the command order within each path.
+type permissionBatch struct {
+	paths  []string
+	byPath map[string][]indexedCommand
+}
+
+type indexedCommand struct {
+	idx int
+	cmd instruction.Command
+}
+
+func (b *permissionBatch) pending() bool { return len(b.paths) > 0 }
+
+func (b *permissionBatch) add(idx int, cmd instruction.Command) {
+	if b.byPath == nil {
+		b.byPath = make(map[string][]indexedCommand)
+	}
+	if _, ok := b.byPath[cmd.File]; !ok {
+		b.paths = append(b.paths, cmd.File)
+	}
+	b.byPath[cmd.File] = append(b.byPath[cmd.File], indexedCommand{idx: idx, cmd: cmd})
+}
+
+// run starts one step per path on pool. A path's commands run in order and
+// stop at the first failure, which is reported at the index of the path's
+// first command.
+func (b *permissionBatch) run(pool *workPool, apply func(instruction.Command) error) {
+	for _, path := range b.paths {
+		cmds := b.byPath[path]
+		pool.Go(cmds[0].idx, func() error {
+			for _, c := range cmds {
+				if err := apply(c.cmd); err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+	}
 }
 
 func findMatches(builder *instruction.Builder, perm appspec.Permission) []string {
@@ -389,6 +753,15 @@ func hasType(types []string, t string) bool {
 	return false
 }
 
+// isWithin reports whether path is dir or below it. An empty dir matches nothing.
+func isWithin(path, dir string) bool {
+	if dir == "" {
+		return false
+	}
+	rel, err := filepath.Rel(dir, path)
+	return err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
+
 func isExistingDir(path string) bool {
 	info, err := os.Stat(path)
 	return err == nil && info.IsDir()
@@ -9,6 +9,7 @@ import (
 	"testing"
 
 	"github.com/gurre/codedeploy-agent-go/logic/appspec"
+	"github.com/gurre/codedeploy-agent-go/logic/instruction"
 )
 
 // TestInstall_SingleFile verifies that a single source file is copied to the
@@ -20,7 +21,7 @@ func TestInstall_SingleFile(t *testing.T) {
 	createFile(t, filepath.Join(archiveDir, "config.txt"), "data")
 
 	mock := newMockFileOp()
-	inst := NewInstaller(mock, slog.Default())
+	inst := NewInstaller(mock, false, IncrementalOff, slog.Default())
 
 	spec := appspec.Spec{
 		Files: []appspec.FileMapping{
@@ -28,7 +29,7 @@ func TestInstall_SingleFile(t *testing.T) {
 		},
 	}
 
-	if err := inst.Install("dg-1", archiveDir, instructionsDir, spec, "OVERWRITE"); err != nil {
+	if err := inst.Install("dg-1", "d-1", archiveDir, instructionsDir, "", spec, "OVERWRITE"); err != nil {
 		t.Fatalf("Install: %v", err)
 	}
 
@@ -60,7 +61,7 @@ func TestInstall_DirectoryCopy(t *testing.T) {
 	createFile(t, filepath.Join(srcDir, "sub", "b.txt"), "b")
 
 	mock := newMockFileOp()
-	inst := NewInstaller(mock, slog.Default())
+	inst := NewInstaller(mock, false, IncrementalOff, slog.Default())
 
 	spec := appspec.Spec{
 		Files: []appspec.FileMapping{
@@ -68,7 +69,7 @@ func TestInstall_DirectoryCopy(t *testing.T) {
 		},
 	}
 
-	if err := inst.Install("dg-1", archiveDir, instructionsDir, spec, "OVERWRITE"); err != nil {
+	if err := inst.Install("dg-1", "d-1", archiveDir, instructionsDir, "", spec, "OVERWRITE"); err != nil {
 		t.Fatalf("Install: %v", err)
 	}
 
@@ -83,6 +84,72 @@ func TestInstall_DirectoryCopy(t *testing.T) {
 	}
 }
 
+// TestInstall_DirectoryCopy_IncludeExclude verifies that a files entry's
+// include and exclude globs select what a directory source copies, that an
+// excluded directory is skipped whole, and that directories holding no
+// included file are not created.
+func TestInstall_DirectoryCopy_IncludeExclude(t *testing.T) {
+	archiveDir, instructionsDir, destDir := setupDirs(t)
+	srcDir := filepath.Join(archiveDir, "app")
+	createFile(t, filepath.Join(srcDir, "index.php"), "index")
+	createFile(t, filepath.Join(srcDir, "README.md"), "docs")
+	createFile(t, filepath.Join(srcDir, "lib", "db.php"), "db")
+	createFile(t, filepath.Join(srcDir, "lib", "tests", "db_test.php"), "test")
+	createFile(t, filepath.Join(srcDir, ".git", "config.php"), "git")
+	createFile(t, filepath.Join(srcDir, "assets", "site.css"), "css")
+	createFile(t, filepath.Join(srcDir, "vendor", "autoload.txt"), "vendor")
+
+	mock := newMockFileOp()
+	inst := NewInstaller(mock, false, IncrementalOff, slog.Default())
+	spec := appspec.Spec{Files: []appspec.FileMapping{{
+		Source:      "app",
+		Destination: destDir,
+		FileFilter: appspec.FileFilter{
+			Include: []string{"**/*.php", "vendor"},
+			Exclude: []string{".git", "**/tests"},
+		},
+	}}}
+	if err := inst.Install("dg-1", "d-1", archiveDir, instructionsDir, "", spec, "DISALLOW"); err != nil {
+		t.Fatalf("Install: %v", err)
+	}
+
+	for _, want := range []string{"index.php", "lib/db.php", "vendor/autoload.txt"} {
+		if !mock.copiedTo(filepath.Join(destDir, filepath.FromSlash(want))) {
+			t.Errorf("expected copy of %s, copies: %v", want, mock.copies)
+		}
+	}
+	if len(mock.copies) != 3 {
+		t.Errorf("copies = %v, want exactly 3", mock.copies)
+	}
+	for _, dir := range []string{"lib", "vendor"} {
+		if !mock.mkdirCalled(filepath.Join(destDir, dir)) {
+			t.Errorf("expected mkdir of %s, mkdirs: %v", dir, mock.mkdirs)
+		}
+	}
+	for _, dir := range []string{"assets", ".git", "lib/tests"} {
+		if mock.mkdirCalled(filepath.Join(destDir, filepath.FromSlash(dir))) {
+			t.Errorf("unexpected mkdir of %s", dir)
+		}
+	}
+}
+
+// TestInstall_FilterOnFileSourceRejected verifies that include and exclude on
+// a single-file source are reported instead of being silently ignored.
+func TestInstall_FilterOnFileSourceRejected(t *testing.T) {
+	archiveDir, instructionsDir, destDir := setupDirs(t)
+	createFile(t, filepath.Join(archiveDir, "app.conf"), "conf")
+
+	inst := NewInstaller(newMockFileOp(), false, IncrementalOff, slog.Default())
+	spec := appspec.Spec{Files: []appspec.FileMapping{{
+		Source: "app.conf", Destination: destDir,
+		FileFilter: appspec.FileFilter{Exclude: []string{"*.bak"}},
+	}}}
+	err := inst.Install("dg-1", "d-1", archiveDir, instructionsDir, "", spec, "DISALLOW")
+	if err == nil || !strings.Contains(err.Error(), "need a directory source") {
+		t.Errorf("Install err = %v, want directory source error", err)
+	}
+}
+
 // TestInstall_FileExistsBehavior_Disallow verifies that the installer returns
 // an error when the destination file already exists and file_exists_behavior is
 // DISALLOW. This prevents accidental overwrites in production deployments where
@@ -93,7 +160,7 @@ func TestInstall_FileExistsBehavior_Disallow(t *testing.T) {
 	createFile(t, filepath.Join(destDir, "app.bin"), "existing")
 
 	mock := newMockFileOp()
-	inst := NewInstaller(mock, slog.Default())
+	inst := NewInstaller(mock, false, IncrementalOff, slog.Default())
 
 	spec := appspec.Spec{
 		Files: []appspec.FileMapping{
@@ -101,7 +168,7 @@ func TestInstall_FileExistsBehavior_Disallow(t *testing.T) {
 		},
 	}
 
-	err := inst.Install("dg-1", archiveDir, instructionsDir, spec, "DISALLOW")
+	err := inst.Install("dg-1", "d-1", archiveDir, instructionsDir, "", spec, "DISALLOW")
 	if err == nil {
 		t.Fatal("expected error for DISALLOW when file exists")
 	}
@@ -119,7 +186,7 @@ func TestInstall_FileExistsBehavior_Overwrite(t *testing.T) {
 	createFile(t, filepath.Join(destDir, "app.bin"), "existing")
 
 	mock := newMockFileOp()
-	inst := NewInstaller(mock, slog.Default())
+	inst := NewInstaller(mock, false, IncrementalOff, slog.Default())
 
 	spec := appspec.Spec{
 		Files: []appspec.FileMapping{
@@ -127,7 +194,7 @@ func TestInstall_FileExistsBehavior_Overwrite(t *testing.T) {
 		},
 	}
 
-	if err := inst.Install("dg-1", archiveDir, instructionsDir, spec, "OVERWRITE"); err != nil {
+	if err := inst.Install("dg-1", "d-1", archiveDir, instructionsDir, "", spec, "OVERWRITE"); err != nil {
 		t.Fatalf("Install: %v", err)
 	}
 
@@ -147,7 +214,7 @@ func TestInstall_FileExistsBehavior_Retain(t *testing.T) {
 	createFile(t, filepath.Join(destDir, "config.yml"), "user-edited")
 
 	mock := newMockFileOp()
-	inst := NewInstaller(mock, slog.Default())
+	inst := NewInstaller(mock, false, IncrementalOff, slog.Default())
 
 	spec := appspec.Spec{
 		Files: []appspec.FileMapping{
@@ -155,7 +222,7 @@ func TestInstall_FileExistsBehavior_Retain(t *testing.T) {
 		},
 	}
 
-	if err := inst.Install("dg-1", archiveDir, instructionsDir, spec, "RETAIN"); err != nil {
+	if err := inst.Install("dg-1", "d-1", archiveDir, instructionsDir, "", spec, "RETAIN"); err != nil {
 		t.Fatalf("Install: %v", err)
 	}
 
@@ -174,7 +241,7 @@ func TestInstall_Permissions(t *testing.T) {
 	createFile(t, filepath.Join(archiveDir, "run.sh"), "#!/bin/sh")
 
 	mock := newMockFileOp()
-	inst := NewInstaller(mock, slog.Default())
+	inst := NewInstaller(mock, false, IncrementalOff, slog.Default())
 
 	destFile := filepath.Join(destDir, "run.sh")
 	mode, err := appspec.ParseMode("0755")
@@ -198,7 +265,7 @@ func TestInstall_Permissions(t *testing.T) {
 		},
 	}
 
-	if err := inst.Install("dg-1", archiveDir, instructionsDir, spec, "OVERWRITE"); err != nil {
+	if err := inst.Install("dg-1", "d-1", archiveDir, instructionsDir, "", spec, "OVERWRITE"); err != nil {
 		t.Fatalf("Install: %v", err)
 	}
 
@@ -218,12 +285,15 @@ func TestInstall_Cleanup(t *testing.T) {
 	createFile(t, filepath.Join(archiveDir, "new.txt"), "new")
 
 	// Write a previous cleanup file with paths to be removed
+	oldDir := filepath.Join(t.TempDir(), "deploy")
+	oldFile := filepath.Join(oldDir, "file.txt")
+	createFile(t, oldFile, "old")
 	cleanupPath := filepath.Join(instructionsDir, "dg-1-cleanup")
-	prevCleanup := "/old/deploy/file.txt\n/old/deploy\n"
+	prevCleanup := oldDir + "\n" + oldFile + "\n"
 	writeFile(t, cleanupPath, prevCleanup)
 
 	mock := newMockFileOp()
-	inst := NewInstaller(mock, slog.Default())
+	inst := NewInstaller(mock, false, IncrementalOff, slog.Default())
 
 	spec := appspec.Spec{
 		Files: []appspec.FileMapping{
@@ -231,17 +301,17 @@ func TestInstall_Cleanup(t *testing.T) {
 		},
 	}
 
-	if err := inst.Install("dg-1", archiveDir, instructionsDir, spec, "OVERWRITE"); err != nil {
+	if err := inst.Install("dg-1", "d-1", archiveDir, instructionsDir, "", spec, "OVERWRITE"); err != nil {
 		t.Fatalf("Install: %v", err)
 	}
 
-	// ParseRemoveCommands reverses the order: /old/deploy first, then /old/deploy/file.txt
-	// But Remove is called in reversed (bottom-up) order: file first, then directory
-	if !mock.removeCalled("/old/deploy/file.txt") {
-		t.Errorf("expected Remove for /old/deploy/file.txt, removes: %v", mock.removes)
+	// The cleanup file lists paths in install order and removal runs in
+	// reverse: the file first, leaving the directory empty so it can go too.
+	if _, err := os.Lstat(oldFile); !os.IsNotExist(err) {
+		t.Errorf("expected %s removed, stat err = %v", oldFile, err)
 	}
-	if !mock.removeCalled("/old/deploy") {
-		t.Errorf("expected Remove for /old/deploy, removes: %v", mock.removes)
+	if _, err := os.Lstat(oldDir); !os.IsNotExist(err) {
+		t.Errorf("expected %s removed, stat err = %v", oldDir, err)
 	}
 }
 
@@ -258,7 +328,7 @@ func TestInstall_MissingAncestors(t *testing.T) {
 	nonExistentBase := filepath.Join(t.TempDir(), "deep", "nested", "dir")
 
 	mock := newMockFileOp()
-	inst := NewInstaller(mock, slog.Default())
+	inst := NewInstaller(mock, false, IncrementalOff, slog.Default())
 
 	spec := appspec.Spec{
 		Files: []appspec.FileMapping{
@@ -266,7 +336,7 @@ func TestInstall_MissingAncestors(t *testing.T) {
 		},
 	}
 
-	if err := inst.Install("dg-1", archiveDir, instructionsDir, spec, "OVERWRITE"); err != nil {
+	if err := inst.Install("dg-1", "d-1", archiveDir, instructionsDir, "", spec, "OVERWRITE"); err != nil {
 		t.Fatalf("Install: %v", err)
 	}
 
@@ -292,7 +362,7 @@ func TestInstall_Permissions_ACL(t *testing.T) {
 	createFile(t, filepath.Join(archiveDir, "data.txt"), "payload")
 
 	mock := newMockFileOp()
-	inst := NewInstaller(mock, slog.Default())
+	inst := NewInstaller(mock, false, IncrementalOff, slog.Default())
 
 	destFile := filepath.Join(destDir, "data.txt")
 	acl, err := appspec.ParseACL([]string{"user:deploy:rwx", "group:web:r-x"})
@@ -314,7 +384,7 @@ func TestInstall_Permissions_ACL(t *testing.T) {
 		},
 	}
 
-	if err := inst.Install("dg-1", archiveDir, instructionsDir, spec, "OVERWRITE"); err != nil {
+	if err := inst.Install("dg-1", "d-1", archiveDir, instructionsDir, "", spec, "OVERWRITE"); err != nil {
 		t.Fatalf("Install: %v", err)
 	}
 
@@ -325,13 +395,13 @@ func TestInstall_Permissions_ACL(t *testing.T) {
 
 // TestInstall_Permissions_Context verifies that SELinux context from the appspec
 // permissions section is applied via SetContext. This exercises the semanage
-// command path and the cleanup file "semanage\x00path" format.
+// command path and the context entry in the cleanup file.
 func TestInstall_Permissions_Context(t *testing.T) {
 	archiveDir, instructionsDir, destDir := setupDirs(t)
 	createFile(t, filepath.Join(archiveDir, "index.html"), "<html>")
 
 	mock := newMockFileOp()
-	inst := NewInstaller(mock, slog.Default())
+	inst := NewInstaller(mock, false, IncrementalOff, slog.Default())
 
 	destFile := filepath.Join(destDir, "index.html")
 	spec := appspec.Spec{
@@ -355,7 +425,7 @@ func TestInstall_Permissions_Context(t *testing.T) {
 		},
 	}
 
-	if err := inst.Install("dg-1", archiveDir, instructionsDir, spec, "OVERWRITE"); err != nil {
+	if err := inst.Install("dg-1", "d-1", archiveDir, instructionsDir, "", spec, "OVERWRITE"); err != nil {
 		t.Fatalf("Install: %v", err)
 	}
 
@@ -369,8 +439,16 @@ func TestInstall_Permissions_Context(t *testing.T) {
 	if err != nil {
 		t.Fatalf("read cleanup file: %v", err)
 	}
-	if !strings.Contains(string(data), "semanage\x00"+destFile) {
-		t.Errorf("cleanup file should contain semanage entry for %q, got: %q", destFile, data)
+	cleanup, err := instruction.ParseCleanup(data)
+	if err != nil {
+		t.Fatalf("parse cleanup file: %v", err)
+	}
+	found := false
+	for _, e := range cleanup.Entries {
+		found = found || (e.Type == instruction.EntryContext && e.Path == destFile)
+	}
+	if !found {
+		t.Errorf("cleanup file should contain context entry for %q, got: %s", destFile, data)
 	}
 }
 
@@ -387,7 +465,7 @@ func TestInstall_Permissions_DirectoryMatch(t *testing.T) {
 	createFile(t, filepath.Join(srcDir, "config.yml"), "key: val")
 
 	mock := newMockFileOp()
-	inst := NewInstaller(mock, slog.Default())
+	inst := NewInstaller(mock, false, IncrementalOff, slog.Default())
 
 	mode, _ := appspec.ParseMode("0755")
 	spec := appspec.Spec{
@@ -405,7 +483,7 @@ func TestInstall_Permissions_DirectoryMatch(t *testing.T) {
 		},
 	}
 
-	if err := inst.Install("dg-1", archiveDir, instructionsDir, spec, "OVERWRITE"); err != nil {
+	if err := inst.Install("dg-1", "d-1", archiveDir, instructionsDir, "", spec, "OVERWRITE"); err != nil {
 		t.Fatalf("Install: %v", err)
 	}
 
@@ -424,12 +502,14 @@ func TestInstall_CleanupWithSemanage(t *testing.T) {
 	archiveDir, instructionsDir, destDir := setupDirs(t)
 	createFile(t, filepath.Join(archiveDir, "new.txt"), "new")
 
+	oldFile := filepath.Join(t.TempDir(), "file.txt")
+	createFile(t, oldFile, "old")
 	cleanupPath := filepath.Join(instructionsDir, "dg-1-cleanup")
-	prevCleanup := "/old/file.txt\nsemanage\x00/old/context.html\n"
+	prevCleanup := oldFile + "\nsemanage\x00/old/context.html\n"
 	writeFile(t, cleanupPath, prevCleanup)
 
 	mock := newMockFileOp()
-	inst := NewInstaller(mock, slog.Default())
+	inst := NewInstaller(mock, false, IncrementalOff, slog.Default())
 
 	spec := appspec.Spec{
 		Files: []appspec.FileMapping{
@@ -437,12 +517,12 @@ func TestInstall_CleanupWithSemanage(t *testing.T) {
 		},
 	}
 
-	if err := inst.Install("dg-1", archiveDir, instructionsDir, spec, "OVERWRITE"); err != nil {
+	if err := inst.Install("dg-1", "d-1", archiveDir, instructionsDir, "", spec, "OVERWRITE"); err != nil {
 		t.Fatalf("Install: %v", err)
 	}
 
-	if !mock.removeCalled("/old/file.txt") {
-		t.Errorf("expected Remove for /old/file.txt, removes: %v", mock.removes)
+	if _, err := os.Lstat(oldFile); !os.IsNotExist(err) {
+		t.Errorf("expected %s removed, stat err = %v", oldFile, err)
 	}
 	if !mock.removeContextCalled("/old/context.html") {
 		t.Errorf("expected RemoveContext for /old/context.html, removeContexts: %v", mock.removeContexts)
@@ -462,7 +542,7 @@ func TestInstall_AppspecFEB_OverwriteOverridesDisallow(t *testing.T) {
 	createFile(t, filepath.Join(destDir, "app.bin"), "existing-content")
 
 	mock := newMockFileOp()
-	inst := NewInstaller(mock, slog.Default())
+	inst := NewInstaller(mock, false, IncrementalOff, slog.Default())
 
 	spec := appspec.Spec{
 		Files: []appspec.FileMapping{
@@ -471,7 +551,7 @@ func TestInstall_AppspecFEB_OverwriteOverridesDisallow(t *testing.T) {
 		FileExistsBehavior: "OVERWRITE",
 	}
 
-	err := inst.Install("dg-1", archiveDir, instructionsDir, spec, "DISALLOW")
+	err := inst.Install("dg-1", "d-1", archiveDir, instructionsDir, "", spec, "DISALLOW")
 	if err != nil {
 		t.Fatalf("Install should succeed when appspec OVERWRITE overrides param DISALLOW: %v", err)
 	}
@@ -493,7 +573,7 @@ func TestInstall_AppspecFEB_RetainOverridesDisallow(t *testing.T) {
 	createFile(t, filepath.Join(destDir, "config.yml"), "user-config")
 
 	mock := newMockFileOp()
-	inst := NewInstaller(mock, slog.Default())
+	inst := NewInstaller(mock, false, IncrementalOff, slog.Default())
 
 	spec := appspec.Spec{
 		Files: []appspec.FileMapping{
@@ -502,7 +582,7 @@ func TestInstall_AppspecFEB_RetainOverridesDisallow(t *testing.T) {
 		FileExistsBehavior: "RETAIN",
 	}
 
-	err := inst.Install("dg-1", archiveDir, instructionsDir, spec, "DISALLOW")
+	err := inst.Install("dg-1", "d-1", archiveDir, instructionsDir, "", spec, "DISALLOW")
 	if err != nil {
 		t.Fatalf("Install should succeed when appspec RETAIN overrides param DISALLOW: %v", err)
 	}
@@ -524,7 +604,7 @@ func TestInstall_AppspecFEB_DisallowOverridesOverwrite(t *testing.T) {
 	createFile(t, filepath.Join(destDir, "app.bin"), "existing-content")
 
 	mock := newMockFileOp()
-	inst := NewInstaller(mock, slog.Default())
+	inst := NewInstaller(mock, false, IncrementalOff, slog.Default())
 
 	spec := appspec.Spec{
 		Files: []appspec.FileMapping{
@@ -533,7 +613,7 @@ func TestInstall_AppspecFEB_DisallowOverridesOverwrite(t *testing.T) {
 		FileExistsBehavior: "DISALLOW",
 	}
 
-	err := inst.Install("dg-1", archiveDir, instructionsDir, spec, "OVERWRITE")
+	err := inst.Install("dg-1", "d-1", archiveDir, instructionsDir, "", spec, "OVERWRITE")
 	if err == nil {
 		t.Fatal("expected error when appspec DISALLOW overrides param OVERWRITE")
 	}
@@ -542,13 +622,44 @@ func TestInstall_AppspecFEB_DisallowOverridesOverwrite(t *testing.T) {
 	}
 }
 
+// TestInstall_MappingFEB_RetainsConfigOverwritesCode verifies that a files
+// entry's own file_exists_behavior beats the appspec and deployment settings,
+// so one deployment can overwrite code while keeping host-edited config.
+func TestInstall_MappingFEB_RetainsConfigOverwritesCode(t *testing.T) {
+	archiveDir, instructionsDir, destDir := setupDirs(t)
+	createFile(t, filepath.Join(archiveDir, "app", "main.py"), "new code")
+	createFile(t, filepath.Join(archiveDir, "config", "app.conf"), "new config")
+	createFile(t, filepath.Join(destDir, "app", "main.py"), "old code")
+	createFile(t, filepath.Join(destDir, "config", "app.conf"), "edited config")
+
+	mock := newMockFileOp()
+	inst := NewInstaller(mock, false, IncrementalOff, slog.Default())
+	spec := appspec.Spec{
+		Files: []appspec.FileMapping{
+			{Source: "app", Destination: filepath.Join(destDir, "app")},
+			{Source: "config", Destination: filepath.Join(destDir, "config"), FileExistsBehavior: "RETAIN"},
+		},
+		FileExistsBehavior: "OVERWRITE",
+	}
+
+	if err := inst.Install("dg-1", "d-1", archiveDir, instructionsDir, "", spec, "DISALLOW"); err != nil {
+		t.Fatalf("Install: %v", err)
+	}
+	if !mock.copiedTo(filepath.Join(destDir, "app", "main.py")) {
+		t.Errorf("main.py should be overwritten, copies: %v", mock.copies)
+	}
+	if mock.copiedTo(filepath.Join(destDir, "config", "app.conf")) {
+		t.Error("app.conf should be retained")
+	}
+}
+
 // TestInstall_SourceNotFound verifies that a missing source file produces a
 // clear error rather than proceeding with a broken install.
 func TestInstall_SourceNotFound(t *testing.T) {
 	archiveDir, instructionsDir, destDir := setupDirs(t)
 
 	mock := newMockFileOp()
-	inst := NewInstaller(mock, slog.Default())
+	inst := NewInstaller(mock, false, IncrementalOff, slog.Default())
 
 	spec := appspec.Spec{
 		Files: []appspec.FileMapping{
@@ -556,7 +667,7 @@ func TestInstall_SourceNotFound(t *testing.T) {
 		},
 	}
 
-	err := inst.Install("dg-1", archiveDir, instructionsDir, spec, "OVERWRITE")
+	err := inst.Install("dg-1", "d-1", archiveDir, instructionsDir, "", spec, "OVERWRITE")
 	if err == nil {
 		t.Fatal("expected error for missing source file")
 	}
@@ -586,7 +697,7 @@ func TestInstall_FileExistsBehavior_OverwriteWithCleanup(t *testing.T) {
 	createFile(t, filepath.Join(archiveDir1, "testfile.txt"), "INITIAL")
 
 	mock1 := newMockFileOp()
-	inst1 := NewInstaller(mock1, slog.Default())
+	inst1 := NewInstaller(mock1, false, IncrementalOff, slog.Default())
 
 	destFile := filepath.Join(destDir, "testfile.txt")
 	spec1 := appspec.Spec{
@@ -596,7 +707,7 @@ func TestInstall_FileExistsBehavior_OverwriteWithCleanup(t *testing.T) {
 	}
 
 	// Deployment 1 uses DISALLOW (default) to create the file
-	if err := inst1.Install("dg-1", archiveDir1, instructionsDir, spec1, "DISALLOW"); err != nil {
+	if err := inst1.Install("dg-1", "d-1", archiveDir1, instructionsDir, "", spec1, "DISALLOW"); err != nil {
 		t.Fatalf("Deployment 1 Install: %v", err)
 	}
 
@@ -622,7 +733,7 @@ func TestInstall_FileExistsBehavior_OverwriteWithCleanup(t *testing.T) {
 	createFile(t, filepath.Join(archiveDir2, "testfile.txt"), "OVERWRITTEN")
 
 	mock2 := newMockFileOp()
-	inst2 := NewInstaller(mock2, slog.Default())
+	inst2 := NewInstaller(mock2, false, IncrementalOff, slog.Default())
 
 	spec2 := appspec.Spec{
 		Files: []appspec.FileMapping{
@@ -632,7 +743,7 @@ func TestInstall_FileExistsBehavior_OverwriteWithCleanup(t *testing.T) {
 	}
 
 	// Deployment 2 uses OVERWRITE to replace the file
-	if err := inst2.Install("dg-1", archiveDir2, instructionsDir, spec2, "DISALLOW"); err != nil {
+	if err := inst2.Install("dg-1", "d-2", archiveDir2, instructionsDir, "", spec2, "DISALLOW"); err != nil {
 		t.Fatalf("Deployment 2 Install: %v", err)
 	}
 
@@ -663,7 +774,7 @@ func TestInstall_FileExistsBehavior_RetainWithCleanup(t *testing.T) {
 	createFile(t, filepath.Join(archiveDir, "testfile.txt"), "deployment-2-content")
 
 	mock := newMockFileOp()
-	inst := NewInstaller(mock, slog.Default())
+	inst := NewInstaller(mock, false, IncrementalOff, slog.Default())
 
 	destFile := filepath.Join(destDir, "testfile.txt")
 	spec := appspec.Spec{
@@ -673,7 +784,7 @@ func TestInstall_FileExistsBehavior_RetainWithCleanup(t *testing.T) {
 	}
 
 	// Deployment 2: OVERWRITE (creates the file)
-	if err := inst.Install("dg-1", archiveDir, instructionsDir, spec, "OVERWRITE"); err != nil {
+	if err := inst.Install("dg-1", "d-1", archiveDir, instructionsDir, "", spec, "OVERWRITE"); err != nil {
 		t.Fatalf("Deployment 2 Install: %v", err)
 	}
 
@@ -692,7 +803,7 @@ func TestInstall_FileExistsBehavior_RetainWithCleanup(t *testing.T) {
 
 	// Reset mock to track deployment 3 calls separately
 	mock2 := newMockFileOp()
-	inst2 := NewInstaller(mock2, slog.Default())
+	inst2 := NewInstaller(mock2, false, IncrementalOff, slog.Default())
 
 	// Deployment 3: RETAIN (should preserve deployment 2 file)
 	spec3 := appspec.Spec{
@@ -702,7 +813,7 @@ func TestInstall_FileExistsBehavior_RetainWithCleanup(t *testing.T) {
 		FileExistsBehavior: "RETAIN",
 	}
 
-	if err := inst2.Install("dg-1", archiveDir2, instructionsDir, spec3, "OVERWRITE"); err != nil {
+	if err := inst2.Install("dg-1", "d-3", archiveDir2, instructionsDir, "", spec3, "OVERWRITE"); err != nil {
 		t.Fatalf("Deployment 3 Install: %v", err)
 	}
 
@@ -794,6 +905,8 @@ type mockFileOp struct {
 	contexts       []string
 	removes        []string
 	removeContexts []string
+	removeAlls     []string
+	renames        []string
 }
 
 func newMockFileOp() *mockFileOp {
@@ -872,6 +985,24 @@ func (m *mockFileOp) Remove(path string) error {
 	return nil
 }
 
+func (m *mockFileOp) RemoveAll(path string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.removeAlls = append(m.removeAlls, path)
+	return os.RemoveAll(path)
+}
+
+func (m *mockFileOp) Symlink(target, link string) error {
+	return os.Symlink(target, link)
+}
+
+func (m *mockFileOp) Rename(oldPath, newPath string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.renames = append(m.renames, newPath)
+	return os.Rename(oldPath, newPath)
+}
+
 func (m *mockFileOp) copiedTo(destination string) bool {
 	m.mu.Lock()
 	defer m.mu.Unlock()
@@ -0,0 +1,420 @@
+package installer
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+
+	json "github.com/goccy/go-json"
+
+	"github.com/gurre/codedeploy-agent-go/logic/instruction"
+)
+
+// journalOp identifies an undoable install step.
+type journalOp string
+
+const (
+	// journalCreate records a file or symlink copied to a path that did not exist.
+	journalCreate journalOp = "create"
+	// journalMkdir records a directory created by the install.
+	journalMkdir journalOp = "mkdir"
+	// journalReplace records an existing file moved to Backup before being overwritten.
+	journalReplace journalOp = "replace"
+	// journalRemove records a path of the previous deployment moved to Backup
+	// by cleanup. A directory that could not be moved is recreated with Mode,
+	// and a file context is added back from Context.
+	journalRemove journalOp = "remove"
+	// journalSave records a copy of an install state file in Backup, or with
+	// no Backup that the file did not exist.
+	journalSave journalOp = "save"
+)
+
+// journalEntry is one line of the undo journal.
+type journalEntry struct {
+	Op      journalOp               `json:"op"`
+	Path    string                  `json:"path"`
+	Backup  string                  `json:"backup,omitempty"`
+	Mode    os.FileMode             `json:"mode,omitempty"`
+	Context *instruction.ContextCmd `json:"context,omitempty"`
+}
+
+const journalSuffix = "-install.journal"
+
+// journalPath returns the undo journal for a deployment group, kept next to
+// its <group>-install.json.
+func journalPath(instructionsDir, deploymentGroupID string) string {
+	return filepath.Join(instructionsDir, deploymentGroupID+journalSuffix)
+}
+
+// journalBackupDir returns the scratch directory holding what rollback needs
+// and commit discards.
+func journalBackupDir(journalFile string) string {
+	return strings.TrimSuffix(journalFile, ".journal") + "-backup"
+}
+
+// journal is a write-ahead undo log. Each entry is flushed before the step it
+// describes is performed, so a crash leaves at most one entry whose step did
+// not happen; undo tolerates that.
+//
+// Copies run in parallel, so the journal is safe for concurrent use.
+type journal struct {
+	mu         sync.Mutex
+	file       *os.File
+	bw         *bufio.Writer
+	backupDir  string
+	scratchDir string
+	made       map[string]bool
+	replaced   []journalEntry
+	backups    int
+}
+
+// openJournal creates the journal at path. Originals of overwritten files are
+// moved to backupDir, or to a scratch directory next to the journal when
+// backupDir is empty. A non-empty backupDir survives commit. Paths removed by
+// cleanup and saved state files always go to the scratch directory.
+func openJournal(path, backupDir string) (*journal, error) {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0o644)
+	if err != nil {
+		return nil, err
+	}
+	j := &journal{
+		file:       f,
+		bw:         bufio.NewWriter(f),
+		backupDir:  backupDir,
+		scratchDir: journalBackupDir(path),
+		made:       make(map[string]bool, 2),
+	}
+	if j.backupDir == "" {
+		j.backupDir = j.scratchDir
+	}
+	return j, nil
+}
+
+// record appends an entry and flushes it to the OS. The journal guards
+// against agent crashes, not power loss, so it does not fsync per entry.
+func (j *journal) record(e journalEntry) error {
+	data, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	if e.Op == journalReplace {
+		j.replaced = append(j.replaced, e)
+	}
+	if _, err := j.bw.Write(append(data, '\n')); err != nil {
+		return err
+	}
+	return j.bw.Flush()
+}
+
+// nextBackup returns a fresh path in the backup directory, creating it on
+// first use.
+func (j *journal) nextBackup() (string, error) {
+	return j.next(j.backupDir)
+}
+
+// nextScratch returns a fresh path in the scratch directory, creating it on
+// first use.
+func (j *journal) nextScratch() (string, error) {
+	return j.next(j.scratchDir)
+}
+
+func (j *journal) next(dir string) (string, error) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	if !j.made[dir] {
+		if err := os.MkdirAll(dir, 0o700); err != nil {
+			return "", err
+		}
+		j.made[dir] = true
+	}
+	j.backups++
+	return filepath.Join(dir, strconv.Itoa(j.backups)), nil
+}
+
+func (j *journal) close() error {
+	return j.file.Close()
+}
+
+// commit discards the journal after a successful install, along with the
+// scratch directory. A persistent backup directory is kept.
+func (j *journal) commit() {
+	_ = j.close()
+	_ = os.RemoveAll(j.scratchDir)
+	_ = os.Remove(j.file.Name())
+}
+
+// copyJournaled copies source to destination, journaling whether the
+// destination was created or replaced, and reports whether it was replaced.
+// An existing file is moved into the backup directory first so that rollback
+// can put it back.
+func (inst *Installer) copyJournaled(j *journal, source, destination string) (bool, error) {
+	if _, err := os.Lstat(destination); err != nil {
+		if err := j.record(journalEntry{Op: journalCreate, Path: destination}); err != nil {
+			return false, fmt.Errorf("journal: %w", err)
+		}
+		return false, inst.fileOp.Copy(source, destination)
+	}
+
+	backup, err := j.nextBackup()
+	if err != nil {
+		return true, fmt.Errorf("journal: %w", err)
+	}
+	entry := journalEntry{Op: journalReplace, Path: destination, Backup: backup}
+	if err := j.record(entry); err != nil {
+		return true, fmt.Errorf("journal: %w", err)
+	}
+	if err := inst.moveFile(destination, backup); err != nil {
+		return true, fmt.Errorf("backup %s: %w", destination, err)
+	}
+	return true, inst.fileOp.Copy(source, destination)
+}
+
+// mkdirJournaled creates a directory and then journals it. Mkdir fails on an
+// existing path, so only a directory this install created is ever journaled;
+// a crash between the two leaves an empty directory behind.
+func (inst *Installer) mkdirJournaled(j *journal, dir string) error {
+	if err := inst.fileOp.Mkdir(dir); err != nil {
+		return err
+	}
+	if err := j.record(journalEntry{Op: journalMkdir, Path: dir}); err != nil {
+		return fmt.Errorf("journal: %w", err)
+	}
+	return nil
+}
+
+// saveJournaled keeps a copy of an install state file in the scratch
+// directory so that rollback can restore it. The entry is recorded once the
+// copy is complete; a missing file is recorded without a backup, which makes
+// rollback remove whatever the install writes there.
+func (inst *Installer) saveJournaled(j *journal, path string) error {
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		if err := j.record(journalEntry{Op: journalSave, Path: path}); err != nil {
+			return fmt.Errorf("journal: %w", err)
+		}
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	backup, err := j.nextScratch()
+	if err != nil {
+		return fmt.Errorf("journal: %w", err)
+	}
+	if err := os.WriteFile(backup, data, 0o600); err != nil {
+		return fmt.Errorf("journal: %w", err)
+	}
+	if err := j.record(journalEntry{Op: journalSave, Path: path, Backup: backup}); err != nil {
+		return fmt.Errorf("journal: %w", err)
+	}
+	return nil
+}
+
+// removeJournaled removes a path of the previous deployment by moving it into
+// the scratch directory, so that rollback can move it back. Missing paths and
+// non-empty directories are left alone, as Remove would. A directory that
+// cannot be moved is removed and recreated with its mode on rollback. Only
+// journal failures are returned; a path that cannot be removed is logged and
+// left in place.
+func (inst *Installer) removeJournaled(j *journal, path string) error {
+	info, err := os.Lstat(path)
+	if err != nil {
+		return nil
+	}
+	if info.IsDir() {
+		if names, err := os.ReadDir(path); err != nil || len(names) > 0 {
+			return nil
+		}
+	}
+	backup, err := j.nextScratch()
+	if err != nil {
+		return fmt.Errorf("journal: %w", err)
+	}
+	entry := journalEntry{Op: journalRemove, Path: path, Backup: backup}
+	if info.IsDir() {
+		entry.Mode = info.Mode().Perm()
+	}
+	if err := j.record(entry); err != nil {
+		return fmt.Errorf("journal: %w", err)
+	}
+	if info.IsDir() {
+		if err = inst.fileOp.Rename(path, backup); err != nil {
+			err = inst.fileOp.Remove(path)
+		}
+	} else {
+		err = inst.moveFile(path, backup)
+	}
+	if err != nil {
+		inst.logger.Warn("cleanup failed to remove path", "path", path, "error", err)
+	}
+	return nil
+}
+
+// removeContextJournaled removes the file context rule for path, journaling
+// the rule the previous install added so that rollback can add it back.
+// Removal is best effort, as for removeJournaled.
+func (inst *Installer) removeContextJournaled(j *journal, path string, ctx *instruction.ContextCmd) error {
+	if err := j.record(journalEntry{Op: journalRemove, Path: path, Context: ctx}); err != nil {
+		return fmt.Errorf("journal: %w", err)
+	}
+	_ = inst.fileOp.RemoveContext(path)
+	return nil
+}
+
+// moveFile renames src to dst, falling back to copy-and-remove when they are
+// on different filesystems.
+func (inst *Installer) moveFile(src, dst string) error {
+	if err := inst.fileOp.Rename(src, dst); err == nil {
+		return nil
+	}
+	if err := inst.fileOp.Copy(src, dst); err != nil {
+		return err
+	}
+	return inst.fileOp.Remove(src)
+}
+
+// rollback replays a journal in reverse, removing created paths, restoring
+// replaced and removed paths from their backups and putting back saved state
+// files, then discards the journal.
+// Undo is best effort: every entry is attempted and the first failure is
+// returned.
+func (inst *Installer) rollback(path string) error {
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	entries := parseJournal(data)
+
+	var firstErr error
+	for i := len(entries) - 1; i >= 0; i-- {
+		e := entries[i]
+		var err error
+		switch e.Op {
+		case journalCreate, journalMkdir:
+			// Reverse order removes children before their directories.
+			err = inst.fileOp.Remove(e.Path)
+		case journalReplace:
+			// A missing backup means the crash happened before the original
+			// was moved, so the original is still in place.
+			if _, statErr := os.Lstat(e.Backup); statErr != nil {
+				continue
+			}
+			if err = inst.fileOp.Remove(e.Path); err == nil {
+				err = inst.moveFile(e.Backup, e.Path)
+			}
+			// Drop the backup directory once it is empty; a persistent
+			// backup directory has nothing left to restore.
+			_ = os.Remove(filepath.Dir(e.Backup))
+		case journalRemove:
+			err = inst.undoRemove(e)
+		case journalSave:
+			err = undoSave(e)
+		}
+		if err != nil {
+			inst.logger.Warn("rollback step failed", "op", e.Op, "path", e.Path, "error", err)
+			if firstErr == nil {
+				firstErr = err
+			}
+		}
+	}
+	inst.logger.Info("install rolled back", "journal", path, "steps", len(entries))
+
+	_ = os.RemoveAll(journalBackupDir(path))
+	if err := os.Remove(path); err != nil && firstErr == nil {
+		firstErr = err
+	}
+	return firstErr
+}
+
+// undoRemove puts back a path removed by cleanup. A path that still exists
+// was never moved.
+func (inst *Installer) undoRemove(e journalEntry) error {
+	if e.Context != nil {
+		return inst.fileOp.SetContext(e.Path, e.Context.User, e.Context.Type, e.Context.Range)
+	}
+	if e.Backup == "" {
+		inst.logger.Warn("cannot restore file context, previous rule unknown", "path", e.Path)
+		return nil
+	}
+	if _, err := os.Lstat(e.Path); err == nil {
+		return nil
+	}
+	if _, err := os.Lstat(e.Backup); err == nil {
+		if e.Mode != 0 {
+			return inst.fileOp.Rename(e.Backup, e.Path)
+		}
+		return inst.moveFile(e.Backup, e.Path)
+	}
+	if e.Mode == 0 {
+		return nil
+	}
+	if err := inst.fileOp.Mkdir(e.Path); err != nil {
+		return err
+	}
+	return inst.fileOp.Chmod(e.Path, e.Mode)
+}
+
+// undoSave restores a saved install state file, or removes it when it did
+// not exist before the install. A missing backup means the crash happened
+// before the file was saved, so it is still unchanged.
+func undoSave(e journalEntry) error {
+	if e.Backup == "" {
+		if err := os.Remove(e.Path); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		return nil
+	}
+	data, err := os.ReadFile(e.Backup)
+	if err != nil {
+		return nil
+	}
+	return os.WriteFile(e.Path, data, 0o644)
+}
+
+// parseJournal decodes journal lines. A torn final line from a crash
+// mid-write is ignored.
+func parseJournal(data []byte) []journalEntry {
+	lines := bytes.Split(data, []byte("\n"))
+	entries := make([]journalEntry, 0, len(lines))
+	for _, line := range lines {
+		if len(bytes.TrimSpace(line)) == 0 {
+			continue
+		}
+		var e journalEntry
+		if err := json.Unmarshal(line, &e); err != nil {
+			continue
+		}
+		entries = append(entries, e)
+	}
+	return entries
+}
+
+// Recover rolls back every install interrupted by an agent crash, as found
+// by leftover undo journals in instructionsDir. Call it on startup before
+// processing new deployments.
+//
+//	if err := inst.Recover(deployment.InstructionsDir(cfg.RootDir)); err != nil { ... }
+func (inst *Installer) Recover(instructionsDir string) error {
+	matches, err := filepath.Glob(filepath.Join(instructionsDir, "*"+journalSuffix))
+	if err != nil {
+		return fmt.Errorf("installer: recover: %w", err)
+	}
+	var firstErr error
+	for _, path := range matches {
+		inst.logger.Warn("rolling back interrupted install", "journal", path)
+		if err := inst.rollback(path); err != nil && firstErr == nil {
+			firstErr = fmt.Errorf("installer: recover %s: %w", path, err)
+		}
+	}
+	return firstErr
+}
@@ -0,0 +1,291 @@
+package installer
+
+import (
+	"errors"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/gurre/codedeploy-agent-go/logic/appspec"
+)
+
+// TestInstall_FailureRollsBack verifies that when a command fails after files
+// were copied, new files and directories are removed and overwritten
+// originals are restored, so the host is left as it was before Install.
+func TestInstall_FailureRollsBack(t *testing.T) {
+	archiveDir, instructionsDir, destDir := setupDirs(t)
+	createFile(t, filepath.Join(archiveDir, "app", "existing.txt"), "new")
+	createFile(t, filepath.Join(archiveDir, "app", "sub", "added.txt"), "added")
+	createFile(t, filepath.Join(destDir, "existing.txt"), "original")
+
+	op := &diskFileOp{mockFileOp: newMockFileOp(), failChown: true}
+	inst := NewInstaller(op, false, IncrementalOff, slog.Default())
+	spec := appspec.Spec{
+		Files: []appspec.FileMapping{{Source: "app", Destination: destDir}},
+		Permissions: []appspec.Permission{{
+			Object: destDir, Pattern: "**", Type: []string{"file"}, Owner: "deploy",
+		}},
+	}
+
+	if err := inst.Install("dg-1", "d-1", archiveDir, instructionsDir, "", spec, "OVERWRITE"); err == nil {
+		t.Fatal("expected install error from chown")
+	}
+
+	got, err := os.ReadFile(filepath.Join(destDir, "existing.txt"))
+	if err != nil || string(got) != "original" {
+		t.Errorf("existing.txt = %q (%v), want original content restored", got, err)
+	}
+	if _, err := os.Lstat(filepath.Join(destDir, "sub")); !os.IsNotExist(err) {
+		t.Errorf("created directory should be removed, Lstat err = %v", err)
+	}
+	assertNoJournal(t, instructionsDir, "dg-1")
+}
+
+// TestInstall_SuccessDiscardsJournal verifies that a successful install
+// leaves neither the journal nor backups behind, so the next startup does
+// not roll it back.
+func TestInstall_SuccessDiscardsJournal(t *testing.T) {
+	archiveDir, instructionsDir, destDir := setupDirs(t)
+	createFile(t, filepath.Join(archiveDir, "config.txt"), "new")
+	createFile(t, filepath.Join(destDir, "config.txt"), "old")
+
+	inst := NewInstaller(&diskFileOp{mockFileOp: newMockFileOp()}, false, IncrementalOff, slog.Default())
+	spec := appspec.Spec{Files: []appspec.FileMapping{{Source: "config.txt", Destination: destDir}}}
+	if err := inst.Install("dg-1", "d-1", archiveDir, instructionsDir, "", spec, "OVERWRITE"); err != nil {
+		t.Fatalf("Install: %v", err)
+	}
+
+	got, _ := os.ReadFile(filepath.Join(destDir, "config.txt"))
+	if string(got) != "new" {
+		t.Errorf("config.txt = %q, want new", got)
+	}
+	assertNoJournal(t, instructionsDir, "dg-1")
+}
+
+// TestRecover_ReplaysCrashedInstall verifies that a journal left by a crash
+// is replayed on startup: the created file is removed and the backed-up
+// original is moved back. The torn final line, written as the agent died, is
+// ignored.
+func TestRecover_ReplaysCrashedInstall(t *testing.T) {
+	_, instructionsDir, destDir := setupDirs(t)
+	journalFile := journalPath(instructionsDir, "dg-1")
+	backup := filepath.Join(journalBackupDir(journalFile), "1")
+
+	created := filepath.Join(destDir, "created.txt")
+	replaced := filepath.Join(destDir, "replaced.txt")
+	createFile(t, created, "new")
+	createFile(t, replaced, "new")
+	createFile(t, backup, "original")
+	writeFile(t, journalFile,
+		`{"op":"create","path":"`+created+`"}`+"\n"+
+			`{"op":"replace","path":"`+replaced+`","backup":"`+backup+`"}`+"\n"+
+			`{"op":"crea`)
+
+	inst := NewInstaller(&diskFileOp{mockFileOp: newMockFileOp()}, false, IncrementalOff, slog.Default())
+	if err := inst.Recover(instructionsDir); err != nil {
+		t.Fatalf("Recover: %v", err)
+	}
+
+	if _, err := os.Lstat(created); !os.IsNotExist(err) {
+		t.Errorf("created file should be removed, Lstat err = %v", err)
+	}
+	got, err := os.ReadFile(replaced)
+	if err != nil || string(got) != "original" {
+		t.Errorf("replaced.txt = %q (%v), want original", got, err)
+	}
+	assertNoJournal(t, instructionsDir, "dg-1")
+}
+
+// TestRecover_MissingBackupKeepsOriginal verifies that a replace entry whose
+// backup was never written (crash between journaling and moving) leaves the
+// destination untouched, since it still holds the original.
+func TestRecover_MissingBackupKeepsOriginal(t *testing.T) {
+	_, instructionsDir, destDir := setupDirs(t)
+	journalFile := journalPath(instructionsDir, "dg-1")
+	target := filepath.Join(destDir, "app.conf")
+	createFile(t, target, "original")
+	writeFile(t, journalFile, `{"op":"replace","path":"`+target+`","backup":"/nonexistent/1"}`+"\n")
+
+	inst := NewInstaller(&diskFileOp{mockFileOp: newMockFileOp()}, false, IncrementalOff, slog.Default())
+	if err := inst.Recover(instructionsDir); err != nil {
+		t.Fatalf("Recover: %v", err)
+	}
+	got, err := os.ReadFile(target)
+	if err != nil || string(got) != "original" {
+		t.Errorf("app.conf = %q (%v), want original", got, err)
+	}
+}
+
+// TestInstall_FailureRestoresPreviousDeployment verifies that a failed
+// install puts back what cleanup removed of the previous deployment, along
+// with the group's install and cleanup files, so that later verification and
+// ownership checks see the deployment that is actually on disk.
+func TestInstall_FailureRestoresPreviousDeployment(t *testing.T) {
+	archiveDir, instructionsDir, destDir := setupDirs(t)
+	createFile(t, filepath.Join(archiveDir, "app", "old", "a.txt"), "v1")
+	op := &diskFileOp{mockFileOp: newMockFileOp()}
+	inst := NewInstaller(op, false, IncrementalOff, slog.Default())
+	spec := appspec.Spec{Files: []appspec.FileMapping{{Source: "app", Destination: destDir}}}
+	if err := inst.Install("dg-1", "d-1", archiveDir, instructionsDir, "", spec, "OVERWRITE"); err != nil {
+		t.Fatalf("first Install: %v", err)
+	}
+	installPath := filepath.Join(instructionsDir, "dg-1-install.json")
+	cleanupPath := filepath.Join(instructionsDir, "dg-1-cleanup")
+	installBefore, _ := os.ReadFile(installPath)
+	cleanupBefore, _ := os.ReadFile(cleanupPath)
+
+	archive2 := t.TempDir()
+	createFile(t, filepath.Join(archive2, "app", "new.txt"), "v2")
+	op.failChown = true
+	spec.Permissions = []appspec.Permission{{Object: destDir, Pattern: "**", Type: []string{"file"}, Owner: "deploy"}}
+	if err := inst.Install("dg-1", "d-2", archive2, instructionsDir, "", spec, "OVERWRITE"); err == nil {
+		t.Fatal("expected install error from chown")
+	}
+
+	got, err := os.ReadFile(filepath.Join(destDir, "old", "a.txt"))
+	if err != nil || string(got) != "v1" {
+		t.Errorf("old/a.txt = %q (%v), want previous deployment restored", got, err)
+	}
+	if _, err := os.Lstat(filepath.Join(destDir, "new.txt")); !os.IsNotExist(err) {
+		t.Errorf("new.txt should be removed, Lstat err = %v", err)
+	}
+	if got, _ := os.ReadFile(installPath); string(got) != string(installBefore) {
+		t.Errorf("install.json = %s, want %s", got, installBefore)
+	}
+	if got, _ := os.ReadFile(cleanupPath); string(got) != string(cleanupBefore) {
+		t.Errorf("cleanup = %s, want %s", got, cleanupBefore)
+	}
+	assertNoJournal(t, instructionsDir, "dg-1")
+}
+
+// TestMkdirJournaled_ExistingDirNotJournaled verifies that a mkdir failing on
+// an existing directory is not journaled, so rollback cannot remove a
+// directory the install did not create.
+func TestMkdirJournaled_ExistingDirNotJournaled(t *testing.T) {
+	_, instructionsDir, destDir := setupDirs(t)
+	dir := filepath.Join(destDir, "existing")
+	if err := os.Mkdir(dir, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	op := &diskFileOp{mockFileOp: newMockFileOp(), failMkdir: true}
+	inst := NewInstaller(op, false, IncrementalOff, slog.Default())
+	journalFile := journalPath(instructionsDir, "dg-1")
+	j, err := openJournal(journalFile, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := inst.mkdirJournaled(j, dir); err == nil {
+		t.Fatal("expected mkdir error for existing directory")
+	}
+	_ = j.close()
+	if err := inst.rollback(journalFile); err != nil {
+		t.Fatalf("rollback: %v", err)
+	}
+	if _, err := os.Lstat(dir); err != nil {
+		t.Errorf("existing directory should be kept, Lstat err = %v", err)
+	}
+}
+
+// TestRecover_RestoresCleanupRemovals verifies that a crash after cleanup
+// moved the previous deployment's paths aside is undone on startup: files
+// come back from the scratch directory, a directory removed in place is
+// recreated with its mode, and saved state files are restored or removed.
+func TestRecover_RestoresCleanupRemovals(t *testing.T) {
+	_, instructionsDir, destDir := setupDirs(t)
+	journalFile := journalPath(instructionsDir, "dg-1")
+	scratch := journalBackupDir(journalFile)
+
+	dir := filepath.Join(destDir, "old")
+	file := filepath.Join(dir, "a.txt")
+	createFile(t, filepath.Join(scratch, "1"), "saved install")
+	createFile(t, filepath.Join(scratch, "2"), "v1")
+	installPath := filepath.Join(instructionsDir, "dg-1-install.json")
+	cleanupPath := filepath.Join(instructionsDir, "dg-1-cleanup")
+	writeFile(t, installPath, "new install")
+	writeFile(t, cleanupPath, "new cleanup")
+	writeFile(t, journalFile,
+		`{"op":"save","path":"`+installPath+`","backup":"`+filepath.Join(scratch, "1")+`"}`+"\n"+
+			`{"op":"save","path":"`+cleanupPath+`"}`+"\n"+
+			`{"op":"remove","path":"`+file+`","backup":"`+filepath.Join(scratch, "2")+`"}`+"\n"+
+			`{"op":"remove","path":"`+dir+`","backup":"`+filepath.Join(scratch, "3")+`","mode":488}`+"\n")
+
+	op := &diskFileOp{mockFileOp: newMockFileOp()}
+	inst := NewInstaller(op, false, IncrementalOff, slog.Default())
+	if err := inst.Recover(instructionsDir); err != nil {
+		t.Fatalf("Recover: %v", err)
+	}
+
+	// Cleanup removed the file before its directory, so undo recreates
+	// the directory first.
+	if _, err := os.Stat(dir); err != nil || !op.chmodCalled(dir) {
+		t.Errorf("old dir should be recreated and chmodded, Stat err = %v, chmods: %v", err, op.chmods)
+	}
+	got, err := os.ReadFile(file)
+	if err != nil || string(got) != "v1" {
+		t.Errorf("a.txt = %q (%v), want v1", got, err)
+	}
+	if got, _ := os.ReadFile(installPath); string(got) != "saved install" {
+		t.Errorf("install.json = %q, want saved install", got)
+	}
+	if _, err := os.Lstat(cleanupPath); !os.IsNotExist(err) {
+		t.Errorf("cleanup file did not exist before, Lstat err = %v", err)
+	}
+	assertNoJournal(t, instructionsDir, "dg-1")
+}
+
+func assertNoJournal(t *testing.T, instructionsDir, groupID string) {
+	t.Helper()
+	journalFile := journalPath(instructionsDir, groupID)
+	for _, p := range []string{journalFile, journalBackupDir(journalFile)} {
+		if _, err := os.Lstat(p); !os.IsNotExist(err) {
+			t.Errorf("%s should be removed, Lstat err = %v", p, err)
+		}
+	}
+}
+
+// diskFileOp performs real copies and removals so rollback effects can be
+// observed on disk. failChown makes every Chown fail, and failMkdir makes
+// Mkdir fail on an existing path like filesystem.Operator.
+type diskFileOp struct {
+	*mockFileOp
+	failChown bool
+	failMkdir bool
+}
+
+func (d *diskFileOp) Mkdir(path string) error {
+	if d.failMkdir {
+		return os.Mkdir(path, 0o755)
+	}
+	return d.mockFileOp.Mkdir(path)
+}
+
+func (d *diskFileOp) Copy(source, destination string) error {
+	_ = d.mockFileOp.Copy(source, destination) // record the call
+	src, err := os.Open(source)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = src.Close() }()
+	dst, err := os.Create(destination)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = dst.Close() }()
+	_, err = io.Copy(dst, src)
+	return err
+}
+
+func (d *diskFileOp) Remove(path string) error {
+	// Missing paths and non-empty directories are skipped, like filesystem.Operator.
+	_ = os.Remove(path)
+	return nil
+}
+
+func (d *diskFileOp) Chown(_, _, _ string) error {
+	if d.failChown {
+		return errors.New("chown: invalid user: deploy")
+	}
+	return nil
+}
@@ -0,0 +1,97 @@
+package installer
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/gurre/codedeploy-agent-go/logic/instruction"
+)
+
+const installSuffix = "-install.json"
+
+// DestinationConflict is a path that an install would copy to while another
+// deployment group on the host manages it.
+type DestinationConflict struct {
+	Path              string `json:"path"`
+	DeploymentGroupID string `json:"deployment_group_id"`
+}
+
+// destinationOwners indexes the copy destinations of every deployment group's
+// last install, as recorded in the <group>-install.json files, by path. Each
+// group's cleanup removes its own destinations, so a path in two groups is
+// deleted by whichever redeploys first. exceptGroup is left out of the index.
+func (inst *Installer) destinationOwners(instructionsDir, exceptGroup string) map[string]string {
+	matches, err := filepath.Glob(filepath.Join(instructionsDir, "*"+installSuffix))
+	if err != nil {
+		return nil
+	}
+	sort.Strings(matches)
+
+	owners := make(map[string]string)
+	for _, path := range matches {
+		group := strings.TrimSuffix(filepath.Base(path), installSuffix)
+		if group == exceptGroup {
+			continue
+		}
+		data, err := os.ReadFile(path)
+		if err != nil {
+			inst.logger.Warn("skipping unreadable install file", "path", path, "error", err)
+			continue
+		}
+		cmds, err := instruction.ParseInstallCommands(data)
+		if err != nil {
+			inst.logger.Warn("skipping unparsable install file", "path", path, "error", err)
+			continue
+		}
+		for _, c := range cmds {
+			if c.Type == instruction.TypeCopy {
+				if _, taken := owners[c.Destination]; !taken {
+					owners[c.Destination] = group
+				}
+			}
+		}
+	}
+	return owners
+}
+
+// destinationConflicts returns the copies in cmds whose destination another
+// deployment group manages, sorted by path.
+func (inst *Installer) destinationConflicts(instructionsDir, deploymentGroupID string, cmds []instruction.Command) []DestinationConflict {
+	owners := inst.destinationOwners(instructionsDir, deploymentGroupID)
+	conflicts := make([]DestinationConflict, 0)
+	if len(owners) == 0 {
+		return conflicts
+	}
+	for _, c := range cmds {
+		if c.Type != instruction.TypeCopy {
+			continue
+		}
+		if group, ok := owners[c.Destination]; ok {
+			conflicts = append(conflicts, DestinationConflict{Path: c.Destination, DeploymentGroupID: group})
+		}
+	}
+	sort.Slice(conflicts, func(i, j int) bool { return conflicts[i].Path < conflicts[j].Path })
+	return conflicts
+}
+
+// checkDestinations fails when cmds copy to paths another deployment group
+// manages, unless sharing is allowed by the appspec or the agent. Allowed
+// overlaps are logged, since the groups still clean up each other's files.
+func (inst *Installer) checkDestinations(instructionsDir, deploymentGroupID string, cmds []instruction.Command, allowShared bool) error {
+	conflicts := inst.destinationConflicts(instructionsDir, deploymentGroupID, cmds)
+	if len(conflicts) == 0 {
+		return nil
+	}
+	if allowShared || inst.allowSharedDestinations {
+		for _, c := range conflicts {
+			inst.logger.Warn("destination shared with another deployment group", "path", c.Path, "group", c.DeploymentGroupID)
+		}
+		return nil
+	}
+	first := conflicts[0]
+	return fmt.Errorf("%s is managed by deployment group %s (%d paths in total); set allow_shared_destinations to share them",
+		first.Path, first.DeploymentGroupID, len(conflicts))
+}
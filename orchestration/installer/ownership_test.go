@@ -0,0 +1,100 @@
+package installer
+
+import (
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/gurre/codedeploy-agent-go/logic/appspec"
+)
+
+// TestInstall_RejectsDestinationOfOtherGroup verifies that a group cannot
+// install over files another group's last install copied, since each group's
+// cleanup would delete the other's files, and that the refusal happens before
+// anything is copied or cleaned up.
+func TestInstall_RejectsDestinationOfOtherGroup(t *testing.T) {
+	archiveDir, instructionsDir, destDir := setupDirs(t)
+	createFile(t, filepath.Join(archiveDir, "app", "index.html"), "hello")
+	spec := appspec.Spec{Files: []appspec.FileMapping{{Source: "app", Destination: destDir}}}
+
+	inst := NewInstaller(&diskFileOp{mockFileOp: newMockFileOp()}, false, IncrementalOff, slog.Default())
+	if err := inst.Install("dg-a", "d-1", archiveDir, instructionsDir, "", spec, "OVERWRITE"); err != nil {
+		t.Fatalf("Install dg-a: %v", err)
+	}
+
+	mock := newMockFileOp()
+	other := NewInstaller(mock, false, IncrementalOff, slog.Default())
+	err := other.Install("dg-b", "d-2", archiveDir, instructionsDir, "", spec, "OVERWRITE")
+	if err == nil || !strings.Contains(err.Error(), "managed by deployment group dg-a") {
+		t.Fatalf("Install dg-b err = %v, want managed by dg-a", err)
+	}
+	if len(mock.copies) != 0 {
+		t.Errorf("no file should be copied, got %v", mock.copies)
+	}
+	if _, err := os.Stat(filepath.Join(instructionsDir, "dg-b-install.json")); !os.IsNotExist(err) {
+		t.Errorf("dg-b-install.json should not be written, stat err = %v", err)
+	}
+
+	// The owning group redeploys the same paths freely.
+	if err := inst.Install("dg-a", "d-3", archiveDir, instructionsDir, "", spec, "OVERWRITE"); err != nil {
+		t.Errorf("redeploy dg-a: %v", err)
+	}
+}
+
+// TestInstall_SharedDestinationsAllowed verifies that sharing can be allowed
+// either by the appspec or by the agent setting.
+func TestInstall_SharedDestinationsAllowed(t *testing.T) {
+	archiveDir, instructionsDir, destDir := setupDirs(t)
+	createFile(t, filepath.Join(archiveDir, "app", "index.html"), "hello")
+	spec := appspec.Spec{Files: []appspec.FileMapping{{Source: "app", Destination: destDir}}}
+
+	inst := NewInstaller(&diskFileOp{mockFileOp: newMockFileOp()}, false, IncrementalOff, slog.Default())
+	if err := inst.Install("dg-a", "d-1", archiveDir, instructionsDir, "", spec, "OVERWRITE"); err != nil {
+		t.Fatalf("Install dg-a: %v", err)
+	}
+
+	shared := spec
+	shared.AllowSharedDestinations = true
+	if err := inst.Install("dg-b", "d-2", archiveDir, instructionsDir, "", shared, "OVERWRITE"); err != nil {
+		t.Errorf("appspec allow_shared_destinations: %v", err)
+	}
+
+	agentWide := NewInstaller(&diskFileOp{mockFileOp: newMockFileOp()}, false, IncrementalOff, slog.Default())
+	agentWide.SetAllowSharedDestinations(true)
+	if err := agentWide.Install("dg-c", "d-3", archiveDir, instructionsDir, "", spec, "OVERWRITE"); err != nil {
+		t.Errorf("agent allow_shared_destinations: %v", err)
+	}
+}
+
+// TestPlan_ReportsGroupConflicts verifies that plan lists every destination
+// owned by another group together with its owner.
+func TestPlan_ReportsGroupConflicts(t *testing.T) {
+	archiveDir, instructionsDir, destDir := setupDirs(t)
+	createFile(t, filepath.Join(archiveDir, "app", "a.txt"), "a")
+	createFile(t, filepath.Join(archiveDir, "app", "b.txt"), "b")
+	spec := appspec.Spec{Files: []appspec.FileMapping{{Source: "app", Destination: destDir}}}
+
+	inst := NewInstaller(&diskFileOp{mockFileOp: newMockFileOp()}, false, IncrementalOff, slog.Default())
+	if err := inst.Install("dg-a", "d-1", archiveDir, instructionsDir, "", spec, "OVERWRITE"); err != nil {
+		t.Fatalf("Install dg-a: %v", err)
+	}
+
+	plan, err := inst.Plan("dg-b", "d-2", archiveDir, instructionsDir, spec, "OVERWRITE")
+	if err != nil {
+		t.Fatalf("Plan: %v", err)
+	}
+	want := []DestinationConflict{
+		{Path: filepath.Join(destDir, "a.txt"), DeploymentGroupID: "dg-a"},
+		{Path: filepath.Join(destDir, "b.txt"), DeploymentGroupID: "dg-a"},
+	}
+	if len(plan.GroupConflicts) != len(want) {
+		t.Fatalf("GroupConflicts = %v, want %v", plan.GroupConflicts, want)
+	}
+	for i := range want {
+		if plan.GroupConflicts[i] != want[i] {
+			t.Errorf("GroupConflicts[%d] = %v, want %v", i, plan.GroupConflicts[i], want[i])
+		}
+	}
+}
@@ -0,0 +1,109 @@
+package installer
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"github.com/gurre/codedeploy-agent-go/logic/appspec"
+	"github.com/gurre/codedeploy-agent-go/logic/instruction"
+)
+
+// Plan describes what Install would do against the current destination tree.
+type Plan struct {
+	// Commands are the install commands in execution order. Copies marked
+	// Unchanged would be skipped by an incremental install.
+	Commands []instruction.Command `json:"commands"`
+	// Overwrites lists copy destinations that already exist.
+	Overwrites []string `json:"overwrites"`
+	// Cleanup lists what the previous deployment installed and would be
+	// removed, in removal order.
+	Cleanup []instruction.CleanupEntry `json:"cleanup"`
+	// KeptChanged lists previously installed files that would be kept
+	// because they were edited since install.
+	KeptChanged []instruction.CleanupEntry `json:"kept_changed"`
+	// Retained lists existing files left alone under RETAIN.
+	Retained []string `json:"retained"`
+	// Conflicts lists existing files that make the install fail under DISALLOW.
+	Conflicts []string `json:"conflicts"`
+	// GroupConflicts lists copy destinations managed by another deployment
+	// group, which make the install fail unless sharing is allowed.
+	GroupConflicts []DestinationConflict `json:"group_conflicts"`
+}
+
+// Plan computes the install of spec from archiveDir without changing
+// anything: no file is copied or removed, and no journal or cleanup file is
+// touched. Templates are rendered into a temporary directory that is removed
+// before Plan returns. Unlike Install, DISALLOW conflicts are collected instead of
+// stopping at the first one.
+//
+//	plan, err := inst.Plan("dg-1", "d-1", archiveDir, deployment.InstructionsDir(root), spec, "DISALLOW")
+func (inst *Installer) Plan(
+	deploymentGroupID string,
+	deploymentID string,
+	archiveDir string,
+	instructionsDir string,
+	spec appspec.Spec,
+	fileExistsBehavior string,
+) (Plan, error) {
+	incremental := inst.incremental
+	if spec.Release != nil {
+		incremental = IncrementalOff
+		spec = releaseSpec(spec, deploymentID)
+	}
+
+	conflicts := make([]string, 0)
+	builder, err := inst.generateInstructions(archiveDir, spec, fileExistsBehavior, &conflicts)
+	if err != nil {
+		return Plan{}, fmt.Errorf("installer: plan: %w", err)
+	}
+	commands := builder.Commands()
+
+	// Render templates as Install does, so that their stats describe the
+	// rendered output and a template error shows up in the plan.
+	rendered, err := os.MkdirTemp("", "codedeploy-plan-rendered-")
+	if err != nil {
+		return Plan{}, fmt.Errorf("installer: plan: %w", err)
+	}
+	defer func() { _ = os.RemoveAll(rendered) }()
+	if err := inst.renderTemplates(commands, rendered, deploymentGroupID, deploymentID); err != nil {
+		return Plan{}, fmt.Errorf("installer: plan: render: %w", err)
+	}
+	if incremental != IncrementalOff {
+		prev := previousStats(filepath.Join(instructionsDir, deploymentGroupID+"-install.json"))
+		inst.attachStats(commands)
+		inst.markUnchanged(commands, prev, incremental)
+	}
+
+	prevCleanup, err := inst.readCleanup(filepath.Join(instructionsDir, deploymentGroupID+"-cleanup"))
+	if err != nil {
+		return Plan{}, fmt.Errorf("installer: plan: read cleanup: %w", err)
+	}
+	skipped := builder.SkippedPaths()
+	remove, kept := planCleanup(prevCleanup, skipped, builder.CopyTargets())
+
+	plan := Plan{
+		Commands:    commands,
+		Overwrites:  make([]string, 0),
+		Cleanup:     append(make([]instruction.CleanupEntry, 0, len(remove)), remove...),
+		KeptChanged: append(make([]instruction.CleanupEntry, 0, len(kept)), kept...),
+		Retained:    make([]string, 0),
+		Conflicts:   conflicts,
+	}
+	if !spec.AllowSharedDestinations && !inst.allowSharedDestinations {
+		plan.GroupConflicts = inst.destinationConflicts(instructionsDir, deploymentGroupID, commands)
+	} else {
+		plan.GroupConflicts = make([]DestinationConflict, 0)
+	}
+	for _, p := range skipped {
+		if builder.IsCopyTarget(p) {
+			plan.Overwrites = append(plan.Overwrites, p)
+		} else {
+			plan.Retained = append(plan.Retained, p)
+		}
+	}
+	sort.Strings(plan.Overwrites)
+	sort.Strings(plan.Retained)
+	return plan, nil
+}
@@ -0,0 +1,135 @@
+package installer
+
+import (
+	"log/slog"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+
+	"github.com/gurre/codedeploy-agent-go/logic/appspec"
+	"github.com/gurre/codedeploy-agent-go/logic/instruction"
+	"github.com/gurre/codedeploy-agent-go/logic/render"
+)
+
+// TestPlan_DisallowCollectsConflictsWithoutWriting verifies that Plan reports
+// every existing destination under DISALLOW instead of stopping at the first,
+// and that it calls no file operation and writes no instruction file.
+func TestPlan_DisallowCollectsConflictsWithoutWriting(t *testing.T) {
+	archiveDir, instructionsDir, destDir := setupDirs(t)
+	createFile(t, filepath.Join(archiveDir, "app", "a.txt"), "a")
+	createFile(t, filepath.Join(archiveDir, "app", "b.txt"), "b")
+	createFile(t, filepath.Join(archiveDir, "app", "c.txt"), "c")
+	createFile(t, filepath.Join(destDir, "a.txt"), "existing")
+	createFile(t, filepath.Join(destDir, "b.txt"), "existing")
+	spec := appspec.Spec{Files: []appspec.FileMapping{{Source: "app", Destination: destDir}}}
+
+	mock := newMockFileOp()
+	inst := NewInstaller(mock, false, IncrementalOff, slog.Default())
+	plan, err := inst.Plan("dg-1", "d-1", archiveDir, instructionsDir, spec, "DISALLOW")
+	if err != nil {
+		t.Fatalf("Plan: %v", err)
+	}
+
+	want := []string{filepath.Join(destDir, "a.txt"), filepath.Join(destDir, "b.txt")}
+	if !slices.Equal(plan.Conflicts, want) {
+		t.Errorf("Conflicts = %v, want %v", plan.Conflicts, want)
+	}
+	if len(mock.copies) != 0 || len(mock.mkdirs) != 0 || len(mock.removes) != 0 {
+		t.Errorf("Plan called file operations: copies %v mkdirs %v removes %v", mock.copies, mock.mkdirs, mock.removes)
+	}
+	entries, err := os.ReadDir(instructionsDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 0 {
+		t.Errorf("instructions dir should stay empty, got %v", entries)
+	}
+	if got, _ := os.ReadFile(filepath.Join(destDir, "a.txt")); string(got) != "existing" {
+		t.Errorf("a.txt = %q, want untouched", got)
+	}
+}
+
+// TestPlan_SplitsOverwritesRetainedAndCleanup verifies that Plan separates
+// files an install would replace from files it would leave alone, and lists
+// the previous deployment's files that the new one drops.
+func TestPlan_SplitsOverwritesRetainedAndCleanup(t *testing.T) {
+	archiveDir, instructionsDir, destDir := setupDirs(t)
+	createFile(t, filepath.Join(archiveDir, "app", "config.txt"), "new")
+	createFile(t, filepath.Join(archiveDir, "app", "data.txt"), "new")
+	createFile(t, filepath.Join(destDir, "config.txt"), "old")
+	createFile(t, filepath.Join(destDir, "data.txt"), "old")
+	createFile(t, filepath.Join(destDir, "gone.txt"), "v1")
+	prev := instruction.Cleanup{DeploymentGroupID: "dg-1", Entries: []instruction.CleanupEntry{
+		{Path: filepath.Join(destDir, "gone.txt"), Type: instruction.EntryFile, DeploymentID: "d-0"},
+	}}
+	data, err := prev.ToJSON()
+	if err != nil {
+		t.Fatal(err)
+	}
+	writeFile(t, filepath.Join(instructionsDir, "dg-1-cleanup"), string(data))
+
+	spec := appspec.Spec{Files: []appspec.FileMapping{{Source: "app", Destination: destDir}}}
+	inst := NewInstaller(newMockFileOp(), false, IncrementalOff, slog.Default())
+
+	overwrite, err := inst.Plan("dg-1", "d-1", archiveDir, instructionsDir, spec, "OVERWRITE")
+	if err != nil {
+		t.Fatalf("Plan OVERWRITE: %v", err)
+	}
+	existing := []string{filepath.Join(destDir, "config.txt"), filepath.Join(destDir, "data.txt")}
+	if !slices.Equal(overwrite.Overwrites, existing) || len(overwrite.Retained) != 0 {
+		t.Errorf("OVERWRITE: Overwrites = %v, Retained = %v, want %v overwritten", overwrite.Overwrites, overwrite.Retained, existing)
+	}
+
+	plan, err := inst.Plan("dg-1", "d-1", archiveDir, instructionsDir, spec, "RETAIN")
+	if err != nil {
+		t.Fatalf("Plan RETAIN: %v", err)
+	}
+	if !slices.Equal(plan.Retained, existing) || len(plan.Overwrites) != 0 {
+		t.Errorf("RETAIN: Retained = %v, Overwrites = %v, want %v retained", plan.Retained, plan.Overwrites, existing)
+	}
+	if len(plan.Cleanup) != 1 || plan.Cleanup[0].Path != filepath.Join(destDir, "gone.txt") {
+		t.Errorf("Cleanup = %+v, want gone.txt", plan.Cleanup)
+	}
+	if _, err := os.Lstat(filepath.Join(destDir, "gone.txt")); err != nil {
+		t.Errorf("gone.txt must not be removed by Plan: %v", err)
+	}
+	if len(plan.Conflicts) != 0 {
+		t.Errorf("Conflicts = %v, want none", plan.Conflicts)
+	}
+}
+
+// TestPlan_TemplateUnchangedAfterInstall verifies that Plan renders templates
+// as Install does, so that a template whose rendered output is already
+// installed is planned as unchanged, with the stats install.json records.
+func TestPlan_TemplateUnchangedAfterInstall(t *testing.T) {
+	archiveDir, instructionsDir, destDir := setupDirs(t)
+	createFile(t, filepath.Join(archiveDir, "app.conf"), "region={{ .Instance.Region }}")
+	spec := appspec.Spec{Files: []appspec.FileMapping{{Source: "app.conf", Destination: destDir, Templates: []string{"*.conf"}}}}
+
+	inst := NewInstaller(&diskFileOp{mockFileOp: newMockFileOp()}, false, IncrementalHash, slog.Default())
+	inst.SetTemplateSource(&fakeTemplateSource{instance: render.Instance{Region: "eu-west-1"}})
+	if err := inst.Install("dg-1", "d-1", archiveDir, instructionsDir, "", spec, "OVERWRITE"); err != nil {
+		t.Fatalf("Install: %v", err)
+	}
+
+	plan, err := inst.Plan("dg-1", "d-2", archiveDir, instructionsDir, spec, "OVERWRITE")
+	if err != nil {
+		t.Fatalf("Plan: %v", err)
+	}
+	dest := filepath.Join(destDir, "app.conf")
+	installed := installedCopy(t, instructionsDir, dest)
+	for _, c := range plan.Commands {
+		if c.Type != instruction.TypeCopy || c.Destination != dest {
+			continue
+		}
+		if !c.Unchanged {
+			t.Error("rendered template already installed should be planned as unchanged")
+		}
+		if c.Stats == nil || c.Stats.SHA256 != installed.Stats.SHA256 || c.Stats.Size != installed.Stats.Size {
+			t.Errorf("plan stats = %+v, want those in install.json %+v", c.Stats, installed.Stats)
+		}
+		return
+	}
+	t.Fatalf("no copy to %s in plan", dest)
+}
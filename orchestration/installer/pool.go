@@ -0,0 +1,79 @@
+package installer
+
+import "sync"
+
+// defaultWorkers bounds the install steps that run at once. Copies are
+// I/O-bound, so this is not tied to the CPU count; network-backed disks
+// benefit most.
+const defaultWorkers = 8
+
+// workPool runs install steps on at most n long-lived goroutines, which
+// bounds the copies in flight and the file descriptors they hold open. When
+// a step fails no further steps are started, and Wait reports the failure of
+// the earliest step in command order so that the returned error does not
+// depend on scheduling.
+type workPool struct {
+	tasks   chan poolTask
+	n       int
+	started int
+	wg      sync.WaitGroup
+	mu      sync.Mutex
+	err     error
+	errIdx  int
+}
+
+type poolTask struct {
+	idx int
+	fn  func() error
+}
+
+func newWorkPool(n int) *workPool {
+	return &workPool{tasks: make(chan poolTask), n: max(n, 1)}
+}
+
+// Go runs fn for the command at index idx, blocking while every worker is
+// busy.
+func (p *workPool) Go(idx int, fn func() error) {
+	if p.failed() {
+		return
+	}
+	p.wg.Add(1)
+	if p.started < p.n {
+		p.started++
+		go p.work()
+	}
+	p.tasks <- poolTask{idx: idx, fn: fn}
+}
+
+func (p *workPool) work() {
+	for t := range p.tasks {
+		if err := t.fn(); err != nil {
+			p.mu.Lock()
+			if p.err == nil || t.idx < p.errIdx {
+				p.err, p.errIdx = err, t.idx
+			}
+			p.mu.Unlock()
+		}
+		p.wg.Done()
+	}
+}
+
+// Wait blocks until every started step has finished and returns the error
+// of the earliest failed step.
+func (p *workPool) Wait() error {
+	p.wg.Wait()
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.err
+}
+
+// Close stops the workers. The pool must not be used afterwards.
+func (p *workPool) Close() {
+	close(p.tasks)
+}
+
+func (p *workPool) failed() bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.err != nil
+}
@@ -0,0 +1,182 @@
+package installer
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gurre/codedeploy-agent-go/logic/appspec"
+)
+
+// TestInstall_Parallel_CleanupFileMatchesSequential verifies that the cleanup
+// file written by a parallel install is identical to a sequential one, so the
+// next deployment's cleanup does not depend on scheduling.
+func TestInstall_Parallel_CleanupFileMatchesSequential(t *testing.T) {
+	archiveDir, _, _ := setupDirs(t)
+	for d := range 4 {
+		for f := range 25 {
+			createFile(t, filepath.Join(archiveDir, "app", fmt.Sprintf("dir%d", d), fmt.Sprintf("f%02d", f)), "x")
+		}
+	}
+	// Both runs install to the same path from a clean slate.
+	destDir := filepath.Join(t.TempDir(), "dest")
+	spec := appspec.Spec{Files: []appspec.FileMapping{{Source: "app", Destination: destDir}}}
+
+	cleanup := func(workers int) string {
+		mkdirAll(t, destDir)
+		defer func() { _ = os.RemoveAll(destDir) }()
+		instructionsDir := filepath.Join(t.TempDir(), "instructions")
+		inst := NewInstaller(&slowCopyFileOp{mockFileOp: newMockFileOp()}, false, IncrementalOff, slog.Default())
+		inst.workers = workers
+		if err := inst.Install("dg-1", "d-1", archiveDir, instructionsDir, "", spec, "OVERWRITE"); err != nil {
+			t.Fatalf("Install with %d workers: %v", workers, err)
+		}
+		data, err := os.ReadFile(filepath.Join(instructionsDir, "dg-1-cleanup"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return string(data)
+	}
+
+	if seq, par := cleanup(1), cleanup(16); seq != par {
+		t.Errorf("cleanup differs:\nsequential:\n%s\nparallel:\n%s", seq, par)
+	}
+}
+
+// TestInstall_Parallel_PermissionsAfterCopy verifies that no permission
+// command runs before its file has been copied, and that chmod precedes
+// chown on each path as in a sequential install.
+func TestInstall_Parallel_PermissionsAfterCopy(t *testing.T) {
+	archiveDir, instructionsDir, destDir := setupDirs(t)
+	for f := range 40 {
+		createFile(t, filepath.Join(archiveDir, "bin", fmt.Sprintf("run%02d.sh", f)), "#!/bin/sh")
+	}
+	mode, err := appspec.ParseMode("0755")
+	if err != nil {
+		t.Fatal(err)
+	}
+	spec := appspec.Spec{
+		Files: []appspec.FileMapping{{Source: "bin", Destination: destDir}},
+		Permissions: []appspec.Permission{{
+			Object: destDir, Pattern: "**", Type: []string{"file"}, Owner: "deploy", Mode: &mode,
+		}},
+	}
+
+	op := &slowCopyFileOp{mockFileOp: newMockFileOp()}
+	inst := NewInstaller(op, false, IncrementalOff, slog.Default())
+	if err := inst.Install("dg-1", "d-1", archiveDir, instructionsDir, "", spec, "OVERWRITE"); err != nil {
+		t.Fatalf("Install: %v", err)
+	}
+	if len(op.violations) > 0 {
+		t.Errorf("ordering violations: %v", op.violations)
+	}
+	if got := len(op.chowns); got != 40 {
+		t.Errorf("chowns = %d, want 40", got)
+	}
+}
+
+// TestInstall_Parallel_CopyFailureRollsBack verifies that a failed copy among
+// many parallel ones still fails the install and rolls back the copies that
+// completed.
+func TestInstall_Parallel_CopyFailureRollsBack(t *testing.T) {
+	archiveDir, instructionsDir, destDir := setupDirs(t)
+	for f := range 30 {
+		createFile(t, filepath.Join(archiveDir, "app", fmt.Sprintf("f%02d", f)), "x")
+	}
+	spec := appspec.Spec{Files: []appspec.FileMapping{{Source: "app", Destination: destDir}}}
+
+	op := &diskFileOp{mockFileOp: newMockFileOp()}
+	failing := &failOneCopyFileOp{diskFileOp: op, fail: filepath.Join(destDir, "f17")}
+	inst := NewInstaller(failing, false, IncrementalOff, slog.Default())
+	if err := inst.Install("dg-1", "d-1", archiveDir, instructionsDir, "", spec, "OVERWRITE"); err == nil {
+		t.Fatal("expected install error")
+	}
+
+	entries, err := os.ReadDir(destDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 0 {
+		t.Errorf("destination should be empty after rollback, has %d entries", len(entries))
+	}
+	assertNoJournal(t, instructionsDir, "dg-1")
+}
+
+// TestWorkPool_EarliestError verifies that Wait reports the failure of the
+// earliest step in command order, not the first to finish.
+func TestWorkPool_EarliestError(t *testing.T) {
+	p := newWorkPool(4)
+	defer p.Close()
+	p.Go(1, func() error {
+		time.Sleep(10 * time.Millisecond)
+		return errors.New("first")
+	})
+	p.Go(3, func() error { return errors.New("third") })
+	if err := p.Wait(); err == nil || err.Error() != "first" {
+		t.Errorf("Wait = %v, want first", err)
+	}
+}
+
+// slowCopyFileOp delays copies to force overlap and records permission
+// commands that run before their copy completed or out of order.
+type slowCopyFileOp struct {
+	*mockFileOp
+	mu         sync.Mutex
+	copied     map[string]bool
+	chmodded   map[string]bool
+	violations []string
+}
+
+func (s *slowCopyFileOp) Copy(source, destination string) error {
+	time.Sleep(time.Millisecond)
+	if err := s.mockFileOp.Copy(source, destination); err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.copied == nil {
+		s.copied = make(map[string]bool)
+	}
+	s.copied[destination] = true
+	return nil
+}
+
+func (s *slowCopyFileOp) Chmod(path string, mode os.FileMode) error {
+	s.mu.Lock()
+	if !s.copied[path] {
+		s.violations = append(s.violations, "chmod before copy: "+path)
+	}
+	if s.chmodded == nil {
+		s.chmodded = make(map[string]bool)
+	}
+	s.chmodded[path] = true
+	s.mu.Unlock()
+	return s.mockFileOp.Chmod(path, mode)
+}
+
+func (s *slowCopyFileOp) Chown(path, owner, group string) error {
+	s.mu.Lock()
+	if !s.chmodded[path] {
+		s.violations = append(s.violations, "chown before chmod: "+path)
+	}
+	s.mu.Unlock()
+	return s.mockFileOp.Chown(path, owner, group)
+}
+
+// failOneCopyFileOp fails the copy to a single destination.
+type failOneCopyFileOp struct {
+	*diskFileOp
+	fail string
+}
+
+func (f *failOneCopyFileOp) Copy(source, destination string) error {
+	if destination == f.fail {
+		return errors.New("input/output error")
+	}
+	return f.diskFileOp.Copy(source, destination)
+}
@@ -0,0 +1,106 @@
+package installer
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/gurre/codedeploy-agent-go/logic/appspec"
+	"github.com/gurre/codedeploy-agent-go/logic/retention"
+)
+
+// releaseSpec returns a copy of spec with every file destination and
+// permission object under the current link rewritten into the deployment's
+// release directory. Entries outside the current link are left unchanged and
+// are installed in place as usual.
+func releaseSpec(spec appspec.Spec, deploymentID string) appspec.Spec {
+	rel := *spec.Release
+
+	files := make([]appspec.FileMapping, len(spec.Files))
+	for i, fm := range spec.Files {
+		fm.Destination, _ = rel.Rewrite(fm.Destination, deploymentID)
+		files[i] = fm
+	}
+	perms := make([]appspec.Permission, len(spec.Permissions))
+	for i, perm := range spec.Permissions {
+		perm.Object, _ = rel.Rewrite(perm.Object, deploymentID)
+		perms[i] = perm
+	}
+
+	spec.Files = files
+	spec.Permissions = perms
+	return spec
+}
+
+// prepareRelease ensures the releases directory exists and that the current
+// link can be switched. A real directory at the link path, or a deployment
+// whose release is already active, is rejected because it cannot be replaced
+// atomically.
+func (inst *Installer) prepareRelease(rel appspec.Release, deploymentID string) error {
+	link := rel.CurrentLink()
+	if info, err := os.Lstat(link); err == nil {
+		if info.Mode()&os.ModeSymlink == 0 {
+			return fmt.Errorf("%s exists and is not a symlink", link)
+		}
+		if active, err := filepath.EvalSymlinks(link); err == nil {
+			if want, err := filepath.EvalSymlinks(rel.Dir(deploymentID)); err == nil && active == want {
+				return fmt.Errorf("release %s is already active", deploymentID)
+			}
+		}
+	}
+	return inst.fileOp.MkdirAll(rel.ReleasesDir())
+}
+
+// activateRelease points the current link at the deployment's release. The
+// new link is created beside the old one and renamed over it, so readers see
+// either the previous release or the new one, never a missing link.
+func (inst *Installer) activateRelease(rel appspec.Release, deploymentID string) error {
+	tmp := filepath.Join(rel.Root, ".current-"+deploymentID)
+	_ = inst.fileOp.RemoveAll(tmp)
+
+	target, err := filepath.Rel(rel.Root, rel.Dir(deploymentID))
+	if err != nil {
+		return err
+	}
+	if err := inst.fileOp.Symlink(target, tmp); err != nil {
+		return err
+	}
+	if err := inst.fileOp.Rename(tmp, rel.CurrentLink()); err != nil {
+		_ = inst.fileOp.RemoveAll(tmp)
+		return err
+	}
+	inst.logger.Info("release activated", "link", rel.CurrentLink(), "release", rel.Dir(deploymentID))
+	return nil
+}
+
+// pruneReleases removes the oldest release directories beyond rel.Keep. The
+// active release is never removed. Failures are logged, not returned: the
+// deployment has already succeeded by the time releases are pruned.
+func (inst *Installer) pruneReleases(rel appspec.Release, deploymentID string) {
+	entries, err := os.ReadDir(rel.ReleasesDir())
+	if err != nil {
+		inst.logger.Warn("failed to list releases", "dir", rel.ReleasesDir(), "error", err)
+		return
+	}
+
+	revisions := make([]retention.Revision, 0, len(entries))
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			continue
+		}
+		r := retention.Revision{Path: filepath.Join(rel.ReleasesDir(), entry.Name())}
+		if info, err := entry.Info(); err == nil {
+			r.ModTime = info.ModTime()
+		}
+		revisions = append(revisions, r)
+	}
+
+	active := map[string]bool{rel.Dir(deploymentID): true}
+	for _, path := range retention.Expired(revisions, rel.Keep, active) {
+		if err := inst.fileOp.RemoveAll(path); err != nil {
+			inst.logger.Warn("failed to remove old release", "release", path, "error", err)
+			continue
+		}
+		inst.logger.Info("removed old release", "release", path)
+	}
+}
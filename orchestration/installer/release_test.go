@@ -0,0 +1,195 @@
+package installer
+
+import (
+	"errors"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gurre/codedeploy-agent-go/logic/appspec"
+)
+
+// TestInstall_Release_SwitchesCurrentLink verifies that files mapped under the
+// current link are copied into the deployment's release directory, that the
+// link is switched to that release, and that release contents are kept out of
+// the cleanup file so the next deployment does not delete a rollback target.
+// The copy's stats are still recorded for verification.
+func TestInstall_Release_SwitchesCurrentLink(t *testing.T) {
+	archiveDir, instructionsDir, _ := setupDirs(t)
+	root := filepath.Join(t.TempDir(), "app")
+	createFile(t, filepath.Join(archiveDir, "web", "index.html"), "hello")
+
+	mock := newMockFileOp()
+	inst := NewInstaller(mock, false, IncrementalOff, slog.Default())
+	spec := releaseTestSpec(root, 5)
+
+	if err := inst.Install("dg-1", "d-1", archiveDir, instructionsDir, "", spec, "DISALLOW"); err != nil {
+		t.Fatalf("Install: %v", err)
+	}
+
+	want := filepath.Join(root, "releases", "d-1", "index.html")
+	if !mock.copiedTo(want) {
+		t.Errorf("expected copy to %q, got %v", want, mock.copies)
+	}
+	target, err := os.Readlink(filepath.Join(root, "current"))
+	if err != nil {
+		t.Fatalf("readlink current: %v", err)
+	}
+	if target != filepath.Join("releases", "d-1") {
+		t.Errorf("current -> %q, want releases/d-1", target)
+	}
+
+	data, err := os.ReadFile(filepath.Join(instructionsDir, "dg-1-cleanup"))
+	if err != nil {
+		t.Fatalf("read cleanup: %v", err)
+	}
+	if strings.Contains(string(data), filepath.Join(root, "releases")) {
+		t.Errorf("cleanup file must not list release contents, got %q", data)
+	}
+	if cmd := installedCopy(t, instructionsDir, want); cmd.Stats == nil || cmd.Stats.SHA256 == "" {
+		t.Errorf("stats = %+v, want recorded with sha256", cmd.Stats)
+	}
+}
+
+// TestInstall_Release_PrunesOldReleases verifies that only the newest keep
+// releases survive a successful install, and that the new release counts
+// towards keep.
+func TestInstall_Release_PrunesOldReleases(t *testing.T) {
+	archiveDir, instructionsDir, _ := setupDirs(t)
+	root := filepath.Join(t.TempDir(), "app")
+	createFile(t, filepath.Join(archiveDir, "web", "index.html"), "hello")
+
+	base := time.Now().Add(-time.Hour)
+	for i, id := range []string{"d-old1", "d-old2", "d-old3"} {
+		dir := filepath.Join(root, "releases", id)
+		mkdirAll(t, dir)
+		mtime := base.Add(time.Duration(i) * time.Minute)
+		if err := os.Chtimes(dir, mtime, mtime); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	inst := NewInstaller(newMockFileOp(), false, IncrementalOff, slog.Default())
+	if err := inst.Install("dg-1", "d-new", archiveDir, instructionsDir, "", releaseTestSpec(root, 2), "DISALLOW"); err != nil {
+		t.Fatalf("Install: %v", err)
+	}
+
+	for id, want := range map[string]bool{"d-old1": false, "d-old2": false, "d-old3": true, "d-new": true} {
+		_, err := os.Stat(filepath.Join(root, "releases", id))
+		if got := err == nil; got != want {
+			t.Errorf("release %s exists = %v, want %v", id, got, want)
+		}
+	}
+}
+
+// TestInstall_Release_FailureKeepsCurrent verifies that a copy failure leaves
+// the current link on the previous release and discards the partial release,
+// which is the point of releases-style installs.
+func TestInstall_Release_FailureKeepsCurrent(t *testing.T) {
+	archiveDir, instructionsDir, _ := setupDirs(t)
+	root := filepath.Join(t.TempDir(), "app")
+	createFile(t, filepath.Join(archiveDir, "web", "index.html"), "hello")
+	mkdirAll(t, filepath.Join(root, "releases", "d-0"))
+	if err := os.Symlink(filepath.Join("releases", "d-0"), filepath.Join(root, "current")); err != nil {
+		t.Fatal(err)
+	}
+
+	inst := NewInstaller(&failingCopyFileOp{mockFileOp: newMockFileOp()}, false, IncrementalOff, slog.Default())
+	if err := inst.Install("dg-1", "d-1", archiveDir, instructionsDir, "", releaseTestSpec(root, 5), "DISALLOW"); err == nil {
+		t.Fatal("expected install error")
+	}
+
+	target, err := os.Readlink(filepath.Join(root, "current"))
+	if err != nil || target != filepath.Join("releases", "d-0") {
+		t.Errorf("current -> %q (%v), want releases/d-0", target, err)
+	}
+	if _, err := os.Stat(filepath.Join(root, "releases", "d-1")); !os.IsNotExist(err) {
+		t.Errorf("partial release should be removed, stat err = %v", err)
+	}
+}
+
+// TestInstall_Release_ActivationFailureRollsBack verifies that a current
+// link that cannot be switched fails the install and rolls it back: the
+// previous release stays live, the new one is discarded, and the group's
+// install file still describes the previous deployment, so the host is
+// never left half-deployed.
+func TestInstall_Release_ActivationFailureRollsBack(t *testing.T) {
+	archiveDir, instructionsDir, _ := setupDirs(t)
+	root := filepath.Join(t.TempDir(), "app")
+	createFile(t, filepath.Join(archiveDir, "web", "index.html"), "hello")
+
+	inst := NewInstaller(newMockFileOp(), false, IncrementalOff, slog.Default())
+	if err := inst.Install("dg-1", "d-0", archiveDir, instructionsDir, "", releaseTestSpec(root, 5), "DISALLOW"); err != nil {
+		t.Fatalf("Install d-0: %v", err)
+	}
+	installPath := filepath.Join(instructionsDir, "dg-1-install.json")
+	before, err := os.ReadFile(installPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	inst = NewInstaller(&failingLinkFileOp{mockFileOp: newMockFileOp()}, false, IncrementalOff, slog.Default())
+	err = inst.Install("dg-1", "d-1", archiveDir, instructionsDir, "", releaseTestSpec(root, 5), "DISALLOW")
+	if err == nil || !strings.Contains(err.Error(), "activate release") {
+		t.Fatalf("expected activate release error, got %v", err)
+	}
+
+	target, err := os.Readlink(filepath.Join(root, "current"))
+	if err != nil || target != filepath.Join("releases", "d-0") {
+		t.Errorf("current -> %q (%v), want releases/d-0", target, err)
+	}
+	if _, err := os.Stat(filepath.Join(root, "releases", "d-1")); !os.IsNotExist(err) {
+		t.Errorf("unactivated release should be removed, stat err = %v", err)
+	}
+	if after, err := os.ReadFile(installPath); err != nil || string(after) != string(before) {
+		t.Errorf("install file = %q (%v), want the previous deployment's %q", after, err, before)
+	}
+}
+
+// TestInstall_Release_RejectsDirectoryAtLink verifies that a real directory at
+// the current link path is rejected instead of being replaced, since a
+// directory cannot be swapped for a symlink atomically.
+func TestInstall_Release_RejectsDirectoryAtLink(t *testing.T) {
+	archiveDir, instructionsDir, _ := setupDirs(t)
+	root := filepath.Join(t.TempDir(), "app")
+	createFile(t, filepath.Join(archiveDir, "web", "index.html"), "hello")
+	mkdirAll(t, filepath.Join(root, "current"))
+
+	inst := NewInstaller(newMockFileOp(), false, IncrementalOff, slog.Default())
+	err := inst.Install("dg-1", "d-1", archiveDir, instructionsDir, "", releaseTestSpec(root, 5), "DISALLOW")
+	if err == nil || !strings.Contains(err.Error(), "not a symlink") {
+		t.Errorf("expected not-a-symlink error, got %v", err)
+	}
+}
+
+func releaseTestSpec(root string, keep int) appspec.Spec {
+	return appspec.Spec{
+		Files: []appspec.FileMapping{
+			{Source: "web", Destination: filepath.Join(root, "current")},
+		},
+		Release: &appspec.Release{Root: root, Keep: keep},
+	}
+}
+
+// failingCopyFileOp fails every Copy to simulate a disk error mid-install.
+type failingCopyFileOp struct {
+	*mockFileOp
+}
+
+func (f *failingCopyFileOp) Copy(_, _ string) error { return errors.New("no space left on device") }
+
+// failingLinkFileOp fails renames onto a current link, to simulate a
+// release that cannot be activated after its files are installed.
+type failingLinkFileOp struct {
+	*mockFileOp
+}
+
+func (f *failingLinkFileOp) Rename(oldPath, newPath string) error {
+	if filepath.Base(newPath) == "current" {
+		return errors.New("rename: permission denied")
+	}
+	return f.mockFileOp.Rename(oldPath, newPath)
+}
@@ -0,0 +1,125 @@
+package installer
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+
+	"github.com/gurre/codedeploy-agent-go/logic/instruction"
+	"github.com/gurre/codedeploy-agent-go/logic/render"
+)
+
+// TemplateSource supplies the host data that templates are rendered with.
+type TemplateSource interface {
+	// Instance returns the instance metadata.
+	Instance() (render.Instance, error)
+	// Variables returns the host-local template variables.
+	Variables() (map[string]any, error)
+}
+
+// SetTemplateSource sets where templates get instance metadata and host
+// variables from. Without one both are empty. Call it before the first
+// Install.
+//
+//	inst.SetTemplateSource(&templateSourceBridge{imds: client, varsFile: cfg.TemplateVariablesFile})
+func (inst *Installer) SetTemplateSource(src TemplateSource) {
+	inst.templates = src
+}
+
+// WithDeployment returns an installer that renders templates with the
+// given deployment variables. The receiver is not modified, so one installer
+// can serve concurrent deployments.
+//
+//	err := inst.WithDeployment(render.Deployment{ApplicationName: "shop"}).Install(...)
+func (inst *Installer) WithDeployment(d render.Deployment) *Installer {
+	c := *inst
+	c.deployment = d
+	return &c
+}
+
+// renderedDir returns the scratch directory holding rendered templates for a
+// deployment group's install, kept next to its undo journal.
+func renderedDir(instructionsDir, deploymentGroupID string) string {
+	return filepath.Join(instructionsDir, deploymentGroupID+"-install-rendered")
+}
+
+// renderTemplates renders the source of every template copy in cmds into dir
+// and points the copy at the result, so the copy, its journal entry and its
+// recorded stats all see the rendered content. Host data is only fetched when
+// there is a template. cmds is modified in place.
+func (inst *Installer) renderTemplates(cmds []instruction.Command, dir, deploymentGroupID, deploymentID string) error {
+	var data *render.Data
+	for i := range cmds {
+		c := &cmds[i]
+		if c.Type != instruction.TypeCopy || !c.Template {
+			continue
+		}
+		if data == nil {
+			d, err := inst.templateData(deploymentGroupID, deploymentID)
+			if err != nil {
+				return err
+			}
+			data = &d
+			if err := os.MkdirAll(dir, 0o700); err != nil {
+				return err
+			}
+		}
+
+		info, err := os.Lstat(c.Source)
+		if err != nil {
+			return err
+		}
+		if !info.Mode().IsRegular() {
+			return fmt.Errorf("template %s is not a regular file", c.Source)
+		}
+		src, err := os.ReadFile(c.Source)
+		if err != nil {
+			return err
+		}
+		out, err := render.Render(filepath.Base(c.Source), src, *data)
+		if err != nil {
+			return fmt.Errorf("%s: %w", c.Source, err)
+		}
+		rendered := filepath.Join(dir, strconv.Itoa(i))
+		if err := os.WriteFile(rendered, out, 0o600); err != nil {
+			return err
+		}
+		if err := os.Chmod(rendered, info.Mode().Perm()); err != nil {
+			return err
+		}
+		c.Rendered = rendered
+	}
+	return nil
+}
+
+// templateData assembles what templates see. Deployment IDs missing from
+// WithDeployment are filled in from the install. Instance metadata is best
+// effort, since on-premises hosts have none; a variables file that cannot be
+// read fails the install.
+func (inst *Installer) templateData(deploymentGroupID, deploymentID string) (render.Data, error) {
+	data := render.Data{Deployment: inst.deployment, Vars: map[string]any{}}
+	if data.Deployment.DeploymentGroupID == "" {
+		data.Deployment.DeploymentGroupID = deploymentGroupID
+	}
+	if data.Deployment.DeploymentID == "" {
+		data.Deployment.DeploymentID = deploymentID
+	}
+	if inst.templates == nil {
+		return data, nil
+	}
+
+	instance, err := inst.templates.Instance()
+	if err != nil {
+		inst.logger.Warn("no instance metadata for templates", "error", err)
+	}
+	data.Instance = instance
+	vars, err := inst.templates.Variables()
+	if err != nil {
+		return data, fmt.Errorf("template variables: %w", err)
+	}
+	if vars != nil {
+		data.Vars = vars
+	}
+	return data, nil
+}
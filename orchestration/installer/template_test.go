@@ -0,0 +1,151 @@
+package installer
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/gurre/codedeploy-agent-go/logic/appspec"
+	"github.com/gurre/codedeploy-agent-go/logic/render"
+)
+
+// TestInstall_Template_RendersIntoDestination verifies that a file marked as
+// a template lands rendered with deployment, instance and host variables,
+// that other files in the same mapping are copied verbatim, and that the
+// recorded hash is of the rendered content so the next install compares what
+// is actually on the host.
+func TestInstall_Template_RendersIntoDestination(t *testing.T) {
+	archiveDir, instructionsDir, destDir := setupDirs(t)
+	createFile(t, filepath.Join(archiveDir, "conf", "app.conf"),
+		"app={{ .Deployment.ApplicationName }} group={{ .Deployment.DeploymentGroupName }} "+
+			"dg={{ .Deployment.DeploymentGroupID }} region={{ .Instance.Region }} db={{ .Vars.db_host }}")
+	createFile(t, filepath.Join(archiveDir, "conf", "raw.txt"), "{{ not rendered }}")
+
+	inst := NewInstaller(&diskFileOp{mockFileOp: newMockFileOp()}, false, IncrementalHash, slog.Default())
+	inst.SetTemplateSource(&fakeTemplateSource{
+		instance: render.Instance{Region: "eu-west-1"},
+		vars:     map[string]any{"db_host": "db.internal"},
+	})
+	spec := appspec.Spec{Files: []appspec.FileMapping{{Source: "conf", Destination: destDir, Templates: []string{"*.conf"}}}}
+	d := render.Deployment{ApplicationName: "shop", DeploymentGroupName: "prod"}
+
+	if err := inst.WithDeployment(d).Install("dg-1", "d-1", archiveDir, instructionsDir, "", spec, "OVERWRITE"); err != nil {
+		t.Fatalf("Install: %v", err)
+	}
+
+	dest := filepath.Join(destDir, "app.conf")
+	want := "app=shop group=prod dg=dg-1 region=eu-west-1 db=db.internal"
+	if got, err := os.ReadFile(dest); err != nil || string(got) != want {
+		t.Errorf("app.conf = %q (%v), want %q", got, err, want)
+	}
+	if got, _ := os.ReadFile(filepath.Join(destDir, "raw.txt")); string(got) != "{{ not rendered }}" {
+		t.Errorf("raw.txt = %q, want it copied verbatim", got)
+	}
+
+	sum := sha256.Sum256([]byte(want))
+	cmd := installedCopy(t, instructionsDir, dest)
+	if !cmd.Template || cmd.Stats == nil || cmd.Stats.SHA256 != hex.EncodeToString(sum[:]) {
+		t.Errorf("install.json copy = %+v, stats %+v, want the rendered hash", cmd, cmd.Stats)
+	}
+	if _, err := os.Stat(renderedDir(instructionsDir, "dg-1")); !os.IsNotExist(err) {
+		t.Errorf("rendered staging directory should be removed, stat err = %v", err)
+	}
+}
+
+// TestInstall_Template_IncrementalOffRecordsRenderedHash verifies that with
+// incremental installs off, install.json still records the hash of the
+// rendered output and not of the template source, so verification compares
+// against what is on the host.
+func TestInstall_Template_IncrementalOffRecordsRenderedHash(t *testing.T) {
+	archiveDir, instructionsDir, destDir := setupDirs(t)
+	source := "region={{ .Instance.Region }}"
+	createFile(t, filepath.Join(archiveDir, "app.conf"), source)
+
+	inst := NewInstaller(&diskFileOp{mockFileOp: newMockFileOp()}, false, IncrementalOff, slog.Default())
+	inst.SetTemplateSource(&fakeTemplateSource{instance: render.Instance{Region: "eu-west-1"}})
+	spec := appspec.Spec{Files: []appspec.FileMapping{{Source: "app.conf", Destination: destDir, Templates: []string{"*.conf"}}}}
+	if err := inst.Install("dg-1", "d-1", archiveDir, instructionsDir, "", spec, "OVERWRITE"); err != nil {
+		t.Fatalf("Install: %v", err)
+	}
+
+	rendered := sha256.Sum256([]byte("region=eu-west-1"))
+	templated := sha256.Sum256([]byte(source))
+	cmd := installedCopy(t, instructionsDir, filepath.Join(destDir, "app.conf"))
+	if cmd.Stats == nil || cmd.Stats.SHA256 != hex.EncodeToString(rendered[:]) {
+		t.Errorf("stats = %+v, want sha256 %x of the rendered output", cmd.Stats, rendered)
+	}
+	if cmd.Stats != nil && cmd.Stats.SHA256 == hex.EncodeToString(templated[:]) {
+		t.Error("recorded hash is of the template source")
+	}
+}
+
+// TestInstall_Template_FailsBeforeTouchingHost verifies that a template
+// referring to an undefined variable, or a variables file that cannot be
+// read, fails the install before any file is copied.
+func TestInstall_Template_FailsBeforeTouchingHost(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		src  *fakeTemplateSource
+		want string
+	}{
+		{"missing variable", &fakeTemplateSource{vars: map[string]any{}}, "db_host"},
+		{"unreadable variables", &fakeTemplateSource{varsErr: errors.New("permission denied")}, "permission denied"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			archiveDir, instructionsDir, destDir := setupDirs(t)
+			createFile(t, filepath.Join(archiveDir, "app.conf"), "db={{ .Vars.db_host }}")
+			createFile(t, filepath.Join(archiveDir, "other.txt"), "x")
+
+			mock := &diskFileOp{mockFileOp: newMockFileOp()}
+			inst := NewInstaller(mock, false, IncrementalOff, slog.Default())
+			inst.SetTemplateSource(tc.src)
+			spec := appspec.Spec{Files: []appspec.FileMapping{
+				{Source: "other.txt", Destination: destDir},
+				{Source: "app.conf", Destination: destDir, Templates: []string{"app.conf"}},
+			}}
+
+			err := inst.Install("dg-1", "d-1", archiveDir, instructionsDir, "", spec, "OVERWRITE")
+			if err == nil || !strings.Contains(err.Error(), tc.want) {
+				t.Fatalf("Install error = %v, want it to mention %q", err, tc.want)
+			}
+			if len(mock.copies) != 0 {
+				t.Errorf("nothing should be copied, got %v", mock.copies)
+			}
+		})
+	}
+}
+
+// TestInstall_Template_InstanceMetadataIsBestEffort verifies that an
+// on-premises host without instance metadata can still render templates
+// that do not need it.
+func TestInstall_Template_InstanceMetadataIsBestEffort(t *testing.T) {
+	archiveDir, instructionsDir, destDir := setupDirs(t)
+	createFile(t, filepath.Join(archiveDir, "app.conf"), "id={{ .Deployment.DeploymentID }}")
+
+	inst := NewInstaller(&diskFileOp{mockFileOp: newMockFileOp()}, false, IncrementalOff, slog.Default())
+	inst.SetTemplateSource(&fakeTemplateSource{instanceErr: errors.New("no IMDS")})
+	spec := appspec.Spec{Files: []appspec.FileMapping{{Source: "app.conf", Destination: destDir, Templates: []string{"app.conf"}}}}
+
+	if err := inst.Install("dg-1", "d-7", archiveDir, instructionsDir, "", spec, "OVERWRITE"); err != nil {
+		t.Fatalf("Install: %v", err)
+	}
+	if got, _ := os.ReadFile(filepath.Join(destDir, "app.conf")); string(got) != "id=d-7" {
+		t.Errorf("app.conf = %q, want id=d-7", got)
+	}
+}
+
+// fakeTemplateSource serves fixed host data to templates.
+type fakeTemplateSource struct {
+	instance    render.Instance
+	instanceErr error
+	vars        map[string]any
+	varsErr     error
+}
+
+func (f *fakeTemplateSource) Instance() (render.Instance, error) { return f.instance, f.instanceErr }
+func (f *fakeTemplateSource) Variables() (map[string]any, error) { return f.vars, f.varsErr }
@@ -0,0 +1,306 @@
+// Package verify detects drift between the files the installer last put in
+// place and what is on disk now. It reads each deployment group's
+// install.json and reports managed paths that are missing, modified, joined
+// by unmanaged files in directories the agent created, or that no longer have
+// the mode, owner or ACL the appspec asked for.
+package verify
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/gurre/codedeploy-agent-go/logic/appspec"
+	"github.com/gurre/codedeploy-agent-go/logic/instruction"
+	"github.com/gurre/codedeploy-agent-go/state/deployment"
+)
+
+// Kind classifies a drift finding.
+type Kind string
+
+const (
+	KindMissing  Kind = "missing"
+	KindModified Kind = "modified"
+	KindExtra    Kind = "extra"
+	KindMode     Kind = "mode"
+	KindOwner    Kind = "owner"
+	KindACL      Kind = "acl"
+)
+
+// Inspector reads file metadata that needs platform support.
+type Inspector interface {
+	// Owner returns the numeric owner of path without following symlinks.
+	Owner(path string) (uid, gid int, err error)
+	// LookupOwner resolves owner and group names to IDs; empty names give -1.
+	LookupOwner(owner, group string) (uid, gid int, err error)
+	// ACLMatches reports whether path carries the given setfacl entries.
+	ACLMatches(path string, acl []string) (bool, error)
+}
+
+// Finding is one drifted path.
+type Finding struct {
+	Kind              Kind   `json:"kind"`
+	Path              string `json:"path"`
+	DeploymentGroupID string `json:"deployment_group_id"`
+	Expected          string `json:"expected,omitempty"`
+	Actual            string `json:"actual,omitempty"`
+}
+
+// Report summarises a verification run.
+type Report struct {
+	Findings []Finding `json:"findings"`
+	Groups   []string  `json:"deployment_groups"`
+	Checked  int       `json:"checked"`
+}
+
+// Drift reports whether any finding was made.
+func (r Report) Drift() bool {
+	return len(r.Findings) > 0
+}
+
+// Verifier compares install records under a deployment root with the disk.
+type Verifier struct {
+	inspector Inspector
+	logger    *slog.Logger
+	rootDir   string
+}
+
+// NewVerifier creates a verifier for the given deployment root.
+//
+//	v := verify.NewVerifier(filesystem.NewOperator(), cfg.RootDir, logger)
+//	report, err := v.Verify(ctx, "")
+func NewVerifier(inspector Inspector, rootDir string, logger *slog.Logger) *Verifier {
+	return &Verifier{inspector: inspector, logger: logger, rootDir: rootDir}
+}
+
+// Verify checks the last install of deploymentGroupID, or of every group with
+// an install record when it is empty. Content is compared by size and
+// SHA-256 as recorded after the install; records written by agents that did
+// not record stats are only checked for presence.
+func (v *Verifier) Verify(ctx context.Context, deploymentGroupID string) (Report, error) {
+	report := Report{Findings: make([]Finding, 0, 8)}
+
+	groups := []string{deploymentGroupID}
+	if deploymentGroupID == "" {
+		var err error
+		if groups, err = v.installedGroups(); err != nil {
+			return report, err
+		}
+	}
+
+	for _, groupID := range groups {
+		if err := ctx.Err(); err != nil {
+			return report, fmt.Errorf("verify: cancelled: %w", err)
+		}
+		data, err := os.ReadFile(deployment.InstallFile(v.rootDir, groupID))
+		if err != nil {
+			return report, fmt.Errorf("verify: read install record for %s: %w", groupID, err)
+		}
+		cmds, err := instruction.ParseInstallCommands(data)
+		if err != nil {
+			return report, fmt.Errorf("verify: parse install record for %s: %w", groupID, err)
+		}
+		report.Groups = append(report.Groups, groupID)
+		findings, checked := v.verifyGroup(groupID, cmds)
+		report.Findings = append(report.Findings, findings...)
+		report.Checked += checked
+	}
+	return report, nil
+}
+
+// RunPeriodic runs Verify for every group each interval until ctx is
+// cancelled, logging each finding. Errors never stop the loop.
+//
+//	go verifier.RunPeriodic(ctx, cfg.VerifyInterval)
+func (v *Verifier) RunPeriodic(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			report, err := v.Verify(ctx, "")
+			if err != nil {
+				v.logger.Warn("verify: run failed", "error", err)
+				continue
+			}
+			for _, f := range report.Findings {
+				v.logger.Warn("verify: drift", "kind", f.Kind, "path", f.Path, "group", f.DeploymentGroupID,
+					"expected", f.Expected, "actual", f.Actual)
+			}
+			v.logger.Info("verify: run finished", "checked", report.Checked, "drifted", len(report.Findings))
+		}
+	}
+}
+
+func (v *Verifier) installedGroups() ([]string, error) {
+	matches, err := filepath.Glob(deployment.InstallFile(v.rootDir, "*"))
+	if err != nil {
+		return nil, fmt.Errorf("verify: list install records: %w", err)
+	}
+	groups := make([]string, 0, len(matches))
+	for _, m := range matches {
+		groups = append(groups, strings.TrimSuffix(filepath.Base(m), "-install.json"))
+	}
+	sort.Strings(groups)
+	return groups, nil
+}
+
+// verifyGroup checks one install record and returns its findings and the
+// number of managed paths checked.
+func (v *Verifier) verifyGroup(groupID string, cmds []instruction.Command) ([]Finding, int) {
+	var findings []Finding
+	add := func(kind Kind, path, expected, actual string) {
+		findings = append(findings, Finding{Kind: kind, Path: path, DeploymentGroupID: groupID, Expected: expected, Actual: actual})
+	}
+
+	managed := make(map[string]bool, len(cmds))
+	withACL := make(map[string]bool)
+	var dirs []string
+	for _, c := range cmds {
+		switch c.Type {
+		case instruction.TypeCopy:
+			managed[c.Destination] = true
+		case instruction.TypeMkdir:
+			managed[c.Directory] = true
+			dirs = append(dirs, c.Directory)
+		case instruction.TypeSetfacl:
+			withACL[c.File] = true
+		}
+	}
+
+	missing := make(map[string]bool)
+	for _, c := range cmds {
+		switch c.Type {
+		case instruction.TypeCopy:
+			if !v.checkCopy(c, add) {
+				missing[c.Destination] = true
+			}
+		case instruction.TypeMkdir:
+			info, err := os.Lstat(c.Directory)
+			if err != nil || !info.IsDir() {
+				missing[c.Directory] = true
+				add(KindMissing, c.Directory, "", "")
+			}
+		case instruction.TypeChmod:
+			if missing[c.File] {
+				continue
+			}
+			v.checkMode(c, withACL[c.File], add)
+		case instruction.TypeChown:
+			if missing[c.File] {
+				continue
+			}
+			v.checkOwner(c, add)
+		case instruction.TypeSetfacl:
+			if missing[c.File] {
+				continue
+			}
+			ok, err := v.inspector.ACLMatches(c.File, c.ACL)
+			if err != nil {
+				v.logger.Debug("verify: acl not checked", "path", c.File, "error", err)
+				continue
+			}
+			if !ok {
+				add(KindACL, c.File, strings.Join(c.ACL, ","), "")
+			}
+		}
+	}
+
+	for _, dir := range dirs {
+		if missing[dir] {
+			continue
+		}
+		entries, err := os.ReadDir(dir)
+		if err != nil {
+			continue
+		}
+		for _, e := range entries {
+			if path := filepath.Join(dir, e.Name()); !managed[path] {
+				add(KindExtra, path, "", "")
+			}
+		}
+	}
+	return findings, len(managed)
+}
+
+// checkCopy reports a missing or modified copy destination. It returns false
+// when the destination is missing.
+func (v *Verifier) checkCopy(c instruction.Command, add func(Kind, string, string, string)) bool {
+	info, err := os.Lstat(c.Destination)
+	if err != nil {
+		add(KindMissing, c.Destination, "", "")
+		return false
+	}
+	s := c.Stats
+	if s == nil {
+		return true
+	}
+	if !info.Mode().IsRegular() {
+		add(KindModified, c.Destination, "regular file", info.Mode().Type().String())
+		return true
+	}
+	if info.Size() != s.Size {
+		add(KindModified, c.Destination, fmt.Sprintf("%d bytes", s.Size), fmt.Sprintf("%d bytes", info.Size()))
+		return true
+	}
+	if s.SHA256 != "" {
+		sum, err := instruction.HashFile(c.Destination)
+		if err != nil {
+			add(KindModified, c.Destination, "sha256 "+s.SHA256, err.Error())
+		} else if sum != s.SHA256 {
+			add(KindModified, c.Destination, "sha256 "+s.SHA256, "sha256 "+sum)
+		}
+		return true
+	}
+	if s.InstalledMtime != 0 && info.ModTime().UnixNano() != s.InstalledMtime {
+		add(KindModified, c.Destination,
+			"mtime "+time.Unix(0, s.InstalledMtime).UTC().Format(time.RFC3339Nano),
+			"mtime "+info.ModTime().UTC().Format(time.RFC3339Nano))
+	}
+	return true
+}
+
+// checkMode compares permission bits. A POSIX ACL with a mask replaces the
+// group bits, so those are not compared for paths that also have an ACL.
+func (v *Verifier) checkMode(c instruction.Command, hasACL bool, add func(Kind, string, string, string)) {
+	mode, err := appspec.ParseMode(c.Mode)
+	if err != nil {
+		return
+	}
+	info, err := os.Lstat(c.File)
+	if err != nil {
+		return
+	}
+	compare := os.FileMode(0o777)
+	if hasACL {
+		compare = 0o707
+	}
+	want := os.FileMode(mode.Value) & compare
+	if got := info.Mode().Perm() & compare; got != want {
+		add(KindMode, c.File, fmt.Sprintf("%04o", want), fmt.Sprintf("%04o", got))
+	}
+}
+
+func (v *Verifier) checkOwner(c instruction.Command, add func(Kind, string, string, string)) {
+	wantUID, wantGID, err := v.inspector.LookupOwner(c.Owner, c.Group)
+	if err != nil {
+		v.logger.Debug("verify: owner not checked", "path", c.File, "error", err)
+		return
+	}
+	uid, gid, err := v.inspector.Owner(c.File)
+	if err != nil {
+		v.logger.Debug("verify: owner not checked", "path", c.File, "error", err)
+		return
+	}
+	if (wantUID >= 0 && uid != wantUID) || (wantGID >= 0 && gid != wantGID) {
+		add(KindOwner, c.File, c.Owner+":"+c.Group, fmt.Sprintf("%d:%d", uid, gid))
+	}
+}
@@ -0,0 +1,239 @@
+package verify
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/gurre/codedeploy-agent-go/logic/instruction"
+	"github.com/gurre/codedeploy-agent-go/state/deployment"
+)
+
+// TestVerify_CleanInstallHasNoDrift verifies that files matching their
+// recorded hash, mode and owner produce no findings, so an untouched host
+// exits cleanly.
+func TestVerify_CleanInstallHasNoDrift(t *testing.T) {
+	root, dest := t.TempDir(), t.TempDir()
+	app := filepath.Join(dest, "app")
+	file := filepath.Join(app, "index.html")
+	writeFile(t, file, "hello", 0o644)
+	writeInstall(t, root, "dg-1", []instruction.Command{
+		{Type: instruction.TypeMkdir, Directory: app},
+		copyCmd(file, "hello"),
+		{Type: instruction.TypeChmod, File: file, Mode: "644"},
+		{Type: instruction.TypeChown, File: file, Owner: "deploy"},
+	})
+
+	report, err := NewVerifier(&fakeInspector{uid: 1000, owner: 1000}, root, slog.Default()).Verify(context.Background(), "")
+	if err != nil {
+		t.Fatalf("Verify: %v", err)
+	}
+	if report.Drift() {
+		t.Errorf("unexpected findings: %+v", report.Findings)
+	}
+	if report.Checked != 2 {
+		t.Errorf("Checked = %d, want 2", report.Checked)
+	}
+}
+
+// TestVerify_ReportsEachKindOfDrift verifies that a deleted file, an edited
+// file, a stray file in an agent-created directory and changed mode, owner
+// and ACL are each reported against the right path.
+func TestVerify_ReportsEachKindOfDrift(t *testing.T) {
+	root, dest := t.TempDir(), t.TempDir()
+	app := filepath.Join(dest, "app")
+	edited := filepath.Join(app, "edited.conf")
+	gone := filepath.Join(app, "gone.conf")
+	writeFile(t, edited, "hand edit", 0o600)
+	writeFile(t, filepath.Join(app, "stray.log"), "x", 0o644)
+	writeInstall(t, root, "dg-1", []instruction.Command{
+		{Type: instruction.TypeMkdir, Directory: app},
+		copyCmd(edited, "original"),
+		copyCmd(gone, "original"),
+		{Type: instruction.TypeChmod, File: edited, Mode: "644"},
+		{Type: instruction.TypeChmod, File: gone, Mode: "644"},
+		{Type: instruction.TypeChown, File: edited, Owner: "deploy"},
+		{Type: instruction.TypeSetfacl, File: edited, ACL: []string{"u:deploy:rw"}},
+	})
+
+	inspector := &fakeInspector{uid: 1000, owner: 0, aclMismatch: true}
+	report, err := NewVerifier(inspector, root, slog.Default()).Verify(context.Background(), "dg-1")
+	if err != nil {
+		t.Fatalf("Verify: %v", err)
+	}
+
+	want := map[Kind]string{
+		KindMissing:  gone,
+		KindModified: edited,
+		KindExtra:    filepath.Join(app, "stray.log"),
+		KindOwner:    edited,
+		KindACL:      edited,
+	}
+	got := make(map[Kind]string)
+	for _, f := range report.Findings {
+		if f.DeploymentGroupID != "dg-1" {
+			t.Errorf("finding %+v has wrong group", f)
+		}
+		got[f.Kind] = f.Path
+	}
+	for kind, path := range want {
+		if got[kind] != path {
+			t.Errorf("%s finding = %q, want %q", kind, got[kind], path)
+		}
+	}
+	// The ACL mask owns the group bits, so only owner and other bits count:
+	// 0600 against 0644 still differs in the other bits.
+	if got[KindMode] != edited {
+		t.Errorf("mode finding = %q, want %q", got[KindMode], edited)
+	}
+	if len(report.Findings) != 6 {
+		t.Errorf("findings = %+v, want 6", report.Findings)
+	}
+}
+
+// TestVerify_ACLIgnoresGroupBits verifies that a path carrying an ACL is not
+// reported for group-bit differences, since setting an ACL mask rewrites
+// them.
+func TestVerify_ACLIgnoresGroupBits(t *testing.T) {
+	root, dest := t.TempDir(), t.TempDir()
+	file := filepath.Join(dest, "f")
+	writeFile(t, file, "x", 0o674)
+	writeInstall(t, root, "dg-1", []instruction.Command{
+		{Type: instruction.TypeCopy, Destination: file},
+		{Type: instruction.TypeChmod, File: file, Mode: "644"},
+		{Type: instruction.TypeSetfacl, File: file, ACL: []string{"u:deploy:rw"}},
+	})
+
+	report, err := NewVerifier(&fakeInspector{}, root, slog.Default()).Verify(context.Background(), "dg-1")
+	if err != nil {
+		t.Fatalf("Verify: %v", err)
+	}
+	if report.Drift() {
+		t.Errorf("unexpected findings: %+v", report.Findings)
+	}
+}
+
+// TestVerify_MtimeWithoutHash verifies that installs recorded in mtime mode
+// are checked by the destination's modification time after install.
+func TestVerify_MtimeWithoutHash(t *testing.T) {
+	root, dest := t.TempDir(), t.TempDir()
+	file := filepath.Join(dest, "f")
+	writeFile(t, file, "abc", 0o644)
+	info, err := os.Stat(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cmd := instruction.Command{Type: instruction.TypeCopy, Destination: file,
+		Stats: &instruction.FileStats{Size: 3, InstalledMtime: info.ModTime().UnixNano() - 1}}
+	writeInstall(t, root, "dg-1", []instruction.Command{cmd})
+
+	report, err := NewVerifier(&fakeInspector{}, root, slog.Default()).Verify(context.Background(), "dg-1")
+	if err != nil {
+		t.Fatalf("Verify: %v", err)
+	}
+	if len(report.Findings) != 1 || report.Findings[0].Kind != KindModified {
+		t.Errorf("findings = %+v, want one modified", report.Findings)
+	}
+}
+
+// TestVerify_UnknownGroup verifies that asking for a group without an install
+// record is an error rather than a clean report.
+func TestVerify_UnknownGroup(t *testing.T) {
+	_, err := NewVerifier(&fakeInspector{}, t.TempDir(), slog.Default()).Verify(context.Background(), "dg-none")
+	if err == nil {
+		t.Fatal("expected error for missing install record")
+	}
+}
+
+// TestVerify_UnsupportedInspectorSkips verifies that owner and ACL checks are
+// skipped, not reported, where the platform cannot inspect them.
+func TestVerify_UnsupportedInspectorSkips(t *testing.T) {
+	root, dest := t.TempDir(), t.TempDir()
+	file := filepath.Join(dest, "f")
+	writeFile(t, file, "x", 0o644)
+	writeInstall(t, root, "dg-1", []instruction.Command{
+		{Type: instruction.TypeCopy, Destination: file},
+		{Type: instruction.TypeChown, File: file, Owner: "deploy"},
+		{Type: instruction.TypeSetfacl, File: file, ACL: []string{"u:deploy:rw"}},
+	})
+
+	report, err := NewVerifier(&fakeInspector{unsupported: true}, root, slog.Default()).Verify(context.Background(), "")
+	if err != nil {
+		t.Fatalf("Verify: %v", err)
+	}
+	if report.Drift() {
+		t.Errorf("unexpected findings: %+v", report.Findings)
+	}
+}
+
+func copyCmd(dest, content string) instruction.Command {
+	sum := sha256.Sum256([]byte(content))
+	return instruction.Command{
+		Type:        instruction.TypeCopy,
+		Destination: dest,
+		Stats:       &instruction.FileStats{SHA256: hex.EncodeToString(sum[:]), Size: int64(len(content))},
+	}
+}
+
+func writeInstall(t *testing.T, root, groupID string, cmds []instruction.Command) {
+	t.Helper()
+	data, err := (&instruction.Instructions{Commands: cmds}).ToJSON()
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := deployment.InstallFile(root, groupID)
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, data, 0o644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func writeFile(t *testing.T, path, content string, mode os.FileMode) {
+	t.Helper()
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, []byte(content), mode); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chmod(path, mode); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// fakeInspector resolves every owner name to uid and reports owner as the
+// owner of every file. aclMismatch makes every ACL differ.
+type fakeInspector struct {
+	uid         int
+	owner       int
+	aclMismatch bool
+	unsupported bool
+}
+
+func (f *fakeInspector) Owner(_ string) (int, int, error) {
+	if f.unsupported {
+		return 0, 0, errors.ErrUnsupported
+	}
+	return f.owner, 0, nil
+}
+
+func (f *fakeInspector) LookupOwner(owner, _ string) (int, int, error) {
+	if owner == "" {
+		return -1, -1, nil
+	}
+	return f.uid, -1, nil
+}
+
+func (f *fakeInspector) ACLMatches(_ string, _ []string) (bool, error) {
+	if f.unsupported {
+		return false, errors.ErrUnsupported
+	}
+	return !f.aclMismatch, nil
+}
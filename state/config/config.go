@@ -19,13 +19,41 @@ type Agent struct {
 	OngoingDeploymentTracking string
 	// OnPremisesConfigFile is the path to on-premises credentials config.
 	OnPremisesConfigFile string
+	// TemplateVariablesFile is the YAML file whose values templates in the
+	// appspec files section see as .Vars.
+	TemplateVariablesFile string
 	// ProxyURI is the HTTP proxy URI, if any.
 	ProxyURI string
 	// DeployControlEndpoint overrides the CodeDeploy Commands endpoint.
 	DeployControlEndpoint string
 	// S3EndpointOverride overrides the S3 endpoint.
 	S3EndpointOverride string
+	// IncrementalInstall selects how unchanged files are skipped on
+	// install: "hash" (size and SHA-256), "mtime" (size and modification
+	// time) or "off".
+	IncrementalInstall string
+	// HookShell is the shell that runs inline command hooks which name
+	// none; empty is /bin/sh on Unix and powershell.exe on Windows.
+	HookShell string
+	// HookEnvPolicy selects which variables of the agent's environment hook
+	// scripts inherit: "inherit" (all), "minimal" or "allowlist".
+	HookEnvPolicy string
+	// HookEnvAllowlist names the variables, or NAME* prefixes, hook scripts
+	// inherit under the allowlist policy.
+	HookEnvAllowlist []string
 
+	// HookGracePeriod is how long a timed-out hook script has to exit after
+	// SIGTERM before it is killed; zero kills it at once. An appspec
+	// grace_period overrides it per script.
+	HookGracePeriod time.Duration
+	// HookOutputWait is how long, after a hook script exits, the processes
+	// it started have to close its stdout and stderr before the hook fails
+	// with OutputsLeftOpen.
+	HookOutputWait time.Duration
+	// HookLimitCPUTime is the CPU time each hook script process may use
+	// before it is killed; zero is unlimited. Like the other HookLimit
+	// fields, an appspec limits entry overrides it per script.
+	HookLimitCPUTime time.Duration
 	// KillAgentMaxWait is the graceful shutdown timeout.
 	KillAgentMaxWait time.Duration
 	// PollInterval is the delay between polling cycles.
@@ -36,9 +64,35 @@ type Agent struct {
 	ErrorBackoff time.Duration
 	// HTTPReadTimeout is the HTTP read timeout for API calls.
 	HTTPReadTimeout time.Duration
+	// GCInterval is the delay between background deployment-root garbage
+	// collection runs. Zero disables the background task.
+	GCInterval time.Duration
+	// VerifyInterval is the delay between background drift checks of the
+	// last install. Zero disables the background task.
+	VerifyInterval time.Duration
+	// DeploymentLogsMaxAge is how long rotated files in deployment-logs are
+	// kept before garbage collection removes them.
+	DeploymentLogsMaxAge time.Duration
 
 	// MaxRevisions is the number of deployment archives to retain.
 	MaxRevisions int
+	// HookLimitOpenFiles caps each hook script process's open files; zero
+	// is unlimited.
+	HookLimitOpenFiles int
+	// HookLimitProcesses caps each hook script's processes, through
+	// cgroup v2; zero is unlimited.
+	HookLimitProcesses int
+	// HookLimitAddressSpace caps each hook script process's virtual memory
+	// in bytes; zero is unlimited.
+	HookLimitAddressSpace int64
+	// HookLimitMemory caps the memory of each hook script and its
+	// descendants in bytes, through cgroup v2; zero is unlimited.
+	HookLimitMemory int64
+	// HookLimitCPUs caps the CPU of each hook script and its descendants,
+	// in CPUs, through cgroup v2; zero is unlimited. The cgroup limits need
+	// the agent's cgroup delegated to it, as systemd does with Delegate=yes:
+	// the agent moves itself into a child cgroup named agent to use them.
+	HookLimitCPUs float64
 
 	// UseFIPSMode enables FIPS-compliant endpoints.
 	UseFIPSMode bool
@@ -50,6 +104,19 @@ type Agent struct {
 	EnableDeploymentsLog bool
 	// DisableIMDSv1 disables fallback to IMDSv1.
 	DisableIMDSv1 bool
+	// HookLeaveDaemonsRunning leaves running the processes that held a hook
+	// script's output past HookOutputWait, rather than killing them.
+	HookLeaveDaemonsRunning bool
+	// HookChmodScripts makes hook scripts without executable bits
+	// executable, as the Ruby agent does, rather than failing the hook
+	// with ScriptNotExecutable.
+	HookChmodScripts bool
+	// BackupOverwrittenFiles keeps files replaced under OVERWRITE that the
+	// agent did not install in the deployment's backup directory.
+	BackupOverwrittenFiles bool
+	// AllowSharedDestinations lets deployment groups install to paths that
+	// another group on the host already manages.
+	AllowSharedDestinations bool
 }
 
 // Default returns an Agent config with the same defaults as the Ruby agent.
@@ -63,13 +130,18 @@ func Default() Agent {
 		LogDir:                    "/var/log/aws/codedeploy-agent",
 		OngoingDeploymentTracking: "ongoing-deployment",
 		OnPremisesConfigFile:      "/etc/codedeploy-agent/conf/codedeploy.onpremises.yml",
+		TemplateVariablesFile:     "/etc/codedeploy-agent/conf/template-variables.yml",
+		IncrementalInstall:        "hash",
+		HookOutputWait:            10 * time.Second,
 		KillAgentMaxWait:          7200 * time.Second,
 		PollInterval:              30 * time.Second,
 		ActivePollInterval:        10 * time.Second,
 		ErrorBackoff:              30 * time.Second,
 		HTTPReadTimeout:           80 * time.Second,
+		DeploymentLogsMaxAge:      7 * 24 * time.Hour,
 		MaxRevisions:              5,
 		EnableDeploymentsLog:      true,
+		HookChmodScripts:          true,
 	}
 }
 
@@ -23,6 +23,9 @@ func TestDefaultConfigHasExpectedValues(t *testing.T) {
 	if cfg.MaxRevisions != 5 {
 		t.Errorf("MaxRevisions = %d", cfg.MaxRevisions)
 	}
+	if cfg.GCInterval != 0 {
+		t.Errorf("GCInterval = %v, background GC should be off by default", cfg.GCInterval)
+	}
 	if !cfg.EnableDeploymentsLog {
 		t.Error("EnableDeploymentsLog should be true by default")
 	}
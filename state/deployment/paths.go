@@ -36,6 +36,22 @@ func (l Layout) ArchiveDir() string {
 	return filepath.Join(l.DeploymentRootDir(), "deployment-archive")
 }
 
+// ArchiveTempDir returns the scratch directory used while stripping a leading
+// directory from an unpacked archive. It only outlives an unpack that was
+// interrupted part-way.
+// Example: /opt/codedeploy-agent/deployment-root/dg-123/d-456/deployment-archive-temp
+func (l Layout) ArchiveTempDir() string {
+	return l.ArchiveDir() + "-temp"
+}
+
+// BackupDir returns the directory holding originals of files that the
+// deployment overwrote, with a manifest.json listing them. It shares the
+// deployment directory's retention.
+// Example: /opt/codedeploy-agent/deployment-root/dg-123/d-456/overwritten-backup
+func (l Layout) BackupDir() string {
+	return filepath.Join(l.DeploymentRootDir(), "overwritten-backup")
+}
+
 // BundleFile returns the path to the downloaded bundle artifact.
 // Example: /opt/codedeploy-agent/deployment-root/dg-123/d-456/bundle.tar
 func (l Layout) BundleFile() string {
@@ -19,6 +19,8 @@ func TestLayoutPathsAreConsistent(t *testing.T) {
 		path string
 	}{
 		{"ArchiveDir", l.ArchiveDir()},
+		{"ArchiveTempDir", l.ArchiveTempDir()},
+		{"BackupDir", l.BackupDir()},
 		{"BundleFile", l.BundleFile()},
 		{"ScriptLogFile", l.ScriptLogFile()},
 		{"LogsDir", l.LogsDir()},